@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net/http"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -19,6 +20,10 @@ type Account struct {
 	checkpoint    []byte
 	lastItemID    *int64
 
+	// Options for the client, as returned by the data
+	// source's ClientOptions; nil if it has none.
+	Options interface{}
+
 	t  *Timeline
 	ds DataSource
 }
@@ -96,7 +101,11 @@ func (t *Timeline) AddAccount(dataSourceID, userID string) error {
 // source ID and the user ID for that data source. The Client is
 // actually wrapped by a type with unexported fields that are
 // necessary for internal use.
-func (t *Timeline) NewClient(dataSourceID, userID string) (WrappedClient, error) {
+//
+// The options configure the client; they must be of the type
+// returned by the data source's ClientOptions, or nil to use
+// its defaults.
+func (t *Timeline) NewClient(dataSourceID, userID string, options interface{}) (WrappedClient, error) {
 	ds, ok := dataSources[dataSourceID]
 	if !ok {
 		return WrappedClient{}, fmt.Errorf("data source not registered: %s", dataSourceID)
@@ -110,6 +119,26 @@ func (t *Timeline) NewClient(dataSourceID, userID string) (WrappedClient, error)
 		return WrappedClient{}, fmt.Errorf("getting account: %v", err)
 	}
 
+	if options != nil && ds.ClientOptions == nil {
+		return WrappedClient{}, fmt.Errorf("data source %s does not have any options", dataSourceID)
+	}
+	if options == nil && ds.ClientOptions != nil {
+		options = ds.ClientOptions()
+	}
+	if options != nil {
+		if want := ds.ClientOptions(); reflect.TypeOf(options) != reflect.TypeOf(want) {
+			return WrappedClient{}, fmt.Errorf("options for data source %s must be of type %T, not %T",
+				dataSourceID, want, options)
+		}
+		if v, ok := options.(interface{ Validate() error }); ok {
+			err := v.Validate()
+			if err != nil {
+				return WrappedClient{}, fmt.Errorf("invalid options: %v", err)
+			}
+		}
+	}
+	acc.Options = options
+
 	cl, err := ds.NewClient(acc)
 	if err != nil {
 		return WrappedClient{}, fmt.Errorf("making client from data source: %v", err)
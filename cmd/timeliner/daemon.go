@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// runDaemon keeps the accounts of clients synced according to
+// their schedules in the config until ctx is cancelled, at which
+// point it returns once any running operations have stopped.
+func runDaemon(ctx context.Context, clients []timeliner.WrappedClient) error {
+	status := &daemonStatus{
+		Started:  time.Now(),
+		Accounts: make(map[string]*accountStatus),
+		file:     daemonCfg.StatusFile,
+	}
+
+	// if there is a status file from a previous run, we
+	// can pick up the schedule where it left off
+	prevStatus, err := loadDaemonStatus(daemonCfg.StatusFile)
+	if err != nil {
+		log.Printf("[ERROR] Loading previous status; starting schedules fresh: %v", err)
+	}
+
+	var schedulers []*scheduler
+	for i := range clients {
+		wc := &clients[i]
+		key := wc.DataSourceID() + "/" + wc.UserID()
+		sched := accountCfgs[key].Schedule
+		if sched == nil || (sched.GetLatest <= 0 && sched.GetAll <= 0) {
+			return fmt.Errorf("no schedule configured for account %s", key)
+		}
+
+		acctStatus := &accountStatus{Jobs: make(map[string]*jobStatus)}
+		var prevJobs map[string]*jobStatus
+		if prev, ok := prevStatus.Accounts[key]; ok {
+			prevJobs = prev.Jobs
+		}
+		addJob := func(name string, interval duration) {
+			if interval <= 0 {
+				return
+			}
+			js := &jobStatus{Interval: interval.String(), NextRun: time.Now()}
+			if prev, ok := prevJobs[name]; ok && prev.LastSuccess != nil {
+				js.LastSuccess = prev.LastSuccess
+				js.NextRun = prev.LastSuccess.Add(time.Duration(interval))
+			}
+			acctStatus.Jobs[name] = js
+		}
+		addJob(jobGetLatest, sched.GetLatest)
+		addJob(jobGetAll, sched.GetAll)
+		status.Accounts[key] = acctStatus
+
+		schedulers = append(schedulers, &scheduler{
+			wc:       wc,
+			key:      key,
+			schedule: *sched,
+			status:   status,
+		})
+	}
+
+	status.write()
+
+	if daemonCfg.StatusAddr != "" {
+		srv := &http.Server{Addr: daemonCfg.StatusAddr, Handler: status}
+		go func() {
+			log.Printf("[INFO] Serving status on %s", daemonCfg.StatusAddr)
+			err := srv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("[ERROR] Status server: %v", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range schedulers {
+		wg.Add(1)
+		go func(s *scheduler) {
+			defer wg.Done()
+			s.run(ctx)
+		}(s)
+	}
+	wg.Wait()
+
+	log.Println("[INFO] Daemon stopped")
+
+	return nil
+}
+
+// scheduler runs the scheduled jobs of a single account,
+// one at a time.
+type scheduler struct {
+	wc       *timeliner.WrappedClient
+	key      string
+	schedule scheduleConfig
+	status   *daemonStatus
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		// figure out which job is due next; a get-all
+		// takes precedence over a get-latest since it
+		// gets everything a get-latest would
+		job, next := s.nextJob()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *scheduler) nextJob() (string, time.Time) {
+	s.status.Lock()
+	defer s.status.Unlock()
+	var job string
+	var next time.Time
+	for _, name := range []string{jobGetAll, jobGetLatest} {
+		js, ok := s.status.Accounts[s.key].Jobs[name]
+		if !ok {
+			continue
+		}
+		if job == "" || js.NextRun.Before(next) {
+			job, next = name, js.NextRun
+		}
+	}
+	return job, next
+}
+
+// runJob runs job until it succeeds, it has been retried
+// too many times, or ctx is cancelled; then it schedules
+// the job's next run.
+func (s *scheduler) runJob(ctx context.Context, job string) {
+	maxAttempts := 1 + daemonCfg.MaxRetries
+	if daemonCfg.MaxRetries == 0 {
+		maxAttempts = 1 + defaultDaemonRetries
+	} else if daemonCfg.MaxRetries < 0 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := s.backoff(attempt)
+			log.Printf("[INFO][%s] Retrying %s in %s", s.key, job, wait)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		s.updateJob(job, func(js *jobStatus) {
+			now := time.Now()
+			js.Running = true
+			js.LastStart = &now
+		})
+
+		log.Printf("[INFO][%s] Starting %s", s.key, job)
+		switch job {
+		case jobGetLatest:
+			err = s.wc.GetLatest(ctx)
+		case jobGetAll:
+			err = s.wc.GetAll(ctx, s.schedule.Reprocess, s.schedule.Prune, s.schedule.Integrity)
+		}
+
+		if err == timeliner.ErrInterrupted {
+			s.updateJob(job, func(js *jobStatus) { js.Running = false })
+			return
+		}
+
+		s.updateJob(job, func(js *jobStatus) {
+			js.Running = false
+			now := time.Now()
+			if err == nil {
+				js.LastSuccess = &now
+				js.LastError = ""
+				js.Failures = 0
+			} else {
+				js.LastError = err.Error()
+				js.LastErrorTime = &now
+				js.Failures++
+			}
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			log.Printf("[INFO][%s] Finished %s", s.key, job)
+			break
+		}
+		log.Printf("[ERROR][%s] Running %s: %v", s.key, job, err)
+	}
+
+	// schedule the next run; since a get-all gets everything
+	// that a get-latest would, it pushes the get-latest back
+	now := time.Now()
+	s.updateJob(job, func(js *jobStatus) {
+		js.NextRun = now.Add(s.interval(job))
+	})
+	if job == jobGetAll && err == nil {
+		s.updateJob(jobGetLatest, func(js *jobStatus) {
+			js.NextRun = now.Add(s.interval(jobGetLatest))
+		})
+	}
+}
+
+// backoff returns how long to wait before the given retry
+// attempt. It grows exponentially with jitter, but is never
+// less than the interval allowed by the data source's rate
+// limit, nor more than the configured maximum.
+func (s *scheduler) backoff(attempt int) time.Duration {
+	maxBackoff := time.Duration(daemonCfg.MaxBackoff)
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	wait := baseBackoff << uint(attempt-1)
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+	// full jitter within the upper half of the window, so
+	// that retries of many accounts don't line up
+	wait = wait/2 + time.Duration(mathrand.Int63n(int64(wait/2)+1))
+	if floor := s.wc.RateLimit().Interval(); wait < floor {
+		wait = floor
+	}
+	return wait
+}
+
+func (s *scheduler) interval(job string) time.Duration {
+	if job == jobGetAll {
+		return time.Duration(s.schedule.GetAll)
+	}
+	return time.Duration(s.schedule.GetLatest)
+}
+
+func (s *scheduler) updateJob(job string, fn func(*jobStatus)) {
+	s.status.Lock()
+	js, ok := s.status.Accounts[s.key].Jobs[job]
+	if ok {
+		fn(js)
+	}
+	s.status.Unlock()
+	s.status.write()
+}
+
+// daemonStatus is the status of the daemon, which is
+// written to the status file and served over HTTP.
+type daemonStatus struct {
+	sync.Mutex `json:"-"`
+	Started    time.Time                 `json:"started"`
+	Accounts   map[string]*accountStatus `json:"accounts"`
+
+	file string
+}
+
+type accountStatus struct {
+	Jobs map[string]*jobStatus `json:"jobs"`
+}
+
+type jobStatus struct {
+	Interval      string     `json:"interval"`
+	Running       bool       `json:"running"`
+	LastStart     *time.Time `json:"last_start,omitempty"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Failures      int        `json:"consecutive_failures"`
+	NextRun       time.Time  `json:"next_run"`
+}
+
+// ServeHTTP serves the status as JSON.
+func (ds *daemonStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ds.Lock()
+	defer ds.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(ds)
+	if err != nil {
+		log.Printf("[ERROR] Encoding status: %v", err)
+	}
+}
+
+// write writes the status to the status file, if
+// configured. The file is replaced atomically so
+// that readers never see a partial status.
+func (ds *daemonStatus) write() {
+	if ds.file == "" {
+		return
+	}
+	ds.Lock()
+	defer ds.Unlock()
+	statusJSON, err := json.MarshalIndent(ds, "", "\t")
+	if err != nil {
+		log.Printf("[ERROR] Encoding status: %v", err)
+		return
+	}
+	tmpFile := ds.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, statusJSON, 0644)
+	if err != nil {
+		log.Printf("[ERROR] Writing status file: %v", err)
+		return
+	}
+	err = os.Rename(tmpFile, ds.file)
+	if err != nil {
+		log.Printf("[ERROR] Replacing status file: %v", err)
+	}
+}
+
+// loadDaemonStatus loads the status from the status file,
+// if it exists.
+func loadDaemonStatus(file string) (*daemonStatus, error) {
+	ds := new(daemonStatus)
+	if file == "" {
+		return ds, nil
+	}
+	statusJSON, err := ioutil.ReadFile(filepath.Clean(file))
+	if os.IsNotExist(err) {
+		return ds, nil
+	}
+	if err != nil {
+		return ds, err
+	}
+	err = json.Unmarshal(statusJSON, ds)
+	return ds, err
+}
+
+// scheduleConfig is the schedule of an account in daemon mode.
+type scheduleConfig struct {
+	GetLatest duration `toml:"get_latest"`
+	GetAll    duration `toml:"get_all"`
+	Prune     bool     `toml:"prune"`
+	Reprocess bool     `toml:"reprocess"`
+	Integrity bool     `toml:"integrity"`
+}
+
+// daemonConfig configures daemon mode.
+type daemonConfig struct {
+	StatusFile string   `toml:"status_file"`
+	StatusAddr string   `toml:"status_addr"`
+	MaxRetries int      `toml:"max_retries"` // 0 for default, < 0 for none
+	MaxBackoff duration `toml:"max_backoff"`
+}
+
+// duration is a time.Duration that can be decoded
+// from a string like "1h30m" in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(dur)
+	return nil
+}
+
+func (d duration) String() string { return time.Duration(d).String() }
+
+const (
+	jobGetLatest = "get-latest"
+	jobGetAll    = "get-all"
+)
+
+const (
+	defaultDaemonRetries = 5
+	baseBackoff          = 30 * time.Second
+	defaultMaxBackoff    = 30 * time.Minute
+)
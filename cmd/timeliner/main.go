@@ -6,22 +6,36 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/twitter"
 	"github.com/mholt/timeliner/oauth2client"
 	"golang.org/x/oauth2"
 
 	// plug in data sources
+	_ "github.com/mholt/timeliner/datasources/email"
 	_ "github.com/mholt/timeliner/datasources/facebook"
 	_ "github.com/mholt/timeliner/datasources/googlecalendar"
 	_ "github.com/mholt/timeliner/datasources/googlelocation"
 	_ "github.com/mholt/timeliner/datasources/googlephotos"
+	_ "github.com/mholt/timeliner/datasources/googletakeout"
+	_ "github.com/mholt/timeliner/datasources/gpstracks"
+	_ "github.com/mholt/timeliner/datasources/ics"
+	_ "github.com/mholt/timeliner/datasources/imap"
 	_ "github.com/mholt/timeliner/datasources/instagram"
-	"github.com/mholt/timeliner/datasources/twitter"
+	_ "github.com/mholt/timeliner/datasources/lastfm"
+	_ "github.com/mholt/timeliner/datasources/localfiles"
+	_ "github.com/mholt/timeliner/datasources/mastodon"
+	_ "github.com/mholt/timeliner/datasources/smsbackuprestore"
+	_ "github.com/mholt/timeliner/datasources/spotify"
+	_ "github.com/mholt/timeliner/datasources/telegram"
+	_ "github.com/mholt/timeliner/datasources/whatsapp"
+	_ "github.com/mholt/timeliner/datasources/youtube"
 )
 
 func init() {
@@ -32,10 +46,11 @@ func init() {
 
 	flag.BoolVar(&prune, "prune", prune, "When finishing, delete items not found on remote (download-all or import only)")
 	flag.BoolVar(&integrity, "integrity", integrity, "Perform integrity check on existing items and reprocess if needed (download-all or import only)")
-	flag.BoolVar(&reprocess, "reprocess", reprocess, "Reprocess every item that has not been modified locally (download-all or import only)")
+	flag.BoolVar(&reprocess, "reprocess", reprocess, "Reprocess every item that has not been modified locally, downloading data files again unless the data source reports they are unchanged (download-all or import only)")
+	flag.BoolVar(&keepRevisionFiles, "keep-revision-files", keepRevisionFiles, "Keep prior data files of items whose data files are replaced when reprocessing")
 
-	flag.BoolVar(&twitterRetweets, "twitter-retweets", twitterRetweets, "Twitter: include retweets")
-	flag.BoolVar(&twitterReplies, "twitter-replies", twitterReplies, "Twitter: include replies that are not just replies to self")
+	flag.BoolVar(&twitterRetweets, "twitter-retweets", twitterRetweets, "Deprecated: set retweets in the config of Twitter accounts instead")
+	flag.BoolVar(&twitterReplies, "twitter-replies", twitterReplies, "Deprecated: set replies in the config of Twitter accounts instead")
 }
 
 func main() {
@@ -44,6 +59,10 @@ func main() {
 	if maxRetries < 0 {
 		maxRetries = 0
 	}
+	if twitterRetweets || twitterReplies {
+		log.Println("[WARNING] The -twitter-retweets and -twitter-replies flags are deprecated; " +
+			"set retweets and replies in the config of Twitter accounts instead")
+	}
 
 	// split the CLI arguments into subcommand and arguments
 	args := flag.Args()
@@ -51,6 +70,24 @@ func main() {
 		log.Fatal("[FATAL] Missing subcommand and account arguments (specify one or more of 'data_source_id/user_id')")
 	}
 	subcmd := args[0]
+
+	// as special cases, handle subcommands that operate on
+	// items rather than accounts
+	switch subcmd {
+	case "item-history", "restore-revision":
+		runRevisionCommand(subcmd, args[1:])
+		return
+	case "options":
+		if len(args) != 2 {
+			log.Fatal("[FATAL] Expecting: options <data_source_id>")
+		}
+		err := printOptions(args[1])
+		if err != nil {
+			log.Fatalf("[FATAL] %v", err)
+		}
+		return
+	}
+
 	accountList := args[1:]
 	if subcmd == "import" {
 		// special case; import takes an extra argument before account list
@@ -69,6 +106,15 @@ func main() {
 		log.Fatalf("[FATAL] Loading configuration: %v", err)
 	}
 
+	// the daemon runs all scheduled accounts by default
+	if subcmd == "daemon" && len(accountList) == 0 {
+		for acct, cfg := range accountCfgs {
+			if cfg.Schedule != nil {
+				accountList = append(accountList, acct)
+			}
+		}
+	}
+
 	// parse the accounts out of the CLI
 	accounts, err := getAccounts(accountList)
 	if err != nil {
@@ -84,6 +130,7 @@ func main() {
 		log.Fatalf("[FATAL] Opening timeline: %v", err)
 	}
 	defer tl.Close()
+	tl.KeepRevisionDataFiles = keepRevisionFiles
 
 	// as a special case, handle AddAccount separately
 	if subcmd == "add-account" {
@@ -99,21 +146,21 @@ func main() {
 	// make a client for each account
 	var clients []timeliner.WrappedClient
 	for _, a := range accounts {
-		wc, err := tl.NewClient(a.dataSourceID, a.userID)
+		opts := accountCfgs[a.dataSourceID+"/"+a.userID].options
+		if a.dataSourceID == twitter.DataSourceID {
+			opts = applyTwitterFlags(opts)
+		}
+		wc, err := tl.NewClient(a.dataSourceID, a.userID, opts)
 		if err != nil {
 			log.Fatalf("[FATAL][%s/%s] Creating data source client: %v", a.dataSourceID, a.userID, err)
 		}
-
-		// configure the client
-		switch v := wc.Client.(type) {
-		case *twitter.Client:
-			v.Retweets = twitterRetweets
-			v.Replies = twitterReplies
-		}
-
 		clients = append(clients, wc)
 	}
 
+	// stop cleanly if interrupted
+	ctx, stop := trapSignals()
+	defer stop()
+
 	switch subcmd {
 	case "get-latest":
 		if reprocess || prune || integrity {
@@ -125,23 +172,9 @@ func main() {
 			wg.Add(1)
 			go func(wc timeliner.WrappedClient) {
 				defer wg.Done()
-				ctx, cancel := context.WithCancel(context.Background())
-				for retryNum := 0; retryNum < 1+maxRetries; retryNum++ {
-					if retryNum > 0 {
-						log.Println("[INFO] Retrying command")
-					}
-					err := wc.GetLatest(ctx)
-					if err != nil {
-						log.Printf("[ERROR][%s/%s] Getting latest: %v",
-							wc.DataSourceID(), wc.UserID(), err)
-						if retryAfter > 0 {
-							time.Sleep(retryAfter)
-						}
-						continue
-					}
-					break
-				}
-				defer cancel() // TODO: Make this useful, maybe?
+				withRetries(ctx, wc, "Getting latest", func() error {
+					return wc.GetLatest(ctx)
+				})
 			}(wc)
 		}
 		wg.Wait()
@@ -152,42 +185,146 @@ func main() {
 			wg.Add(1)
 			go func(wc timeliner.WrappedClient) {
 				defer wg.Done()
-				ctx, cancel := context.WithCancel(context.Background())
-				for retryNum := 0; retryNum < 1+maxRetries; retryNum++ {
-					if retryNum > 0 {
-						log.Println("[INFO] Retrying command")
-					}
-					err := wc.GetAll(ctx, reprocess, prune, integrity)
-					if err != nil {
-						log.Printf("[ERROR][%s/%s] Downloading all: %v",
-							wc.DataSourceID(), wc.UserID(), err)
-						if retryAfter > 0 {
-							time.Sleep(retryAfter)
-						}
-						continue
-					}
-					break
-				}
-				defer cancel() // TODO: Make this useful, maybe?
+				withRetries(ctx, wc, "Downloading all", func() error {
+					return wc.GetAll(ctx, reprocess, prune, integrity)
+				})
 			}(wc)
 		}
 		wg.Wait()
 
+	case "daemon":
+		err := runDaemon(ctx, clients)
+		if err != nil {
+			log.Fatalf("[FATAL] Running daemon: %v", err)
+		}
+		return
+
 	case "import":
 		file := args[1]
 		wc := clients[0]
 
-		ctx, cancel := context.WithCancel(context.Background())
 		err = wc.Import(ctx, file, reprocess, prune, integrity)
-		if err != nil {
+		if err != nil && err != timeliner.ErrInterrupted {
 			log.Printf("[ERROR][%s/%s] Importing: %v",
 				wc.DataSourceID(), wc.UserID(), err)
 		}
-		defer cancel() // TODO: Make this useful, maybe?
 
 	default:
 		log.Fatalf("[FATAL] Unrecognized subcommand: %s", subcmd)
 	}
+
+	if ctx.Err() != nil {
+		log.Println("[INFO] Interrupted; progress was saved and can be resumed")
+		stop()
+		tl.Close()
+		os.Exit(exitCodeInterrupted)
+	}
+}
+
+// withRetries runs op for wc, retrying it on failure according
+// to the -max-retries and -retry-after flags, until it succeeds
+// or ctx is cancelled. The desc describes op in error messages.
+func withRetries(ctx context.Context, wc timeliner.WrappedClient, desc string, op func() error) {
+	for retryNum := 0; retryNum < 1+maxRetries; retryNum++ {
+		if retryNum > 0 {
+			log.Println("[INFO] Retrying command")
+		}
+		err := op()
+		if err == nil || err == timeliner.ErrInterrupted {
+			return
+		}
+		log.Printf("[ERROR][%s/%s] %s: %v",
+			wc.DataSourceID(), wc.UserID(), desc, err)
+		if retryAfter > 0 {
+			timer := time.NewTimer(retryAfter)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// runRevisionCommand runs the item-history or restore-revision
+// subcommand with the given arguments.
+func runRevisionCommand(subcmd string, args []string) {
+	if len(args) != 1 {
+		if subcmd == "item-history" {
+			log.Fatal("[FATAL] Expecting: item-history <item_id>")
+		}
+		log.Fatal("[FATAL] Expecting: restore-revision <revision_id>")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("[FATAL] Invalid ID '%s': %v", args[0], err)
+	}
+
+	// viewing history only needs to read
+	open := timeliner.Open
+	if subcmd == "item-history" {
+		open = timeliner.OpenReadOnly
+	}
+	tl, err := open(repoDir)
+	if err != nil {
+		log.Fatalf("[FATAL] Opening timeline: %v", err)
+	}
+	defer tl.Close()
+
+	switch subcmd {
+	case "item-history":
+		revs, err := tl.ItemRevisions(id)
+		if err != nil {
+			log.Fatalf("[FATAL] Loading item history: %v", err)
+		}
+		if len(revs) == 0 {
+			fmt.Printf("No revisions of item %d\n", id)
+			return
+		}
+		for _, rev := range revs {
+			fmt.Printf("revision %d  replaced %s  changed: %s\n",
+				rev.ID, rev.Revised.Format(time.RFC3339), strings.Join(rev.Changed, ", "))
+			if rev.Timestamp != nil {
+				fmt.Printf("\ttimestamp: %s\n", rev.Timestamp.Format(time.RFC3339))
+			}
+			if rev.DataText != nil {
+				fmt.Printf("\tdata_text: %q\n", *rev.DataText)
+			}
+			if rev.DataFile != nil {
+				fmt.Printf("\tdata_file: %s\n", *rev.DataFile)
+			}
+			if rev.Latitude != nil && rev.Longitude != nil {
+				fmt.Printf("\tlocation: %f, %f\n", *rev.Latitude, *rev.Longitude)
+			}
+		}
+
+	case "restore-revision":
+		err := tl.RestoreItemRevision(id)
+		if err != nil {
+			log.Fatalf("[FATAL] Restoring revision: %v", err)
+		}
+	}
+}
+
+// applyTwitterFlags returns the options of a Twitter account with
+// those set by the deprecated flags, which used to configure all
+// Twitter accounts before they could be configured in the config
+// file. Options set in the config file are kept.
+func applyTwitterFlags(opts interface{}) interface{} {
+	if !twitterRetweets && !twitterReplies {
+		return opts
+	}
+	twOpts, _ := opts.(*twitter.Options)
+	if twOpts == nil {
+		twOpts = new(twitter.Options)
+	}
+	twOpts.Retweets = twOpts.Retweets || twitterRetweets
+	twOpts.Replies = twOpts.Replies || twitterReplies
+	return twOpts
 }
 
 func loadConfig() error {
@@ -202,6 +339,31 @@ func loadConfig() error {
 	if err != nil {
 		return fmt.Errorf("decoding config file: %v", err)
 	}
+
+	// each account's section has its schedule, if any,
+	// along with the options for its data source's client
+	accountCfgs = make(map[string]accountConfig)
+	for acct, prim := range cmdConfig.Accounts {
+		var acctCfg accountConfig
+		err := md.PrimitiveDecode(prim, &acctCfg)
+		if err != nil {
+			return fmt.Errorf("decoding account %s: %v", acct, err)
+		}
+		dsID := strings.SplitN(acct, "/", 2)[0]
+		ds, ok := timeliner.LookupDataSource(dsID)
+		if !ok {
+			return fmt.Errorf("account %s: data source not registered: %s", acct, dsID)
+		}
+		if ds.ClientOptions != nil {
+			acctCfg.options = ds.ClientOptions()
+			err := md.PrimitiveDecode(prim, acctCfg.options)
+			if err != nil {
+				return fmt.Errorf("decoding options for account %s: %v", acct, err)
+			}
+		}
+		accountCfgs[acct] = acctCfg
+	}
+
 	if len(md.Undecoded()) > 0 {
 		return fmt.Errorf("unrecognized key(s) in config file: %+v", md.Undecoded())
 	}
@@ -227,6 +389,8 @@ func loadConfig() error {
 		}
 	}
 
+	daemonCfg = cmdConfig.Daemon
+
 	// TODO: Should this be passed into timeliner.Open() instead?
 	timeliner.OAuth2AppSource = func(providerID string, scopes []string) (oauth2client.App, error) {
 		cfg, ok := oauth2Configs[providerID]
@@ -261,7 +425,19 @@ type accountInfo struct {
 }
 
 type commandConfig struct {
-	OAuth2 oauth2Config `toml:"oauth2"`
+	OAuth2   oauth2Config              `toml:"oauth2"`
+	Accounts map[string]toml.Primitive `toml:"accounts"`
+	Daemon   daemonConfig              `toml:"daemon"`
+}
+
+// accountConfig is the configuration for a single
+// account, keyed by "data_source_id/user_id".
+type accountConfig struct {
+	Schedule *scheduleConfig `toml:"schedule"`
+
+	// the options for the data source's client, which
+	// are decoded from the same section of the config
+	options interface{}
 }
 
 type oauth2Config struct {
@@ -286,6 +462,12 @@ var (
 	prune     bool
 	reprocess bool
 
+	keepRevisionFiles bool
+
+	// deprecated; set in the config of Twitter accounts instead
 	twitterRetweets bool
 	twitterReplies  bool
+
+	accountCfgs map[string]accountConfig
+	daemonCfg   daemonConfig
 )
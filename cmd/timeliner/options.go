@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mholt/timeliner"
+)
+
+// printOptions prints the options that can be configured
+// for accounts of the data source with the given ID.
+func printOptions(dataSourceID string) error {
+	ds, ok := timeliner.LookupDataSource(dataSourceID)
+	if !ok {
+		return fmt.Errorf("data source not registered: %s", dataSourceID)
+	}
+	if ds.ClientOptions == nil {
+		fmt.Printf("%s has no options\n", ds.Name)
+		return nil
+	}
+
+	defaults := reflect.ValueOf(ds.ClientOptions()).Elem()
+	typ := defaults.Type()
+
+	fmt.Printf("Options for %s, configured in [accounts.\"%s/<user_id>\"]:\n\n", ds.Name, ds.ID)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" || name == "-" {
+			continue
+		}
+		fmt.Printf("%s (%s, default: %#v)\n", name, field.Type, defaults.Field(i).Interface())
+		if desc := field.Tag.Get("desc"); desc != "" {
+			fmt.Printf("\t%s\n", desc)
+		}
+	}
+
+	return nil
+}
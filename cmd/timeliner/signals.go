@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// trapSignals returns a context that is cancelled when the
+// process receives SIGINT or SIGTERM, so that operations can
+// stop cleanly. If a second signal is received before the
+// process exits, it exits immediately. The returned function
+// stops trapping signals and releases the context.
+func trapSignals() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigchan:
+			log.Printf("[INFO] Received %s; finishing up (signal again to force quit)", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		if sig, ok := <-sigchan; ok {
+			log.Printf("[INFO] Received %s again; exiting immediately", sig)
+			os.Exit(exitCodeForceQuit)
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigchan)
+		cancel()
+	}
+}
+
+const (
+	// exitCodeInterrupted is the exit code when an
+	// operation was stopped cleanly by a signal.
+	exitCodeInterrupted = 130
+
+	// exitCodeForceQuit is the exit code when the
+	// process was forced to quit by a second signal.
+	exitCodeForceQuit = 131
+)
@@ -11,12 +11,28 @@ import (
 )
 
 func init() {
+	// the gob encoding of metadata starts with the description of
+	// its type, which is followed by the value; encoding a second
+	// value with the same encoder yields only the value, which
+	// tells us where the type description ends
 	tdBuf := new(bytes.Buffer)
-	err := gob.NewEncoder(tdBuf).Encode(Metadata{})
+	enc := gob.NewEncoder(tdBuf)
+	err := enc.Encode(Metadata{})
 	if err != nil {
 		log.Fatalf("[FATAL] Unable to gob-encode metadata struct: %v", err)
 	}
-	metadataGobPrefix = tdBuf.Bytes()
+	firstLen := tdBuf.Len()
+	err = enc.Encode(Metadata{})
+	if err != nil {
+		log.Fatalf("[FATAL] Unable to gob-encode metadata struct: %v", err)
+	}
+	typeLen := firstLen - (tdBuf.Len() - firstLen)
+	metadataGobPrefix = tdBuf.Bytes()[:typeLen:typeLen]
+
+	// the value of empty metadata is only its length,
+	// its type ID, and the end of the struct
+	emptyValue := tdBuf.Bytes()[firstLen:]
+	metadataTypeID = emptyValue[1 : len(emptyValue)-1]
 }
 
 // RegisterDataSource registers ds as a data source.
@@ -102,6 +118,24 @@ type DataSource struct {
 	// returns a type which can facilitate
 	// transactions with the service.
 	NewClient NewClientFn
+
+	// If the client can be configured per
+	// account, ClientOptions returns a pointer
+	// to a new options struct filled out with
+	// default values. Its fields should have
+	// `toml` tags with their names and `desc`
+	// tags describing them. If it has a method
+	// Validate() error, that will be called
+	// before the options are used. The options
+	// are passed to NewClient in Account.Options.
+	ClientOptions func() interface{}
+}
+
+// LookupDataSource returns the data source registered
+// with the given ID, and whether it was found.
+func LookupDataSource(id string) (DataSource, bool) {
+	ds, ok := dataSources[id]
+	return ds, ok
 }
 
 // authFunc gets the authentication function for this
@@ -191,6 +225,12 @@ type Client interface {
 	// timeliner.Checkpoint to set a checkpoint. Checkpoints are not
 	// required, but if the implementation sets checkpoints, it
 	// should be able to resume from one, too.
+	//
+	// opt.SyncState consists of the state last saved by calling
+	// timeliner.SaveSyncState for this account, if any. Unlike
+	// checkpoints, sync state is kept after a successful run, so
+	// it can be used to sync incrementally, for example by caching
+	// what was seen on the last run.
 	ListItems(ctx context.Context, itemChan chan<- *ItemGraph, opt Options) error
 }
 
@@ -0,0 +1,226 @@
+// Package email implements a Timeliner data source for
+// importing email messages from mbox files and Maildir
+// directories. Its message parser is exported for use
+// by other data sources that deal with email.
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Email"
+	DataSourceID   = "email"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the messages in the mbox file or Maildir
+// directory at opt.Filename, which must be non-empty. In a
+// Maildir, messages are read from all "cur" and "new" folders,
+// so Maildir++ subfolders are included. Timeframes are not
+// honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	info, err := os.Stat(opt.Filename)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", opt.Filename, err)
+	}
+
+	if info.IsDir() {
+		return listMaildir(ctx, opt.Filename, itemChan)
+	}
+	return listMbox(ctx, opt.Filename, itemChan)
+}
+
+// listMaildir lists the messages in the Maildir at root.
+func listMaildir(ctx context.Context, root string, itemChan chan<- *timeliner.ItemGraph) error {
+	err := filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[ERROR][%s] Accessing %s: %v", DataSourceID, fpath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errStopWalk
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if dir := filepath.Base(filepath.Dir(fpath)); dir != "cur" && dir != "new" {
+			return nil
+		}
+
+		f, err := os.Open(fpath)
+		if err != nil {
+			log.Printf("[ERROR][%s] Opening %s: %v", DataSourceID, fpath, err)
+			return nil
+		}
+		defer f.Close()
+
+		// Maildir files are named after the time they were
+		// delivered, which is also their modification time
+		msg, err := ParseMessage(bufio.NewReader(f), info.ModTime())
+		if err != nil {
+			log.Printf("[ERROR][%s] Parsing %s: %v", DataSourceID, fpath, err)
+			return nil
+		}
+		itemChan <- msg.ItemGraph()
+
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// errStopWalk stops walking the folder.
+var errStopWalk = fmt.Errorf("stop walk")
+
+// listMbox lists the messages in the mbox file at fpath.
+func listMbox(ctx context.Context, fpath string, itemChan chan<- *timeliner.ItemGraph) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return fmt.Errorf("opening mbox file: %v", err)
+	}
+	defer f.Close()
+
+	mr := &mboxReader{r: bufio.NewReader(f)}
+	for i := 1; ; i++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		raw, received, err := mr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading mbox file: %v", err)
+		}
+		msg, err := ParseMessage(bytes.NewReader(raw), received)
+		if err != nil {
+			log.Printf("[ERROR][%s] Parsing message %d in %s: %v", DataSourceID, i, fpath, err)
+			continue
+		}
+		itemChan <- msg.ItemGraph()
+	}
+
+	return nil
+}
+
+// mboxReader splits an mbox file into messages. Each message
+// begins with a "From " line, and lines in the message that
+// would look like one are quoted with ">", which is undone.
+type mboxReader struct {
+	r        *bufio.Reader
+	fromLine string // separator line of the next message
+}
+
+// next returns the next message and the time it was received
+// according to its "From " line (which may be the zero value).
+func (mr *mboxReader) next() ([]byte, time.Time, error) {
+	var buf bytes.Buffer
+	var prevBlank = true
+	for {
+		line, err := mr.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, time.Time{}, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		if strings.HasPrefix(line, "From ") && prevBlank {
+			if mr.fromLine != "" {
+				// start of the next message
+				from := mr.fromLine
+				mr.fromLine = line
+				return buf.Bytes(), fromLineTime(from), nil
+			}
+			buf.Reset() // not part of any message
+			mr.fromLine = line
+			continue
+		}
+		prevBlank = strings.TrimRight(line, "\r\n") == ""
+
+		if mboxQuotedFrom(line) {
+			line = line[1:]
+		}
+		buf.WriteString(line)
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if mr.fromLine == "" {
+		return nil, time.Time{}, io.EOF
+	}
+	from := mr.fromLine
+	mr.fromLine = ""
+	return buf.Bytes(), fromLineTime(from), nil
+}
+
+// mboxQuotedFrom returns true if line is a "From " line
+// that was quoted with one or more ">".
+func mboxQuotedFrom(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") &&
+		strings.HasPrefix(line, ">")
+}
+
+// fromLineTime returns the time in an mbox "From " line,
+// such as "From sender@example.com Thu Jan  2 15:04:05 2020",
+// or the zero value if there isn't one.
+func fromLineTime(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	date := strings.Join(fields[2:], " ")
+	for _, layout := range []string{
+		"Mon Jan _2 15:04:05 2006",
+		"Mon Jan _2 15:04:05 -0700 2006",
+		"Mon Jan _2 15:04:05 MST 2006",
+	} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
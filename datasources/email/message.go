@@ -0,0 +1,330 @@
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset" // decode all charsets
+	"github.com/emersion/go-message/mail"
+	"github.com/mholt/timeliner"
+)
+
+// Message is an email message.
+type Message struct {
+	id          string
+	timestamp   time.Time
+	from        *mail.Address
+	to, cc, bcc []*mail.Address
+	subject     string
+	body        string
+	inReplyTo   string
+	attachments []*Attachment
+}
+
+// ParseMessage reads the email message in RFC 5322 format from r.
+// If the message has no valid Date header, received (the time the
+// message was received, if known) is used as its timestamp. If it
+// has no Message-ID, an ID is derived from its contents.
+func ParseMessage(r io.Reader, received time.Time) (*Message, error) {
+	h := sha256.New()
+	r = io.TeeReader(r, h)
+
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("reading message header: %v", err)
+	}
+
+	m := new(Message)
+	m.id, _ = mr.Header.MessageID()
+	m.timestamp, err = mr.Header.Date()
+	if err != nil || m.timestamp.IsZero() {
+		m.timestamp = received
+	}
+	m.subject, _ = mr.Header.Subject()
+	if from, err := mr.Header.AddressList("From"); err == nil && len(from) > 0 {
+		m.from = from[0]
+	}
+	m.to, _ = mr.Header.AddressList("To")
+	m.cc, _ = mr.Header.AddressList("Cc")
+	m.bcc, _ = mr.Header.AddressList("Bcc")
+
+	// the message being replied to is the one in In-Reply-To,
+	// or else the last one in References, which lists the
+	// thread's messages from oldest to newest
+	if ids, _ := mr.Header.MsgIDList("In-Reply-To"); len(ids) > 0 {
+		m.inReplyTo = ids[0]
+	} else if ids, _ := mr.Header.MsgIDList("References"); len(ids) > 0 {
+		m.inReplyTo = ids[len(ids)-1]
+	}
+
+	var plain, htm string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return nil, fmt.Errorf("reading message part: %v", err)
+		}
+
+		contentType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+
+		// inline parts that aren't text, like embedded
+		// images, are attachments as far as we're concerned
+		var filename string
+		switch hdr := p.Header.(type) {
+		case *mail.InlineHeader:
+			if strings.HasPrefix(contentType, "text/") {
+				text, err := ioutil.ReadAll(p.Body)
+				if err != nil {
+					return nil, fmt.Errorf("reading message text: %v", err)
+				}
+				switch {
+				case contentType == "text/plain" && plain == "":
+					plain = string(text)
+				case contentType == "text/html" && htm == "":
+					htm = string(text)
+				}
+				continue
+			}
+			filename = params["name"]
+		case *mail.AttachmentHeader:
+			filename, _ = hdr.Filename()
+		}
+
+		data, err := ioutil.ReadAll(p.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading attachment: %v", err)
+		}
+		m.attachments = append(m.attachments, &Attachment{
+			msg:      m,
+			index:    len(m.attachments),
+			filename: path.Base(filename),
+			mimeType: contentType,
+			data:     data,
+		})
+	}
+
+	m.body = strings.TrimSpace(plain)
+	if m.body == "" && htm != "" {
+		m.body = htmlToText(htm)
+	}
+
+	if m.id == "" {
+		// make sure the whole message was hashed
+		_, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return nil, fmt.Errorf("reading message: %v", err)
+		}
+		m.id = "sha256_" + hex.EncodeToString(h.Sum(nil))
+	}
+
+	return m, nil
+}
+
+// ItemGraph returns the item graph for m, which relates it
+// to its attachments, its recipients, and the message it is
+// in reply to, even if that message is stored after it.
+func (m *Message) ItemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(m)
+	for _, a := range m.attachments {
+		ig.Add(a, timeliner.RelAttached)
+	}
+	for _, recipients := range []struct {
+		addrs []*mail.Address
+		rel   timeliner.Relation
+	}{
+		{m.to, timeliner.RelSentTo},
+		{m.cc, timeliner.RelCC},
+		{m.bcc, relBCC},
+	} {
+		for _, addr := range recipients.addrs {
+			ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+				UserID:   addressID(addr),
+				Name:     addr.Name,
+				Relation: recipients.rel,
+			})
+		}
+	}
+	if m.inReplyTo != "" {
+		ig.Relations = append(ig.Relations, timeliner.RawRelation{
+			FromItemID: m.id,
+			ToItemID:   m.inReplyTo,
+			Relation:   timeliner.RelReplyTo,
+		})
+	}
+	return ig
+}
+
+// relBCC relates a message to its blind-copied recipients:
+// "<from> was blind-copied to <to>"
+var relBCC = timeliner.Relation{Label: "bcc", Bidirectional: false}
+
+// ID returns the message's Message-ID, without angle brackets.
+func (m *Message) ID() string {
+	return m.id
+}
+
+func (m *Message) Timestamp() time.Time {
+	return m.timestamp
+}
+
+func (m *Message) Class() timeliner.ItemClass {
+	return timeliner.ClassEmail
+}
+
+func (m *Message) Owner() (*string, *string) {
+	if m.from == nil {
+		return nil, nil
+	}
+	id := addressID(m.from)
+	return &id, &m.from.Name
+}
+
+// DataText returns the subject and body of the message.
+func (m *Message) DataText() (*string, error) {
+	text := strings.TrimSpace(m.subject + "\n\n" + m.body)
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (m *Message) DataFileName() *string {
+	return nil
+}
+
+func (m *Message) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *Message) DataFileHash() []byte {
+	return nil
+}
+
+func (m *Message) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *Message) Metadata() (*timeliner.Metadata, error) {
+	if m.inReplyTo == "" {
+		return nil, nil
+	}
+	return &timeliner.Metadata{ParentID: m.inReplyTo}, nil
+}
+
+func (m *Message) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// Attachment is a file attached to an email message.
+type Attachment struct {
+	msg      *Message
+	index    int
+	filename string
+	mimeType string
+	data     []byte
+}
+
+// ID returns the ID of the message and the attachment's position
+// in it, since attachments have no IDs of their own.
+func (a *Attachment) ID() string {
+	return fmt.Sprintf("%s_%d", a.msg.id, a.index)
+}
+
+func (a *Attachment) Timestamp() time.Time {
+	return a.msg.timestamp
+}
+
+func (a *Attachment) Class() timeliner.ItemClass {
+	switch {
+	case strings.HasPrefix(a.mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(a.mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(a.mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (a *Attachment) Owner() (*string, *string) {
+	return a.msg.Owner()
+}
+
+func (a *Attachment) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (a *Attachment) DataFileName() *string {
+	if a.filename == "" || a.filename == "." || a.filename == "/" {
+		return nil
+	}
+	return &a.filename
+}
+
+func (a *Attachment) DataFileReader() (io.ReadCloser, error) {
+	return timeliner.FakeCloser(bytes.NewReader(a.data)), nil
+}
+
+func (a *Attachment) DataFileHash() []byte {
+	return nil
+}
+
+func (a *Attachment) DataFileMIMEType() *string {
+	return &a.mimeType
+}
+
+func (a *Attachment) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (a *Attachment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// addressID returns the user ID of the person at addr, which
+// is their email address; addresses are case-insensitive in
+// practice, so it is lower-cased.
+func addressID(addr *mail.Address) string {
+	return strings.ToLower(addr.Address)
+}
+
+// htmlToText makes a crude plain-text rendering of
+// an HTML message body for search and display.
+func htmlToText(s string) string {
+	s = htmlInvisible.ReplaceAllString(s, "")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	var out []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+var (
+	htmlInvisible = regexp.MustCompile(`(?is)<(head|script|style)\b.*?</(head|script|style)>`)
+	htmlBreaks    = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h\d)>`)
+	htmlTags      = regexp.MustCompile(`(?s)<[^>]*>`)
+)
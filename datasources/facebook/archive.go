@@ -0,0 +1,316 @@
+package facebook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/metaarchive"
+)
+
+// getFromArchive lists the items in the "Download Your Information"
+// archive at filename, which must be in JSON format. It may be the
+// .zip file of the archive or a folder it was extracted into. Posts
+// (including check-ins, which are posts with a place), photos and
+// videos, albums, comments, Messenger conversations, and life events
+// are listed. Since the archive has no IDs, the owner of the archive
+// is the account owner, and other people are identified by their
+// names. Timeframes are not honored.
+func (c *Client) getFromArchive(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, filename string) error {
+	arch, err := openArchive(filename)
+	if err != nil {
+		return err
+	}
+	defer timeliner.AfterItems(ctx, func() { arch.Close() })
+
+	arch.ownerName, err = arch.loadOwnerName()
+	if err != nil {
+		log.Printf("[ERROR][%s] Loading profile information: %v", DataSourceID, err)
+	}
+
+	listers := []struct {
+		what string
+		fn   func(context.Context, chan<- *timeliner.ItemGraph) error
+	}{
+		{"posts", arch.listPosts},
+		{"albums", arch.listAlbums},
+		{"photos and videos", arch.listMedia},
+		{"comments", arch.listComments},
+		{"life events", arch.listLifeEvents},
+		{"Messenger conversations", arch.listThreads},
+	}
+	for _, l := range listers {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := l.fn(ctx, itemChan)
+		if err != nil {
+			log.Printf("[ERROR][%s] Listing %s: %v", DataSourceID, l.what, err)
+		}
+	}
+
+	return nil
+}
+
+// listPosts lists the owner's posts and the media attached to them.
+func (arch *archive) listPosts(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Files(postsFileRegex) {
+		var posts []archivePost
+		err := arch.ReadList(name, &posts, "status_updates")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range posts {
+			if ctx.Err() != nil {
+				return nil
+			}
+			p := &posts[i]
+			p.arch = arch
+			if ig := p.itemGraph(); ig != nil {
+				itemChan <- ig
+			}
+		}
+	}
+	return nil
+}
+
+// listAlbums lists the photos in the owner's albums,
+// each album as a collection.
+func (arch *archive) listAlbums(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Names {
+		if path.Base(path.Dir(name)) != "album" || path.Ext(name) != ".json" {
+			continue
+		}
+
+		var album archiveAlbum
+		err := arch.ReadJSON(name, &album)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if len(album.Photos) == 0 {
+			continue
+		}
+
+		// albums do not have IDs, but the folder
+		// their photos are in is named after one
+		coll := timeliner.Collection{
+			OriginalID: "album_" + path.Base(path.Dir(album.Photos[0].URI)),
+		}
+		if album.Name != "" {
+			coll.Name = &album.Name
+		}
+		if album.Description != "" {
+			coll.Description = &album.Description
+		}
+		for i := range album.Photos {
+			m := &album.Photos[i]
+			m.arch = arch
+			if !arch.Has(m.URI) {
+				log.Printf("[ERROR][%s] Album photo not in archive: %s", DataSourceID, m.URI)
+				continue
+			}
+			coll.Items = append(coll.Items, timeliner.CollectionItem{
+				Item:     m,
+				Position: i,
+			})
+		}
+
+		ig := timeliner.NewItemGraph(nil)
+		ig.Collections = append(ig.Collections, coll)
+		itemChan <- ig
+	}
+	return nil
+}
+
+// listMedia lists the owner's videos and the photos
+// that are not in any album or post.
+func (arch *archive) listMedia(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Files(mediaFileRegex) {
+		var media []archiveMedia
+		err := arch.ReadList(name, &media, "videos", "other_photos")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range media {
+			if ctx.Err() != nil {
+				return nil
+			}
+			m := &media[i]
+			m.arch = arch
+			if !arch.Has(m.URI) {
+				log.Printf("[ERROR][%s] Media not in archive: %s", DataSourceID, m.URI)
+				continue
+			}
+			itemChan <- timeliner.NewItemGraph(m)
+		}
+	}
+	return nil
+}
+
+// listComments lists the comments the owner made.
+func (arch *archive) listComments(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Files(commentsFileRegex) {
+		var comments []archiveComment
+		err := arch.ReadList(name, &comments, "comments")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range comments {
+			if ctx.Err() != nil {
+				return nil
+			}
+			cm := &comments[i]
+			if cm.text() == "" {
+				continue
+			}
+			cm.arch = arch
+			itemChan <- timeliner.NewItemGraph(cm)
+		}
+	}
+	return nil
+}
+
+// listLifeEvents lists the life events in the owner's profile
+// update history, which are not always posted to their timeline.
+func (arch *archive) listLifeEvents(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Files(profileUpdatesFileRegex) {
+		var updates []archivePost
+		err := arch.ReadList(name, &updates, "profile_updates")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range updates {
+			if ctx.Err() != nil {
+				return nil
+			}
+			for _, le := range updates[i].lifeEvents() {
+				itemChan <- timeliner.NewItemGraph(le)
+			}
+		}
+	}
+	return nil
+}
+
+// listThreads lists the messages in the owner's Messenger
+// conversations, each conversation as a collection.
+func (arch *archive) listThreads(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, names := range arch.ThreadFiles() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var th archiveThread
+		err := arch.ReadThread(names, &th)
+		if err != nil {
+			log.Printf("[ERROR][%s] Reading conversation: %v", DataSourceID, err)
+			continue
+		}
+		if len(th.Messages) == 0 {
+			continue
+		}
+
+		coll := timeliner.Collection{OriginalID: "thread_" + th.ThreadPath}
+		if th.Title != "" {
+			coll.Name = &th.Title
+		}
+
+		seen := make(map[string]int)
+		for i := range th.Messages {
+			if ctx.Err() != nil {
+				return nil
+			}
+			m := &th.Messages[i]
+			m.arch = arch
+			m.thread = &th
+			m.setID(seen)
+
+			ig := m.itemGraph()
+			if ig == nil {
+				continue
+			}
+			cl := coll
+			cl.Items = []timeliner.CollectionItem{{Item: m, Position: i}}
+			ig.Collections = append(ig.Collections, cl)
+			itemChan <- ig
+		}
+	}
+
+	return nil
+}
+
+// archive is a "Download Your Information" archive.
+type archive struct {
+	*metaarchive.Archive
+	ownerName string
+}
+
+// openArchive opens the archive at filename.
+func openArchive(filename string) (*archive, error) {
+	ma, err := metaarchive.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	arch := &archive{Archive: ma}
+
+	// media URIs are relative to the top of the archive, which
+	// may be in a folder if the archive was re-zipped; the top
+	// has the folder of the owner's profile information
+	for _, name := range arch.Names {
+		if i := strings.Index(name, "profile_information/"); i >= 0 && (i == 0 || name[i-1] == '/') {
+			arch.Root = name[:i]
+			break
+		}
+	}
+
+	return arch, nil
+}
+
+// owner returns the owner of the archive as the owner of an
+// item, which is the account owner, named if the name is known.
+func (arch *archive) owner() (*string, *string) {
+	if arch.ownerName == "" {
+		return nil, nil
+	}
+	return nil, &arch.ownerName
+}
+
+// loadOwnerName returns the full name of the owner of the archive
+// from their profile information, which is how the owner is named
+// in conversations.
+func (arch *archive) loadOwnerName() (string, error) {
+	for _, name := range arch.Files(profileFileRegex) {
+		var info struct {
+			Profile   *archiveProfile `json:"profile"`
+			ProfileV2 *archiveProfile `json:"profile_v2"`
+		}
+		err := arch.ReadJSON(name, &info)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", name, err)
+		}
+		if info.ProfileV2 != nil {
+			return info.ProfileV2.Name.FullName, nil
+		}
+		if info.Profile != nil {
+			return info.Profile.Name.FullName, nil
+		}
+	}
+	return "", nil
+}
+
+type archiveProfile struct {
+	Name struct {
+		FullName string `json:"full_name"`
+	} `json:"name"`
+}
+
+var (
+	postsFileRegex          = regexp.MustCompile(`^your_posts.*\.json$`)
+	mediaFileRegex          = regexp.MustCompile(`^(your_videos|your_uncategorized_photos)\.json$`)
+	commentsFileRegex       = regexp.MustCompile(`^(your_)?comments(_[0-9]+)?\.json$`)
+	profileUpdatesFileRegex = regexp.MustCompile(`^profile_update_history\.json$`)
+	profileFileRegex        = regexp.MustCompile(`^profile_information\.json$`)
+)
@@ -0,0 +1,734 @@
+package facebook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/metaarchive"
+)
+
+// archivePost is a post in an archive; also used
+// for the entries of the profile update history.
+type archivePost struct {
+	Time        int64               `json:"timestamp"`
+	Title       string              `json:"title"`
+	Data        []archivePostData   `json:"data"`
+	Attachments []archiveAttachment `json:"attachments"`
+
+	arch *archive
+}
+
+type archivePostData struct {
+	Post string `json:"post"`
+}
+
+type archiveAttachment struct {
+	Data []archiveAttachmentData `json:"data"`
+}
+
+type archiveAttachmentData struct {
+	Media           *archiveMedia     `json:"media"`
+	Place           *archivePlace     `json:"place"`
+	LifeEvent       *archiveLifeEvent `json:"life_event"`
+	ExternalContext *struct {
+		URL  string `json:"url"`
+		Name string `json:"name"`
+	} `json:"external_context"`
+}
+
+// itemGraph returns the item graph for the post, which relates it
+// to its photos, videos, and life events. A post that is nothing
+// but one of those is not an item of its own; its attachment is.
+func (p *archivePost) itemGraph() *timeliner.ItemGraph {
+	var attached []timeliner.Item
+	for _, m := range p.media() {
+		if !p.arch.Has(m.URI) {
+			log.Printf("[ERROR][%s] Post media not in archive: %s", DataSourceID, m.URI)
+			continue
+		}
+		attached = append(attached, m)
+	}
+	for _, le := range p.lifeEvents() {
+		attached = append(attached, le)
+	}
+
+	if p.text() == "" && p.link() == "" && p.place() == nil {
+		switch len(attached) {
+		case 0:
+			return nil
+		case 1:
+			return timeliner.NewItemGraph(attached[0])
+		}
+	}
+
+	ig := timeliner.NewItemGraph(p)
+	for _, it := range attached {
+		ig.Add(it, timeliner.RelAttached)
+	}
+	return ig
+}
+
+func (p *archivePost) text() string {
+	var texts []string
+	for _, d := range p.Data {
+		if d.Post != "" {
+			texts = append(texts, d.Post)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (p *archivePost) link() string {
+	for _, a := range p.Attachments {
+		for _, d := range a.Data {
+			if d.ExternalContext != nil && d.ExternalContext.URL != "" {
+				return d.ExternalContext.URL
+			}
+		}
+	}
+	return ""
+}
+
+func (p *archivePost) place() *archivePlace {
+	for _, a := range p.Attachments {
+		for _, d := range a.Data {
+			if d.Place != nil {
+				return d.Place
+			}
+		}
+	}
+	return nil
+}
+
+func (p *archivePost) media() []*archiveMedia {
+	var media []*archiveMedia
+	for _, a := range p.Attachments {
+		for _, d := range a.Data {
+			if d.Media != nil && d.Media.URI != "" {
+				d.Media.arch = p.arch
+				media = append(media, d.Media)
+			}
+		}
+	}
+	return media
+}
+
+func (p *archivePost) lifeEvents() []*archiveLifeEvent {
+	var events []*archiveLifeEvent
+	for _, a := range p.Attachments {
+		for _, d := range a.Data {
+			if d.LifeEvent != nil && d.LifeEvent.Title != "" {
+				d.LifeEvent.postTimestamp = p.Time
+				events = append(events, d.LifeEvent)
+			}
+		}
+	}
+	return events
+}
+
+// ID returns an ID made from the time and contents of
+// the post, since posts in archives have no IDs.
+func (p *archivePost) ID() string {
+	var uris []string
+	for _, m := range p.media() {
+		uris = append(uris, m.URI)
+	}
+	return metaarchive.ContentID("post_", strconv.FormatInt(p.Time, 10),
+		p.text(), p.link(), strings.Join(uris, "\n"))
+}
+
+func (p *archivePost) Timestamp() time.Time {
+	return time.Unix(p.Time, 0)
+}
+
+func (p *archivePost) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (p *archivePost) Owner() (*string, *string) {
+	return p.arch.owner()
+}
+
+func (p *archivePost) DataText() (*string, error) {
+	text := p.text()
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (p *archivePost) DataFileName() *string {
+	return nil
+}
+
+func (p *archivePost) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (p *archivePost) DataFileHash() []byte {
+	return nil
+}
+
+func (p *archivePost) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata returns the link the post shares, its title (like
+// "Alice shared a link."), and for a check-in, the place.
+func (p *archivePost) Metadata() (*timeliner.Metadata, error) {
+	m := &timeliner.Metadata{
+		Link:        p.link(),
+		Description: p.Title,
+	}
+	if pl := p.place(); pl != nil {
+		m.Type = "checkin"
+		m.GeneralArea = pl.Name
+	}
+	return m, nil
+}
+
+func (p *archivePost) Location() (*timeliner.Location, error) {
+	return p.place().location(), nil
+}
+
+// archivePlace is the place of a check-in or life event.
+type archivePlace struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Coordinate *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"coordinate"`
+}
+
+func (pl *archivePlace) location() *timeliner.Location {
+	if pl == nil || pl.Coordinate == nil {
+		return nil
+	}
+	return &timeliner.Location{
+		Latitude:  &pl.Coordinate.Latitude,
+		Longitude: &pl.Coordinate.Longitude,
+	}
+}
+
+// archiveLifeEvent is a life event, like starting a new job.
+type archiveLifeEvent struct {
+	Title     string `json:"title"`
+	StartDate *struct {
+		Year  int `json:"year"`
+		Month int `json:"month"`
+		Day   int `json:"day"`
+	} `json:"start_date"`
+	Place *archivePlace `json:"place"`
+
+	postTimestamp int64
+}
+
+// ID returns an ID made from the title and date of the life
+// event, so that it is the same whether it is in a post or
+// in the profile update history.
+func (le *archiveLifeEvent) ID() string {
+	return metaarchive.ContentID("life_event_", le.Title, le.Timestamp().Format("2006-01-02"))
+}
+
+// Timestamp returns the date the life event started,
+// or if it has none, when it was posted.
+func (le *archiveLifeEvent) Timestamp() time.Time {
+	if le.StartDate != nil && le.StartDate.Year > 0 {
+		month, day := le.StartDate.Month, le.StartDate.Day
+		if month == 0 {
+			month = 1
+		}
+		if day == 0 {
+			day = 1
+		}
+		return time.Date(le.StartDate.Year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Unix(le.postTimestamp, 0)
+}
+
+func (le *archiveLifeEvent) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+func (le *archiveLifeEvent) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (le *archiveLifeEvent) DataText() (*string, error) {
+	return &le.Title, nil
+}
+
+func (le *archiveLifeEvent) DataFileName() *string {
+	return nil
+}
+
+func (le *archiveLifeEvent) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (le *archiveLifeEvent) DataFileHash() []byte {
+	return nil
+}
+
+func (le *archiveLifeEvent) DataFileMIMEType() *string {
+	return nil
+}
+
+func (le *archiveLifeEvent) Metadata() (*timeliner.Metadata, error) {
+	m := &timeliner.Metadata{Type: "life_event"}
+	if le.Place != nil {
+		m.GeneralArea = le.Place.Name
+	}
+	return m, nil
+}
+
+func (le *archiveLifeEvent) Location() (*timeliner.Location, error) {
+	return le.Place.location(), nil
+}
+
+// archiveAlbum is a photo album.
+type archiveAlbum struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Photos      []archiveMedia `json:"photos"`
+}
+
+// archiveMedia is a photo, video, or other file in the
+// archive, posted by the owner or sent in a conversation.
+type archiveMedia struct {
+	URI               string              `json:"uri"`
+	CreationTimestamp int64               `json:"creation_timestamp"`
+	Description       string              `json:"description"`
+	MediaMetadata     *archiveMediaHolder `json:"media_metadata"`
+
+	arch       *archive
+	senderName string    // set if sent in a conversation by someone other than the owner
+	sentTime   time.Time // set if sent in a conversation
+}
+
+type archiveMediaHolder struct {
+	PhotoMetadata *archiveMediaMetadata `json:"photo_metadata"`
+	VideoMetadata *archiveMediaMetadata `json:"video_metadata"`
+}
+
+// archiveMediaMetadata is the metadata of a photo or video, which
+// is mostly from its EXIF data. Older archives have the fields of
+// the metadata directly; newer ones have them in a list.
+type archiveMediaMetadata struct {
+	exif map[string]interface{}
+}
+
+func (amm *archiveMediaMetadata) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+	if list, ok := fields["exif_data"]; ok {
+		var exifData []map[string]interface{}
+		err := json.Unmarshal(list, &exifData)
+		if err != nil {
+			return fmt.Errorf("decoding EXIF data: %v", err)
+		}
+		if len(exifData) > 0 {
+			amm.exif = exifData[0]
+		}
+		return nil
+	}
+	return json.Unmarshal(b, &amm.exif)
+}
+
+// exif returns the EXIF data of the media, if any.
+func (m *archiveMedia) exif() map[string]interface{} {
+	if m.MediaMetadata == nil {
+		return nil
+	}
+	if mm := m.MediaMetadata.PhotoMetadata; mm != nil {
+		return mm.exif
+	}
+	if mm := m.MediaMetadata.VideoMetadata; mm != nil {
+		return mm.exif
+	}
+	return nil
+}
+
+// ID returns the path of the file in the archive, which is
+// named after the ID of the media on Facebook.
+func (m *archiveMedia) ID() string {
+	return m.URI
+}
+
+// Timestamp returns the time the photo was taken if known,
+// or else the time it was uploaded or sent.
+func (m *archiveMedia) Timestamp() time.Time {
+	if taken := exifNumber(m.exif(), "taken_timestamp"); taken > 0 {
+		return time.Unix(int64(taken), 0)
+	}
+	if m.CreationTimestamp > 0 {
+		return time.Unix(m.CreationTimestamp, 0)
+	}
+	return m.sentTime
+}
+
+func (m *archiveMedia) Class() timeliner.ItemClass {
+	switch mimeType := m.mimeType(); {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (m *archiveMedia) Owner() (*string, *string) {
+	if m.senderName != "" {
+		return &m.senderName, &m.senderName
+	}
+	return m.arch.owner()
+}
+
+func (m *archiveMedia) DataText() (*string, error) {
+	if m.Description == "" {
+		return nil, nil
+	}
+	return &m.Description, nil
+}
+
+func (m *archiveMedia) DataFileName() *string {
+	name := path.Base(m.URI)
+	return &name
+}
+
+func (m *archiveMedia) DataFileReader() (io.ReadCloser, error) {
+	return m.arch.OpenURI(m.URI)
+}
+
+func (m *archiveMedia) DataFileHash() []byte {
+	return nil
+}
+
+func (m *archiveMedia) DataFileMIMEType() *string {
+	mimeType := m.mimeType()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+// Metadata returns the media's EXIF data, with the most
+// important fields of it broken out.
+func (m *archiveMedia) Metadata() (*timeliner.Metadata, error) {
+	exif := m.exif()
+	if len(exif) == 0 {
+		return nil, nil
+	}
+	cameraMake, _ := exif["camera_make"].(string)
+	cameraModel, _ := exif["camera_model"].(string)
+	return &timeliner.Metadata{
+		EXIF:            exif,
+		Width:           int(exifNumber(exif, "original_width")),
+		Height:          int(exifNumber(exif, "original_height")),
+		CameraMake:      cameraMake,
+		CameraModel:     cameraModel,
+		FocalLength:     exifNumber(exif, "focal_length"),
+		ApertureFNumber: exifNumber(exif, "f_stop"),
+		ISOEquivalent:   int(exifNumber(exif, "iso_speed")),
+		ExposureTime:    exifExposure(exif),
+	}, nil
+}
+
+func (m *archiveMedia) Location() (*timeliner.Location, error) {
+	exif := m.exif()
+	lat, lon := exifNumber(exif, "latitude"), exifNumber(exif, "longitude")
+	if lat == 0 && lon == 0 {
+		return nil, nil
+	}
+	return &timeliner.Location{Latitude: &lat, Longitude: &lon}, nil
+}
+
+func (m *archiveMedia) mimeType() string {
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(m.URI)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// exifNumber returns the number in the EXIF data with the
+// given key, which may be a number or a string of one.
+func exifNumber(exif map[string]interface{}, key string) float64 {
+	switch v := exif[key].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// exifExposure returns the exposure time in the EXIF data,
+// which is a number of seconds or a fraction like "1/100".
+func exifExposure(exif map[string]interface{}) time.Duration {
+	if s, ok := exif["exposure"].(string); ok {
+		if parts := strings.SplitN(s, "/", 2); len(parts) == 2 {
+			num, err1 := strconv.ParseFloat(parts[0], 64)
+			den, err2 := strconv.ParseFloat(parts[1], 64)
+			if err1 == nil && err2 == nil && den != 0 {
+				return time.Duration(num / den * float64(time.Second))
+			}
+			return 0
+		}
+	}
+	return time.Duration(exifNumber(exif, "exposure") * float64(time.Second))
+}
+
+// archiveComment is a comment the owner made.
+type archiveComment struct {
+	Time  int64  `json:"timestamp"`
+	Title string `json:"title"`
+	Data  []struct {
+		Comment *struct {
+			Comment string `json:"comment"`
+		} `json:"comment"`
+	} `json:"data"`
+
+	arch *archive
+}
+
+func (cm *archiveComment) text() string {
+	for _, d := range cm.Data {
+		if d.Comment != nil && d.Comment.Comment != "" {
+			return d.Comment.Comment
+		}
+	}
+	return ""
+}
+
+func (cm *archiveComment) ID() string {
+	return metaarchive.ContentID("comment_", strconv.FormatInt(cm.Time, 10), cm.text())
+}
+
+func (cm *archiveComment) Timestamp() time.Time {
+	return time.Unix(cm.Time, 0)
+}
+
+func (cm *archiveComment) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (cm *archiveComment) Owner() (*string, *string) {
+	return cm.arch.owner()
+}
+
+func (cm *archiveComment) DataText() (*string, error) {
+	text := cm.text()
+	return &text, nil
+}
+
+func (cm *archiveComment) DataFileName() *string {
+	return nil
+}
+
+func (cm *archiveComment) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (cm *archiveComment) DataFileHash() []byte {
+	return nil
+}
+
+func (cm *archiveComment) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata returns the title of the comment, which says
+// what was commented on, like "Alice commented on Bob's post."
+func (cm *archiveComment) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Description: cm.Title, Type: "comment"}, nil
+}
+
+func (cm *archiveComment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// archiveThread is a Messenger conversation, or one
+// of the files the conversation is split across.
+type archiveThread struct {
+	Participants []struct {
+		Name string `json:"name"`
+	} `json:"participants"`
+	Messages   []archiveMessage `json:"messages"`
+	Title      string           `json:"title"`
+	ThreadPath string           `json:"thread_path"`
+}
+
+// archiveMessage is a message in a Messenger conversation.
+type archiveMessage struct {
+	SenderName  string         `json:"sender_name"`
+	TimestampMS int64          `json:"timestamp_ms"`
+	Content     string         `json:"content"`
+	Photos      []archiveMedia `json:"photos"`
+	Videos      []archiveMedia `json:"videos"`
+	AudioFiles  []archiveMedia `json:"audio_files"`
+	Files       []archiveMedia `json:"files"`
+	Gifs        []archiveMedia `json:"gifs"`
+	Sticker     *archiveMedia  `json:"sticker"`
+	Share       *struct {
+		Link string `json:"link"`
+	} `json:"share"`
+
+	id     string
+	arch   *archive
+	thread *archiveThread
+}
+
+// setID sets the ID of the message, which is made from its
+// conversation, time, sender, and contents, since messages in
+// archives have no IDs. Messages that would have the same ID
+// get a suffix with a count.
+func (m *archiveMessage) setID(seen map[string]int) {
+	var uris []string
+	for _, a := range m.media() {
+		uris = append(uris, a.URI)
+	}
+	id := metaarchive.ContentID("message_", m.thread.ThreadPath, strconv.FormatInt(m.TimestampMS, 10),
+		m.SenderName, m.Content, strings.Join(uris, "\n"))
+	if n := seen[id]; n > 0 {
+		seen[id]++
+		id = fmt.Sprintf("%s_%d", id, n)
+	} else {
+		seen[id] = 1
+	}
+	m.id = id
+}
+
+// itemGraph returns the item graph for the message, which relates
+// it to its attachments, and if the owner sent it, to the other
+// people in the conversation.
+func (m *archiveMessage) itemGraph() *timeliner.ItemGraph {
+	media := m.media()
+	if m.Content == "" && len(media) == 0 && m.link() == "" {
+		return nil
+	}
+
+	ig := timeliner.NewItemGraph(m)
+	for _, a := range media {
+		if !m.arch.Has(a.URI) {
+			log.Printf("[ERROR][%s] Message attachment not in archive: %s", DataSourceID, a.URI)
+			continue
+		}
+		ig.Add(a, timeliner.RelAttached)
+	}
+
+	if m.fromOwner() {
+		for _, p := range m.thread.Participants {
+			if p.Name == "" || p.Name == m.arch.ownerName {
+				continue
+			}
+			ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+				UserID:   p.Name,
+				Name:     p.Name,
+				Relation: timeliner.RelSentTo,
+			})
+		}
+	}
+
+	return ig
+}
+
+// media returns the files attached to the message.
+func (m *archiveMessage) media() []*archiveMedia {
+	var media []*archiveMedia
+	for _, list := range [][]archiveMedia{m.Photos, m.Videos, m.AudioFiles, m.Files, m.Gifs} {
+		for i := range list {
+			media = append(media, &list[i])
+		}
+	}
+	if m.Sticker != nil {
+		media = append(media, m.Sticker)
+	}
+	for _, a := range media {
+		a.arch = m.arch
+		a.sentTime = m.Timestamp()
+		if !m.fromOwner() {
+			a.senderName = m.SenderName
+		}
+	}
+	return media
+}
+
+func (m *archiveMessage) fromOwner() bool {
+	return m.SenderName == "" || m.SenderName == m.arch.ownerName
+}
+
+func (m *archiveMessage) link() string {
+	if m.Share == nil {
+		return ""
+	}
+	return m.Share.Link
+}
+
+func (m *archiveMessage) ID() string {
+	return m.id
+}
+
+func (m *archiveMessage) Timestamp() time.Time {
+	return time.Unix(0, m.TimestampMS*int64(time.Millisecond))
+}
+
+func (m *archiveMessage) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+func (m *archiveMessage) Owner() (*string, *string) {
+	if m.fromOwner() {
+		return m.arch.owner()
+	}
+	return &m.SenderName, &m.SenderName
+}
+
+func (m *archiveMessage) DataText() (*string, error) {
+	if m.Content == "" {
+		return nil, nil
+	}
+	return &m.Content, nil
+}
+
+func (m *archiveMessage) DataFileName() *string {
+	return nil
+}
+
+func (m *archiveMessage) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *archiveMessage) DataFileHash() []byte {
+	return nil
+}
+
+func (m *archiveMessage) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *archiveMessage) Metadata() (*timeliner.Metadata, error) {
+	if link := m.link(); link != "" {
+		return &timeliner.Metadata{Link: link}, nil
+	}
+	return nil, nil
+}
+
+func (m *archiveMessage) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+package facebook
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+type fbEventPage struct {
+	Data   []fbEvent `json:"data"`
+	Paging fbPaging  `json:"paging"`
+}
+
+// fbEvent is an event the account owner is attending,
+// was invited to, or hosts.
+type fbEvent struct {
+	EventID     string   `json:"id,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	StartTime   string   `json:"start_time,omitempty"`
+	EndTime     string   `json:"end_time,omitempty"`
+	Place       *fbPlace `json:"place,omitempty"`
+	RSVPStatus  string   `json:"rsvp_status,omitempty"`
+	Host        *fbFrom  `json:"owner,omitempty"`
+}
+
+func (e *fbEvent) ID() string {
+	return e.EventID
+}
+
+func (e *fbEvent) Timestamp() time.Time {
+	return fbTimeToGoTime(e.StartTime)
+}
+
+// DataText returns the name and description of the event.
+func (e *fbEvent) DataText() (*string, error) {
+	text := strings.TrimSpace(e.Name + "\n\n" + e.Description)
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (e *fbEvent) DataFileName() *string {
+	return nil
+}
+
+func (e *fbEvent) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (e *fbEvent) DataFileHash() []byte {
+	return nil
+}
+
+func (e *fbEvent) DataFileMIMEType() *string {
+	return nil
+}
+
+// Owner returns the host of the event, which may be a page.
+func (e *fbEvent) Owner() (*string, *string) {
+	if e.Host == nil || e.Host.ID == "" {
+		return nil, nil
+	}
+	return &e.Host.ID, &e.Host.Name
+}
+
+func (e *fbEvent) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+// Metadata returns the name, place, and duration of the event,
+// and the account owner's response to it as its type.
+func (e *fbEvent) Metadata() (*timeliner.Metadata, error) {
+	meta := &timeliner.Metadata{
+		Name: e.Name,
+		Link: "https://www.facebook.com/events/" + e.EventID,
+		Type: e.RSVPStatus,
+	}
+	if e.Place != nil {
+		meta.GeneralArea = e.Place.Name
+	}
+	start, end := fbTimeToGoTime(e.StartTime), fbTimeToGoTime(e.EndTime)
+	if !start.IsZero() && !end.IsZero() {
+		meta.Duration = end.Sub(start)
+	}
+	return meta, nil
+}
+
+func (e *fbEvent) Location() (*timeliner.Location, error) {
+	if e.Place == nil || (e.Place.Location.Latitude == 0 && e.Place.Location.Longitude == 0) {
+		return nil, nil
+	}
+	return &timeliner.Location{
+		Latitude:  &e.Place.Location.Latitude,
+		Longitude: &e.Place.Location.Longitude,
+	}, nil
+}
@@ -35,6 +35,7 @@ var dataSource = timeliner.DataSource{
 			"user_posts",
 			"user_photos",
 			"user_videos",
+			"user_events",
 		},
 	},
 	RateLimit: timeliner.RateLimit{
@@ -66,12 +67,14 @@ type Client struct {
 	checkpoint checkpointInfo
 }
 
-// ListItems lists the items on the Facebook account.
+// ListItems lists the items on the Facebook account, or if
+// opt.Filename is specified, in a "Download Your Information"
+// archive.
 func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
 	defer close(itemChan)
 
 	if opt.Filename != "" {
-		return fmt.Errorf("importing from a file is not supported")
+		return c.getFromArchive(ctx, itemChan, opt.Filename)
 	}
 
 	// load any previous checkpoint
@@ -79,7 +82,7 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 
 	errChan := make(chan error)
 
-	// TODO: events, comments (if possible), ...
+	// comments are listed with the posts in the feed
 	go func() {
 		err := c.getFeed(ctx, itemChan, opt.Timeframe)
 		errChan <- err
@@ -88,11 +91,15 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 		err := c.getCollections(ctx, itemChan, opt.Timeframe)
 		errChan <- err
 	}()
+	go func() {
+		err := c.getEvents(ctx, itemChan, opt.Timeframe)
+		errChan <- err
+	}()
 
-	// read exactly 2 errors (or nils) because we
-	// started 2 goroutines to do things
+	// read exactly 3 errors (or nils) because we
+	// started 3 goroutines to do things
 	var errs []string
-	for i := 0; i < 2; i++ {
+	for i := 0; i < 3; i++ {
 		err := <-errChan
 		if err != nil {
 			errs = append(errs, err.Error())
@@ -105,10 +112,26 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	return nil
 }
 
+// getFeed lists the posts in the feed. If timeframe.Since is set,
+// the feed is walked forward from then; otherwise, it is walked
+// backward from the most recent post (or timeframe.Until).
 func (c *Client) getFeed(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
 	c.checkpoint.mu.Lock()
 	nextPageURL := c.checkpoint.ItemsNextPage
+	done := c.checkpoint.FeedDone
 	c.checkpoint.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	if timeframe.Since != nil {
+		err := c.getFeedForward(ctx, itemChan, timeframe)
+		if err != nil {
+			return err
+		}
+		c.markDone(ctx, &c.checkpoint.FeedDone)
+		return nil
+	}
 
 	var err error
 
@@ -122,6 +145,7 @@ func (c *Client) getFeed(ctx context.Context, itemChan chan<- *timeliner.ItemGra
 				return err
 			}
 			if nextPageURL == nil {
+				c.markDone(ctx, &c.checkpoint.FeedDone)
 				return nil
 			}
 
@@ -133,6 +157,107 @@ func (c *Client) getFeed(ctx context.Context, itemChan chan<- *timeliner.ItemGra
 	}
 }
 
+// getFeedForward lists the posts in the feed from timeframe.Since
+// until timeframe.Until (or now), one window of time at a time. The
+// "next" page of a feed requested with since and until goes the wrong
+// direction, and the "order" parameter is broken (see
+// https://developers.facebook.com/support/bugs/2231843933505877/),
+// so instead of paging, each window is requested in full, as one
+// page; a window with more posts than fit on a page is narrowed,
+// or, if it is as narrow as it gets, paged through after all.
+func (c *Client) getFeedForward(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
+	from := *timeframe.Since
+	c.checkpoint.mu.Lock()
+	if c.checkpoint.FeedSince != nil && c.checkpoint.FeedSince.After(from) {
+		from = *c.checkpoint.FeedSince
+	}
+	c.checkpoint.mu.Unlock()
+
+	end := time.Now()
+	if timeframe.Until != nil && timeframe.Until.Before(end) {
+		end = *timeframe.Until
+	}
+
+	window := feedWindow
+	for from.Before(end) {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		to := from.Add(window)
+		if to.After(end) {
+			to = end
+		}
+		user, err := c.requestPage("", timeliner.Timeframe{Since: &from, Until: &to})
+		if err != nil {
+			return fmt.Errorf("requesting posts from %s to %s: %v", from, to, err)
+		}
+		posts := user.Feed.Data
+		if len(posts) >= feedPageLimit {
+			if window > minFeedWindow {
+				window /= 2
+				continue
+			}
+			more, err := c.getFeedWindowPages(ctx, user.Feed.Paging.Next, from, to)
+			if err != nil {
+				return fmt.Errorf("requesting more posts from %s to %s: %v", from, to, err)
+			}
+			posts = append(posts, more...)
+		}
+
+		c.processFeedPosts(itemChan, posts)
+
+		from = to
+		if window < feedWindow {
+			window *= 2
+		}
+
+		c.checkpoint.mu.Lock()
+		c.checkpoint.FeedSince = &from
+		c.checkpoint.save(ctx)
+		c.checkpoint.mu.Unlock()
+	}
+
+	return nil
+}
+
+// getFeedWindowPages gets the posts in the pages of a window of the
+// feed that follow its first page, starting with the page at next.
+// Since the pages may go past the window, posts outside of it are
+// left out, and paging stops at the first page without any in it.
+func (c *Client) getFeedWindowPages(ctx context.Context, next *string, from, to time.Time) ([]fbPost, error) {
+	var posts []fbPost
+	for next != nil {
+		if ctx.Err() != nil {
+			return posts, nil
+		}
+		user, err := c.requestPage(*next, timeliner.Timeframe{})
+		if err != nil {
+			return nil, err
+		}
+		var inWindow int
+		for _, post := range user.Feed.Data {
+			created := fbTimeToGoTime(post.CreatedTime)
+			if created.Before(from) || created.After(to) {
+				continue
+			}
+			posts = append(posts, post)
+			inWindow++
+		}
+		if inWindow == 0 {
+			break
+		}
+		next = user.Feed.Paging.Next
+	}
+	return posts, nil
+}
+
+const (
+	feedWindow    = 30 * 24 * time.Hour
+	minFeedWindow = time.Hour
+	feedPageLimit = 100
+)
+
 func (c *Client) getFeedNextPage(itemChan chan<- *timeliner.ItemGraph,
 	nextPageURL *string, timeframe timeliner.Timeframe) (*string, error) {
 
@@ -141,20 +266,21 @@ func (c *Client) getFeedNextPage(itemChan chan<- *timeliner.ItemGraph,
 		nextPageURLStr = *nextPageURL
 	}
 
-	// TODO: When requesting a page using since&until, the results
-	// need to be ordered differently because they start at the since,
-	// and if we go "next" it goes the wrong direction; furthermore,
-	// their "order" method is broken: https://developers.facebook.com/support/bugs/2231843933505877/
-	// - that all needs to be figured out before we do much more here
-	// with regards to timeframes
-	user, err := c.requestPage(nextPageURLStr, timeframe)
+	// only timeframe.Until is used here; see getFeedForward
+	user, err := c.requestPage(nextPageURLStr, timeliner.Timeframe{Until: timeframe.Until})
 	if err != nil {
 		return nil, fmt.Errorf("requesting next page: %v", err)
 	}
 
-	// TODO: Refactor this into smaller functions...
+	c.processFeedPosts(itemChan, user.Feed.Data)
+
+	return user.Feed.Paging.Next, nil
+}
 
-	for _, post := range user.Feed.Data {
+// processFeedPosts sends the posts for processing, with
+// their attached media and their comments.
+func (c *Client) processFeedPosts(itemChan chan<- *timeliner.ItemGraph, posts []fbPost) {
+	for _, post := range posts {
 
 		ig := timeliner.NewItemGraph(post)
 
@@ -188,10 +314,55 @@ func (c *Client) getFeedNextPage(itemChan chan<- *timeliner.ItemGraph,
 			}
 		}
 
+		err := c.connectComments(ig, post)
+		if err != nil {
+			log.Printf("[ERROR][%s] Getting comments on post %s: %v", DataSourceID, post.PostID, err)
+		}
+
 		itemChan <- ig
 	}
+}
 
-	return user.Feed.Paging.Next, nil
+// connectComments connects the comments on the post of ig to ig,
+// each in reply to the post, or to the comment it replies to.
+func (c *Client) connectComments(ig *timeliner.ItemGraph, post fbPost) error {
+	if post.Comments == nil {
+		return nil
+	}
+
+	var comments []fbComment
+	page := post.Comments
+	for {
+		comments = append(comments, page.Data...)
+		if page.Paging.Next == nil {
+			break
+		}
+		var nextPage fbCommentPage
+		err := c.apiRequestFullURL("GET", *page.Paging.Next, nil, &nextPage)
+		if err != nil {
+			return fmt.Errorf("requesting next page of comments: %v", err)
+		}
+		page = &nextPage
+	}
+
+	commentIGs := make(map[string]*timeliner.ItemGraph)
+	for i := range comments {
+		commentIGs[comments[i].CommentID] = timeliner.NewItemGraph(&comments[i])
+	}
+	for i := range comments {
+		cm := &comments[i]
+		cig := commentIGs[cm.CommentID]
+		if cm.Parent != nil && commentIGs[cm.Parent.ID] != nil {
+			cig.Connect(commentIGs[cm.Parent.ID], timeliner.RelReplyTo)
+		} else {
+			cig.Connect(ig, timeliner.RelReplyTo)
+		}
+		// the edge from the post to the comment has no relations;
+		// it is only there so the comment is processed with the post
+		ig.Edges[cig] = nil
+	}
+
+	return nil
 }
 
 func (c *Client) requestPage(nextPageURL string, timeframe timeliner.Timeframe) (fbUser, error) {
@@ -208,8 +379,9 @@ func (c *Client) requestPage(nextPageURL string, timeframe timeliner.Timeframe)
 
 	// otherwise, we'll need to craft our own URL to kick things off
 
-	timeConstraint := fieldTimeConstraint(timeframe)
-	nested := "{attachments,backdated_time,created_time,description,from,link,message,name,parent_id,place,status_type,type,with_tags}"
+	timeConstraint := fieldTimeConstraint(timeframe) + fmt.Sprintf(".limit(%d)", feedPageLimit)
+	nested := "{attachments,backdated_time,created_time,description,from,link,message,name,parent_id,place,status_type,type,with_tags," +
+		"comments.filter(stream).limit(100){id,from,message,created_time,parent{id}}}"
 
 	v := url.Values{
 		"fields": {"feed" + timeConstraint + nested},
@@ -251,7 +423,11 @@ func (c *Client) requestMedia(mediaType, mediaID string) (*fbMedia, error) {
 func (c *Client) getCollections(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
 	c.checkpoint.mu.Lock()
 	nextPageURL := c.checkpoint.AlbumsNextPage
+	done := c.checkpoint.AlbumsDone
 	c.checkpoint.mu.Unlock()
+	if done {
+		return nil
+	}
 
 	var err error
 	for {
@@ -264,6 +440,7 @@ func (c *Client) getCollections(ctx context.Context, itemChan chan<- *timeliner.
 				return err
 			}
 			if nextPageURL == nil {
+				c.markDone(ctx, &c.checkpoint.AlbumsDone)
 				return nil
 			}
 
@@ -343,6 +520,99 @@ func (c *Client) getCollectionsNextPage(itemChan chan<- *timeliner.ItemGraph,
 	return page.Paging.Next, nil
 }
 
+// getEvents lists the events the account owner is attending,
+// was invited to, or hosts. Accounts that were added before
+// events were listed may not have granted the permission to
+// list them, in which case they are skipped.
+func (c *Client) getEvents(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
+	c.checkpoint.mu.Lock()
+	nextPageURL := c.checkpoint.EventsNextPage
+	done := c.checkpoint.EventsDone
+	c.checkpoint.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	granted, err := c.permissionGranted("user_events")
+	if err != nil {
+		return fmt.Errorf("checking permission to list events: %v", err)
+	}
+	if !granted {
+		log.Printf("[ERROR][%s] Skipping events: the user_events permission was not granted; "+
+			"re-authenticate the account to grant it", DataSourceID)
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			var page fbEventPage
+			var err error
+			if nextPageURL == nil {
+				v := url.Values{
+					"fields": {"id,name,description,start_time,end_time,place,rsvp_status,owner"},
+					"limit":  {"100"},
+				}
+				v = qsTimeConstraint(v, timeframe)
+				err = c.apiRequest("GET", "me/events?"+v.Encode(), nil, &page)
+			} else {
+				err = c.apiRequestFullURL("GET", *nextPageURL, nil, &page)
+			}
+			if err != nil {
+				return fmt.Errorf("requesting next page of events: %v", err)
+			}
+
+			for i := range page.Data {
+				itemChan <- timeliner.NewItemGraph(&page.Data[i])
+			}
+
+			nextPageURL = page.Paging.Next
+			if nextPageURL == nil {
+				c.markDone(ctx, &c.checkpoint.EventsDone)
+				return nil
+			}
+
+			c.checkpoint.mu.Lock()
+			c.checkpoint.EventsNextPage = nextPageURL
+			c.checkpoint.save(ctx)
+			c.checkpoint.mu.Unlock()
+		}
+	}
+}
+
+// permissionGranted returns whether the account owner
+// granted the app the permission with the given name.
+func (c *Client) permissionGranted(name string) (bool, error) {
+	var perms struct {
+		Data []struct {
+			Permission string `json:"permission"`
+			Status     string `json:"status"`
+		} `json:"data"`
+	}
+	err := c.apiRequest("GET", "me/permissions", nil, &perms)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms.Data {
+		if p.Permission == name {
+			return p.Status == "granted", nil
+		}
+	}
+	return false, nil
+}
+
+// markDone sets the flag in the checkpoint that marks
+// part of the listing as done, and saves the checkpoint,
+// so that part is skipped if the listing is resumed.
+func (c *Client) markDone(ctx context.Context, flag *bool) {
+	c.checkpoint.mu.Lock()
+	*flag = true
+	c.checkpoint.save(ctx)
+	c.checkpoint.mu.Unlock()
+}
+
 func (c *Client) apiRequest(method, endpoint string, reqBodyData, respInto interface{}) error {
 	return c.apiRequestFullURL(method, apiBase+endpoint, reqBodyData, respInto)
 }
@@ -435,9 +705,16 @@ func qsTimeConstraint(v url.Values, timeframe timeliner.Timeframe) url.Values {
 	return v
 }
 
+// checkpointInfo records how far the listing of the feed,
+// albums, and events has gotten, and which of them are done.
 type checkpointInfo struct {
 	ItemsNextPage  *string
 	AlbumsNextPage *string
+	EventsNextPage *string
+	FeedSince      *time.Time // start of the next window of the feed, when walking forward
+	FeedDone       bool
+	AlbumsDone     bool
+	EventsDone     bool
 	mu             *sync.Mutex
 }
 
@@ -22,6 +22,7 @@ type fbPost struct {
 	StatusType    string            `json:"status_type,omitempty"`
 	Type          string            `json:"type,omitempty"`
 	PostID        string            `json:"id,omitempty"`
+	Comments      *fbCommentPage    `json:"comments,omitempty"`
 }
 
 func (p fbPost) ID() string {
@@ -124,4 +125,70 @@ func fbTimeToGoTime(fbTime string) time.Time {
 	return ts
 }
 
-const fbTimeFormat = "2006-01-02T15:04:05+0000"
+const fbTimeFormat = "2006-01-02T15:04:05-0700"
+
+type fbCommentPage struct {
+	Data   []fbComment `json:"data"`
+	Paging fbPaging    `json:"paging"`
+}
+
+// fbComment is a comment on a post, or a reply to a comment.
+type fbComment struct {
+	CommentID   string `json:"id,omitempty"`
+	CreatedTime string `json:"created_time,omitempty"`
+	From        fbFrom `json:"from,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Parent      *struct {
+		ID string `json:"id,omitempty"`
+	} `json:"parent,omitempty"`
+}
+
+func (cm *fbComment) ID() string {
+	return cm.CommentID
+}
+
+func (cm *fbComment) Timestamp() time.Time {
+	return fbTimeToGoTime(cm.CreatedTime)
+}
+
+func (cm *fbComment) DataText() (*string, error) {
+	return &cm.Message, nil
+}
+
+func (cm *fbComment) DataFileName() *string {
+	return nil
+}
+
+func (cm *fbComment) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (cm *fbComment) DataFileHash() []byte {
+	return nil
+}
+
+func (cm *fbComment) DataFileMIMEType() *string {
+	return nil
+}
+
+// Owner returns the author of the comment, or nil if the API
+// doesn't say who it is, which it doesn't for most people who
+// haven't authorized the app.
+func (cm *fbComment) Owner() (*string, *string) {
+	if cm.From.ID == "" {
+		return nil, nil
+	}
+	return &cm.From.ID, &cm.From.Name
+}
+
+func (cm *fbComment) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (cm *fbComment) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Type: "comment"}, nil
+}
+
+func (cm *fbComment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
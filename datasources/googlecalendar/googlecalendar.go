@@ -1,17 +1,20 @@
+// Package googlecalendar implements a Timeliner data source for
+// the Google Calendar API.
 package googlecalendar
 
 import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/mholt/timeliner"
 	"golang.org/x/net/context"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
+// Data source name and ID
 const (
 	DataSourceName = "Google Calendar"
 	DataSourceID   = "google_calendar"
@@ -19,7 +22,7 @@ const (
 
 var dataSource = timeliner.DataSource{
 	ID:   DataSourceID,
-	Name: DataSourceID,
+	Name: DataSourceName,
 	OAuth2: timeliner.OAuth2{
 		ProviderID: "google",
 		Scopes: []string{
@@ -49,7 +52,7 @@ func init() {
 	}
 }
 
-// Client interacts with the Google Photos
+// Client interacts with the Google Calendar
 // API. It requires an OAuth2-authorized
 // HTTP client in order to work properly.
 type Client struct {
@@ -58,8 +61,16 @@ type Client struct {
 	userID string
 }
 
-// ListItems lists items from the data source.
-// opt.Timeframe precision is day-level at best.
+// ListItems lists the events of all the calendars in the user's
+// calendar list, each of which is a collection. Recurring events
+// are listed as their individual occurrences.
+//
+// When a calendar has been listed completely, its sync token is
+// saved in the sync state. If opt.Timeframe.Since is set (as with
+// get-latest), only the events that changed since then are listed
+// using that token, or if there isn't one (or it expired), events
+// that start after opt.Timeframe.Since. Events that were deleted
+// are listed with a sync token only, and are marked as cancelled.
 func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
 	defer close(itemChan)
 
@@ -67,46 +78,192 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 		return fmt.Errorf("importing data from a file is not supported")
 	}
 
-	// get items and collections
-	errChan := make(chan error)
-	go func() {
-		err := c.listItems(ctx, itemChan, opt.Timeframe)
-		errChan <- err
-	}()
+	var ckpt checkpointInfo
+	ckpt.load(opt.Checkpoint)
 
-	var errs []string
-	for i := 0; i < 1; i++ {
-		err := <-errChan
+	syncTokens := make(map[string]string)
+	if opt.Timeframe.Since != nil && opt.SyncState != nil {
+		err := timeliner.UnmarshalGob(opt.SyncState, &syncTokens)
 		if err != nil {
-			log.Printf("[ERROR][%s/%s] A listing goroutine errored: %v", DataSourceID, c.userID, err)
-			errs = append(errs, err.Error())
+			log.Printf("[ERROR][%s/%s] Decoding sync tokens; doing a full sync: %v",
+				DataSourceID, c.userID, err)
+			syncTokens = make(map[string]string)
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("one or more errors: %s", strings.Join(errs, ", "))
+
+	srv, err := calendar.New(c.HTTPClient)
+	if err != nil {
+		return fmt.Errorf("creating calendar service: %v", err)
+	}
+
+	cals, err := c.listCalendars(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	for _, cal := range cals {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if tkn, ok := ckpt.SyncTokens[cal.Id]; ok {
+			// already listed before being interrupted
+			syncTokens[cal.Id] = tkn
+			continue
+		}
+		tkn, err := c.listEvents(ctx, srv, cal, syncTokens[cal.Id], &ckpt, opt.Timeframe, itemChan)
+		if err != nil {
+			return fmt.Errorf("listing events of calendar %s: %v", cal.Id, err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		syncTokens[cal.Id] = tkn
+		ckpt.SyncTokens[cal.Id] = tkn
+		delete(ckpt.PageTokens, cal.Id)
+		ckpt.save(ctx)
+	}
+
+	stateBytes, err := timeliner.MarshalGob(syncTokens)
+	if err != nil {
+		return fmt.Errorf("encoding sync tokens: %v", err)
 	}
+	timeliner.SaveSyncState(ctx, stateBytes)
 
 	return nil
 }
 
-func (c *Client) listItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
-	srv, err := calendar.New(c.HTTPClient)
+// listCalendars returns the calendars in the user's calendar list.
+func (c *Client) listCalendars(ctx context.Context, srv *calendar.Service) ([]*calendar.CalendarListEntry, error) {
+	var cals []*calendar.CalendarListEntry
+	var pageToken string
+	for {
+		page, err := srv.CalendarList.List().ShowHidden(true).
+			PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("getting calendar list: %v", err)
+		}
+		cals = append(cals, page.Items...)
+		if page.NextPageToken == "" {
+			return cals, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
 
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List("primary").ShowDeleted(false).
-		SingleEvents(true).TimeMin(t).MaxResults(10).OrderBy("startTime").Do()
-	if err != nil {
-		return fmt.Errorf("getting items on next page: %v", err)
+// listEvents lists the events of cal, incrementally if syncToken is
+// not empty, and returns the sync token for the next incremental
+// listing. The page token of each page is saved in the checkpoint.
+func (c *Client) listEvents(ctx context.Context, srv *calendar.Service, cal *calendar.CalendarListEntry,
+	syncToken string, ckpt *checkpointInfo, timeframe timeliner.Timeframe,
+	itemChan chan<- *timeliner.ItemGraph) (string, error) {
+	coll := timeliner.Collection{OriginalID: cal.Id}
+	if cal.Summary != "" {
+		coll.Name = &cal.Summary
+	}
+	if cal.Description != "" {
+		coll.Description = &cal.Description
 	}
-	for _, item := range events.Items {
-		var event eventItem
-		log.Printf("[info] %v", item)
 
-		itemChan <- &timeliner.ItemGraph{
-			Node: event,
+	pageToken := ckpt.PageTokens[cal.Id]
+	for {
+		call := srv.Events.List(cal.Id).SingleEvents(true).MaxResults(250)
+		if syncToken != "" {
+			// a sync token can't be combined with any
+			// filters; it lists changed events, including
+			// deleted ones
+			call = call.SyncToken(syncToken)
+		} else {
+			call = call.ShowDeleted(false)
+			if timeframe.Since != nil {
+				call = call.TimeMin(timeframe.Since.Format(time.RFC3339))
+			}
+			if timeframe.Until != nil {
+				call = call.TimeMax(timeframe.Until.Format(time.RFC3339))
+			}
 		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		page, err := call.Context(ctx).Do()
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusGone && syncToken != "" {
+			// the sync token expired; do a full sync instead
+			log.Printf("[INFO][%s/%s] Sync token for calendar %s expired; doing a full sync",
+				DataSourceID, c.userID, cal.Id)
+			syncToken, pageToken = "", ""
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("getting events on next page: %v", err)
+		}
+
+		for _, ev := range page.Items {
+			if ev.Status == "cancelled" {
+				if syncToken == "" {
+					continue
+				}
+				// the event was deleted since the last sync, which
+				// only says so by its ID; get the rest of it so
+				// that its item is kept, but marked as cancelled
+				full, err := srv.Events.Get(cal.Id, ev.Id).Context(ctx).Do()
+				if err != nil {
+					log.Printf("[ERROR][%s/%s] Getting cancelled event %s: %v",
+						DataSourceID, c.userID, ev.Id, err)
+					continue
+				}
+				ev = full
+			}
+			item := &eventItem{event: ev}
+			ig := item.itemGraph()
+			cl := coll
+			cl.Items = []timeliner.CollectionItem{{Item: item}}
+			ig.Collections = append(ig.Collections, cl)
+			itemChan <- ig
+		}
+
+		if page.NextPageToken == "" {
+			return page.NextSyncToken, nil
+		}
+		pageToken = page.NextPageToken
+
+		if ctx.Err() != nil {
+			return "", nil
+		}
+		ckpt.PageTokens[cal.Id] = pageToken
+		ckpt.save(ctx)
 	}
+}
 
-	return nil
+// checkpointInfo stores how far the listing got: the next page
+// of the calendar being listed, and the sync tokens of the
+// calendars that were listed completely.
+type checkpointInfo struct {
+	PageTokens map[string]string
+	SyncTokens map[string]string
+}
+
+// save records the checkpoint.
+func (ch *checkpointInfo) save(ctx context.Context) {
+	gobBytes, err := timeliner.MarshalGob(ch)
+	if err != nil {
+		log.Printf("[ERROR][%s] Encoding checkpoint: %v", DataSourceID, err)
+		return
+	}
+	timeliner.Checkpoint(ctx, gobBytes)
+}
 
+// load decodes the checkpoint, if any.
+func (ch *checkpointInfo) load(checkpointGob []byte) {
+	if len(checkpointGob) > 0 {
+		err := timeliner.UnmarshalGob(checkpointGob, ch)
+		if err != nil {
+			log.Printf("[ERROR][%s] Decoding checkpoint: %v", DataSourceID, err)
+		}
+	}
+	if ch.PageTokens == nil {
+		ch.PageTokens = make(map[string]string)
+	}
+	if ch.SyncTokens == nil {
+		ch.SyncTokens = make(map[string]string)
+	}
 }
@@ -56,17 +56,41 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	}
 	defer file.Close()
 
-	dec := json.NewDecoder(file)
+	return ListLocations(ctx, file, itemChan)
+}
+
+// ListLocations lists the locations in the Location History
+// JSON read from r, sending them on itemChan. It understands both
+// the raw location points (the standalone export or Records.json
+// in a Google Takeout) and the monthly files of Semantic Location
+// History, which contain place visits and activity segments. It
+// does not close itemChan. Location IDs are derived from their
+// timestamps, so the same location has the same ID wherever it
+// came from.
+func ListLocations(ctx context.Context, r io.Reader, itemChan chan<- *timeliner.ItemGraph) error {
+	dec := json.NewDecoder(r)
 
 	// read the following opening tokens:
 	// 1. open brace '{'
-	// 2. "locations" field name,
+	// 2. "locations" or "timelineObjects" field name,
 	// 3. the array value's opening bracket '['
+	var field interface{}
 	for i := 0; i < 3; i++ {
-		_, err := dec.Token()
+		tkn, err := dec.Token()
 		if err != nil {
 			return fmt.Errorf("decoding opening token: %v", err)
 		}
+		if i == 1 {
+			field = tkn
+		}
+	}
+
+	switch field {
+	case "locations":
+	case "timelineObjects":
+		return listTimelineObjects(ctx, dec, itemChan)
+	default:
+		return fmt.Errorf("unrecognized location history format: top-level field %v", field)
 	}
 
 	var prev *location
@@ -76,7 +100,7 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 			return nil
 		default:
 			var err error
-			prev, err = c.processLocation(dec, prev, itemChan)
+			prev, err = processLocation(dec, prev, itemChan)
 			if err != nil {
 				return fmt.Errorf("processing location item: %v", err)
 			}
@@ -86,7 +110,7 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	return nil
 }
 
-func (c *Client) processLocation(dec *json.Decoder, prev *location,
+func processLocation(dec *json.Decoder, prev *location,
 	itemChan chan<- *timeliner.ItemGraph) (*location, error) {
 
 	var l *location
@@ -152,6 +176,7 @@ func similar(a, b int) bool {
 
 type location struct {
 	TimestampMs      string       `json:"timestampMs"`
+	TimestampRFC3339 string       `json:"timestamp"` // newer exports use this instead of timestampMs
 	LatitudeE7       int          `json:"latitudeE7"`
 	LongitudeE7      int          `json:"longitudeE7"`
 	Accuracy         int          `json:"accuracy"`
@@ -254,6 +279,13 @@ func (l location) ID() string {
 }
 
 func (l location) Timestamp() time.Time {
+	if l.TimestampMs == "" && l.TimestampRFC3339 != "" {
+		ts, err := time.Parse(time.RFC3339, l.TimestampRFC3339)
+		if err != nil {
+			return time.Time{}
+		}
+		return ts.Truncate(time.Second)
+	}
 	ts, err := strconv.Atoi(l.TimestampMs)
 	if err != nil {
 		return time.Time{}
@@ -0,0 +1,346 @@
+package googlelocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// listTimelineObjects lists the place visits and activity
+// segments of Semantic Location History from dec, which
+// must be positioned inside the timelineObjects array.
+// Each is related to the raw location points that were
+// recorded during it, whether they are imported before or
+// after it, and activity segments are related to the
+// waypoints of their route.
+func listTimelineObjects(ctx context.Context, dec *json.Decoder, itemChan chan<- *timeliner.ItemGraph) error {
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var obj timelineObject
+		err := dec.Decode(&obj)
+		if err != nil {
+			return fmt.Errorf("decoding timeline object: %v", err)
+		}
+
+		var it timeliner.Item
+		var dur semanticDuration
+		var waypoints []*waypoint
+		switch {
+		case obj.PlaceVisit != nil:
+			it, dur = obj.PlaceVisit, obj.PlaceVisit.Duration
+		case obj.ActivitySegment != nil:
+			it, dur = obj.ActivitySegment, obj.ActivitySegment.Duration
+			waypoints = obj.ActivitySegment.waypoints()
+		default:
+			continue
+		}
+		if dur.start().IsZero() {
+			continue
+		}
+
+		ig := timeliner.NewItemGraph(it)
+		for _, wp := range waypoints {
+			ig.Add(wp, relIncludes)
+		}
+		ig.SpanRelations = append(ig.SpanRelations, timeliner.SpanRelation{
+			Start:    dur.start(),
+			End:      dur.end(),
+			Class:    timeliner.ClassLocation,
+			Relation: relIncludes,
+		})
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// relIncludes relates a visit or activity to a location
+// point that was recorded during it, or to a waypoint of
+// an activity's route: "<from> includes <to>"
+var relIncludes = timeliner.Relation{Label: "includes", Bidirectional: false}
+
+type timelineObject struct {
+	PlaceVisit      *placeVisit      `json:"placeVisit,omitempty"`
+	ActivitySegment *activitySegment `json:"activitySegment,omitempty"`
+}
+
+// placeVisit is a stay at a place.
+type placeVisit struct {
+	Place           semanticLocation `json:"location"`
+	Duration        semanticDuration `json:"duration"`
+	PlaceConfidence string           `json:"placeConfidence,omitempty"`
+	CenterLatE7     int              `json:"centerLatE7,omitempty"`
+	CenterLngE7     int              `json:"centerLngE7,omitempty"`
+}
+
+// ID returns a string representation of the start time,
+// since it is assumed that one cannot be in two places
+// at once.
+func (pv *placeVisit) ID() string {
+	return fmt.Sprintf("visit_%d", pv.Duration.start().Unix())
+}
+
+func (pv *placeVisit) Timestamp() time.Time {
+	return pv.Duration.start()
+}
+
+func (pv *placeVisit) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+func (pv *placeVisit) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (pv *placeVisit) DataText() (*string, error) {
+	switch {
+	case pv.Place.Name != "":
+		return &pv.Place.Name, nil
+	case pv.Place.Address != "":
+		return &pv.Place.Address, nil
+	}
+	return nil, nil
+}
+
+func (pv *placeVisit) DataFileName() *string {
+	return nil
+}
+
+func (pv *placeVisit) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (pv *placeVisit) DataFileHash() []byte {
+	return nil
+}
+
+func (pv *placeVisit) DataFileMIMEType() *string {
+	return nil
+}
+
+func (pv *placeVisit) Metadata() (*timeliner.Metadata, error) {
+	m := &timeliner.Metadata{
+		Name:        pv.Place.Name,
+		GeneralArea: strings.Replace(pv.Place.Address, "\n", ", ", -1),
+		Type:        pv.Place.SemanticType,
+		Duration:    pv.Duration.end().Sub(pv.Duration.start()),
+	}
+	if pv.Place.PlaceID != "" {
+		m.Link = "https://www.google.com/maps/place/?q=place_id:" + pv.Place.PlaceID
+	}
+	return m, nil
+}
+
+func (pv *placeVisit) Location() (*timeliner.Location, error) {
+	latE7, lngE7 := pv.Place.LatitudeE7, pv.Place.LongitudeE7
+	if latE7 == 0 && lngE7 == 0 {
+		latE7, lngE7 = pv.CenterLatE7, pv.CenterLngE7
+	}
+	return locationE7(latE7, lngE7), nil
+}
+
+// activitySegment is a movement between places.
+type activitySegment struct {
+	StartLocation semanticLocation `json:"startLocation"`
+	EndLocation   semanticLocation `json:"endLocation"`
+	Duration      semanticDuration `json:"duration"`
+	Distance      float64          `json:"distance,omitempty"` // meters
+	ActivityType  string           `json:"activityType,omitempty"`
+	Confidence    string           `json:"confidence,omitempty"`
+	WaypointPath  struct {
+		Waypoints []struct {
+			LatE7 int `json:"latE7"`
+			LngE7 int `json:"lngE7"`
+		} `json:"waypoints,omitempty"`
+		DistanceMeters float64 `json:"distanceMeters,omitempty"`
+	} `json:"waypointPath,omitempty"`
+}
+
+// ID returns a string representation of the start time,
+// since it is assumed that one cannot go two ways at once.
+func (as *activitySegment) ID() string {
+	return fmt.Sprintf("activity_%d", as.Duration.start().Unix())
+}
+
+func (as *activitySegment) Timestamp() time.Time {
+	return as.Duration.start()
+}
+
+func (as *activitySegment) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+func (as *activitySegment) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (as *activitySegment) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (as *activitySegment) DataFileName() *string {
+	return nil
+}
+
+func (as *activitySegment) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (as *activitySegment) DataFileHash() []byte {
+	return nil
+}
+
+func (as *activitySegment) DataFileMIMEType() *string {
+	return nil
+}
+
+func (as *activitySegment) Metadata() (*timeliner.Metadata, error) {
+	dist := as.Distance
+	if dist == 0 {
+		dist = as.WaypointPath.DistanceMeters
+	}
+	return &timeliner.Metadata{
+		Type:     strings.ToLower(as.ActivityType),
+		Duration: as.Duration.end().Sub(as.Duration.start()),
+		Distance: int(dist),
+	}, nil
+}
+
+func (as *activitySegment) Location() (*timeliner.Location, error) {
+	return locationE7(as.StartLocation.LatitudeE7, as.StartLocation.LongitudeE7), nil
+}
+
+// waypoints returns the waypoints of the segment's route.
+// Waypoints are not timestamped, so their times are estimated
+// by spreading them evenly over the segment's duration.
+func (as *activitySegment) waypoints() []*waypoint {
+	points := as.WaypointPath.Waypoints
+	start, end := as.Duration.start(), as.Duration.end()
+	var wps []*waypoint
+	for i, p := range points {
+		ts := start
+		if len(points) > 1 && end.After(start) {
+			ts = start.Add(end.Sub(start) * time.Duration(i) / time.Duration(len(points)-1))
+		}
+		wps = append(wps, &waypoint{
+			id:    fmt.Sprintf("%s_waypoint_%d", as.ID(), i),
+			ts:    ts.Truncate(time.Second),
+			latE7: p.LatE7,
+			lngE7: p.LngE7,
+		})
+	}
+	return wps
+}
+
+// waypoint is a point on the route of an activity segment.
+type waypoint struct {
+	id           string
+	ts           time.Time
+	latE7, lngE7 int
+}
+
+func (wp *waypoint) ID() string {
+	return wp.id
+}
+
+func (wp *waypoint) Timestamp() time.Time {
+	return wp.ts
+}
+
+func (wp *waypoint) Class() timeliner.ItemClass {
+	return timeliner.ClassLocation
+}
+
+func (wp *waypoint) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (wp *waypoint) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (wp *waypoint) DataFileName() *string {
+	return nil
+}
+
+func (wp *waypoint) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (wp *waypoint) DataFileHash() []byte {
+	return nil
+}
+
+func (wp *waypoint) DataFileMIMEType() *string {
+	return nil
+}
+
+func (wp *waypoint) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (wp *waypoint) Location() (*timeliner.Location, error) {
+	return locationE7(wp.latE7, wp.lngE7), nil
+}
+
+type semanticLocation struct {
+	LatitudeE7   int    `json:"latitudeE7"`
+	LongitudeE7  int    `json:"longitudeE7"`
+	PlaceID      string `json:"placeId,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Name         string `json:"name,omitempty"`
+	SemanticType string `json:"semanticType,omitempty"`
+}
+
+// semanticDuration is the time span of a timeline object;
+// older exports use Unix milliseconds, newer ones RFC 3339.
+type semanticDuration struct {
+	StartTimestampMs string `json:"startTimestampMs,omitempty"`
+	EndTimestampMs   string `json:"endTimestampMs,omitempty"`
+	StartTimestamp   string `json:"startTimestamp,omitempty"`
+	EndTimestamp     string `json:"endTimestamp,omitempty"`
+}
+
+func (d semanticDuration) start() time.Time {
+	return parseSemanticTime(d.StartTimestampMs, d.StartTimestamp)
+}
+
+func (d semanticDuration) end() time.Time {
+	return parseSemanticTime(d.EndTimestampMs, d.EndTimestamp)
+}
+
+func parseSemanticTime(ms, rfc3339 string) time.Time {
+	if ms == "" && rfc3339 != "" {
+		ts, err := time.Parse(time.RFC3339, rfc3339)
+		if err != nil {
+			return time.Time{}
+		}
+		return ts.Truncate(time.Second)
+	}
+	msInt, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(msInt/1000, 0)
+}
+
+func locationE7(latE7, lngE7 int) *timeliner.Location {
+	if latE7 == 0 && lngE7 == 0 {
+		return nil
+	}
+	lat := float64(latE7) / 1e7
+	lon := float64(lngE7) / 1e7
+	return &timeliner.Location{
+		Latitude:  &lat,
+		Longitude: &lon,
+	}
+}
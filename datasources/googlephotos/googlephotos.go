@@ -1,5 +1,10 @@
 // Package googlephotos implements the Google Photos service
 // using its API, documented at https://developers.google.com/photos/.
+//
+// The API does not tell which media items were deleted, so finding
+// them takes a complete listing, as done by GetAll when pruning.
+// Items that are already stored are not downloaded again, so the
+// listing only pages through the media items' information.
 package googlephotos
 
 import (
@@ -80,26 +85,27 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	// load any previous checkpoint
 	c.checkpoint.load(opt.Checkpoint)
 
-	// get items and collections
-	errChan := make(chan error)
-	go func() {
-		err := c.listItems(ctx, itemChan, opt.Timeframe)
-		errChan <- err
-	}()
-	go func() {
-		err := c.listCollections(ctx, itemChan, opt.Timeframe)
-		errChan <- err
-	}()
-
-	// read exactly 2 error (or nil) values to ensure we
-	// block precisely until the two listers are done
+	// get items, then collections; items in shared albums are
+	// attributed to whoever added them unless they are in the
+	// owner's library, which is looked up among the stored items,
+	// so the library is listed and processed first
 	var errs []string
-	for i := 0; i < 2; i++ {
-		err := <-errChan
-		if err != nil {
-			log.Printf("[ERROR][%s/%s] A listing goroutine errored: %v", DataSourceID, c.userID, err)
-			errs = append(errs, err.Error())
-		}
+	err := c.listItems(ctx, itemChan, opt.Timeframe)
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Listing items: %v", DataSourceID, c.userID, err)
+		errs = append(errs, err.Error())
+	}
+	libraryProcessed := make(chan struct{})
+	timeliner.AfterItems(ctx, func() { close(libraryProcessed) })
+	select {
+	case <-libraryProcessed:
+	case <-ctx.Done():
+		return nil
+	}
+	err = c.listCollections(ctx, itemChan, opt.Timeframe)
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Listing albums: %v", DataSourceID, c.userID, err)
+		errs = append(errs, err.Error())
 	}
 	if len(errs) > 0 {
 		return fmt.Errorf("one or more errors: %s", strings.Join(errs, ", "))
@@ -156,6 +162,7 @@ func (c *Client) getItemsNextPage(itemChan chan<- *timeliner.ItemGraph,
 	}
 
 	for _, item := range page.MediaItems {
+		item.inLibrary = true
 		itemChan <- &timeliner.ItemGraph{
 			Node: item,
 		}
@@ -164,7 +171,8 @@ func (c *Client) getItemsNextPage(itemChan chan<- *timeliner.ItemGraph,
 	return page.NextPageToken, nil
 }
 
-// listCollections lists media items by iterating each album. As
+// listCollections lists media items by iterating each album, first
+// the owner's albums, then the shared albums they have joined. As
 // of Jan. 2019, the Google Photos API does not allow searching
 // media items with both an album ID and filters. Because this
 // search is predicated on album ID, we cannot be constrained by
@@ -173,47 +181,57 @@ func (c *Client) getItemsNextPage(itemChan chan<- *timeliner.ItemGraph,
 // See https://developers.google.com/photos/library/reference/rest/v1/mediaItems/search.
 func (c *Client) listCollections(ctx context.Context,
 	itemChan chan<- *timeliner.ItemGraph, timeframe timeliner.Timeframe) error {
-	c.checkpoint.mu.Lock()
-	albumPageToken := c.checkpoint.AlbumsNextPage
-	c.checkpoint.mu.Unlock()
+	// the owner's shared albums are also among their albums
+	listed := make(map[string]bool)
+
+	for _, endpoint := range []string{"/albums", "/sharedAlbums"} {
+		c.checkpoint.mu.Lock()
+		albumPageToken := c.checkpoint.albumsNextPage(endpoint)
+		c.checkpoint.mu.Unlock()
+
+		for {
+			if ctx.Err() != nil {
+				return nil
+			}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
 			var err error
-			albumPageToken, err = c.getAlbumsAndTheirItemsNextPage(itemChan, albumPageToken, timeframe)
+			albumPageToken, err = c.getAlbumsAndTheirItemsNextPage(ctx, itemChan, endpoint, albumPageToken, timeframe, listed)
 			if err != nil {
 				return err
 			}
 			if albumPageToken == "" {
-				return nil
+				break
 			}
 
 			c.checkpoint.mu.Lock()
-			c.checkpoint.AlbumsNextPage = albumPageToken
+			c.checkpoint.setAlbumsNextPage(endpoint, albumPageToken)
 			c.checkpoint.save(ctx)
 			c.checkpoint.mu.Unlock()
 		}
 	}
+
+	return nil
 }
 
-func (c *Client) getAlbumsAndTheirItemsNextPage(itemChan chan<- *timeliner.ItemGraph,
-	pageToken string, timeframe timeliner.Timeframe) (string, error) {
+func (c *Client) getAlbumsAndTheirItemsNextPage(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, endpoint,
+	pageToken string, timeframe timeliner.Timeframe, listed map[string]bool) (string, error) {
 	vals := url.Values{
 		"pageToken": {pageToken},
 		"pageSize":  {"50"},
 	}
 
 	var respBody listAlbums
-	err := c.apiRequestWithRetry("GET", "/albums?"+vals.Encode(), nil, &respBody)
+	err := c.apiRequestWithRetry("GET", endpoint+"?"+vals.Encode(), nil, &respBody)
 	if err != nil {
 		return pageToken, err
 	}
 
-	for _, album := range respBody.Albums {
-		err = c.getAlbumItems(itemChan, album, timeframe)
+	for _, album := range append(respBody.Albums, respBody.SharedAlbums...) {
+		if listed[album.ID] {
+			continue
+		}
+		listed[album.ID] = true
+		err = c.getAlbumItems(ctx, itemChan, album, timeframe)
 		if err != nil {
 			return "", err
 		}
@@ -222,7 +240,7 @@ func (c *Client) getAlbumsAndTheirItemsNextPage(itemChan chan<- *timeliner.ItemG
 	return respBody.NextPageToken, nil
 }
 
-func (c *Client) getAlbumItems(itemChan chan<- *timeliner.ItemGraph, album gpAlbum, timeframe timeliner.Timeframe) error {
+func (c *Client) getAlbumItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, album gpAlbum, timeframe timeliner.Timeframe) error {
 	var albumItemsNextPage string
 	var counter int
 
@@ -241,6 +259,11 @@ func (c *Client) getAlbumItems(itemChan chan<- *timeliner.ItemGraph, album gpAlb
 		// iterate each media item on this page of the album listing
 		var items []timeliner.CollectionItem
 		for _, it := range page.MediaItems {
+			it.inLibrary, err = timeliner.OwnItemStored(ctx, it.MediaID)
+			if err != nil {
+				return fmt.Errorf("looking up item in library: %v", err)
+			}
+
 			// since we cannot request items in an album and also filter
 			// by timestamp, be sure to filter here; it means we still
 			// have to iterate all items in all albums, but at least we
@@ -406,9 +429,29 @@ func dateRange(timeframe timeliner.Timeframe) listMediaItemsFilterRange {
 // somewhat quickly, before the page tokens/cursors expire),
 // we can just store the page tokens.
 type checkpointInfo struct {
-	ItemsNextPage  string
-	AlbumsNextPage string
-	mu             *sync.Mutex
+	ItemsNextPage        string
+	AlbumsNextPage       string
+	SharedAlbumsNextPage string
+	mu                   *sync.Mutex
+}
+
+// albumsNextPage returns the page token of the
+// albums listed at the given endpoint.
+func (ch *checkpointInfo) albumsNextPage(endpoint string) string {
+	if endpoint == "/sharedAlbums" {
+		return ch.SharedAlbumsNextPage
+	}
+	return ch.AlbumsNextPage
+}
+
+// setAlbumsNextPage sets the page token of the
+// albums listed at the given endpoint.
+func (ch *checkpointInfo) setAlbumsNextPage(endpoint, pageToken string) {
+	if endpoint == "/sharedAlbums" {
+		ch.SharedAlbumsNextPage = pageToken
+	} else {
+		ch.AlbumsNextPage = pageToken
+	}
 }
 
 // save records the checkpoint. It is NOT thread-safe,
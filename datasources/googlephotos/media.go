@@ -1,6 +1,7 @@
 package googlephotos
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,8 +27,12 @@ type mediaItem struct {
 	Description     string           `json:"description"`
 	MIMEType        string           `json:"mimeType"`
 	MediaMetadata   mediaMetadata    `json:"mediaMetadata"`
-	ContributorInfo mediaContributor `json:"mediaContributor"`
+	ContributorInfo mediaContributor `json:"contributorInfo"`
 	Filename        string           `json:"filename"`
+
+	// whether the item is in the owner's library, rather
+	// than only in a shared album someone else added it to
+	inLibrary bool
 }
 
 func (m mediaItem) ID() string {
@@ -38,7 +43,11 @@ func (m mediaItem) Timestamp() time.Time {
 	return m.MediaMetadata.CreationTime
 }
 
+// DataText returns the description of the item, if any.
 func (m mediaItem) DataText() (*string, error) {
+	if m.Description == "" {
+		return nil, nil
+	}
 	return &m.Description, nil
 }
 
@@ -46,6 +55,10 @@ func (m mediaItem) DataFileName() *string {
 	return &m.Filename
 }
 
+// DataFileReader returns a reader of the item's file, which is only
+// downloaded when it is first read, so that getting the reader for an
+// item that is already stored and does not need to be processed again
+// costs nothing.
 func (m mediaItem) DataFileReader() (io.ReadCloser, error) {
 	if m.MediaMetadata.Video != nil && m.MediaMetadata.Video.Status != "READY" {
 		log.Printf("[INFO] Skipping video file because it is not ready (status=%s filename=%s)",
@@ -63,57 +76,49 @@ func (m mediaItem) DataFileReader() (io.ReadCloser, error) {
 		u += "=dv"
 	}
 
-	const maxTries = 5
-	var err error
-	var resp *http.Response
-	for i := 0; i < maxTries; i++ {
-		resp, err = http.Get(u)
-		if err != nil {
-			err = fmt.Errorf("getting media contents: %v", err)
-			log.Printf("[ERROR][%s] %s: %v - retrying... (attempt %d/%d)", DataSourceID, u, err, i+1, maxTries)
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			bodyText, err2 := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*256))
-			resp.Body.Close()
-
-			if err2 == nil {
-				err = fmt.Errorf("HTTP %d: %s: >>> %s <<<", resp.StatusCode, resp.Status, bodyText)
-			} else {
-				err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
-			}
-
-			log.Printf("[ERROR][%s] %s: Bad response: %v - waiting and retrying... (attempt %d/%d)",
-				DataSourceID, u, err, i+1, maxTries)
-			time.Sleep(15 * time.Second)
-			continue
-		}
-		break
-	}
-
-	if resp == nil {
-		return nil, err
-	}
-	return resp.Body, err
+	return &mediaDownload{url: u}, nil
 }
 
+// DataFileHash returns a hash of the properties of the item's file,
+// since the API does not provide a hash of the file itself. It changes
+// if the file is replaced, as when it is edited, or if a video that
+// was not ready when it was last downloaded becomes ready.
 func (m mediaItem) DataFileHash() []byte {
-	return nil
+	var status string
+	if m.MediaMetadata.Video != nil {
+		status = m.MediaMetadata.Video.Status
+	}
+	h := sha256.New()
+	for _, s := range []string{
+		m.MediaID,
+		m.Filename,
+		m.MIMEType,
+		m.MediaMetadata.CreationTime.UTC().Format(time.RFC3339Nano),
+		m.MediaMetadata.Width,
+		m.MediaMetadata.Height,
+		status,
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
 }
 
 func (m mediaItem) DataFileMIMEType() *string {
 	return &m.MIMEType
 }
 
+// Owner returns the person who added the item to a shared album,
+// if it is not in the owner's library; otherwise the item is the
+// owner's, so the ID is left nil.
 func (m mediaItem) Owner() (*string, *string) {
-	// since we only download media owned by the account,
-	// we can leave ID nil and assume the display name
-	// is the account owner's name
-	if m.ContributorInfo.DisplayName != "" {
+	if m.ContributorInfo.DisplayName == "" {
+		return nil, nil
+	}
+	if m.inLibrary {
 		return nil, &m.ContributorInfo.DisplayName
 	}
-	return nil, nil
+	return &m.ContributorInfo.DisplayName, &m.ContributorInfo.DisplayName
 }
 
 func (m mediaItem) Class() timeliner.ItemClass {
@@ -174,6 +179,64 @@ func (m mediaItem) Location() (*timeliner.Location, error) {
 	return nil, nil
 }
 
+// mediaDownload downloads the file of a media item
+// from url when it is first read.
+type mediaDownload struct {
+	url  string
+	body io.ReadCloser
+}
+
+func (md *mediaDownload) Read(p []byte) (int, error) {
+	if md.body == nil {
+		body, err := downloadMedia(md.url)
+		if err != nil {
+			return 0, err
+		}
+		md.body = body
+	}
+	return md.body.Read(p)
+}
+
+func (md *mediaDownload) Close() error {
+	if md.body == nil {
+		return nil
+	}
+	return md.body.Close()
+}
+
+// downloadMedia requests the media file at u, with retries.
+func downloadMedia(u string) (io.ReadCloser, error) {
+	const maxTries = 5
+	var err error
+	var resp *http.Response
+	for i := 0; i < maxTries; i++ {
+		resp, err = http.Get(u)
+		if err != nil {
+			err = fmt.Errorf("getting media contents: %v", err)
+			log.Printf("[ERROR][%s] %s: %v - retrying... (attempt %d/%d)", DataSourceID, u, err, i+1, maxTries)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			bodyText, err2 := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*256))
+			resp.Body.Close()
+
+			if err2 == nil {
+				err = fmt.Errorf("HTTP %d: %s: >>> %s <<<", resp.StatusCode, resp.Status, bodyText)
+			} else {
+				err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+			}
+
+			log.Printf("[ERROR][%s] %s: Bad response: %v - waiting and retrying... (attempt %d/%d)",
+				DataSourceID, u, err, i+1, maxTries)
+			time.Sleep(15 * time.Second)
+			continue
+		}
+		return resp.Body, nil
+	}
+	return nil, err
+}
+
 type mediaMetadata struct {
 	CreationTime time.Time      `json:"creationTime"`
 	Width        string         `json:"width"`
@@ -245,6 +308,7 @@ type listMediaItemsMediaTypeFilter struct {
 
 type listAlbums struct {
 	Albums        []gpAlbum `json:"albums"`
+	SharedAlbums  []gpAlbum `json:"sharedAlbums"`
 	NextPageToken string    `json:"nextPageToken"`
 }
 
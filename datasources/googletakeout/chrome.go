@@ -0,0 +1,101 @@
+package googletakeout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// listBrowserHistory lists the pages in the Chrome
+// BrowserHistory.json read from r.
+func listBrowserHistory(ctx context.Context, r io.Reader, itemChan chan<- *timeliner.ItemGraph) error {
+	dec := json.NewDecoder(r)
+
+	// read the following opening tokens:
+	// 1. open brace '{'
+	// 2. "Browser History" field name,
+	// 3. the array value's opening bracket '['
+	for i := 0; i < 3; i++ {
+		_, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decoding opening token: %v", err)
+		}
+	}
+
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var v pageVisit
+		err := dec.Decode(&v)
+		if err != nil {
+			return fmt.Errorf("decoding page visit: %v", err)
+		}
+		if v.TimeUsec == 0 {
+			continue
+		}
+		itemChan <- timeliner.NewItemGraph(&v)
+	}
+
+	return nil
+}
+
+// pageVisit is a visit to a web page in Chrome.
+type pageVisit struct {
+	Title          string `json:"title"`
+	URL            string `json:"url"`
+	TimeUsec       int64  `json:"time_usec"`
+	PageTransition string `json:"page_transition"`
+	ClientID       string `json:"client_id"`
+}
+
+func (v *pageVisit) ID() string {
+	return fmt.Sprintf("chrome_%d", v.TimeUsec)
+}
+
+func (v *pageVisit) Timestamp() time.Time {
+	return time.Unix(0, v.TimeUsec*int64(time.Microsecond))
+}
+
+func (v *pageVisit) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+func (v *pageVisit) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (v *pageVisit) DataText() (*string, error) {
+	if v.Title == "" {
+		return nil, nil
+	}
+	return &v.Title, nil
+}
+
+func (v *pageVisit) DataFileName() *string {
+	return nil
+}
+
+func (v *pageVisit) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (v *pageVisit) DataFileHash() []byte {
+	return nil
+}
+
+func (v *pageVisit) DataFileMIMEType() *string {
+	return nil
+}
+
+func (v *pageVisit) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Link: v.URL}, nil
+}
+
+func (v *pageVisit) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
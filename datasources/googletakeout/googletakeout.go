@@ -0,0 +1,126 @@
+// Package googletakeout implements a Timeliner data source for
+// importing data from Google Takeout archives, which contain the
+// data from many Google products.
+//
+// Items imported from Takeout belong to the Takeout account, so
+// photos which are also downloaded with the Google Photos data
+// source, or locations also imported with the Google Location
+// History data source, are stored once for each.
+package googletakeout
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver"
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/googlelocation"
+	"github.com/mholt/timeliner/datasources/youtube"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Google Takeout"
+	DataSourceID   = "google_takeout"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists items from the Takeout archive at opt.Filename,
+// which must be non-empty. Each product in the archive is listed
+// by its own handler; products without a handler are skipped.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	// Google Photos media files need their sidecar metadata,
+	// which may come before or after them in the archive, so
+	// the metadata is gathered first and media listed after
+	photos := newPhotosIndex()
+
+	err := archiver.Walk(opt.Filename, func(f archiver.File) error {
+		defer f.Close()
+		if ctx.Err() != nil {
+			return archiver.ErrStopWalk
+		}
+		if f.IsDir() {
+			return nil
+		}
+
+		fpath := archivedPath(f)
+		product := productOf(fpath)
+
+		var err error
+		switch {
+		case product == "Google Photos" && strings.HasSuffix(fpath, ".json"):
+			err = photos.addMetadata(f, fpath)
+		case strings.HasPrefix(product, "Location History") &&
+			(path.Base(fpath) == "Records.json" || path.Base(fpath) == "Location History.json" ||
+				strings.Contains(fpath, "/Semantic Location History/") && strings.HasSuffix(fpath, ".json")):
+			err = googlelocation.ListLocations(ctx, f, itemChan)
+		case strings.HasPrefix(product, "YouTube") &&
+			(path.Base(fpath) == "watch-history.json" || path.Base(fpath) == "search-history.json"):
+			err = youtube.ListHistory(ctx, f, itemChan)
+		case product == "Chrome" && path.Base(fpath) == "BrowserHistory.json":
+			err = listBrowserHistory(ctx, f, itemChan)
+		}
+		if err != nil {
+			log.Printf("[ERROR][%s] Processing %s: %v", DataSourceID, fpath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking archive file %s: %v", opt.Filename, err)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return photos.listMedia(ctx, opt.Filename, itemChan)
+}
+
+// archivedPath returns the full path of f within its archive.
+func archivedPath(f archiver.File) string {
+	switch hdr := f.Header.(type) {
+	case zip.FileHeader:
+		return hdr.Name
+	case *tar.Header:
+		return hdr.Name
+	}
+	return f.Name()
+}
+
+// productOf returns the name of the product folder that
+// fpath is in, e.g. "Google Photos" for the path
+// "Takeout/Google Photos/Trip/IMG_1234.jpg".
+func productOf(fpath string) string {
+	parts := strings.SplitN(strings.TrimPrefix(fpath, "/"), "/", 3)
+	if len(parts) < 3 || parts[0] != "Takeout" {
+		return ""
+	}
+	return parts[1]
+}
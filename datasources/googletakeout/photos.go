@@ -0,0 +1,374 @@
+package googletakeout
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver"
+	"github.com/mholt/timeliner"
+)
+
+// photosIndex holds the metadata of the Google Photos
+// part of a Takeout, which is needed to list its media.
+type photosIndex struct {
+	sidecars map[string]photoMetadata // keyed by the path of the media file it describes
+	albums   map[string]albumMetadata // keyed by album folder path
+}
+
+func newPhotosIndex() *photosIndex {
+	return &photosIndex{
+		sidecars: make(map[string]photoMetadata),
+		albums:   make(map[string]albumMetadata),
+	}
+}
+
+// addMetadata decodes the JSON file at fpath, which is
+// either an album's metadata or a media file's sidecar.
+func (idx *photosIndex) addMetadata(r io.Reader, fpath string) error {
+	dir, name := path.Split(fpath)
+	dir = strings.TrimSuffix(dir, "/")
+
+	if name == "metadata.json" {
+		var album albumMetadata
+		err := json.NewDecoder(r).Decode(&album)
+		if err != nil {
+			return fmt.Errorf("decoding album metadata: %v", err)
+		}
+		idx.albums[dir] = album
+		return nil
+	}
+
+	var meta photoMetadata
+	err := json.NewDecoder(r).Decode(&meta)
+	if err != nil {
+		return fmt.Errorf("decoding sidecar: %v", err)
+	}
+	if meta.PhotoTakenTime.Timestamp == "" && meta.CreationTime.Timestamp == "" {
+		return nil // not a sidecar
+	}
+
+	// the sidecar's name is usually the media file's name plus
+	// ".json", but newer exports insert ".supplemental-metadata"
+	// before that, and long names are truncated; the title is the
+	// media file's original name, but does not distinguish
+	// duplicates in the same folder, so it is only a fallback
+	mediaName := strings.TrimSuffix(name, ".json")
+	if i := strings.Index(mediaName, ".supplemental-metadata"); i > 0 {
+		mediaName = mediaName[:i]
+	} else if i := strings.Index(mediaName, ".suppl"); i > 0 {
+		mediaName = mediaName[:i]
+	}
+	idx.sidecars[path.Join(dir, mediaName)] = meta
+	if meta.Title != "" {
+		if _, ok := idx.sidecars[path.Join(dir, meta.Title)]; !ok {
+			idx.sidecars[path.Join(dir, meta.Title)] = meta
+		}
+	}
+
+	return nil
+}
+
+// sidecarFor returns the sidecar metadata of the media
+// file at fpath, accounting for the ways Takeout names
+// sidecars of duplicate and edited files.
+func (idx *photosIndex) sidecarFor(fpath string) (photoMetadata, bool) {
+	if meta, ok := idx.sidecars[fpath]; ok {
+		return meta, true
+	}
+
+	dir, name := path.Split(fpath)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	// "IMG(1).jpg" is described by "IMG.jpg(1).json"
+	if m := dupeSuffix.FindStringSubmatch(base); m != nil {
+		if meta, ok := idx.sidecars[dir+m[1]+ext+m[2]]; ok {
+			return meta, true
+		}
+	}
+
+	// "IMG-edited.jpg" is described by the original's sidecar
+	if strings.HasSuffix(base, "-edited") {
+		return idx.sidecarFor(dir + strings.TrimSuffix(base, "-edited") + ext)
+	}
+
+	return photoMetadata{}, false
+}
+
+// listMedia lists the photos and videos in the archive. Zip
+// archives can be read at random, so media files are read only
+// when needed; other archives are streamed, which requires the
+// files to be buffered in memory.
+func (idx *photosIndex) listMedia(ctx context.Context, filename string, itemChan chan<- *timeliner.ItemGraph) error {
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return fmt.Errorf("opening zip archive: %v", err)
+		}
+		defer timeliner.AfterItems(ctx, func() { zr.Close() })
+		for _, zf := range zr.File {
+			if ctx.Err() != nil {
+				return nil
+			}
+			zf := zf
+			idx.sendMedia(zf.Name, func() (io.ReadCloser, error) { return zf.Open() }, itemChan)
+		}
+		return nil
+	}
+
+	err := archiver.Walk(filename, func(f archiver.File) error {
+		defer f.Close()
+		if ctx.Err() != nil {
+			return archiver.ErrStopWalk
+		}
+		fpath := archivedPath(f)
+		if f.IsDir() || productOf(fpath) != "Google Photos" || mediaClass(fpath) == timeliner.ClassUnknown {
+			return nil
+		}
+		buf := new(bytes.Buffer)
+		_, err := io.Copy(buf, f)
+		if err != nil {
+			return fmt.Errorf("copying item into memory: %v", err)
+		}
+		idx.sendMedia(fpath, func() (io.ReadCloser, error) {
+			return timeliner.FakeCloser(bytes.NewReader(buf.Bytes())), nil
+		}, itemChan)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking archive file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// sendMedia sends the media file at fpath as an item, along with
+// the album it belongs to, if any. Files that are not media in
+// Google Photos are ignored.
+func (idx *photosIndex) sendMedia(fpath string, open func() (io.ReadCloser, error),
+	itemChan chan<- *timeliner.ItemGraph) {
+	if productOf(fpath) != "Google Photos" {
+		return
+	}
+	class := mediaClass(fpath)
+	if class == timeliner.ClassUnknown {
+		return
+	}
+
+	meta, ok := idx.sidecarFor(fpath)
+	if !ok {
+		log.Printf("[ERROR][%s] No metadata for %s; skipping", DataSourceID, fpath)
+		return
+	}
+
+	p := &photo{
+		filename: path.Base(fpath),
+		class:    class,
+		meta:     meta,
+		open:     open,
+	}
+	ig := timeliner.NewItemGraph(p)
+
+	// photos are in their year folder ("Photos from 2019") as
+	// well as each album folder, which has a metadata file
+	dir := path.Dir(fpath)
+	if album, ok := idx.albums[dir]; ok {
+		name := album.Title
+		if name == "" {
+			name = path.Base(dir)
+		}
+		coll := timeliner.Collection{
+			OriginalID: "album_" + path.Base(dir),
+			Name:       &name,
+			Items:      []timeliner.CollectionItem{{Item: p}},
+		}
+		if album.Description != "" {
+			coll.Description = &album.Description
+		}
+		ig.Collections = append(ig.Collections, coll)
+	}
+
+	itemChan <- ig
+}
+
+// photo is a photo or video from Google Photos.
+type photo struct {
+	filename string
+	class    timeliner.ItemClass
+	meta     photoMetadata
+	open     func() (io.ReadCloser, error)
+}
+
+// ID returns the photo's ID. When the sidecar links to the
+// media item by its ID, that is used; most sidecars link to the
+// photo's page instead, so usually the ID is derived from the
+// original file name and the time it was taken. A photo in more
+// than one album has the same ID in each, so it is only stored
+// once. Photos from Takeout are items of the Takeout account, so
+// they are never the same items as those downloaded with the
+// Google Photos data source, even when the IDs are the same.
+func (p *photo) ID() string {
+	if i := strings.Index(p.meta.URL, mediaIDPath); i >= 0 {
+		id := p.meta.URL[i+len(mediaIDPath):]
+		if j := strings.IndexAny(id, "/?#"); j >= 0 {
+			id = id[:j]
+		}
+		if id != "" {
+			return id
+		}
+	}
+	name := p.meta.Title
+	if name == "" {
+		name = p.filename
+	}
+	return fmt.Sprintf("%s_%d", name, p.Timestamp().Unix())
+}
+
+func (p *photo) Timestamp() time.Time {
+	if ts := p.meta.PhotoTakenTime.time(); !ts.IsZero() {
+		return ts
+	}
+	return p.meta.CreationTime.time()
+}
+
+func (p *photo) Class() timeliner.ItemClass {
+	return p.class
+}
+
+func (p *photo) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (p *photo) DataText() (*string, error) {
+	if p.meta.Description == "" {
+		return nil, nil
+	}
+	return &p.meta.Description, nil
+}
+
+func (p *photo) DataFileName() *string {
+	return &p.filename
+}
+
+func (p *photo) DataFileReader() (io.ReadCloser, error) {
+	return p.open()
+}
+
+func (p *photo) DataFileHash() []byte {
+	return nil
+}
+
+func (p *photo) DataFileMIMEType() *string {
+	mt := mime.TypeByExtension(strings.ToLower(path.Ext(p.filename)))
+	if mt == "" {
+		return nil
+	}
+	return &mt
+}
+
+func (p *photo) Metadata() (*timeliner.Metadata, error) {
+	if p.meta.GeoData.Altitude == 0 {
+		return nil, nil
+	}
+	return &timeliner.Metadata{
+		Altitude: int(p.meta.GeoData.Altitude),
+	}, nil
+}
+
+func (p *photo) Location() (*timeliner.Location, error) {
+	// Takeout uses 0,0 to mean no location
+	geo := p.meta.GeoData
+	if geo.Latitude == 0 && geo.Longitude == 0 {
+		geo = p.meta.GeoDataEXIF
+	}
+	if geo.Latitude == 0 && geo.Longitude == 0 {
+		return nil, nil
+	}
+	return &timeliner.Location{
+		Latitude:  &geo.Latitude,
+		Longitude: &geo.Longitude,
+	}, nil
+}
+
+// mediaClass returns the class of the media file at fpath
+// according to its extension, or ClassUnknown if it is not
+// a photo or video.
+func mediaClass(fpath string) timeliner.ItemClass {
+	ext := strings.ToLower(path.Ext(fpath))
+	switch ext {
+	case ".heic", ".heif", ".dng":
+		return timeliner.ClassImage
+	case ".mp4", ".mov", ".m4v", ".3gp", ".mts":
+		return timeliner.ClassVideo
+	}
+	mt := mime.TypeByExtension(ext)
+	switch {
+	case strings.HasPrefix(mt, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mt, "video/"):
+		return timeliner.ClassVideo
+	}
+	return timeliner.ClassUnknown
+}
+
+// photoMetadata is the structure of a media file's
+// sidecar JSON file.
+type photoMetadata struct {
+	Title          string        `json:"title"`
+	Description    string        `json:"description"`
+	CreationTime   takeoutTime   `json:"creationTime"`
+	PhotoTakenTime takeoutTime   `json:"photoTakenTime"`
+	GeoData        takeoutGeo    `json:"geoData"`
+	GeoDataEXIF    takeoutGeo    `json:"geoDataExif"`
+	URL            string        `json:"url"`
+	People         []photoPerson `json:"people,omitempty"`
+}
+
+type photoPerson struct {
+	Name string `json:"name"`
+}
+
+// albumMetadata is the structure of an album
+// folder's metadata.json file.
+type albumMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type takeoutTime struct {
+	Timestamp string `json:"timestamp"` // Unix seconds
+	Formatted string `json:"formatted"`
+}
+
+func (t takeoutTime) time() time.Time {
+	secs, err := strconv.ParseInt(t.Timestamp, 10, 64)
+	if err != nil || secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+type takeoutGeo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// mediaIDPath precedes the media item ID in the URLs
+// of some sidecars; most have photos.google.com/photo/
+// URLs, whose IDs are not those of the media items.
+const mediaIDPath = "photos.google.com/lr/photo/"
+
+// dupeSuffix matches the "(1)" that Takeout appends
+// to names of files that would otherwise collide.
+var dupeSuffix = regexp.MustCompile(`^(.*)(\(\d+\))$`)
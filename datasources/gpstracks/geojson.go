@@ -0,0 +1,146 @@
+package gpstracks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// parseGeoJSON reads a GeoJSON feature collection. Point features
+// become waypoints; LineString and MultiLineString features become
+// tracks if their properties have a "coordTimes" list with the time
+// of each coordinate, as written by common GPX/KML converters.
+func parseGeoJSON(f *os.File) (*trackFile, error) {
+	var doc struct {
+		Type     string           `json:"type"`
+		Features []geojsonFeature `json:"features"`
+	}
+	err := json.NewDecoder(f).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding GeoJSON: %v", err)
+	}
+	if doc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("not a GeoJSON feature collection: type %q", doc.Type)
+	}
+
+	tf := new(trackFile)
+	for _, feat := range doc.Features {
+		props := feat.Properties
+		switch feat.Geometry.Type {
+		case "Point":
+			var coord []float64
+			err := json.Unmarshal(feat.Geometry.Coordinates, &coord)
+			if err != nil {
+				return nil, fmt.Errorf("decoding point coordinates: %v", err)
+			}
+			pt := geojsonPoint(coord, props.Time)
+			if pt != nil {
+				pt.name = props.Name
+				pt.desc = props.description()
+				tf.waypoints = append(tf.waypoints, pt)
+			}
+
+		case "LineString":
+			var coords [][]float64
+			err := json.Unmarshal(feat.Geometry.Coordinates, &coords)
+			if err != nil {
+				return nil, fmt.Errorf("decoding line coordinates: %v", err)
+			}
+			var times []string
+			_ = json.Unmarshal(props.CoordTimes, &times)
+			tf.tracks = append(tf.tracks, &track{
+				name:     props.Name,
+				desc:     props.description(),
+				kind:     props.kind(),
+				segments: [][]*point{geojsonLine(coords, times)},
+			})
+
+		case "MultiLineString":
+			var lines [][][]float64
+			err := json.Unmarshal(feat.Geometry.Coordinates, &lines)
+			if err != nil {
+				return nil, fmt.Errorf("decoding multi-line coordinates: %v", err)
+			}
+			var times [][]string
+			_ = json.Unmarshal(props.CoordTimes, &times)
+			t := &track{
+				name: props.Name,
+				desc: props.description(),
+				kind: props.kind(),
+			}
+			for i, coords := range lines {
+				var lineTimes []string
+				if i < len(times) {
+					lineTimes = times[i]
+				}
+				t.segments = append(t.segments, geojsonLine(coords, lineTimes))
+			}
+			tf.tracks = append(tf.tracks, t)
+		}
+	}
+
+	return tf, nil
+}
+
+type geojsonFeature struct {
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties geojsonProperties `json:"properties"`
+}
+
+type geojsonProperties struct {
+	Name         string          `json:"name"`
+	Desc         string          `json:"desc"`
+	Description  string          `json:"description"`
+	Type         string          `json:"type"`
+	ActivityType string          `json:"activityType"`
+	Time         string          `json:"time"`
+	CoordTimes   json.RawMessage `json:"coordTimes"`
+}
+
+func (p geojsonProperties) description() string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return p.Desc
+}
+
+func (p geojsonProperties) kind() string {
+	if p.ActivityType != "" {
+		return p.ActivityType
+	}
+	return p.Type
+}
+
+func geojsonLine(coords [][]float64, times []string) []*point {
+	var pts []*point
+	for i, coord := range coords {
+		var ts string
+		if i < len(times) {
+			ts = times[i]
+		}
+		if pt := geojsonPoint(coord, ts); pt != nil {
+			pts = append(pts, pt)
+		}
+	}
+	return pts
+}
+
+// geojsonPoint returns the point at coord, which is longitude,
+// latitude, and optionally elevation, or nil if it is invalid.
+func geojsonPoint(coord []float64, ts string) *point {
+	if len(coord) < 2 {
+		return nil
+	}
+	pt := &point{
+		lon:  coord[0],
+		lat:  coord[1],
+		time: parseTime(ts),
+	}
+	if len(coord) > 2 {
+		pt.elevation = &coord[2]
+	}
+	return pt
+}
@@ -0,0 +1,276 @@
+// Package gpstracks implements a Timeliner data source for
+// importing GPS tracks and waypoints from GPX, KML, and
+// GeoJSON files, such as those exported by GPS devices and
+// fitness or navigation apps.
+package gpstracks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "GPS Tracks"
+	DataSourceID   = "gps_tracks"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		opts := acc.Options.(*Options)
+		return &Client{folder: opts.Folder}, nil
+	},
+	ClientOptions: func() interface{} { return new(Options) },
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options configures a GPS tracks client.
+type Options struct {
+	Folder string `toml:"folder" desc:"The folder of track files to import when no filename is given (e.g. with get-all or in daemon mode)"`
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct {
+	folder string
+}
+
+// ListItems lists the track points and waypoints in the file or
+// folder opt.Filename, or the configured folder if opt.Filename
+// is empty. Files are read according to their extension: .gpx,
+// .kml, or .geojson (or .json). Each track is listed as a
+// collection. Timeframes are not honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	root := opt.Filename
+	if root == "" {
+		root = c.folder
+	}
+	if root == "" {
+		return fmt.Errorf("filename or folder is required")
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", root, err)
+	}
+	if !info.IsDir() {
+		return listFile(ctx, root, itemChan)
+	}
+
+	err = filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[ERROR][%s] Accessing %s: %v", DataSourceID, fpath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errStopWalk
+		}
+		if !info.Mode().IsRegular() || parserFor(fpath) == nil {
+			return nil
+		}
+		err = listFile(ctx, fpath, itemChan)
+		if err != nil {
+			log.Printf("[ERROR][%s] Reading %s: %v", DataSourceID, fpath, err)
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// errStopWalk stops walking the folder.
+var errStopWalk = fmt.Errorf("stop walk")
+
+// parser reads the tracks and waypoints from a file.
+type parser func(f *os.File) (*trackFile, error)
+
+// parserFor returns the parser for the file at
+// fpath, or nil if the file type is not supported.
+func parserFor(fpath string) parser {
+	switch strings.ToLower(filepath.Ext(fpath)) {
+	case ".gpx":
+		return parseGPX
+	case ".kml":
+		return parseKML
+	case ".geojson", ".json":
+		return parseGeoJSON
+	}
+	return nil
+}
+
+// listFile lists the tracks and waypoints in the file at fpath.
+func listFile(ctx context.Context, fpath string, itemChan chan<- *timeliner.ItemGraph) error {
+	parse := parserFor(fpath)
+	if parse == nil {
+		return fmt.Errorf("unsupported file type: %s", filepath.Ext(fpath))
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tf, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %v", fpath, err)
+	}
+
+	for _, trk := range tf.tracks {
+		if ctx.Err() != nil {
+			return nil
+		}
+		listTrack(trk, itemChan)
+	}
+
+	for _, wpt := range tf.waypoints {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// waypoints without a time of their own
+		// are placed at the time of the file
+		if wpt.time.IsZero() {
+			wpt.time = tf.time
+		}
+		if wpt.time.IsZero() {
+			log.Printf("[ERROR][%s] Waypoint %q in %s has no timestamp; skipping", DataSourceID, wpt.name, fpath)
+			continue
+		}
+		wpt.waypoint = true
+		itemChan <- timeliner.NewItemGraph(wpt)
+	}
+
+	return nil
+}
+
+// listTrack sends the points of trk as items in a collection,
+// relating each point to the previous one in its segment.
+func listTrack(trk *track, itemChan chan<- *timeliner.ItemGraph) {
+	var first time.Time
+	for _, seg := range trk.segments {
+		for _, pt := range seg {
+			if !pt.time.IsZero() && (first.IsZero() || pt.time.Before(first)) {
+				first = pt.time
+			}
+		}
+	}
+	if first.IsZero() {
+		// tracks without times, like routes that were planned
+		// rather than recorded, do not belong on a timeline
+		return
+	}
+
+	coll := timeliner.Collection{
+		OriginalID: fmt.Sprintf("track_%d", first.Unix()),
+	}
+	if trk.name != "" {
+		coll.Name = &trk.name
+	}
+	if trk.desc != "" {
+		coll.Description = &trk.desc
+	}
+	movement := movementRelation(trk.kind)
+
+	var position int
+	for _, seg := range trk.segments {
+		var prev *point
+		for _, pt := range seg {
+			// points without a time can't be placed on the
+			// timeline, and we produce IDs based on timestamp,
+			// which must be unique (also, there's not much
+			// value in keeping more than one point per second)
+			if pt.time.IsZero() || (prev != nil && pt.time.Unix() == prev.time.Unix()) {
+				continue
+			}
+			if prev != nil {
+				pt.fillMotion(prev)
+			}
+
+			ig := timeliner.NewItemGraph(pt)
+			if prev != nil {
+				// bidirectional edge, like in Google Location History;
+				// the timestamps make it obvious which point came first
+				ig.Add(prev, movement)
+			}
+			c := coll
+			c.Items = []timeliner.CollectionItem{{Item: pt, Position: position}}
+			ig.Collections = append(ig.Collections, c)
+			itemChan <- ig
+
+			prev = pt
+			position++
+		}
+	}
+}
+
+// movementRelation returns the relation between consecutive
+// points of a track of the given kind (the activity type as
+// named by the app that recorded it). The labels match those
+// used for Google Location History where possible.
+func movementRelation(kind string) timeliner.Relation {
+	kind = strings.ToLower(kind)
+	label := "moved"
+	switch {
+	case strings.Contains(kind, "run"):
+		label = "running"
+	case strings.Contains(kind, "walk"), strings.Contains(kind, "hik"):
+		label = "walking"
+	case strings.Contains(kind, "cycl"), strings.Contains(kind, "bik"), strings.Contains(kind, "ride"):
+		label = "on_bicycle"
+	case strings.Contains(kind, "driv"), strings.Contains(kind, "car"), strings.Contains(kind, "motor"):
+		label = "in_vehicle"
+	}
+	return timeliner.Relation{Label: label, Bidirectional: true}
+}
+
+// trackFile is the contents of a track file.
+type trackFile struct {
+	time      time.Time // when the file was created, if known
+	tracks    []*track
+	waypoints []*point
+}
+
+// track is a recorded path, made of one or more
+// segments of consecutive points.
+type track struct {
+	name     string
+	desc     string
+	kind     string // activity type, if known
+	segments [][]*point
+}
+
+// parseTime parses timestamps as they appear in track
+// files, which are usually, but not always, RFC 3339.
+func parseTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,99 @@
+package gpstracks
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// parseGPX reads a GPX 1.0 or 1.1 file.
+func parseGPX(f *os.File) (*trackFile, error) {
+	var doc gpxDocument
+	err := xml.NewDecoder(f).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding GPX: %v", err)
+	}
+
+	tf := &trackFile{time: parseTime(doc.Metadata.Time)}
+	if tf.time.IsZero() {
+		tf.time = parseTime(doc.Time) // GPX 1.0
+	}
+
+	for _, trk := range doc.Tracks {
+		t := &track{
+			name: trk.Name,
+			desc: trk.Desc,
+			kind: trk.Type,
+		}
+		for _, seg := range trk.Segments {
+			var pts []*point
+			for _, pt := range seg.Points {
+				pts = append(pts, pt.point())
+			}
+			t.segments = append(t.segments, pts)
+		}
+		tf.tracks = append(tf.tracks, t)
+	}
+
+	for _, wpt := range doc.Waypoints {
+		tf.waypoints = append(tf.waypoints, wpt.point())
+	}
+
+	return tf, nil
+}
+
+type gpxDocument struct {
+	Time     string `xml:"time"` // GPX 1.0
+	Metadata struct {
+		Time string `xml:"time"`
+	} `xml:"metadata"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Tracks    []struct {
+		Name     string `xml:"name"`
+		Desc     string `xml:"desc"`
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat       float64  `xml:"lat,attr"`
+	Lon       float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+	Name      string   `xml:"name"`
+	Desc      string   `xml:"desc"`
+	Speed     *float64 `xml:"speed"`  // GPX 1.0
+	Course    *float64 `xml:"course"` // GPX 1.0
+
+	// GPX 1.1 has no speed or course, so Garmin's
+	// extension is the usual place for them
+	Extensions struct {
+		TrackPoint struct {
+			Speed  *float64 `xml:"speed"`
+			Course *float64 `xml:"course"`
+		} `xml:"TrackPointExtension"`
+	} `xml:"extensions"`
+}
+
+func (gp gpxPoint) point() *point {
+	p := &point{
+		lat:       gp.Lat,
+		lon:       gp.Lon,
+		elevation: gp.Elevation,
+		time:      parseTime(gp.Time),
+		speed:     gp.Speed,
+		heading:   gp.Course,
+		name:      gp.Name,
+		desc:      gp.Desc,
+	}
+	if p.speed == nil {
+		p.speed = gp.Extensions.TrackPoint.Speed
+	}
+	if p.heading == nil {
+		p.heading = gp.Extensions.TrackPoint.Course
+	}
+	return p
+}
@@ -0,0 +1,132 @@
+package gpstracks
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseKML reads a KML file. Placemarks may be nested in any
+// number of documents and folders. Points become waypoints and
+// tracks (gx:Track and gx:MultiTrack) become tracks; line strings
+// have no times, so they are not on the timeline.
+func parseKML(f *os.File) (*trackFile, error) {
+	tf := new(trackFile)
+
+	dec := xml.NewDecoder(f)
+	for {
+		tkn, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding KML: %v", err)
+		}
+		start, ok := tkn.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+
+		var pm kmlPlacemark
+		err = dec.DecodeElement(&pm, &start)
+		if err != nil {
+			return nil, fmt.Errorf("decoding placemark: %v", err)
+		}
+
+		if pm.Point != nil {
+			lon, lat, ele, ok := parseKMLCoord(pm.Point.Coordinates, ",")
+			if ok {
+				tf.waypoints = append(tf.waypoints, &point{
+					lat:       lat,
+					lon:       lon,
+					elevation: ele,
+					time:      parseTime(pm.TimeStamp.When),
+					name:      strings.TrimSpace(pm.Name),
+					desc:      strings.TrimSpace(pm.Description),
+				})
+			}
+		}
+
+		tracks := append(pm.Tracks, pm.MultiTrack.Tracks...)
+		if len(tracks) > 0 {
+			t := &track{
+				name: strings.TrimSpace(pm.Name),
+				desc: strings.TrimSpace(pm.Description),
+			}
+			for _, kt := range tracks {
+				t.segments = append(t.segments, kt.points())
+			}
+			tf.tracks = append(tf.tracks, t)
+		}
+	}
+
+	return tf, nil
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	TimeStamp   struct {
+		When string `xml:"when"`
+	} `xml:"TimeStamp"`
+	Point *struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point"`
+	Tracks     []kmlTrack `xml:"Track"`
+	MultiTrack struct {
+		Tracks []kmlTrack `xml:"Track"`
+	} `xml:"MultiTrack"`
+}
+
+// kmlTrack is a gx:Track, which has one
+// "when" element for each "gx:coord".
+type kmlTrack struct {
+	When   []string `xml:"when"`
+	Coords []string `xml:"coord"`
+}
+
+func (kt kmlTrack) points() []*point {
+	var pts []*point
+	for i, coord := range kt.Coords {
+		if i >= len(kt.When) {
+			break
+		}
+		lon, lat, ele, ok := parseKMLCoord(coord, " ")
+		if !ok {
+			continue
+		}
+		pts = append(pts, &point{
+			lat:       lat,
+			lon:       lon,
+			elevation: ele,
+			time:      parseTime(kt.When[i]),
+		})
+	}
+	return pts
+}
+
+// parseKMLCoord parses a KML coordinate, which is longitude,
+// latitude, and optionally altitude, separated by sep.
+func parseKMLCoord(s, sep string) (lon, lat float64, ele *float64, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), sep)
+	if len(parts) < 2 {
+		return 0, 0, nil, false
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, nil, false
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, nil, false
+	}
+	if len(parts) > 2 {
+		if alt, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64); err == nil {
+			ele = &alt
+		}
+	}
+	return lon, lat, ele, true
+}
@@ -0,0 +1,153 @@
+package gpstracks
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// point is a track point or waypoint.
+type point struct {
+	lat, lon  float64
+	elevation *float64 // meters
+	time      time.Time
+	speed     *float64 // meters per second
+	heading   *float64 // degrees
+
+	// waypoints only
+	waypoint bool
+	name     string
+	desc     string
+}
+
+// ID returns a string representation of the timestamp, since
+// there is no ID in track files; as with Google Location History,
+// it is assumed that one cannot be in two places at once. Waypoints
+// are not where one was, but where something is, so their IDs
+// include their name and coordinates.
+func (p *point) ID() string {
+	if p.waypoint {
+		return fmt.Sprintf("wpt_%d_%s_%.6f_%.6f", p.time.Unix(), p.name, p.lat, p.lon)
+	}
+	return fmt.Sprintf("loc_%d", p.time.Unix())
+}
+
+func (p *point) Timestamp() time.Time {
+	return p.time
+}
+
+func (p *point) Class() timeliner.ItemClass {
+	return timeliner.ClassLocation
+}
+
+func (p *point) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (p *point) DataText() (*string, error) {
+	if p.name == "" {
+		return nil, nil
+	}
+	return &p.name, nil
+}
+
+func (p *point) DataFileName() *string {
+	return nil
+}
+
+func (p *point) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (p *point) DataFileHash() []byte {
+	return nil
+}
+
+func (p *point) DataFileMIMEType() *string {
+	return nil
+}
+
+func (p *point) Metadata() (*timeliner.Metadata, error) {
+	var m timeliner.Metadata
+	var hasMetadata bool
+
+	if p.elevation != nil {
+		m.Altitude = int(math.Round(*p.elevation))
+		hasMetadata = true
+	}
+	if p.speed != nil {
+		m.Velocity = int(math.Round(*p.speed))
+		hasMetadata = true
+	}
+	if p.heading != nil {
+		m.Heading = int(math.Round(*p.heading))
+		hasMetadata = true
+	}
+	if p.name != "" {
+		m.Name = p.name
+		hasMetadata = true
+	}
+	if p.desc != "" {
+		m.Description = p.desc
+		hasMetadata = true
+	}
+
+	if hasMetadata {
+		return &m, nil
+	}
+	return nil, nil
+}
+
+func (p *point) Location() (*timeliner.Location, error) {
+	return &timeliner.Location{
+		Latitude:  &p.lat,
+		Longitude: &p.lon,
+	}, nil
+}
+
+// fillMotion computes the speed and heading of p from the
+// previous point, if the file did not record them.
+func (p *point) fillMotion(prev *point) {
+	if p.speed == nil {
+		if secs := p.time.Sub(prev.time).Seconds(); secs > 0 {
+			speed := distance(prev, p) / secs
+			p.speed = &speed
+		}
+	}
+	if p.heading == nil && (p.lat != prev.lat || p.lon != prev.lon) {
+		heading := bearing(prev, p)
+		p.heading = &heading
+	}
+}
+
+// distance returns the great-circle distance
+// between a and b in meters.
+func distance(a, b *point) float64 {
+	lat1, lat2 := radians(a.lat), radians(b.lat)
+	dLat := lat2 - lat1
+	dLon := radians(b.lon - a.lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// bearing returns the initial bearing from a to
+// b in degrees clockwise from north.
+func bearing(a, b *point) float64 {
+	lat1, lat2 := radians(a.lat), radians(b.lat)
+	dLon := radians(b.lon - a.lon)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(deg+360, 360)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371008.8
@@ -0,0 +1,224 @@
+package ics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+	"github.com/teambition/rrule-go"
+)
+
+// occurrences returns the occurrences of the calendar's events
+// in w. Cancelled events and occurrences are left out.
+func (c *calendar) occurrences(w window) []*occurrence {
+	masters := make(map[string]*event)
+	for _, ev := range c.events {
+		if ev.recurrenceID.IsZero() {
+			masters[ev.uid] = ev
+		}
+	}
+
+	var occs []*occurrence
+
+	// events with a recurrence ID replace the occurrence
+	// of the recurring event that was at that time
+	overridden := make(map[string]bool)
+	for _, ev := range c.events {
+		if ev.recurrenceID.IsZero() {
+			continue
+		}
+		series := ev
+		if master, ok := masters[ev.uid]; ok {
+			series = master
+		}
+		id := series.occurrenceID(ev.recurrenceID)
+		overridden[id] = true
+		if ev.status == "CANCELLED" || ev.start.Before(w.after) || ev.start.After(w.before) {
+			continue
+		}
+		occs = append(occs, &occurrence{event: ev, id: id, start: ev.start})
+	}
+
+	for _, ev := range c.events {
+		if !ev.recurrenceID.IsZero() || ev.status == "CANCELLED" {
+			continue
+		}
+		if !ev.recurring() {
+			if w.includes(ev.start) {
+				occs = append(occs, &occurrence{event: ev, id: ev.id(), start: ev.start})
+			}
+			continue
+		}
+		set, err := ev.recurrence()
+		if err != nil {
+			log.Printf("[ERROR][%s] Expanding recurring event %s: %v", DataSourceID, ev.uid, err)
+			continue
+		}
+		for _, start := range set.Between(w.after, w.before, true) {
+			id := ev.occurrenceID(start)
+			if overridden[id] {
+				continue
+			}
+			occs = append(occs, &occurrence{event: ev, id: id, start: start})
+		}
+	}
+
+	return occs
+}
+
+// recurring returns true if the event repeats.
+func (e *event) recurring() bool {
+	return e.rrule != "" || len(e.rdates) > 0
+}
+
+// recurrence returns the set of start times of the
+// occurrences of a recurring event.
+func (e *event) recurrence() (*rrule.Set, error) {
+	set := new(rrule.Set)
+	if e.rrule != "" {
+		ropt, err := rrule.StrToROptionInLocation(e.rrule, e.start.Location())
+		if err != nil {
+			return nil, err
+		}
+		ropt.Dtstart = e.start
+		r, err := rrule.NewRRule(*ropt)
+		if err != nil {
+			return nil, err
+		}
+		set.RRule(r)
+	}
+	// the start is always the first occurrence,
+	// even if it doesn't match the rule
+	set.RDate(e.start)
+	for _, t := range e.rdates {
+		set.RDate(t)
+	}
+	for _, t := range e.exdates {
+		set.ExDate(t)
+	}
+	return set, nil
+}
+
+// id returns the event's UID, or if it doesn't have one,
+// an ID derived from its start time and summary.
+func (e *event) id() string {
+	if e.uid != "" {
+		return e.uid
+	}
+	h := sha256.Sum256([]byte(e.start.Format(time.RFC3339) + "\n" + e.summary))
+	return "sha256_" + hex.EncodeToString(h[:])
+}
+
+// occurrenceID returns the ID of the occurrence of the event
+// that was originally scheduled to start at start. It is the
+// event's ID and the start as a local date or date-time in
+// the event's time zone (the same way RECURRENCE-ID values
+// are usually written), so it doesn't change when the event
+// is imported on a computer in a different time zone.
+func (e *event) occurrenceID(start time.Time) string {
+	layout := "20060102T150405"
+	if e.allDay {
+		layout = "20060102"
+	}
+	return e.id() + "_" + start.In(e.start.Location()).Format(layout)
+}
+
+// length returns how long each occurrence of the event lasts.
+func (e *event) length() time.Duration {
+	switch {
+	case !e.end.IsZero():
+		return e.end.Sub(e.start)
+	case e.duration != 0:
+		return e.duration
+	case e.allDay:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// occurrence is an occurrence of an event; events
+// that don't repeat have exactly one.
+type occurrence struct {
+	*event
+	id    string
+	start time.Time
+}
+
+func (o *occurrence) itemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(o)
+	for _, a := range o.attendees {
+		if a.email == "" {
+			continue
+		}
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   a.email,
+			Name:     a.name,
+			Relation: timeliner.RelAttendee,
+		})
+	}
+	return ig
+}
+
+func (o *occurrence) ID() string {
+	return o.id
+}
+
+func (o *occurrence) Timestamp() time.Time {
+	return o.start
+}
+
+func (o *occurrence) Class() timeliner.ItemClass {
+	return timeliner.ClassEvent
+}
+
+func (o *occurrence) Owner() (*string, *string) {
+	if o.organizer == nil || o.organizer.email == "" {
+		return nil, nil
+	}
+	return &o.organizer.email, &o.organizer.name
+}
+
+// DataText returns the summary and description of the event.
+func (o *occurrence) DataText() (*string, error) {
+	text := strings.TrimSpace(o.summary + "\n\n" + o.description)
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (o *occurrence) DataFileName() *string {
+	return nil
+}
+
+func (o *occurrence) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (o *occurrence) DataFileHash() []byte {
+	return nil
+}
+
+func (o *occurrence) DataFileMIMEType() *string {
+	return nil
+}
+
+func (o *occurrence) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{
+		Name:        o.summary,
+		GeneralArea: o.location,
+		Link:        o.url,
+		Duration:    o.length(),
+	}, nil
+}
+
+func (o *occurrence) Location() (*timeliner.Location, error) {
+	if o.lat == nil || o.lon == nil {
+		return nil, nil
+	}
+	return &timeliner.Location{Latitude: o.lat, Longitude: o.lon}, nil
+}
@@ -0,0 +1,188 @@
+// Package ics implements a Timeliner data source for importing
+// events from iCalendar (.ics) files, as exported by most
+// calendar apps and services.
+package ics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "iCalendar"
+	DataSourceID   = "ics"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		opts := acc.Options.(*Options)
+		return &Client{opts: *opts}, nil
+	},
+	ClientOptions: func() interface{} {
+		return &Options{YearsBack: 10, YearsAhead: 1}
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options configures an iCalendar client.
+type Options struct {
+	YearsBack  int `toml:"years_back" desc:"How many years before now to list the occurrences of recurring events for"`
+	YearsAhead int `toml:"years_ahead" desc:"How many years after now to list the occurrences of recurring events for"`
+}
+
+// Validate returns an error if the options are not usable.
+func (o *Options) Validate() error {
+	if o.YearsBack < 0 || o.YearsAhead < 0 {
+		return fmt.Errorf("years_back and years_ahead must not be negative")
+	}
+	return nil
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct {
+	opts Options
+}
+
+// ListItems lists the events in the .ics file opt.Filename, or
+// in all the .ics files in it if it is a folder. Each calendar
+// is listed as a collection.
+//
+// Recurring events are expanded into their occurrences within
+// the configured number of years before and after now, further
+// limited by opt.Timeframe; other events are listed if they
+// are within opt.Timeframe.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	now := time.Now()
+	w := window{
+		after:  now.AddDate(-c.opts.YearsBack, 0, 0),
+		before: now.AddDate(c.opts.YearsAhead, 0, 0),
+		tf:     opt.Timeframe,
+	}
+	if tf := opt.Timeframe; tf.Since != nil && tf.Since.After(w.after) {
+		w.after = *tf.Since
+	}
+	if tf := opt.Timeframe; tf.Until != nil && tf.Until.Before(w.before) {
+		w.before = *tf.Until
+	}
+
+	info, err := os.Stat(opt.Filename)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", opt.Filename, err)
+	}
+	if !info.IsDir() {
+		return listFile(ctx, opt.Filename, w, itemChan)
+	}
+
+	err = filepath.Walk(opt.Filename, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[ERROR][%s] Accessing %s: %v", DataSourceID, fpath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errStopWalk
+		}
+		if !info.Mode().IsRegular() || !strings.EqualFold(filepath.Ext(fpath), ".ics") {
+			return nil
+		}
+		err = listFile(ctx, fpath, w, itemChan)
+		if err != nil {
+			log.Printf("[ERROR][%s] Listing %s: %v", DataSourceID, fpath, err)
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// errStopWalk stops walking the folder.
+var errStopWalk = fmt.Errorf("stop walk")
+
+// listFile lists the events of the calendars in the file at fpath.
+func listFile(ctx context.Context, fpath string, w window, itemChan chan<- *timeliner.ItemGraph) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cals, err := parseCalendars(f)
+	if err != nil {
+		return fmt.Errorf("parsing calendar: %v", err)
+	}
+
+	for _, cal := range cals {
+		// calendars don't necessarily have IDs or names; if
+		// not, the file is the best way to tell them apart
+		coll := timeliner.Collection{OriginalID: cal.id}
+		name := cal.name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(fpath), filepath.Ext(fpath))
+		}
+		if coll.OriginalID == "" {
+			coll.OriginalID = "calendar_" + name
+		}
+		coll.Name = &name
+		if cal.desc != "" {
+			coll.Description = &cal.desc
+		}
+
+		for _, occ := range cal.occurrences(w) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			ig := occ.itemGraph()
+			c := coll
+			c.Items = []timeliner.CollectionItem{{Item: occ}}
+			ig.Collections = append(ig.Collections, c)
+			itemChan <- ig
+		}
+	}
+
+	return nil
+}
+
+// window is the span of time to list events from.
+// Recurring events are expanded into the occurrences
+// between after and before; other events are listed
+// if they are within the timeframe.
+type window struct {
+	after, before time.Time
+	tf            timeliner.Timeframe
+}
+
+func (w window) includes(t time.Time) bool {
+	if w.tf.Since != nil && t.Before(*w.tf.Since) {
+		return false
+	}
+	if w.tf.Until != nil && t.After(*w.tf.Until) {
+		return false
+	}
+	return true
+}
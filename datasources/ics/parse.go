@@ -0,0 +1,418 @@
+package ics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// calendar is a VCALENDAR component.
+type calendar struct {
+	id     string // X-WR-RELCALID, if any
+	name   string // X-WR-CALNAME, if any
+	desc   string // X-WR-CALDESC, if any
+	events []*event
+}
+
+// event is a VEVENT component. If it has a recurrence ID,
+// it overrides one occurrence of the recurring event that
+// has the same UID.
+type event struct {
+	uid          string
+	summary      string
+	description  string
+	location     string
+	url          string
+	status       string
+	start, end   time.Time
+	duration     time.Duration
+	allDay       bool
+	lat, lon     *float64
+	organizer    *person
+	attendees    []person
+	rrule        string
+	rdates       []time.Time
+	exdates      []time.Time
+	recurrenceID time.Time
+}
+
+// person is an organizer or attendee of an event.
+type person struct {
+	email string
+	name  string
+}
+
+// property is a content line of an iCalendar file.
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseCalendars reads the calendars in the iCalendar
+// stream from r. Components other than events, such as
+// to-dos, alarms, and time zone definitions, are skipped.
+func parseCalendars(r io.Reader) ([]*calendar, error) {
+	var cals []*calendar
+	var cal *calendar
+	var ev *event
+	var stack []string // names of the components we're in
+
+	lr := newLineReader(r)
+	for {
+		line, ok := lr.next()
+		if !ok {
+			break
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lr.lineNum, err)
+		}
+
+		switch p.name {
+		case "BEGIN":
+			comp := strings.ToUpper(p.value)
+			switch {
+			case comp == "VCALENDAR" && len(stack) == 0:
+				cal = new(calendar)
+				cals = append(cals, cal)
+			case comp == "VEVENT" && len(stack) == 1 && cal != nil:
+				ev = new(event)
+			}
+			stack = append(stack, comp)
+			continue
+
+		case "END":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: END:%s without BEGIN", lr.lineNum, p.value)
+			}
+			if comp := stack[len(stack)-1]; comp == "VEVENT" && ev != nil {
+				if !ev.start.IsZero() {
+					cal.events = append(cal.events, ev)
+				}
+				ev = nil
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		switch {
+		case len(stack) == 1 && cal != nil:
+			cal.setProperty(p)
+		case len(stack) == 2 && ev != nil:
+			err := ev.setProperty(p)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", lr.lineNum, p.name, err)
+			}
+		}
+	}
+	if err := lr.err(); err != nil {
+		return nil, err
+	}
+
+	return cals, nil
+}
+
+func (c *calendar) setProperty(p property) {
+	switch p.name {
+	case "X-WR-RELCALID":
+		c.id = p.value
+	case "X-WR-CALNAME":
+		c.name = unescapeText(p.value)
+	case "X-WR-CALDESC":
+		c.desc = unescapeText(p.value)
+	}
+}
+
+func (e *event) setProperty(p property) error {
+	var err error
+	switch p.name {
+	case "UID":
+		e.uid = p.value
+	case "SUMMARY":
+		e.summary = unescapeText(p.value)
+	case "DESCRIPTION":
+		e.description = unescapeText(p.value)
+	case "LOCATION":
+		e.location = unescapeText(p.value)
+	case "URL":
+		e.url = p.value
+	case "STATUS":
+		e.status = strings.ToUpper(p.value)
+	case "DTSTART":
+		e.start, e.allDay, err = parseDateTime(p.value, p.params)
+	case "DTEND":
+		e.end, _, err = parseDateTime(p.value, p.params)
+	case "DURATION":
+		e.duration, err = parseDuration(p.value)
+	case "RECURRENCE-ID":
+		e.recurrenceID, _, err = parseDateTime(p.value, p.params)
+	case "RRULE":
+		if e.rrule == "" { // only one rule is supported
+			e.rrule = p.value
+		}
+	case "RDATE":
+		if p.params["VALUE"] == "PERIOD" {
+			break
+		}
+		var ts []time.Time
+		ts, err = parseDateTimes(p.value, p.params)
+		e.rdates = append(e.rdates, ts...)
+	case "EXDATE":
+		var ts []time.Time
+		ts, err = parseDateTimes(p.value, p.params)
+		e.exdates = append(e.exdates, ts...)
+	case "GEO":
+		parts := strings.Split(p.value, ";")
+		if len(parts) != 2 {
+			return fmt.Errorf("malformed value: %s", p.value)
+		}
+		lat, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return err
+		}
+		e.lat, e.lon = &lat, &lon
+	case "ORGANIZER":
+		e.organizer = &person{email: calAddress(p.value), name: p.params["CN"]}
+	case "ATTENDEE":
+		e.attendees = append(e.attendees, person{email: calAddress(p.value), name: p.params["CN"]})
+	}
+	return err
+}
+
+// lineReader reads the content lines of an iCalendar
+// stream, unfolding lines that were split across more
+// than one physical line.
+type lineReader struct {
+	sc      *bufio.Scanner
+	pending string
+	havePen bool
+	lineNum int // physical line number of the last line read
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	return &lineReader{sc: sc}
+}
+
+// next returns the next unfolded content line.
+func (lr *lineReader) next() (string, bool) {
+	for lr.sc.Scan() {
+		lr.lineNum++
+		line := strings.TrimRight(lr.sc.Text(), "\r")
+		if lr.lineNum == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+		}
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			lr.pending += line[1:]
+			continue
+		}
+		prev, hadPrev := lr.pending, lr.havePen
+		lr.pending, lr.havePen = line, true
+		if hadPrev {
+			return prev, true
+		}
+	}
+	if lr.havePen {
+		lr.havePen = false
+		return lr.pending, true
+	}
+	return "", false
+}
+
+func (lr *lineReader) err() error {
+	return lr.sc.Err()
+}
+
+// parseProperty parses a content line, which has the form
+// NAME;PARAM=VALUE;PARAM="VALUE":VALUE. Names and parameter
+// names are upper-cased, since they are case-insensitive.
+func parseProperty(line string) (property, error) {
+	p := property{params: make(map[string]string)}
+
+	// the value starts after the first colon that is not
+	// in a quoted parameter value
+	var quoted, colon bool
+	var fields []string
+	start := 0
+scan:
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ';', ':':
+			if quoted {
+				continue
+			}
+			fields = append(fields, line[start:i])
+			start = i + 1
+			if line[i] == ':' {
+				p.value = line[start:]
+				colon = true
+				break scan
+			}
+		}
+	}
+	if !colon {
+		return p, fmt.Errorf("malformed content line: %s", line)
+	}
+
+	p.name = strings.ToUpper(fields[0])
+	for _, param := range fields[1:] {
+		eq := strings.Index(param, "=")
+		if eq < 0 {
+			continue
+		}
+		key := strings.ToUpper(param[:eq])
+		p.params[key] = strings.Trim(param[eq+1:], `"`)
+	}
+
+	return p, nil
+}
+
+// unescapeText undoes the escaping of a TEXT value.
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(s[i])
+			}
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// calAddress returns the email address of a CAL-ADDRESS
+// value, such as "mailto:someone@example.com", which is
+// lower-cased since addresses are case-insensitive in
+// practice.
+func calAddress(s string) string {
+	if len(s) >= 7 && strings.EqualFold(s[:7], "mailto:") {
+		s = s[7:]
+	}
+	return strings.ToLower(s)
+}
+
+// parseDateTime parses a DATE or DATE-TIME value in the zone
+// named by its TZID parameter, if it has one. Times that are
+// neither UTC nor in a named zone are "floating", meaning the
+// same wall-clock time in any zone; they are taken to be in
+// the local time zone. It also returns whether the value is
+// a date (an all-day event) rather than a date-time.
+func parseDateTime(value string, params map[string]string) (time.Time, bool, error) {
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		loc = loadLocation(tzid)
+	}
+	if len(value) == 8 || params["VALUE"] == "DATE" {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parseDateTimes parses a comma-separated list of
+// DATE or DATE-TIME values, like those of EXDATE.
+func parseDateTimes(value string, params map[string]string) ([]time.Time, error) {
+	var ts []time.Time
+	for _, v := range strings.Split(value, ",") {
+		t, _, err := parseDateTime(strings.TrimSpace(v), params)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// loadLocation returns the time zone named tzid. Some apps
+// prefix IANA zone names with a path, such as in
+// "/mozilla.org/20050126_1/America/New_York", so if tzid is
+// not a known zone, its leading path elements are dropped
+// until it is. Unknown zones are treated as local time.
+func loadLocation(tzid string) *time.Location {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+	if loc, ok := locations[tzid]; ok {
+		return loc
+	}
+	loc := time.Local
+	for name := tzid; name != ""; {
+		if l, err := time.LoadLocation(name); err == nil {
+			loc = l
+			break
+		}
+		slash := strings.Index(name, "/")
+		if slash < 0 {
+			break
+		}
+		name = name[slash+1:]
+	}
+	locations[tzid] = loc
+	return loc
+}
+
+// locations caches time zones by TZID.
+var (
+	locations   = make(map[string]*time.Location)
+	locationsMu sync.Mutex
+)
+
+// parseDuration parses a DURATION value like "PT1H30M" or "P2W".
+func parseDuration(value string) (time.Duration, error) {
+	m := durationRegexp.FindStringSubmatch(value)
+	if m == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("malformed duration: %s", value)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+	} {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+var durationRegexp = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
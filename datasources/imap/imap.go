@@ -0,0 +1,415 @@
+// Package imap implements a Timeliner data source for
+// importing email from mail servers using IMAP.
+package imap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/email"
+	"golang.org/x/oauth2"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "IMAP"
+	DataSourceID   = "imap"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		opts := acc.Options.(*Options)
+		c := &Client{
+			opts:     *opts,
+			username: opts.Username,
+		}
+		if c.username == "" {
+			c.username = acc.UserID
+		}
+		if opts.OAuth2Provider != "" {
+			scopes := opts.OAuth2Scopes
+			if len(scopes) == 0 {
+				scopes = defaultScopes[opts.OAuth2Provider]
+			}
+			var err error
+			c.tokenSource, err = acc.NewOAuth2TokenSource(timeliner.OAuth2{
+				ProviderID: opts.OAuth2Provider,
+				Scopes:     scopes,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("getting OAuth2 token source: %v", err)
+			}
+		}
+		return c, nil
+	},
+	ClientOptions: func() interface{} {
+		return &Options{Security: "tls"}
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// defaultScopes are the OAuth2 scopes needed
+// for IMAP access by well-known providers.
+var defaultScopes = map[string][]string{
+	"google":    {"https://mail.google.com/"},
+	"microsoft": {"https://outlook.office.com/IMAP.AccessAsUser.All", "offline_access"},
+}
+
+// Options configures an IMAP client.
+type Options struct {
+	Host           string   `toml:"host" desc:"The mail server's address as host:port (e.g. imap.gmail.com:993)"`
+	Security       string   `toml:"security" desc:"How to secure the connection: tls, starttls, or none"`
+	Username       string   `toml:"username" desc:"The username to log in with, if not the account's user ID"`
+	Password       string   `toml:"password" desc:"The password to log in with, unless OAuth2 is used"`
+	OAuth2Provider string   `toml:"oauth2_provider" desc:"The OAuth2 provider to log in with using XOAUTH2 instead of a password (e.g. google)"`
+	OAuth2Scopes   []string `toml:"oauth2_scopes" desc:"The OAuth2 scopes to ask for, if not the provider's usual scopes for IMAP"`
+	Folders        []string `toml:"folders" desc:"The folders to import (default: all folders except junk and trash)"`
+}
+
+// Validate returns an error if the options are not usable.
+func (o *Options) Validate() error {
+	if o.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	switch o.Security {
+	case "tls", "starttls", "none":
+	default:
+		return fmt.Errorf("unknown security %q: must be tls, starttls, or none", o.Security)
+	}
+	if o.Password == "" && o.OAuth2Provider == "" {
+		return fmt.Errorf("password or oauth2_provider is required")
+	}
+	if o.Password != "" && o.OAuth2Provider != "" {
+		return fmt.Errorf("password and oauth2_provider are mutually exclusive")
+	}
+	if o.OAuth2Provider != "" && len(o.OAuth2Scopes) == 0 && defaultScopes[o.OAuth2Provider] == nil {
+		return fmt.Errorf("oauth2_scopes is required for provider %s", o.OAuth2Provider)
+	}
+	return nil
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct {
+	opts        Options
+	username    string
+	tokenSource oauth2.TokenSource
+}
+
+// ListItems lists the messages in the mailbox's folders, each of
+// which is a collection. Importing from files is not supported.
+//
+// Where each folder was left off is remembered by its UIDVALIDITY
+// and the highest UID listed from it. That position is saved in a
+// checkpoint as the listing progresses, so an interrupted listing
+// resumes where it stopped, and in the sync state when done. If
+// opt.Timeframe.Since is set (as with get-latest), only messages
+// after the saved position are listed, or if there isn't one (or
+// the folder's UIDVALIDITY changed), messages since that date.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename != "" {
+		return fmt.Errorf("importing from a file is not supported")
+	}
+
+	var pos positions
+	var err error
+	switch {
+	case opt.Checkpoint != nil:
+		err = timeliner.UnmarshalGob(opt.Checkpoint, &pos)
+	case opt.Timeframe.Since != nil && opt.SyncState != nil:
+		err = timeliner.UnmarshalGob(opt.SyncState, &pos)
+	}
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Decoding saved folder positions; starting over: %v",
+			DataSourceID, c.username, err)
+		pos = nil
+	}
+	if pos == nil {
+		pos = make(positions)
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Logout()
+
+	folders, err := c.folders(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := c.listFolder(ctx, conn, folder, pos, opt.Timeframe, itemChan)
+		if err != nil {
+			return fmt.Errorf("listing folder %s: %v", folder, err)
+		}
+	}
+
+	posBytes, err := timeliner.MarshalGob(pos)
+	if err != nil {
+		return fmt.Errorf("encoding folder positions: %v", err)
+	}
+	timeliner.SaveSyncState(ctx, posBytes)
+
+	return nil
+}
+
+// connect connects and logs in to the mail server.
+func (c *Client) connect() (*client.Client, error) {
+	var conn *client.Client
+	var err error
+	switch c.opts.Security {
+	case "tls":
+		conn, err = client.DialTLS(c.opts.Host, nil)
+	default:
+		conn, err = client.Dial(c.opts.Host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %v", c.opts.Host, err)
+	}
+
+	if c.opts.Security == "starttls" {
+		host := c.opts.Host
+		if i := strings.LastIndex(host, ":"); i > 0 {
+			host = host[:i]
+		}
+		err := conn.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			conn.Logout()
+			return nil, fmt.Errorf("starting TLS: %v", err)
+		}
+	}
+
+	if c.tokenSource != nil {
+		var tkn *oauth2.Token
+		tkn, err = c.tokenSource.Token()
+		if err != nil {
+			conn.Logout()
+			return nil, fmt.Errorf("getting OAuth2 token: %v", err)
+		}
+		err = conn.Authenticate(xoauth2Client{username: c.username, token: tkn.AccessToken})
+	} else {
+		err = conn.Login(c.username, c.opts.Password)
+	}
+	if err != nil {
+		conn.Logout()
+		return nil, fmt.Errorf("logging in as %s: %v", c.username, err)
+	}
+
+	return conn, nil
+}
+
+// folders returns the names of the folders to list.
+func (c *Client) folders(conn *client.Client) ([]string, error) {
+	if len(c.opts.Folders) > 0 {
+		return c.opts.Folders, nil
+	}
+
+	ch := make(chan *goimap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.List("", "*", ch)
+	}()
+
+	var folders []string
+	for mbox := range ch {
+		if hasAttr(mbox, goimap.NoSelectAttr) ||
+			hasAttr(mbox, goimap.JunkAttr) ||
+			hasAttr(mbox, goimap.TrashAttr) {
+			continue
+		}
+		folders = append(folders, mbox.Name)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("listing folders: %v", err)
+	}
+
+	return folders, nil
+}
+
+func hasAttr(mbox *goimap.MailboxInfo, attr string) bool {
+	for _, a := range mbox.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// listFolder lists the messages in folder that are not before its
+// position in pos, and updates its position as messages are listed.
+func (c *Client) listFolder(ctx context.Context, conn *client.Client, folder string,
+	pos positions, tf timeliner.Timeframe, itemChan chan<- *timeliner.ItemGraph) error {
+	status, err := conn.Select(folder, true)
+	if err != nil {
+		return fmt.Errorf("selecting folder: %v", err)
+	}
+
+	// if the UIDs of the folder have changed, our
+	// position in it is meaningless, so start over
+	fp, ok := pos[folder]
+	if ok && fp.UIDValidity != status.UidValidity {
+		ok = false
+	}
+	if !ok {
+		fp = folderPosition{UIDValidity: status.UidValidity}
+	}
+
+	criteria := goimap.NewSearchCriteria()
+	criteria.Uid = new(goimap.SeqSet)
+	criteria.Uid.AddRange(fp.LastUID+1, 0) // 0 means "*"
+	if !ok && tf.Since != nil {
+		criteria.Since = *tf.Since
+	}
+	if tf.Until != nil {
+		// SEARCH BEFORE excludes the given day
+		criteria.Before = tf.Until.Add(24 * time.Hour)
+	}
+	uids, err := conn.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("searching folder: %v", err)
+	}
+
+	// "n:*" always includes the highest UID, even if it is
+	// lower than n, and messages are listed in UID order so
+	// that our position always advances
+	var newUIDs []uint32
+	for _, uid := range uids {
+		if uid > fp.LastUID {
+			newUIDs = append(newUIDs, uid)
+		}
+	}
+	sort.Slice(newUIDs, func(i, j int) bool { return newUIDs[i] < newUIDs[j] })
+
+	for start := 0; start < len(newUIDs); start += fetchBatchSize {
+		if ctx.Err() != nil {
+			return nil
+		}
+		end := start + fetchBatchSize
+		if end > len(newUIDs) {
+			end = len(newUIDs)
+		}
+		batch := newUIDs[start:end]
+
+		err := c.fetchMessages(conn, folder, batch, itemChan)
+		if err != nil {
+			return err
+		}
+
+		fp.LastUID = batch[len(batch)-1]
+		pos[folder] = fp
+		c.checkpoint(ctx, pos)
+	}
+	pos[folder] = fp
+
+	return nil
+}
+
+// fetchMessages fetches the messages with the given UIDs
+// from the selected folder and sends them as items.
+func (c *Client) fetchMessages(conn *client.Client, folder string, uids []uint32,
+	itemChan chan<- *timeliner.ItemGraph) error {
+	seqset := new(goimap.SeqSet)
+	seqset.AddNum(uids...)
+
+	section := &goimap.BodySectionName{Peek: true}
+	items := []goimap.FetchItem{goimap.FetchUid, goimap.FetchInternalDate, section.FetchItem()}
+
+	ch := make(chan *goimap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.UidFetch(seqset, items, ch)
+	}()
+
+	name := folder
+	for msg := range ch {
+		body := msg.GetBody(section)
+		if body == nil {
+			log.Printf("[ERROR][%s/%s] Message %d in %s has no body; skipping",
+				DataSourceID, c.username, msg.Uid, folder)
+			continue
+		}
+		m, err := email.ParseMessage(body, msg.InternalDate)
+		if err != nil {
+			log.Printf("[ERROR][%s/%s] Parsing message %d in %s: %v",
+				DataSourceID, c.username, msg.Uid, folder, err)
+			continue
+		}
+
+		ig := m.ItemGraph()
+		ig.Collections = append(ig.Collections, timeliner.Collection{
+			OriginalID: folder,
+			Name:       &name,
+			Items:      []timeliner.CollectionItem{{Item: m}},
+		})
+		itemChan <- ig
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("fetching messages: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Client) checkpoint(ctx context.Context, pos positions) {
+	posBytes, err := timeliner.MarshalGob(pos)
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Encoding checkpoint: %v", DataSourceID, c.username, err)
+		return
+	}
+	timeliner.Checkpoint(ctx, posBytes)
+}
+
+// positions maps folder names to where
+// they were left off by the last listing.
+type positions map[string]folderPosition
+
+type folderPosition struct {
+	UIDValidity uint32
+	LastUID     uint32
+}
+
+// fetchBatchSize is how many messages to fetch at once.
+const fetchBatchSize = 50
+
+// xoauth2Client implements the XOAUTH2 SASL mechanism.
+// See https://developers.google.com/gmail/imap/xoauth2-protocol.
+type xoauth2Client struct {
+	username, token string
+}
+
+func (x xoauth2Client) Start() (string, []byte, error) {
+	var ir bytes.Buffer
+	fmt.Fprintf(&ir, "user=%s\x01auth=Bearer %s\x01\x01", x.username, x.token)
+	return "XOAUTH2", ir.Bytes(), nil
+}
+
+// Next responds to a challenge, which is only sent when
+// authentication failed and contains details about the
+// error; the response must be empty to complete the
+// exchange, after which the server reports the failure.
+func (x xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
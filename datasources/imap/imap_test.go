@@ -0,0 +1,404 @@
+package imap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"github.com/mholt/timeliner"
+)
+
+// TestListItemsResumes checks that listing resumes after the
+// position in the checkpoint or sync state, unless the folder's
+// UIDVALIDITY changed, in which case it starts over.
+func TestListItemsResumes(t *testing.T) {
+	since := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name        string
+		uidValidity uint32 // of the folder on the server
+		opt         timeliner.Options
+		expectFirst uint32 // UID of the first message expected
+	}{
+		{
+			name:        "no position",
+			uidValidity: 1,
+			expectFirst: 1,
+		},
+		{
+			name:        "from checkpoint",
+			uidValidity: 1,
+			opt:         timeliner.Options{Checkpoint: testPositions(t, 1, 100)},
+			expectFirst: 101,
+		},
+		{
+			name:        "from sync state",
+			uidValidity: 1,
+			opt: timeliner.Options{
+				Timeframe: timeliner.Timeframe{Since: &since},
+				SyncState: testPositions(t, 1, 30),
+			},
+			expectFirst: 31,
+		},
+		{
+			name:        "sync state without since",
+			uidValidity: 1,
+			opt:         timeliner.Options{SyncState: testPositions(t, 1, 30)},
+			expectFirst: 1,
+		},
+		{
+			name:        "uidvalidity changed",
+			uidValidity: 2,
+			opt:         timeliner.Options{Checkpoint: testPositions(t, 1, 100)},
+			expectFirst: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, 120)
+			atomic.StoreUint32(&srv.uidValidity, tc.uidValidity)
+
+			igs, err := listTestItems(srv.client(), tc.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var uids []uint32
+			for _, ig := range igs {
+				uids = append(uids, testMessageUID(t, ig.Node.ID()))
+			}
+			sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+			expected := 120 - int(tc.expectFirst) + 1
+			if len(uids) != expected {
+				t.Fatalf("expected %d messages, got %d: %v", expected, len(uids), uids)
+			}
+			for i, uid := range uids {
+				if uid != tc.expectFirst+uint32(i) {
+					t.Fatalf("expected messages %d to 120, got %v", tc.expectFirst, uids)
+				}
+			}
+		})
+	}
+}
+
+// TestListItemsParsesMIME checks that the text of a multipart
+// message is its plain-text part, with its subject decoded, and
+// that its attachment is related to it.
+func TestListItemsParsesMIME(t *testing.T) {
+	srv := newTestServer(t, 0)
+	srv.addMessage(7, "From: Alice <Alice@Example.org>\r\n"+
+		"To: bob@example.org\r\n"+
+		"Subject: =?UTF-8?Q?Caf=C3=A9?=\r\n"+
+		"Date: Wed, 11 May 2016 14:31:59 +0000\r\n"+
+		"Message-ID: <mime@example.org>\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=outer\r\n"+
+		"\r\n"+
+		"--outer\r\n"+
+		"Content-Type: multipart/alternative; boundary=inner\r\n"+
+		"\r\n"+
+		"--inner\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n"+
+		"\r\n"+
+		"See you at the caf=C3=A9.\r\n"+
+		"--inner\r\n"+
+		"Content-Type: text/html; charset=utf-8\r\n"+
+		"\r\n"+
+		"<p>See you at the <b>café</b>.</p>\r\n"+
+		"--inner--\r\n"+
+		"--outer\r\n"+
+		"Content-Type: image/png\r\n"+
+		"Content-Disposition: attachment; filename=\"map.png\"\r\n"+
+		"Content-Transfer-Encoding: base64\r\n"+
+		"\r\n"+
+		"iVBORw0KGgo=\r\n"+
+		"--outer--\r\n")
+
+	igs, err := listTestItems(srv.client(), timeliner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(igs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(igs))
+	}
+	ig := igs[0]
+
+	if id := ig.Node.ID(); id != "mime@example.org" {
+		t.Errorf("expected ID mime@example.org, got %q", id)
+	}
+	if ownerID, _ := ig.Node.Owner(); ownerID == nil || *ownerID != "alice@example.org" {
+		t.Errorf("expected owner alice@example.org, got %v", ownerID)
+	}
+	text, err := ig.Node.DataText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Café\n\nSee you at the café."; text == nil || *text != expected {
+		t.Errorf("expected text %q, got %v", expected, text)
+	}
+
+	if len(ig.Edges) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(ig.Edges))
+	}
+	for att, rels := range ig.Edges {
+		if len(rels) != 1 || rels[0] != timeliner.RelAttached {
+			t.Errorf("expected attachment to be related as attached, got %v", rels)
+		}
+		if name := att.Node.DataFileName(); name == nil || *name != "map.png" {
+			t.Errorf("expected attachment map.png, got %v", name)
+		}
+		if mt := att.Node.DataFileMIMEType(); mt == nil || *mt != "image/png" {
+			t.Errorf("expected attachment type image/png, got %v", mt)
+		}
+		rc, err := att.Node.DataFileReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "\x89PNG\r\n\x1a\n" {
+			t.Errorf("expected attachment to be decoded, got %q", data)
+		}
+	}
+
+	if len(ig.Collections) != 1 || ig.Collections[0].OriginalID != "INBOX" {
+		t.Errorf("expected message to be in the INBOX collection, got %+v", ig.Collections)
+	}
+}
+
+// TestGetAllResumesAfterCancel cancels a listing while the second
+// batch of messages is fetched, then resumes it from its checkpoint,
+// and checks that every message ends up in the timeline.
+func TestGetAllResumesAfterCancel(t *testing.T) {
+	const count = 2*fetchBatchSize + 20
+	srv := newTestServer(t, count)
+
+	repo := t.TempDir()
+	tl, err := timeliner.Open(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	err = tl.AddAccount(DataSourceID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.be.onFetch = func(uids *goimap.SeqSet) {
+		if uids.Contains(fetchBatchSize + 10) {
+			cancel()
+		}
+	}
+	wc, err := tl.NewClient(DataSourceID, "user", srv.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wc.GetAll(ctx, false, false, false)
+	if err != timeliner.ErrInterrupted {
+		t.Fatalf("expected interruption, got: %v", err)
+	}
+
+	srv.be.onFetch = nil
+	wc, err = tl.NewClient(DataSourceID, "user", srv.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wc.GetAll(context.Background(), false, false, false)
+	if err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(repo, "index.db")+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for uid := 1; uid <= count; uid++ {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM items WHERE original_id=?`,
+			testMessageID(uint32(uid))).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("message %d: expected 1 item, got %d", uid, n)
+		}
+	}
+}
+
+// listTestItems lists the items from c, outside of a timeline.
+func listTestItems(c *Client, opt timeliner.Options) ([]*timeliner.ItemGraph, error) {
+	ch := make(chan *timeliner.ItemGraph)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ListItems(context.Background(), ch, opt)
+	}()
+	var igs []*timeliner.ItemGraph
+	for ig := range ch {
+		igs = append(igs, ig)
+	}
+	return igs, <-done
+}
+
+func testPositions(t *testing.T, uidValidity, lastUID uint32) []byte {
+	b, err := timeliner.MarshalGob(positions{
+		"INBOX": {UIDValidity: uidValidity, LastUID: lastUID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testMessageID(uid uint32) string {
+	return fmt.Sprintf("msg%d@example.org", uid)
+}
+
+func testMessageUID(t *testing.T, id string) uint32 {
+	var uid uint32
+	_, err := fmt.Sscanf(strings.TrimSuffix(id, "@example.org"), "msg%d", &uid)
+	if err != nil {
+		t.Fatalf("unexpected message ID %q: %v", id, err)
+	}
+	return uid
+}
+
+// testServer is an IMAP server with an INBOX of test messages.
+type testServer struct {
+	addr        string
+	be          *testBackend
+	inbox       *memory.Mailbox
+	uidValidity uint32
+}
+
+// newTestServer starts a server whose INBOX has count
+// messages, with UIDs from 1 to count, and UIDVALIDITY 1.
+func newTestServer(t *testing.T, count int) *testServer {
+	srv := &testServer{uidValidity: 1}
+	mem := memory.New()
+	srv.be = &testBackend{Backend: mem, uidValidity: &srv.uidValidity}
+
+	user, err := mem.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.inbox = mbox.(*memory.Mailbox)
+	srv.inbox.Messages = nil
+	for uid := uint32(1); uid <= uint32(count); uid++ {
+		srv.addMessage(uid, fmt.Sprintf("From: sender@example.org\r\n"+
+			"To: username@example.org\r\n"+
+			"Subject: Message %d\r\n"+
+			"Date: %s\r\n"+
+			"Message-ID: <%s>\r\n"+
+			"\r\n"+
+			"This is message %d.\r\n",
+			uid, time.Unix(int64(uid)*3600, 0).UTC().Format(time.RFC1123Z), testMessageID(uid), uid))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(srv.be)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	srv.addr = l.Addr().String()
+
+	return srv
+}
+
+func (srv *testServer) addMessage(uid uint32, body string) {
+	srv.inbox.Messages = append(srv.inbox.Messages, &memory.Message{
+		Uid:  uid,
+		Date: time.Unix(int64(uid)*3600, 0),
+		Size: uint32(len(body)),
+		Body: []byte(body),
+	})
+}
+
+func (srv *testServer) options() *Options {
+	return &Options{
+		Host:     srv.addr,
+		Security: "none",
+		Username: "username",
+		Password: "password",
+	}
+}
+
+func (srv *testServer) client() *Client {
+	return &Client{opts: *srv.options(), username: "username"}
+}
+
+// testBackend is a memory backend whose mailboxes have the given
+// UIDVALIDITY, and which calls onFetch, if set, when messages are
+// fetched.
+type testBackend struct {
+	*memory.Backend
+	uidValidity *uint32
+	onFetch     func(uids *goimap.SeqSet)
+}
+
+func (be *testBackend) Login(connInfo *goimap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := be.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return testUser{User: user, be: be}, nil
+}
+
+type testUser struct {
+	backend.User
+	be *testBackend
+}
+
+func (u testUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return testMailbox{Mailbox: mbox, be: u.be}, nil
+}
+
+type testMailbox struct {
+	backend.Mailbox
+	be *testBackend
+}
+
+func (mbox testMailbox) Status(items []goimap.StatusItem) (*goimap.MailboxStatus, error) {
+	status, err := mbox.Mailbox.Status(items)
+	if err != nil {
+		return nil, err
+	}
+	status.UidValidity = atomic.LoadUint32(mbox.be.uidValidity)
+	return status, nil
+}
+
+func (mbox testMailbox) ListMessages(uid bool, seqSet *goimap.SeqSet, items []goimap.FetchItem, ch chan<- *goimap.Message) error {
+	if mbox.be.onFetch != nil && uid {
+		mbox.be.onFetch(seqSet)
+	}
+	return mbox.Mailbox.ListMessages(uid, seqSet, items, ch)
+}
@@ -0,0 +1,318 @@
+package instagram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/metaarchive"
+)
+
+// archive is an archive downloaded from Instagram.
+type archive struct {
+	*metaarchive.Archive
+
+	// oldLayout is true if the archive has the layout of archives
+	// made before 2020, which have an index of all media
+	oldLayout bool
+
+	profile instaAccountProfile // the owner, from current archives
+}
+
+// openArchive opens the archive at filename.
+func openArchive(filename string) (*archive, error) {
+	ma, err := metaarchive.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	arch := &archive{Archive: ma}
+
+	// paths in the archive's JSON are relative to the top of the
+	// archive, which may be in a folder if it was re-zipped; old
+	// archives have their index files at the top, and current
+	// ones have folders with known names
+	if index := arch.Find("media.json"); index != "" {
+		arch.oldLayout = true
+		arch.Root = strings.TrimSuffix(index, "media.json")
+	} else {
+	findRoot:
+		for _, name := range arch.Names {
+			parts := strings.Split(name, "/")
+			for i, part := range parts[:len(parts)-1] {
+				if topLevelFolders[part] {
+					arch.Root = strings.Join(parts[:i], "/")
+					if arch.Root != "" {
+						arch.Root += "/"
+					}
+					break findRoot
+				}
+			}
+		}
+	}
+
+	return arch, nil
+}
+
+// listItems lists the items in an archive with the current layout:
+// the owner's posts, stories, profile pictures, comments, and
+// conversations. Errors listing each of them are logged, so that
+// the rest are still listed.
+func (arch *archive) listItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	err := arch.loadProfile()
+	if err != nil {
+		return fmt.Errorf("loading profile: %v", err)
+	}
+
+	listers := []struct {
+		what string
+		fn   func(context.Context, chan<- *timeliner.ItemGraph) error
+	}{
+		{"posts", arch.listPosts},
+		{"stories", arch.listStories},
+		{"profile pictures", arch.listProfilePictures},
+		{"comments", arch.listComments},
+		{"conversations", arch.listThreads},
+	}
+	for _, l := range listers {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := l.fn(ctx, itemChan)
+		if err != nil {
+			log.Printf("[ERROR][%s] Listing %s: %v", DataSourceID, l.what, err)
+		}
+	}
+
+	return nil
+}
+
+// loadProfile loads the owner's username and name from the
+// archive's personal information, which the items need.
+func (arch *archive) loadProfile() error {
+	name := arch.Find("personal_information.json")
+	if name == "" {
+		return fmt.Errorf("no personal_information.json file found")
+	}
+	var info instaPersonalInfo
+	err := arch.ReadJSON(name, &info)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if len(info.ProfileUser) == 0 {
+		return fmt.Errorf("%s: no profile", name)
+	}
+	user := info.ProfileUser[0]
+	arch.profile.Username = user.StringMapData["Username"].Value
+	arch.profile.Name = user.StringMapData["Name"].Value
+	if arch.profile.Username == "" {
+		return fmt.Errorf("%s: no username", name)
+	}
+	return nil
+}
+
+// listPosts lists the owner's posts. Posts with one photo or
+// video are listed as that media, with the caption of the post;
+// posts with more are listed with their media attached, and as
+// a collection of them.
+func (arch *archive) listPosts(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Names {
+		if !postsFileRegex.MatchString(path.Base(name)) {
+			continue
+		}
+		var posts []instaPost
+		err := arch.ReadList(name, &posts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i := range posts {
+			if ctx.Err() != nil {
+				return nil
+			}
+			p := &posts[i]
+			p.arch = arch
+			if ig := p.itemGraph(); ig != nil {
+				itemChan <- ig
+			}
+		}
+	}
+	return nil
+}
+
+// listStories lists the photos and videos the owner
+// posted to their story, as a collection.
+func (arch *archive) listStories(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	name := arch.Find("stories.json")
+	if name == "" {
+		return nil
+	}
+	var stories []instaMedia
+	err := arch.ReadList(name, &stories, "ig_stories")
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for i := range stories {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m := &stories[i]
+		m.arch = arch
+		if !arch.Has(m.URI) {
+			log.Printf("[ERROR][%s] Story not in archive: %s", DataSourceID, m.URI)
+			continue
+		}
+		ig := timeliner.NewItemGraph(m)
+		ig.Collections = append(ig.Collections, storiesCollection(m, i))
+		itemChan <- ig
+	}
+	return nil
+}
+
+// listProfilePictures lists the owner's profile pictures,
+// which are in their personal information, and in older
+// current archives, also in a file of their own.
+func (arch *archive) listProfilePictures(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	var pics []instaMedia
+
+	if name := arch.Find("personal_information.json"); name != "" {
+		var info instaPersonalInfo
+		err := arch.ReadJSON(name, &info)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, user := range info.ProfileUser {
+			if pic, ok := user.MediaMapData["Profile Photo"]; ok {
+				pics = append(pics, pic)
+			}
+		}
+	}
+	if name := arch.Find("profile_photos.json"); name != "" {
+		var photos []instaMedia
+		err := arch.ReadList(name, &photos, "ig_profile_picture")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		pics = append(pics, photos...)
+	}
+
+	seen := make(map[string]bool)
+	for i := range pics {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m := &pics[i]
+		m.arch = arch
+		if seen[m.URI] || !arch.Has(m.URI) {
+			continue
+		}
+		seen[m.URI] = true
+		itemChan <- timeliner.NewItemGraph(m)
+	}
+	return nil
+}
+
+// listComments lists the comments the owner made,
+// as replies to the owners of what they commented on.
+func (arch *archive) listComments(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, name := range arch.Names {
+		if path.Base(path.Dir(name)) != "comments" || !commentsFileRegex.MatchString(path.Base(name)) {
+			continue
+		}
+		var entries []instaCommentEntry
+		err := arch.ReadList(name, &entries,
+			"comments_media_comments", "comments_reels_comments", "comments_story_comments")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		seen := make(map[string]int)
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return nil
+			}
+			cm := entry.comment()
+			if cm.Text == "" {
+				continue
+			}
+			cm.arch = arch
+			cm.setID(seen)
+			itemChan <- cm.itemGraph()
+		}
+	}
+	return nil
+}
+
+// listThreads lists the messages in the owner's
+// conversations, each conversation as a collection.
+func (arch *archive) listThreads(ctx context.Context, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, names := range arch.ThreadFiles() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var th instaThread
+		err := arch.ReadThread(names, &th)
+		if err != nil {
+			log.Printf("[ERROR][%s] Reading conversation: %v", DataSourceID, err)
+			continue
+		}
+		if len(th.Messages) == 0 {
+			continue
+		}
+
+		coll := timeliner.Collection{OriginalID: "thread_" + th.ThreadPath}
+		if th.Title != "" {
+			coll.Name = &th.Title
+		}
+
+		seen := make(map[string]int)
+		for i := range th.Messages {
+			if ctx.Err() != nil {
+				return nil
+			}
+			m := &th.Messages[i]
+			m.arch = arch
+			m.thread = &th
+			m.setID(seen)
+
+			ig := m.itemGraph()
+			if ig == nil {
+				continue
+			}
+			cl := coll
+			cl.Items = []timeliner.CollectionItem{{Item: m, Position: i}}
+			ig.Collections = append(ig.Collections, cl)
+			itemChan <- ig
+		}
+	}
+
+	return nil
+}
+
+// owner returns the owner of the archive as the owner of an item.
+func (arch *archive) owner() (*string, *string) {
+	return &arch.profile.Username, &arch.profile.Name
+}
+
+// fromOwner returns true if the person with the given name,
+// which may also be a username, is the owner of the archive.
+func (arch *archive) fromOwner(name string) bool {
+	return name == "" || name == arch.profile.Name || name == arch.profile.Username
+}
+
+// topLevelFolders are the names of folders at the top of current archives.
+var topLevelFolders = map[string]bool{
+	"your_instagram_activity": true,
+	"personal_information":    true,
+	"content":                 true,
+	"messages":                true,
+	"comments":                true,
+	"media":                   true,
+}
+
+var (
+	postsFileRegex    = regexp.MustCompile(`^posts_\d+\.json$`)
+	commentsFileRegex = regexp.MustCompile(`comments(_\d+)?\.json$`)
+)
@@ -0,0 +1,556 @@
+package instagram
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+	"github.com/mholt/timeliner/datasources/metaarchive"
+)
+
+// instaPersonalInfo is the owner's personal information
+// in current archives.
+type instaPersonalInfo struct {
+	ProfileUser []struct {
+		MediaMapData  map[string]instaMedia       `json:"media_map_data"`
+		StringMapData map[string]instaStringValue `json:"string_map_data"`
+	} `json:"profile_user"`
+}
+
+// instaStringValue is a value in current archives,
+// which may have a link and a time.
+type instaStringValue struct {
+	Href      string `json:"href"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// instaPost is a post in current archives,
+// which has one or more photos or videos.
+type instaPost struct {
+	Media             []instaMedia `json:"media"`
+	Title             string       `json:"title"`
+	CreationTimestamp int64        `json:"creation_timestamp"`
+
+	arch *archive
+}
+
+// itemGraph returns the item graph for the post. A post with
+// one photo or video is that media, with the post's caption;
+// a post with more relates the post to its media, which are
+// also a collection in the order they were posted.
+func (p *instaPost) itemGraph() *timeliner.ItemGraph {
+	var media []*instaMedia
+	for i := range p.Media {
+		m := &p.Media[i]
+		if !p.arch.Has(m.URI) {
+			log.Printf("[ERROR][%s] Post media not in archive: %s", DataSourceID, m.URI)
+			continue
+		}
+		m.arch = p.arch
+		media = append(media, m)
+	}
+	if len(media) == 0 {
+		return nil
+	}
+
+	if len(media) == 1 {
+		media[0].caption = p.caption()
+		return timeliner.NewItemGraph(media[0])
+	}
+
+	ig := timeliner.NewItemGraph(p)
+	coll := timeliner.Collection{OriginalID: p.ID()}
+	for i, m := range media {
+		ig.Add(m, timeliner.RelAttached)
+		coll.Items = append(coll.Items, timeliner.CollectionItem{Item: m, Position: i})
+	}
+	ig.Collections = append(ig.Collections, coll)
+	return ig
+}
+
+// caption returns the caption of the post, which is
+// on the media of posts with only one in some archives.
+func (p *instaPost) caption() string {
+	if p.Title != "" || len(p.Media) == 0 {
+		return p.Title
+	}
+	return p.Media[0].Title
+}
+
+// ID returns an ID made from the first media of the post,
+// since posts in archives have no IDs.
+func (p *instaPost) ID() string {
+	return "post_" + p.Media[0].ID()
+}
+
+func (p *instaPost) Timestamp() time.Time {
+	if p.CreationTimestamp == 0 {
+		return p.Media[0].Timestamp()
+	}
+	return time.Unix(p.CreationTimestamp, 0)
+}
+
+func (p *instaPost) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (p *instaPost) Owner() (*string, *string) {
+	return p.arch.owner()
+}
+
+func (p *instaPost) DataText() (*string, error) {
+	caption := p.caption()
+	if caption == "" {
+		return nil, nil
+	}
+	return &caption, nil
+}
+
+func (p *instaPost) DataFileName() *string {
+	return nil
+}
+
+func (p *instaPost) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (p *instaPost) DataFileHash() []byte {
+	return nil
+}
+
+func (p *instaPost) DataFileMIMEType() *string {
+	return nil
+}
+
+func (p *instaPost) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (p *instaPost) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// instaMedia is a photo or video in current archives,
+// which was posted by the owner or sent in a conversation.
+type instaMedia struct {
+	URI               string `json:"uri"`
+	CreationTimestamp int64  `json:"creation_timestamp"`
+	Title             string `json:"title"`
+	MediaMetadata     *struct {
+		PhotoMetadata *instaMediaMetadata `json:"photo_metadata"`
+		VideoMetadata *instaMediaMetadata `json:"video_metadata"`
+	} `json:"media_metadata"`
+
+	arch       *archive
+	caption    string    // set if the media is a post of its own
+	senderName string    // set if sent in a conversation by someone other than the owner
+	sentTime   time.Time // set if sent in a conversation
+}
+
+type instaMediaMetadata struct {
+	EXIFData []map[string]interface{} `json:"exif_data"`
+}
+
+// exif returns the EXIF data of the media, if any,
+// which is only what Instagram kept of it.
+func (m *instaMedia) exif() map[string]interface{} {
+	if m.MediaMetadata == nil {
+		return nil
+	}
+	exif := make(map[string]interface{})
+	for _, mm := range []*instaMediaMetadata{m.MediaMetadata.PhotoMetadata, m.MediaMetadata.VideoMetadata} {
+		if mm == nil {
+			continue
+		}
+		for _, data := range mm.EXIFData {
+			for k, v := range data {
+				exif[k] = v
+			}
+		}
+	}
+	return exif
+}
+
+// ID returns the name of the media's file without its extension,
+// which is named after the ID of the media on Instagram, like the
+// IDs of media from archives with the old layout.
+func (m *instaMedia) ID() string {
+	name := path.Base(m.URI)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func (m *instaMedia) Timestamp() time.Time {
+	if m.CreationTimestamp > 0 {
+		return time.Unix(m.CreationTimestamp, 0)
+	}
+	return m.sentTime
+}
+
+func (m *instaMedia) Class() timeliner.ItemClass {
+	switch mimeType := m.mimeType(); {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (m *instaMedia) Owner() (*string, *string) {
+	if m.senderName != "" {
+		return &m.senderName, &m.senderName
+	}
+	return m.arch.owner()
+}
+
+// DataText returns the caption of the post the media is,
+// or else the media's own title, if any.
+func (m *instaMedia) DataText() (*string, error) {
+	if m.caption != "" {
+		return &m.caption, nil
+	}
+	if m.Title != "" {
+		return &m.Title, nil
+	}
+	return nil, nil
+}
+
+func (m *instaMedia) DataFileName() *string {
+	name := path.Base(m.URI)
+	return &name
+}
+
+func (m *instaMedia) DataFileReader() (io.ReadCloser, error) {
+	return m.arch.OpenURI(m.URI)
+}
+
+func (m *instaMedia) DataFileHash() []byte {
+	return nil
+}
+
+func (m *instaMedia) DataFileMIMEType() *string {
+	mimeType := m.mimeType()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+func (m *instaMedia) Metadata() (*timeliner.Metadata, error) {
+	exif := m.exif()
+	if len(exif) == 0 {
+		return nil, nil
+	}
+	return &timeliner.Metadata{EXIF: exif}, nil
+}
+
+func (m *instaMedia) Location() (*timeliner.Location, error) {
+	exif := m.exif()
+	lat, latOK := exif["latitude"].(float64)
+	lon, lonOK := exif["longitude"].(float64)
+	if !latOK || !lonOK || (lat == 0 && lon == 0) {
+		return nil, nil
+	}
+	return &timeliner.Location{Latitude: &lat, Longitude: &lon}, nil
+}
+
+func (m *instaMedia) mimeType() string {
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(m.URI)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// instaCommentEntry is a comment in current archives,
+// which have it in one of two forms.
+type instaCommentEntry struct {
+	StringMapData  map[string]instaStringValue `json:"string_map_data"`
+	Title          string                      `json:"title"`
+	StringListData []instaStringValue          `json:"string_list_data"`
+}
+
+// comment returns the comment of the entry.
+func (e instaCommentEntry) comment() *instaComment {
+	if len(e.StringMapData) > 0 {
+		return &instaComment{
+			Text:       e.StringMapData["Comment"].Value,
+			MediaOwner: e.StringMapData["Media Owner"].Value,
+			Time:       e.StringMapData["Time"].Timestamp,
+		}
+	}
+	cm := &instaComment{MediaOwner: e.Title}
+	if len(e.StringListData) > 0 {
+		cm.Text = e.StringListData[0].Value
+		cm.Time = e.StringListData[0].Timestamp
+	}
+	return cm
+}
+
+// instaComment is a comment the owner made
+// on a post by someone, maybe themselves.
+type instaComment struct {
+	Text       string
+	MediaOwner string // username of the owner of the post
+	Time       int64
+
+	id   string
+	arch *archive
+}
+
+// setID sets the ID of the comment, which is made from its
+// time, text, and the owner of what it is on, since comments
+// in archives have no IDs. Comments that would have the same
+// ID get a suffix with a count.
+func (cm *instaComment) setID(seen map[string]int) {
+	id := metaarchive.ContentID("comment_", strconv.FormatInt(cm.Time, 10), cm.MediaOwner, cm.Text)
+	if n := seen[id]; n > 0 {
+		seen[id]++
+		id = fmt.Sprintf("%s_%d", id, n)
+	} else {
+		seen[id] = 1
+	}
+	cm.id = id
+}
+
+// itemGraph returns the item graph for the comment, which
+// relates it to the owner of what it is on, if that is
+// someone other than the owner.
+func (cm *instaComment) itemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(cm)
+	if cm.MediaOwner != "" && cm.MediaOwner != cm.arch.profile.Username {
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   cm.MediaOwner,
+			Relation: timeliner.RelReplyTo,
+		})
+	}
+	return ig
+}
+
+func (cm *instaComment) ID() string {
+	return cm.id
+}
+
+func (cm *instaComment) Timestamp() time.Time {
+	return time.Unix(cm.Time, 0)
+}
+
+func (cm *instaComment) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (cm *instaComment) Owner() (*string, *string) {
+	return cm.arch.owner()
+}
+
+func (cm *instaComment) DataText() (*string, error) {
+	return &cm.Text, nil
+}
+
+func (cm *instaComment) DataFileName() *string {
+	return nil
+}
+
+func (cm *instaComment) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (cm *instaComment) DataFileHash() []byte {
+	return nil
+}
+
+func (cm *instaComment) DataFileMIMEType() *string {
+	return nil
+}
+
+func (cm *instaComment) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Type: "comment"}, nil
+}
+
+func (cm *instaComment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// instaThread is a conversation, or one of
+// the files the conversation is split across.
+type instaThread struct {
+	Participants []struct {
+		Name string `json:"name"`
+	} `json:"participants"`
+	Messages   []instaMessage `json:"messages"`
+	Title      string         `json:"title"`
+	ThreadPath string         `json:"thread_path"`
+}
+
+// instaMessage is a message in a conversation.
+type instaMessage struct {
+	SenderName  string       `json:"sender_name"`
+	TimestampMS int64        `json:"timestamp_ms"`
+	Content     string       `json:"content"`
+	Photos      []instaMedia `json:"photos"`
+	Videos      []instaMedia `json:"videos"`
+	AudioFiles  []instaMedia `json:"audio_files"`
+	Share       *struct {
+		Link      string `json:"link"`
+		ShareText string `json:"share_text"`
+	} `json:"share"`
+
+	id     string
+	arch   *archive
+	thread *instaThread
+}
+
+// setID sets the ID of the message, which is made from its
+// conversation, time, sender, and contents, since messages in
+// archives have no IDs. Messages that would have the same ID
+// get a suffix with a count.
+func (m *instaMessage) setID(seen map[string]int) {
+	var uris []string
+	for _, a := range m.media() {
+		uris = append(uris, a.URI)
+	}
+	id := metaarchive.ContentID("message_", m.thread.ThreadPath, strconv.FormatInt(m.TimestampMS, 10),
+		m.SenderName, m.Content, strings.Join(uris, "\n"))
+	if n := seen[id]; n > 0 {
+		seen[id]++
+		id = fmt.Sprintf("%s_%d", id, n)
+	} else {
+		seen[id] = 1
+	}
+	m.id = id
+}
+
+// itemGraph returns the item graph for the message, which relates
+// it to its attachments, and if the owner sent it, to the other
+// people in the conversation.
+func (m *instaMessage) itemGraph() *timeliner.ItemGraph {
+	media := m.media()
+	if m.text() == "" && len(media) == 0 && m.link() == "" {
+		return nil
+	}
+
+	ig := timeliner.NewItemGraph(m)
+	for _, a := range media {
+		if !m.arch.Has(a.URI) {
+			log.Printf("[ERROR][%s] Message attachment not in archive: %s", DataSourceID, a.URI)
+			continue
+		}
+		ig.Add(a, timeliner.RelAttached)
+	}
+
+	if m.fromOwner() {
+		for _, p := range m.thread.Participants {
+			if m.arch.fromOwner(p.Name) {
+				continue
+			}
+			ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+				UserID:   p.Name,
+				Name:     p.Name,
+				Relation: timeliner.RelSentTo,
+			})
+		}
+	}
+
+	return ig
+}
+
+// media returns the files attached to the message.
+func (m *instaMessage) media() []*instaMedia {
+	var media []*instaMedia
+	for _, list := range [][]instaMedia{m.Photos, m.Videos, m.AudioFiles} {
+		for i := range list {
+			media = append(media, &list[i])
+		}
+	}
+	for _, a := range media {
+		a.arch = m.arch
+		a.sentTime = m.Timestamp()
+		if !m.fromOwner() {
+			a.senderName = m.SenderName
+		}
+	}
+	return media
+}
+
+func (m *instaMessage) fromOwner() bool {
+	return m.arch.fromOwner(m.SenderName)
+}
+
+// text returns the content of the message, or if it only
+// shares a post, the text of what it shares.
+func (m *instaMessage) text() string {
+	if m.Content != "" || m.Share == nil {
+		return m.Content
+	}
+	return m.Share.ShareText
+}
+
+func (m *instaMessage) link() string {
+	if m.Share == nil {
+		return ""
+	}
+	return m.Share.Link
+}
+
+func (m *instaMessage) ID() string {
+	return m.id
+}
+
+func (m *instaMessage) Timestamp() time.Time {
+	return time.Unix(0, m.TimestampMS*int64(time.Millisecond))
+}
+
+func (m *instaMessage) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+func (m *instaMessage) Owner() (*string, *string) {
+	if m.fromOwner() {
+		return m.arch.owner()
+	}
+	return &m.SenderName, &m.SenderName
+}
+
+func (m *instaMessage) DataText() (*string, error) {
+	text := m.text()
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (m *instaMessage) DataFileName() *string {
+	return nil
+}
+
+func (m *instaMessage) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *instaMessage) DataFileHash() []byte {
+	return nil
+}
+
+func (m *instaMessage) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *instaMessage) Metadata() (*timeliner.Metadata, error) {
+	if link := m.link(); link != "" {
+		return &timeliner.Metadata{Link: link}, nil
+	}
+	return nil, nil
+}
+
+func (m *instaMessage) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
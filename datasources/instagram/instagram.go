@@ -4,12 +4,10 @@ package instagram
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
-	"github.com/mholt/archiver"
 	"github.com/mholt/timeliner"
 )
 
@@ -38,6 +36,8 @@ func init() {
 type Client struct{}
 
 // ListItems lists items from the data source. opt.Filename must be non-empty.
+// It may be the .zip file of the archive or a folder it was extracted into,
+// in the layout of either current archives or those made before 2020.
 func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
 	defer close(itemChan)
 
@@ -45,14 +45,24 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 		return fmt.Errorf("filename is required")
 	}
 
+	arch, err := openArchive(opt.Filename)
+	if err != nil {
+		return err
+	}
+	defer timeliner.AfterItems(ctx, func() { arch.Close() })
+
+	if !arch.oldLayout {
+		return arch.listItems(ctx, itemChan)
+	}
+
 	// first, load the profile information
-	prof, err := c.getProfileInfo(opt.Filename)
+	prof, err := c.getProfileInfo(arch)
 	if err != nil {
 		return fmt.Errorf("loading profile: %v", err)
 	}
 
 	// then, load the media index
-	idx, err := c.getMediaIndex(opt.Filename)
+	idx, err := c.getMediaIndex(arch)
 	if err != nil {
 		return fmt.Errorf("loading index: %v", err)
 	}
@@ -61,7 +71,7 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	// need to be processed into the timeline
 	for i, ph := range idx.Photos {
 		idx.Photos[i].profile = prof
-		idx.Photos[i].archiveFilename = opt.Filename
+		idx.Photos[i].arch = arch
 		idx.Photos[i].takenAtParsed, err = time.Parse(takenAtFormat, ph.TakenAt)
 		if err != nil {
 			return fmt.Errorf("parsing photo time %s into format %s: %v", ph.TakenAt, takenAtFormat, err)
@@ -69,69 +79,78 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	}
 	for i, p := range idx.Profile {
 		idx.Profile[i].profile = prof
-		idx.Profile[i].archiveFilename = opt.Filename
-		idx.Photos[i].takenAtParsed, err = time.Parse(takenAtFormat, p.TakenAt)
+		idx.Profile[i].arch = arch
+		idx.Profile[i].takenAtParsed, err = time.Parse(takenAtFormat, p.TakenAt)
 		if err != nil {
 			return fmt.Errorf("parsing profile pic time %s into format %s: %v", p.TakenAt, takenAtFormat, err)
 		}
 	}
 	for i, vid := range idx.Videos {
 		idx.Videos[i].profile = prof
-		idx.Videos[i].archiveFilename = opt.Filename
+		idx.Videos[i].arch = arch
 		idx.Videos[i].takenAtParsed, err = time.Parse(takenAtFormat, vid.TakenAt)
 		if err != nil {
 			return fmt.Errorf("parsing video time %s into format %s: %v", vid.TakenAt, takenAtFormat, err)
 		}
 	}
+	for i, st := range idx.Stories {
+		idx.Stories[i].profile = prof
+		idx.Stories[i].arch = arch
+		idx.Stories[i].takenAtParsed, err = time.Parse(takenAtFormat, st.TakenAt)
+		if err != nil {
+			return fmt.Errorf("parsing story time %s into format %s: %v", st.TakenAt, takenAtFormat, err)
+		}
+	}
 
 	// add all of the media items to the timeline
 	for _, photo := range idx.Photos {
 		itemChan <- timeliner.NewItemGraph(photo)
 	}
+	for _, pic := range idx.Profile {
+		itemChan <- timeliner.NewItemGraph(pic.photo())
+	}
 	for _, video := range idx.Videos {
 		itemChan <- timeliner.NewItemGraph(video)
 	}
+	for i, story := range idx.Stories {
+		ig := timeliner.NewItemGraph(story.item())
+		ig.Collections = append(ig.Collections, storiesCollection(ig.Node, i))
+		itemChan <- ig
+	}
 
 	return nil
 }
 
-func (c *Client) getProfileInfo(filename string) (instaAccountProfile, error) {
+func (c *Client) getProfileInfo(arch *archive) (instaAccountProfile, error) {
 	var prof instaAccountProfile
-	err := archiver.Walk(filename, func(f archiver.File) error {
-		defer f.Close()
-		if f.Name() != "profile.json" {
-			return nil
-		}
-
-		err := json.NewDecoder(f).Decode(&prof)
-		if err != nil {
-			return fmt.Errorf("decoding account file: %v", err)
-		}
-
-		return archiver.ErrStopWalk
-	})
-	return prof, err
+	if !arch.Has("profile.json") {
+		return prof, nil
+	}
+	err := arch.ReadJSON(arch.Root+"profile.json", &prof)
+	if err != nil {
+		return prof, fmt.Errorf("decoding account file: %v", err)
+	}
+	return prof, nil
 }
 
-func (c *Client) getMediaIndex(filename string) (instaMediaIndex, error) {
+func (c *Client) getMediaIndex(arch *archive) (instaMediaIndex, error) {
 	var idx instaMediaIndex
-	err := archiver.Walk(filename, func(f archiver.File) error {
-		defer f.Close()
-		if f.Name() != "media.json" {
-			return nil
-		}
-
-		err := json.NewDecoder(f).Decode(&idx)
-		if err != nil {
-			return fmt.Errorf("decoding media index JSON: %v", err)
-		}
-
-		return archiver.ErrStopWalk
-	})
+	err := arch.ReadJSON(arch.Root+"media.json", &idx)
 	if err != nil {
-		return idx, fmt.Errorf("walking archive file %s: %v", filename, err)
+		return idx, fmt.Errorf("decoding media index JSON: %v", err)
 	}
 	return idx, nil
 }
 
+// storiesCollection returns the collection of the owner's
+// stories, with the given item in it at position i.
+func storiesCollection(it timeliner.Item, i int) timeliner.Collection {
+	name := "Stories"
+	return timeliner.Collection{
+		OriginalID: "stories",
+		Name:       &name,
+		Items:      []timeliner.CollectionItem{{Item: it, Position: i}},
+	}
+}
+
 const takenAtFormat = "2006-01-02T15:04:05"
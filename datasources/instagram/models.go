@@ -1,16 +1,12 @@
 package instagram
 
 import (
-	"archive/zip"
-	"bytes"
-	"fmt"
 	"io"
 	"mime"
 	"path"
 	"strings"
 	"time"
 
-	"github.com/mholt/archiver"
 	"github.com/mholt/timeliner"
 )
 
@@ -18,6 +14,7 @@ type instaMediaIndex struct {
 	Photos  []instaPhoto      `json:"photos"`
 	Profile []instaProfilePic `json:"profile"`
 	Videos  []instaVideo      `json:"videos"`
+	Stories []instaStory      `json:"stories"`
 }
 
 type instaPhoto struct {
@@ -26,9 +23,9 @@ type instaPhoto struct {
 	Path        string `json:"path"`
 	LocationStr string `json:"location,omitempty"`
 
-	takenAtParsed   time.Time
-	archiveFilename string
-	profile         instaAccountProfile
+	takenAtParsed time.Time
+	arch          *archive
+	profile       instaAccountProfile
 }
 
 func (ph instaPhoto) ID() string {
@@ -59,26 +56,7 @@ func (ph instaPhoto) DataFileName() *string {
 }
 
 func (ph instaPhoto) DataFileReader() (io.ReadCloser, error) {
-	var rc io.ReadCloser
-	err := archiver.Walk(ph.archiveFilename, func(f archiver.File) error {
-		if f.Header.(zip.FileHeader).Name != ph.Path {
-			return nil
-		}
-
-		buf := new(bytes.Buffer)
-		_, err := io.Copy(buf, f)
-		if err != nil {
-			return fmt.Errorf("copying item into memory: %v", err)
-		}
-		rc = timeliner.FakeCloser(buf)
-
-		return archiver.ErrStopWalk
-	})
-	if err != nil {
-		return nil, fmt.Errorf("walking archive file %s in search of media: %v",
-			ph.archiveFilename, err)
-	}
-	return rc, nil
+	return ph.arch.OpenURI(ph.Path)
 }
 
 func (ph instaPhoto) DataFileHash() []byte {
@@ -107,9 +85,56 @@ type instaProfilePic struct {
 	IsActiveProfile bool   `json:"is_active_profile"`
 	Path            string `json:"path"`
 
-	takenAtParsed   time.Time
-	archiveFilename string
-	profile         instaAccountProfile
+	takenAtParsed time.Time
+	arch          *archive
+	profile       instaAccountProfile
+}
+
+// photo returns the profile picture as a photo.
+func (pp instaProfilePic) photo() instaPhoto {
+	return instaPhoto{
+		Caption:       pp.Caption,
+		TakenAt:       pp.TakenAt,
+		Path:          pp.Path,
+		takenAtParsed: pp.takenAtParsed,
+		arch:          pp.arch,
+		profile:       pp.profile,
+	}
+}
+
+// instaStory is a photo or video the owner
+// posted to their story.
+type instaStory struct {
+	Caption string `json:"caption"`
+	TakenAt string `json:"taken_at"`
+	Path    string `json:"path"`
+
+	takenAtParsed time.Time
+	arch          *archive
+	profile       instaAccountProfile
+}
+
+// item returns the story as a photo or video,
+// depending on the type of its file.
+func (st instaStory) item() timeliner.Item {
+	if strings.HasPrefix(mime.TypeByExtension(path.Ext(st.Path)), "video/") {
+		return instaVideo{
+			Caption:       st.Caption,
+			TakenAt:       st.TakenAt,
+			Path:          st.Path,
+			takenAtParsed: st.takenAtParsed,
+			arch:          st.arch,
+			profile:       st.profile,
+		}
+	}
+	return instaPhoto{
+		Caption:       st.Caption,
+		TakenAt:       st.TakenAt,
+		Path:          st.Path,
+		takenAtParsed: st.takenAtParsed,
+		arch:          st.arch,
+		profile:       st.profile,
+	}
 }
 
 type instaVideo struct {
@@ -118,9 +143,9 @@ type instaVideo struct {
 	Path        string `json:"path"`
 	LocationStr string `json:"location,omitempty"`
 
-	takenAtParsed   time.Time
-	archiveFilename string
-	profile         instaAccountProfile
+	takenAtParsed time.Time
+	arch          *archive
+	profile       instaAccountProfile
 }
 
 func (vid instaVideo) ID() string {
@@ -151,26 +176,7 @@ func (vid instaVideo) DataFileName() *string {
 }
 
 func (vid instaVideo) DataFileReader() (io.ReadCloser, error) {
-	var rc io.ReadCloser
-	err := archiver.Walk(vid.archiveFilename, func(f archiver.File) error {
-		if f.Header.(zip.FileHeader).Name != vid.Path {
-			return nil
-		}
-
-		buf := new(bytes.Buffer)
-		_, err := io.Copy(buf, f)
-		if err != nil {
-			return fmt.Errorf("copying item into memory: %v", err)
-		}
-		rc = timeliner.FakeCloser(buf)
-
-		return archiver.ErrStopWalk
-	})
-	if err != nil {
-		return nil, fmt.Errorf("walking archive file %s in search of media: %v",
-			vid.archiveFilename, err)
-	}
-	return rc, nil
+	return vid.arch.OpenURI(vid.Path)
 }
 
 func (vid instaVideo) DataFileHash() []byte {
@@ -0,0 +1,214 @@
+// Package lastfm implements a Timeliner data source for importing
+// scrobbles exported from Last.fm, in CSV or JSON format.
+package lastfm
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Last.fm"
+	DataSourceID   = "lastfm"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the scrobbles in the export file at opt.Filename,
+// which must be non-empty. It may be a .csv file, with or without a
+// header, or a .json file of scrobbles as the API returns them, or
+// of pages of them. Scrobbles are also listed in a collection of
+// the scrobbles of their day.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	file, err := os.Open(opt.Filename)
+	if err != nil {
+		return fmt.Errorf("opening export file: %v", err)
+	}
+	defer file.Close()
+
+	var scrobbles []*scrobble
+	if strings.ToLower(path.Ext(opt.Filename)) == ".json" {
+		scrobbles, err = readJSON(file)
+	} else {
+		scrobbles, err = readCSV(file)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", opt.Filename, err)
+	}
+
+	for _, s := range scrobbles {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ts := s.Timestamp()
+		if (opt.Timeframe.Since != nil && ts.Before(*opt.Timeframe.Since)) ||
+			(opt.Timeframe.Until != nil && ts.After(*opt.Timeframe.Until)) {
+			continue
+		}
+		ig := timeliner.NewItemGraph(s)
+		ig.Collections = append(ig.Collections, timeliner.DayCollection("lastfm_scrobbles_", "Last.fm scrobbles on ", s))
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// readCSV reads the scrobbles in the CSV read from r. If it has a
+// header, its columns are named like the fields of the API (uts,
+// artist, album, track); otherwise its columns are the artist,
+// album, track, and time, like "02 Jan 2021 13:45" in UTC.
+func readCSV(r io.Reader) ([]*scrobble, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3}
+	if header := records[0]; containsString(header, "uts") || containsString(header, "artist") {
+		columns = make(map[string]int)
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		records = records[1:]
+	}
+	field := func(rec []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	var scrobbles []*scrobble
+	for _, rec := range records {
+		s := &scrobble{
+			artist: field(rec, "artist"),
+			album:  field(rec, "album"),
+			track:  field(rec, "track"),
+		}
+		if uts := field(rec, "uts"); uts != "" {
+			sec, err := strconv.ParseInt(uts, 10, 64)
+			if err != nil {
+				log.Printf("[ERROR][%s] Skipping scrobble with bad time %q: %v", DataSourceID, uts, err)
+				continue
+			}
+			s.time = time.Unix(sec, 0)
+		} else {
+			s.time, err = time.Parse(csvTimeFormat, field(rec, "date"))
+			if err != nil {
+				log.Printf("[ERROR][%s] Skipping scrobble with bad time: %v", DataSourceID, err)
+				continue
+			}
+		}
+		if s.track == "" {
+			continue
+		}
+		scrobbles = append(scrobbles, s)
+	}
+
+	return scrobbles, nil
+}
+
+// csvTimeFormat is the format of the time in
+// CSV exports that do not have a header.
+const csvTimeFormat = "02 Jan 2006 15:04"
+
+// readJSON reads the scrobbles in the JSON read from r, which is
+// a list of the tracks that the API returns as recent tracks, or
+// a list of pages of them, as exports made with the API save them.
+func readJSON(r io.Reader) ([]*scrobble, error) {
+	var list []json.RawMessage
+	err := json.NewDecoder(r).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	var scrobbles []*scrobble
+	for _, raw := range list {
+		var page struct {
+			Track        []apiTrack `json:"track"`
+			RecentTracks *struct {
+				Track []apiTrack `json:"track"`
+			} `json:"recenttracks"`
+		}
+		err := json.Unmarshal(raw, &page)
+		if err != nil {
+			return nil, fmt.Errorf("decoding page or track: %v", err)
+		}
+
+		tracks := page.Track
+		if page.RecentTracks != nil {
+			tracks = page.RecentTracks.Track
+		}
+		if tracks == nil {
+			var t apiTrack
+			err := json.Unmarshal(raw, &t)
+			if err != nil {
+				return nil, fmt.Errorf("decoding track: %v", err)
+			}
+			tracks = []apiTrack{t}
+		}
+
+		for _, t := range tracks {
+			s, err := t.scrobble()
+			if err != nil {
+				log.Printf("[ERROR][%s] Skipping scrobble: %v", DataSourceID, err)
+				continue
+			}
+			if s != nil {
+				scrobbles = append(scrobbles, s)
+			}
+		}
+	}
+
+	return scrobbles, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if strings.ToLower(strings.TrimSpace(item)) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package lastfm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// apiTrack is a track as the API returns it among recent tracks.
+type apiTrack struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Artist apiValue `json:"artist"`
+	Album  apiValue `json:"album"`
+	Date   *struct {
+		UTS string `json:"uts"`
+	} `json:"date"`
+}
+
+// apiValue is a value the API returns with an MBID, whose
+// text is in "#text", or in "name" in extended responses.
+type apiValue struct {
+	Text string `json:"#text"`
+	Name string `json:"name"`
+}
+
+func (v apiValue) String() string {
+	if v.Text != "" {
+		return v.Text
+	}
+	return v.Name
+}
+
+// scrobble returns the scrobble of the track, or nil if
+// it has no date, which is when it is now playing.
+func (t apiTrack) scrobble() (*scrobble, error) {
+	if t.Date == nil || t.Name == "" {
+		return nil, nil
+	}
+	sec, err := strconv.ParseInt(t.Date.UTS, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing time %q: %v", t.Date.UTS, err)
+	}
+	return &scrobble{
+		time:   time.Unix(sec, 0),
+		artist: t.Artist.String(),
+		album:  t.Album.String(),
+		track:  t.Name,
+		url:    t.URL,
+	}, nil
+}
+
+// scrobble is a play of a track that was scrobbled.
+type scrobble struct {
+	time   time.Time
+	artist string
+	album  string
+	track  string
+	url    string
+}
+
+// ID returns an ID made from the minute of the scrobble and
+// the track, since exports do not have IDs of scrobbles. CSV
+// exports only have the minute, so that the same scrobble has
+// the same ID in either kind of export.
+func (s *scrobble) ID() string {
+	h := sha256.Sum256([]byte(strings.Join([]string{s.artist, s.track}, "\x00")))
+	return fmt.Sprintf("scrobble_%d_%s", s.Timestamp().Unix(), hex.EncodeToString(h[:8]))
+}
+
+// Timestamp returns the minute of the scrobble, so that the
+// same scrobble has the same time (and is in the same place
+// in the scrobbles of its day) in either kind of export.
+func (s *scrobble) Timestamp() time.Time {
+	return s.time.Truncate(time.Minute)
+}
+
+func (s *scrobble) Class() timeliner.ItemClass {
+	return timeliner.ClassAudio
+}
+
+func (s *scrobble) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (s *scrobble) DataText() (*string, error) {
+	return &s.track, nil
+}
+
+func (s *scrobble) DataFileName() *string {
+	return nil
+}
+
+func (s *scrobble) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (s *scrobble) DataFileHash() []byte {
+	return nil
+}
+
+func (s *scrobble) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata returns the track, artist, album, and a link to the
+// track. Scrobbles do not say for how long tracks were played.
+func (s *scrobble) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{
+		Name:   s.track,
+		Artist: s.artist,
+		Album:  s.album,
+		Link:   s.link(),
+	}, nil
+}
+
+func (s *scrobble) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// link returns the link to the track on Last.fm, which
+// is made from its name and artist if not exported.
+func (s *scrobble) link() string {
+	if s.url != "" {
+		return s.url
+	}
+	if s.artist == "" {
+		return ""
+	}
+	return "https://www.last.fm/music/" + urlName(s.artist) + "/_/" + urlName(s.track)
+}
+
+// urlName returns name as Last.fm has it in links,
+// with spaces as plus signs.
+func urlName(name string) string {
+	return strings.Replace(url.PathEscape(name), "%20", "+", -1)
+}
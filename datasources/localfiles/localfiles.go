@@ -0,0 +1,281 @@
+// Package localfiles implements a Timeliner data source for
+// importing photos and videos from folders on local disks.
+package localfiles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Local Files"
+	DataSourceID   = "localfiles"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		opts := acc.Options.(*Options)
+		return &Client{folder: opts.Folder}, nil
+	},
+	ClientOptions: func() interface{} { return new(Options) },
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options configures a local files client.
+type Options struct {
+	Folder string `toml:"folder" desc:"The folder to import from when no filename is given (e.g. with get-all or in daemon mode)"`
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct {
+	folder string
+}
+
+// ListItems lists the photos and videos in the folder opt.Filename,
+// or the configured folder if opt.Filename is empty. Subfolders are
+// listed as collections. Timeframes are not honored; instead, files
+// that have not changed since the last run are not read again.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	root := opt.Filename
+	if root == "" {
+		root = c.folder
+	}
+	if root == "" {
+		return fmt.Errorf("folder is required")
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("resolving folder path: %v", err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("opening folder: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", root)
+	}
+
+	var prevState syncState
+	if opt.SyncState != nil {
+		err := timeliner.UnmarshalGob(opt.SyncState, &prevState)
+		if err != nil {
+			log.Printf("[ERROR][%s] Decoding sync state; all files will be read: %v", DataSourceID, err)
+			prevState = syncState{}
+		}
+	}
+
+	// files in other folders are kept as they were, but files in
+	// this folder are only kept if they are still here
+	state := syncState{Files: make(map[string]fileState)}
+	for fpath, fs := range prevState.Files {
+		if !strings.HasPrefix(fpath, root+string(filepath.Separator)) {
+			state.Files[fpath] = fs
+		}
+	}
+
+	// save what we know so far even if interrupted; every
+	// entry describes a file that was read successfully
+	defer func() {
+		stateBytes, err := timeliner.MarshalGob(state)
+		if err != nil {
+			log.Printf("[ERROR][%s] Encoding sync state: %v", DataSourceID, err)
+			return
+		}
+		timeliner.SaveSyncState(ctx, stateBytes)
+	}()
+
+	err = filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[ERROR][%s] Accessing %s: %v", DataSourceID, fpath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errStopWalk
+		}
+		if strings.HasPrefix(info.Name(), ".") && fpath != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		mediaType := mediaTypeOf(fpath)
+		if mediaType == "" {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return fmt.Errorf("relating %s to %s: %v", fpath, root, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		// if the file has not changed since last time,
+		// we already know its hash and timestamp
+		fs, ok := prevState.Files[fpath]
+		if !ok || fs.Size != info.Size() || !fs.ModTime.Equal(info.ModTime()) {
+			fs, err = readFileState(fpath, info, mediaType)
+			if err != nil {
+				log.Printf("[ERROR][%s] Reading %s: %v", DataSourceID, fpath, err)
+				return nil
+			}
+		}
+		state.Files[fpath] = fs
+
+		itemChan <- makeItemGraph(&mediaFile{
+			path:      fpath,
+			mediaType: mediaType,
+			state:     fs,
+		}, relPath)
+
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// errStopWalk stops walking the folder.
+var errStopWalk = fmt.Errorf("stop walk")
+
+// makeItemGraph makes the item graph for m, which is at
+// relPath within the root folder; if it is in a subfolder,
+// it is added to that folder's collection.
+func makeItemGraph(m *mediaFile, relPath string) *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(m)
+	if dir := path.Dir(relPath); dir != "." {
+		name := path.Base(dir)
+		ig.Collections = append(ig.Collections, timeliner.Collection{
+			OriginalID: dir,
+			Name:       &name,
+			Items:      []timeliner.CollectionItem{{Item: m}},
+		})
+	}
+	return ig
+}
+
+// readFileState reads the file at fpath, computing its
+// hash and finding its timestamp.
+func readFileState(fpath string, info os.FileInfo, mediaType string) (fileState, error) {
+	fs := fileState{
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Timestamp: info.ModTime(),
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return fs, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return fs, fmt.Errorf("hashing file: %v", err)
+	}
+	fs.Hash = h.Sum(nil)
+
+	// prefer the time the photo or video was taken
+	var ts time.Time
+	switch mediaType {
+	case "image":
+		ts, err = exifTimestamp(f)
+	case "video":
+		ts, err = videoTimestamp(f)
+	}
+	if err == nil && !ts.IsZero() {
+		fs.Timestamp = ts
+	}
+
+	return fs, nil
+}
+
+// mediaTypeOf returns "image" or "video" depending on the
+// file extension of fpath, or "" if it is neither.
+func mediaTypeOf(fpath string) string {
+	ext := strings.ToLower(filepath.Ext(fpath))
+	if mt, ok := extraMediaTypes[ext]; ok {
+		return mt
+	}
+	mimeType := mime.TypeByExtension(ext)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	}
+	return ""
+}
+
+// extraMediaTypes covers common extensions of media
+// files that the mime package may not know about.
+var extraMediaTypes = map[string]string{
+	".jpg":  "image",
+	".jpeg": "image",
+	".png":  "image",
+	".gif":  "image",
+	".heic": "image",
+	".heif": "image",
+	".tif":  "image",
+	".tiff": "image",
+	".dng":  "image",
+	".cr2":  "image",
+	".nef":  "image",
+	".arw":  "image",
+	".mp4":  "video",
+	".m4v":  "video",
+	".mov":  "video",
+	".3gp":  "video",
+	".avi":  "video",
+	".mkv":  "video",
+	".mts":  "video",
+}
+
+// syncState is what is remembered between runs
+// so that unchanged files need not be read again.
+type syncState struct {
+	Files map[string]fileState // keyed by absolute path
+}
+
+type fileState struct {
+	Size      int64
+	ModTime   time.Time
+	Hash      []byte // SHA-256
+	Timestamp time.Time
+}
+
+func (fs fileState) id() string {
+	return hex.EncodeToString(fs.Hash)
+}
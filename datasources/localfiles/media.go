@@ -0,0 +1,269 @@
+package localfiles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// mediaFile is a photo or video on disk.
+type mediaFile struct {
+	path      string
+	mediaType string // "image" or "video"
+	state     fileState
+
+	// populated lazily, since most files
+	// are already in the timeline
+	exif     *exif.Exif
+	exifRead bool
+}
+
+// ID returns the hex-encoded SHA-256 of the file's contents,
+// so the same file is the same item wherever it is found.
+func (m *mediaFile) ID() string {
+	return m.state.id()
+}
+
+func (m *mediaFile) Timestamp() time.Time {
+	return m.state.Timestamp
+}
+
+func (m *mediaFile) Class() timeliner.ItemClass {
+	if m.mediaType == "video" {
+		return timeliner.ClassVideo
+	}
+	return timeliner.ClassImage
+}
+
+func (m *mediaFile) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (m *mediaFile) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (m *mediaFile) DataFileName() *string {
+	name := filepath.Base(m.path)
+	return &name
+}
+
+func (m *mediaFile) DataFileReader() (io.ReadCloser, error) {
+	return os.Open(m.path)
+}
+
+func (m *mediaFile) DataFileHash() []byte {
+	return m.state.Hash
+}
+
+func (m *mediaFile) DataFileMIMEType() *string {
+	mt := mime.TypeByExtension(strings.ToLower(filepath.Ext(m.path)))
+	if mt == "" {
+		return nil
+	}
+	return &mt
+}
+
+func (m *mediaFile) Metadata() (*timeliner.Metadata, error) {
+	x := m.loadEXIF()
+	if x == nil {
+		return nil, nil
+	}
+
+	var meta timeliner.Metadata
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	meta.Width = exifInt(x, exif.PixelXDimension)
+	meta.Height = exifInt(x, exif.PixelYDimension)
+	meta.FocalLength = exifFloat(x, exif.FocalLength)
+	meta.ApertureFNumber = exifFloat(x, exif.FNumber)
+	meta.ISOEquivalent = exifInt(x, exif.ISOSpeedRatings)
+	if secs := exifFloat(x, exif.ExposureTime); secs > 0 {
+		meta.ExposureTime = time.Duration(secs * float64(time.Second))
+	}
+
+	return &meta, nil
+}
+
+func (m *mediaFile) Location() (*timeliner.Location, error) {
+	x := m.loadEXIF()
+	if x == nil {
+		return nil, nil
+	}
+	lat, lon, err := x.LatLong()
+	if err != nil {
+		return nil, nil
+	}
+	return &timeliner.Location{
+		Latitude:  &lat,
+		Longitude: &lon,
+	}, nil
+}
+
+// loadEXIF reads the file's EXIF data, if it is an image
+// that has any; otherwise it returns nil.
+func (m *mediaFile) loadEXIF() *exif.Exif {
+	if m.exifRead || m.mediaType != "image" {
+		return m.exif
+	}
+	m.exifRead = true
+	f, err := os.Open(m.path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	m.exif, _ = exif.Decode(f)
+	return m.exif
+}
+
+func exifString(x *exif.Exif, field exif.FieldName) string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func exifInt(x *exif.Exif, field exif.FieldName) int {
+	tag, err := x.Get(field)
+	if err != nil {
+		return 0
+	}
+	i, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+func exifFloat(x *exif.Exif, field exif.FieldName) float64 {
+	tag, err := x.Get(field)
+	if err != nil {
+		return 0
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// exifTimestamp returns the time the image in f was
+// taken according to its EXIF data.
+func exifTimestamp(f *os.File) (time.Time, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return time.Time{}, err
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return x.DateTime()
+}
+
+// videoTimestamp returns the creation time of the MP4 or
+// QuickTime video in f, according to its movie header
+// ("mvhd") atom. Other containers are not supported.
+func videoTimestamp(f *os.File) (time.Time, error) {
+	moov, err := findAtom(f, 0, -1, "moov")
+	if err != nil {
+		return time.Time{}, err
+	}
+	mvhd, err := findAtom(f, moov.dataStart(), moov.end(), "mvhd")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// version (1 byte) and flags (3 bytes), then the creation
+	// time, which is 32 bits in version 0 and 64 in version 1
+	buf := make([]byte, 12)
+	_, err = f.ReadAt(buf, mvhd.dataStart())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading mvhd atom: %v", err)
+	}
+	var secs uint64
+	if buf[0] == 1 {
+		secs = binary.BigEndian.Uint64(buf[4:12])
+	} else {
+		secs = uint64(binary.BigEndian.Uint32(buf[4:8]))
+	}
+	if secs == 0 {
+		return time.Time{}, fmt.Errorf("no creation time")
+	}
+
+	return mp4Epoch.Add(time.Duration(secs) * time.Second), nil
+}
+
+// atom is the header of an MP4/QuickTime box.
+type atom struct {
+	offset     int64
+	size       int64
+	headerSize int64
+}
+
+func (a atom) dataStart() int64 { return a.offset + a.headerSize }
+func (a atom) end() int64       { return a.offset + a.size }
+
+// findAtom finds the atom of the given type among the
+// atoms between offsets start and end (or the end of
+// the file, if end < 0).
+func findAtom(f *os.File, start, end int64, typ string) (atom, error) {
+	if end < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return atom{}, err
+		}
+		end = info.Size()
+	}
+
+	hdr := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		_, err := f.ReadAt(hdr[:8], offset)
+		if err != nil {
+			return atom{}, fmt.Errorf("reading atom header: %v", err)
+		}
+		a := atom{
+			offset:     offset,
+			size:       int64(binary.BigEndian.Uint32(hdr[:4])),
+			headerSize: 8,
+		}
+		switch a.size {
+		case 0:
+			// atom extends to the end
+			a.size = end - offset
+		case 1:
+			// 64-bit size follows the type
+			_, err := f.ReadAt(hdr[8:16], offset+8)
+			if err != nil {
+				return atom{}, fmt.Errorf("reading atom size: %v", err)
+			}
+			a.size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			a.headerSize = 16
+		}
+		if a.size < a.headerSize {
+			return atom{}, fmt.Errorf("invalid atom size %d at offset %d", a.size, offset)
+		}
+		if string(hdr[4:8]) == typ {
+			return a, nil
+		}
+		offset += a.size
+	}
+
+	return atom{}, fmt.Errorf("no %s atom", typ)
+}
+
+// mp4Epoch is the epoch of MP4/QuickTime timestamps.
+var mp4Epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
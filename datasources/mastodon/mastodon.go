@@ -0,0 +1,414 @@
+// Package mastodon implements a Timeliner data source for importing
+// archives requested from Mastodon, which are in the ActivityPub
+// (ActivityStreams 2.0) format.
+package mastodon
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Mastodon"
+	DataSourceID   = "mastodon"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return &Client{acc: acc}, nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct {
+	acc   timeliner.Account
+	owner actor
+}
+
+// ListItems lists the posts and boosts in the archive at opt.Filename,
+// which must be non-empty. It may be the .zip file of the archive or
+// a folder it was extracted into. The posts and boosts are listed
+// from the archive's outbox, followed by the posts the owner liked
+// and bookmarked. Timeframes are not honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	arch, err := openArchive(opt.Filename)
+	if err != nil {
+		return err
+	}
+	defer timeliner.AfterItems(ctx, func() { arch.Close() })
+
+	err = c.loadOwner(arch)
+	if err != nil {
+		return err
+	}
+
+	err = c.listOutbox(ctx, arch, itemChan)
+	if err != nil {
+		return err
+	}
+
+	err = c.listCollection(ctx, arch, "likes.json", timeliner.RelLikes, itemChan)
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Listing likes: %v", DataSourceID, c.acc.UserID, err)
+	}
+	err = c.listCollection(ctx, arch, "bookmarks.json", relBookmarked, itemChan)
+	if err != nil {
+		log.Printf("[ERROR][%s/%s] Listing bookmarks: %v", DataSourceID, c.acc.UserID, err)
+	}
+
+	return nil
+}
+
+// loadOwner loads the owner of the archive from its actor.json file.
+func (c *Client) loadOwner(arch *archive) error {
+	f, err := arch.open("actor.json")
+	if err != nil {
+		return fmt.Errorf("opening actor file: %v", err)
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&c.owner)
+	if err != nil {
+		return fmt.Errorf("decoding actor file: %v", err)
+	}
+	if c.owner.acct() == "" {
+		return fmt.Errorf("actor file has no account identity")
+	}
+	return nil
+}
+
+// listOutbox lists the posts and boosts in the archive's outbox,
+// which is decoded one activity at a time, since it can be large.
+func (c *Client) listOutbox(ctx context.Context, arch *archive, itemChan chan<- *timeliner.ItemGraph) error {
+	f, err := arch.open("outbox.json")
+	if err != nil {
+		return fmt.Errorf("opening outbox: %v", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	err = seekOrderedItems(dec)
+	if err != nil {
+		return fmt.Errorf("reading outbox: %v", err)
+	}
+
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var act activity
+		err := dec.Decode(&act)
+		if err != nil {
+			return fmt.Errorf("decoding activity: %v", err)
+		}
+
+		var ig *timeliner.ItemGraph
+		switch act.Type {
+		case "Create":
+			ig, err = c.createItemGraph(act, arch)
+		case "Announce":
+			ig, err = c.announceItemGraph(act)
+		}
+		if err != nil {
+			log.Printf("[ERROR][%s/%s] Activity %s: %v", DataSourceID, c.acc.UserID, act.ID, err)
+			continue
+		}
+		if ig != nil {
+			itemChan <- ig
+		}
+	}
+
+	return nil
+}
+
+// createItemGraph returns the item graph for a Create activity,
+// which relates the post it created to its attachments, to the
+// post it replies to, and to the people it mentions. Objects
+// other than posts and polls are skipped.
+func (c *Client) createItemGraph(act activity, arch *archive) (*timeliner.ItemGraph, error) {
+	var n note
+	err := json.Unmarshal(act.Object, &n)
+	if err != nil {
+		return nil, fmt.Errorf("decoding object: %v", err)
+	}
+	if n.Type != "Note" && n.Type != "Question" {
+		return nil, nil
+	}
+	n.owner = c.owner
+
+	ig := timeliner.NewItemGraph(&n)
+
+	for i, a := range n.Attachment {
+		p := strings.TrimPrefix(a.URL, "/")
+		if !arch.has(p) {
+			log.Printf("[ERROR][%s/%s] Attachment of %s not in archive: %s",
+				DataSourceID, c.acc.UserID, n.URI, a.URL)
+			continue
+		}
+		ig.Add(&mediaAttachment{
+			attachment: a,
+			parent:     &n,
+			index:      i,
+			arch:       arch,
+			path:       p,
+		}, timeliner.RelAttached)
+	}
+
+	if n.InReplyTo != nil && *n.InReplyTo != "" {
+		ig.Add(newStatusStub(*n.InReplyTo), timeliner.RelReplyTo)
+	}
+
+	// direct messages are sent to the people they mention
+	rel := relMentions
+	if n.isDirect() {
+		rel = timeliner.RelSentTo
+	}
+	for _, t := range n.Tag {
+		if t.Type != "Mention" {
+			continue
+		}
+		acct := t.acct()
+		if acct == "" || acct == c.owner.acct() {
+			continue
+		}
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   acct,
+			Relation: rel,
+		})
+	}
+
+	return ig, nil
+}
+
+// announceItemGraph returns the item graph for an Announce activity,
+// which is a boost of a post that is only known by its ID.
+func (c *Client) announceItemGraph(act activity) (*timeliner.ItemGraph, error) {
+	var objectID string
+	err := json.Unmarshal(act.Object, &objectID)
+	if err != nil {
+		// the object may also be embedded
+		var obj struct {
+			ID string `json:"id"`
+		}
+		err = json.Unmarshal(act.Object, &obj)
+		if err != nil {
+			return nil, fmt.Errorf("decoding object: %v", err)
+		}
+		objectID = obj.ID
+	}
+	if objectID == "" {
+		return nil, fmt.Errorf("boost has no object")
+	}
+
+	b := &boost{activity: act, objectID: objectID, owner: c.owner}
+	ig := timeliner.NewItemGraph(b)
+	ig.Add(newStatusStub(objectID), relBoosts)
+	return ig, nil
+}
+
+// listCollection lists the posts in the collection file with the
+// given name, like the posts the owner liked, which are only known
+// by their IDs, with the owner related to them by rel. It is not an error
+// if the archive does not have the file.
+func (c *Client) listCollection(ctx context.Context, arch *archive, name string,
+	rel timeliner.Relation, itemChan chan<- *timeliner.ItemGraph) error {
+	if !arch.has(name) {
+		return nil
+	}
+
+	f, err := arch.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	err = seekOrderedItems(dec)
+	if err != nil {
+		return err
+	}
+
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var id string
+		err := dec.Decode(&id)
+		if err != nil {
+			return fmt.Errorf("decoding item: %v", err)
+		}
+		if id == "" {
+			continue
+		}
+		ig := timeliner.NewItemGraph(newStatusStub(id))
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:     c.owner.acct(),
+			Name:       c.owner.Name,
+			FromPerson: true,
+			Relation:   rel,
+		})
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// seekOrderedItems advances dec, which is at the start of an
+// ActivityStreams OrderedCollection, past the opening bracket
+// of its orderedItems array, skipping any fields before it.
+func seekOrderedItems(dec *json.Decoder) error {
+	tkn, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("decoding token: %v", err)
+	}
+	if tkn != json.Delim('{') {
+		return fmt.Errorf("expected { but got %v", tkn)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decoding field name: %v", err)
+		}
+		if key == "orderedItems" {
+			tkn, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("decoding token: %v", err)
+			}
+			if tkn != json.Delim('[') {
+				return fmt.Errorf("expected [ but got %v", tkn)
+			}
+			return nil
+		}
+		var skip json.RawMessage
+		err = dec.Decode(&skip)
+		if err != nil {
+			return fmt.Errorf("decoding %v: %v", key, err)
+		}
+	}
+	return fmt.Errorf("no orderedItems field")
+}
+
+// archive is an archive, either a .zip file or a folder
+// it was extracted into. Paths in the archive are relative
+// to the folder that the outbox.json file is in.
+type archive struct {
+	zr       *zip.ReadCloser
+	zipFiles map[string]*zip.File
+	dir      string
+}
+
+// openArchive opens the archive at filename.
+func openArchive(filename string) (*archive, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", filename, err)
+	}
+
+	arch := new(archive)
+	if info.IsDir() {
+		arch.dir = filename
+	} else {
+		arch.zr, err = zip.OpenReader(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening zip file: %v", err)
+		}
+
+		// the archive might have been re-zipped with
+		// its contents in a folder
+		var root string
+		for _, f := range arch.zr.File {
+			if path.Base(f.Name) == "outbox.json" {
+				root = path.Dir(f.Name)
+				break
+			}
+		}
+		arch.zipFiles = make(map[string]*zip.File)
+		for _, f := range arch.zr.File {
+			if root == "." {
+				arch.zipFiles[f.Name] = f
+			} else if strings.HasPrefix(f.Name, root+"/") {
+				arch.zipFiles[strings.TrimPrefix(f.Name, root+"/")] = f
+			}
+		}
+	}
+
+	if !arch.has("outbox.json") {
+		arch.Close()
+		return nil, fmt.Errorf("no outbox.json file found in %s", filename)
+	}
+
+	return arch, nil
+}
+
+// has returns true if the archive has a file at the
+// slash-separated path p.
+func (arch *archive) has(p string) bool {
+	p = path.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return false
+	}
+	if arch.zipFiles != nil {
+		_, ok := arch.zipFiles[p]
+		return ok
+	}
+	info, err := os.Stat(filepath.Join(arch.dir, filepath.FromSlash(p)))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// open opens the file in the archive at the slash-separated path p.
+func (arch *archive) open(p string) (io.ReadCloser, error) {
+	if arch.zipFiles != nil {
+		zf, ok := arch.zipFiles[path.Clean(p)]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", p)
+		}
+		return zf.Open()
+	}
+	return os.Open(filepath.Join(arch.dir, filepath.FromSlash(path.Clean(p))))
+}
+
+func (arch *archive) Close() error {
+	if arch.zr != nil {
+		return arch.zr.Close()
+	}
+	return nil
+}
+
+var (
+	// relBoosts relates a boost to the post it boosts: "<from> boosts <to>"
+	relBoosts = timeliner.Relation{Label: "boosts", Bidirectional: false}
+
+	// relMentions relates a post to a person it mentions: "<from> mentions <to>"
+	relMentions = timeliner.Relation{Label: "mentions", Bidirectional: false}
+
+	// relBookmarked relates a person to a post they
+	// bookmarked: "<from> bookmarked <to>"
+	relBookmarked = timeliner.Relation{Label: "bookmarked", Bidirectional: false}
+)
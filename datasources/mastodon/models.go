@@ -0,0 +1,449 @@
+package mastodon
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// activity is an activity in the outbox, like
+// creating a post (Create) or boosting one (Announce).
+type activity struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Published string          `json:"published"`
+	Object    json.RawMessage `json:"object"`
+}
+
+// note is a post (or a poll, which is a post with choices).
+type note struct {
+	URI        string       `json:"id"`
+	Type       string       `json:"type"`
+	Summary    *string      `json:"summary"` // content warning
+	InReplyTo  *string      `json:"inReplyTo"`
+	Published  string       `json:"published"`
+	URL        string       `json:"url"`
+	To         stringList   `json:"to"`
+	CC         stringList   `json:"cc"`
+	Content    string       `json:"content"`
+	Attachment []attachment `json:"attachment"`
+	Tag        []tag        `json:"tag"`
+
+	owner actor
+}
+
+// ID returns the ID of the post, which is its URI.
+func (n *note) ID() string {
+	return n.URI
+}
+
+func (n *note) Timestamp() time.Time {
+	ts, _ := time.Parse(time.RFC3339, n.Published)
+	return ts
+}
+
+func (n *note) Class() timeliner.ItemClass {
+	if n.isDirect() {
+		return timeliner.ClassPrivateMessage
+	}
+	return timeliner.ClassPost
+}
+
+func (n *note) Owner() (*string, *string) {
+	return n.owner.Owner()
+}
+
+// DataText returns the text of the post's content,
+// which is HTML, with its paragraphs and line breaks.
+func (n *note) DataText() (*string, error) {
+	text := htmlToText(n.Content)
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (n *note) DataFileName() *string {
+	return nil
+}
+
+func (n *note) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (n *note) DataFileHash() []byte {
+	return nil
+}
+
+func (n *note) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata returns the link to the post, and its content
+// warning as its description.
+func (n *note) Metadata() (*timeliner.Metadata, error) {
+	m := &timeliner.Metadata{Link: n.URL}
+	if n.Summary != nil {
+		m.Description = *n.Summary
+	}
+	if n.InReplyTo != nil {
+		m.ParentID = *n.InReplyTo
+	}
+	return m, nil
+}
+
+func (n *note) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// isDirect returns true if the post is a direct message,
+// which is addressed neither to the public nor to the
+// owner's followers, but only to the people it mentions.
+func (n *note) isDirect() bool {
+	if len(n.To) == 0 && len(n.CC) == 0 {
+		return false
+	}
+	for _, list := range []stringList{n.To, n.CC} {
+		for _, addr := range list {
+			if addr == publicAddress || strings.HasSuffix(addr, "/followers") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// publicAddress is the address of posts that are public.
+const publicAddress = "https://www.w3.org/ns/activitystreams#Public"
+
+// attachment is a media file attached to a post.
+type attachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+	Name      string `json:"name"` // description, or alt text
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// tag is a hashtag, mention, or custom emoji in a post.
+type tag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// acct returns the account identity of the person that is
+// mentioned, like "alice@example.social". Mentions of people
+// on the same server as the post do not have the server in
+// their name, so it is taken from the link to the person.
+func (t tag) acct() string {
+	name := strings.TrimPrefix(t.Name, "@")
+	if name == "" || strings.Contains(name, "@") {
+		return name
+	}
+	u, err := url.Parse(t.Href)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return name + "@" + u.Host
+}
+
+// actor is the owner of the archive.
+type actor struct {
+	ID                string `json:"id"`
+	PreferredUsername string `json:"preferredUsername"`
+	Name              string `json:"name"`
+}
+
+// acct returns the account identity of the actor,
+// like "alice@example.social".
+func (a actor) acct() string {
+	u, err := url.Parse(a.ID)
+	if err != nil || u.Host == "" || a.PreferredUsername == "" {
+		return ""
+	}
+	return a.PreferredUsername + "@" + u.Host
+}
+
+// Owner returns the actor as the owner of an item.
+func (a actor) Owner() (*string, *string) {
+	id := a.acct()
+	if a.Name == "" {
+		return &id, nil
+	}
+	name := a.Name
+	return &id, &name
+}
+
+// boost is the owner's boost of a post.
+type boost struct {
+	activity activity
+	objectID string // the ID of the post that was boosted
+	owner    actor
+}
+
+func (b *boost) ID() string {
+	return b.activity.ID
+}
+
+func (b *boost) Timestamp() time.Time {
+	ts, _ := time.Parse(time.RFC3339, b.activity.Published)
+	return ts
+}
+
+func (b *boost) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+func (b *boost) Owner() (*string, *string) {
+	return b.owner.Owner()
+}
+
+func (b *boost) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (b *boost) DataFileName() *string {
+	return nil
+}
+
+func (b *boost) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (b *boost) DataFileHash() []byte {
+	return nil
+}
+
+func (b *boost) DataFileMIMEType() *string {
+	return nil
+}
+
+func (b *boost) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Link: b.objectID, Type: "boost"}, nil
+}
+
+func (b *boost) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mediaAttachment is a media file attached to a post,
+// which is in the archive.
+type mediaAttachment struct {
+	attachment
+	parent *note
+	index  int
+	arch   *archive
+	path   string // in the archive
+}
+
+func (ma *mediaAttachment) ID() string {
+	return ma.parent.URI + "#attachment-" + strconv.Itoa(ma.index)
+}
+
+func (ma *mediaAttachment) Timestamp() time.Time {
+	return ma.parent.Timestamp()
+}
+
+func (ma *mediaAttachment) Class() timeliner.ItemClass {
+	switch mimeType := ma.mimeTypeOrGuess(); {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (ma *mediaAttachment) Owner() (*string, *string) {
+	return ma.parent.Owner()
+}
+
+// DataText returns the description of the media, if any.
+func (ma *mediaAttachment) DataText() (*string, error) {
+	if ma.Name == "" {
+		return nil, nil
+	}
+	return &ma.Name, nil
+}
+
+func (ma *mediaAttachment) DataFileName() *string {
+	name := path.Base(ma.path)
+	return &name
+}
+
+func (ma *mediaAttachment) DataFileReader() (io.ReadCloser, error) {
+	return ma.arch.open(ma.path)
+}
+
+func (ma *mediaAttachment) DataFileHash() []byte {
+	return nil
+}
+
+func (ma *mediaAttachment) DataFileMIMEType() *string {
+	mimeType := ma.mimeTypeOrGuess()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+func (ma *mediaAttachment) Metadata() (*timeliner.Metadata, error) {
+	if ma.Width == 0 && ma.Height == 0 {
+		return nil, nil
+	}
+	return &timeliner.Metadata{Width: ma.Width, Height: ma.Height}, nil
+}
+
+func (ma *mediaAttachment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mimeTypeOrGuess returns the MIME type of the file as given in
+// the archive, or if it isn't, as guessed from its extension.
+func (ma *mediaAttachment) mimeTypeOrGuess() string {
+	if ma.MediaType != "" {
+		return ma.MediaType
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(ma.path)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// statusStub stands in for a post that is only known by its ID,
+// like the post another post replies to or boosts, or a post the
+// owner liked, until the full post is obtained.
+type statusStub struct {
+	id string
+}
+
+func newStatusStub(id string) *statusStub {
+	return &statusStub{id: id}
+}
+
+func (ss *statusStub) ID() string {
+	return ss.id
+}
+
+// Timestamp returns the time the post was posted, which is
+// encoded in the ID of posts on Mastodon servers since 2017.
+func (ss *statusStub) Timestamp() time.Time {
+	u, err := url.Parse(ss.id)
+	if err != nil {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(path.Base(u.Path), 10, 64)
+	if err != nil || n < snowflakeMinID {
+		return time.Time{}
+	}
+	return time.Unix(0, (n>>16)*int64(time.Millisecond))
+}
+
+// snowflakeMinID is a lower bound of the IDs that encode the time
+// they were made; IDs before Mastodon 2.0 were sequential and smaller.
+const snowflakeMinID = 1 << 56
+
+func (ss *statusStub) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+// Owner returns the account identity of the author of the
+// post if its ID has the form Mastodon uses, or nil if not.
+func (ss *statusStub) Owner() (*string, *string) {
+	acct := acctFromStatusID(ss.id)
+	if acct == "" {
+		return nil, nil
+	}
+	return &acct, nil
+}
+
+func (ss *statusStub) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (ss *statusStub) DataFileName() *string {
+	return nil
+}
+
+func (ss *statusStub) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (ss *statusStub) DataFileHash() []byte {
+	return nil
+}
+
+func (ss *statusStub) DataFileMIMEType() *string {
+	return nil
+}
+
+func (ss *statusStub) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Link: ss.id, Placeholder: true}, nil
+}
+
+func (ss *statusStub) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// acctFromStatusID returns the account identity of the author of
+// the post with the given ID, which Mastodon makes like
+// "https://example.social/users/alice/statuses/12345", or an
+// empty string if the ID does not have that form.
+func acctFromStatusID(id string) string {
+	u, err := url.Parse(id)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "users" || parts[2] != "statuses" {
+		return ""
+	}
+	return parts[1] + "@" + u.Host
+}
+
+// stringList is a list of strings, which ActivityStreams
+// allows to be a single string when it has one element.
+type stringList []string
+
+func (sl *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*sl = stringList{s}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(b, &list)
+	*sl = list
+	return err
+}
+
+// htmlToText returns the text of the HTML content of a post,
+// which has paragraphs, line breaks, and links, with blank
+// lines between paragraphs.
+func htmlToText(content string) string {
+	text := paragraphBreakRE.ReplaceAllString(content, "\n\n")
+	text = lineBreakRE.ReplaceAllString(text, "\n")
+	text = tagRE.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+var (
+	paragraphBreakRE = regexp.MustCompile(`(?i)</p>\s*<p[^>]*>`)
+	lineBreakRE      = regexp.MustCompile(`(?i)<br\s*/?>`)
+	tagRE            = regexp.MustCompile(`<[^>]*>`)
+)
@@ -0,0 +1,361 @@
+// Package metaarchive reads the archives of their information that
+// Facebook and Instagram let their users download, which have the
+// same structure: JSON files with the data, and the media files
+// they refer to, in a .zip file or a folder it was extracted into.
+package metaarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Archive is an archive downloaded from Facebook or Instagram,
+// either a .zip file or a folder it was extracted into.
+type Archive struct {
+	// Names are the slash-separated paths of all
+	// the files in the archive, sorted.
+	Names []string
+
+	// Root is the prefix of the names that the paths in the
+	// JSON files, such as the URIs of media, are relative to.
+	// It is not empty if the archive was re-zipped with its
+	// contents in a folder; the data sources find it by the
+	// files they know to be at the top of their archives.
+	Root string
+
+	zr       *zip.ReadCloser
+	zipFiles map[string]*zip.File
+	dir      string
+}
+
+// Open opens the archive at filename. It must be closed
+// once the items that read from it have been processed.
+func Open(filename string) (*Archive, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", filename, err)
+	}
+
+	arch := new(Archive)
+	if info.IsDir() {
+		arch.dir = filename
+		err := filepath.Walk(filename, func(fpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				rel, err := filepath.Rel(filename, fpath)
+				if err != nil {
+					return err
+				}
+				arch.Names = append(arch.Names, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading folder: %v", err)
+		}
+	} else {
+		arch.zr, err = zip.OpenReader(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening zip file: %v", err)
+		}
+		arch.zipFiles = make(map[string]*zip.File)
+		for _, f := range arch.zr.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			arch.zipFiles[f.Name] = f
+			arch.Names = append(arch.Names, f.Name)
+		}
+	}
+	sort.Strings(arch.Names)
+
+	return arch, nil
+}
+
+// Close closes the archive.
+func (arch *Archive) Close() error {
+	if arch.zr != nil {
+		return arch.zr.Close()
+	}
+	return nil
+}
+
+// Find returns the name of the first file in the archive with
+// the given base name, or an empty string if there is none.
+func (arch *Archive) Find(base string) string {
+	for _, name := range arch.Names {
+		if path.Base(name) == base {
+			return name
+		}
+	}
+	return ""
+}
+
+// Files returns the names of the files in the
+// archive whose base names match re.
+func (arch *Archive) Files(re *regexp.Regexp) []string {
+	var names []string
+	for _, name := range arch.Names {
+		if re.MatchString(path.Base(name)) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Has returns true if the archive has the file with the
+// given URI, which is relative to the root of the archive.
+func (arch *Archive) Has(uri string) bool {
+	if uri == "" {
+		return false
+	}
+	name := arch.Root + path.Clean(uri)
+	if arch.zipFiles != nil {
+		_, ok := arch.zipFiles[name]
+		return ok
+	}
+	info, err := os.Stat(filepath.Join(arch.dir, filepath.FromSlash(name)))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// OpenURI opens the file with the given URI, which is
+// relative to the root of the archive.
+func (arch *Archive) OpenURI(uri string) (io.ReadCloser, error) {
+	return arch.Open(arch.Root + path.Clean(uri))
+}
+
+// Open opens the file in the archive with the given name.
+func (arch *Archive) Open(name string) (io.ReadCloser, error) {
+	if arch.zipFiles != nil {
+		zf, ok := arch.zipFiles[name]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		return zf.Open()
+	}
+	return os.Open(filepath.Join(arch.dir, filepath.FromSlash(name)))
+}
+
+// ReadJSON decodes the JSON file in the archive with the given
+// name into v, after fixing the encoding of its strings.
+func (arch *Archive) ReadJSON(name string, v interface{}) error {
+	f, err := arch.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(FixMojibake(b), v)
+}
+
+// ReadList decodes the list in the JSON file in the archive with
+// the given name into v, which must be a pointer to a slice. The
+// list is either the whole file, or, in an object, the value of
+// the first of the given keys (or the key with "_v2" appended,
+// as in newer archives) that the object has.
+func (arch *Archive) ReadList(name string, v interface{}, keys ...string) error {
+	var raw json.RawMessage
+	err := arch.ReadJSON(name, &raw)
+	if err != nil {
+		return err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		return json.Unmarshal(raw, v)
+	}
+
+	var obj map[string]json.RawMessage
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		for _, k := range []string{key, key + "_v2"} {
+			if list, ok := obj[k]; ok {
+				return json.Unmarshal(list, v)
+			}
+		}
+	}
+	return nil
+}
+
+// ThreadFiles returns the names of the files of each conversation
+// in the archive, which are in a folder of their own. The messages
+// of a long conversation are split across files.
+func (arch *Archive) ThreadFiles() [][]string {
+	threadFiles := make(map[string][]string)
+	var threadDirs []string
+	for _, name := range arch.Names {
+		if !messageFileRegex.MatchString(path.Base(name)) {
+			continue
+		}
+		dir := path.Dir(name)
+		if _, ok := threadFiles[dir]; !ok {
+			threadDirs = append(threadDirs, dir)
+		}
+		threadFiles[dir] = append(threadFiles[dir], name)
+	}
+
+	threads := make([][]string, 0, len(threadDirs))
+	for _, dir := range threadDirs {
+		threads = append(threads, threadFiles[dir])
+	}
+	return threads
+}
+
+// ReadThread decodes the conversation split across the given
+// files, as returned by ThreadFiles, into v, which must be a
+// pointer to a struct with the conversation's "messages" and
+// "thread_path". The messages of all the files are decoded,
+// from the oldest to the newest, since each file has them from
+// the newest to the oldest. If the conversation has no path,
+// it is the name of the conversation's folder.
+func (arch *Archive) ReadThread(names []string, v interface{}) error {
+	var thread map[string]json.RawMessage
+	var messages []json.RawMessage
+	for _, name := range names {
+		var part map[string]json.RawMessage
+		err := arch.ReadJSON(name, &part)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		var partMessages []json.RawMessage
+		if raw, ok := part["messages"]; ok {
+			err = json.Unmarshal(raw, &partMessages)
+			if err != nil {
+				return fmt.Errorf("%s: messages: %v", name, err)
+			}
+		}
+		if thread == nil {
+			thread = part
+		}
+		messages = append(messages, partMessages...)
+	}
+	if thread == nil {
+		return nil
+	}
+
+	times := make([]int64, len(messages))
+	for i, raw := range messages {
+		var msg struct {
+			TimestampMS int64 `json:"timestamp_ms"`
+		}
+		err := json.Unmarshal(raw, &msg)
+		if err != nil {
+			return fmt.Errorf("message %d: %v", i, err)
+		}
+		times[i] = msg.TimestampMS
+	}
+	sort.Stable(messagesByTime{messages, times})
+
+	var err error
+	thread["messages"], err = json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	if _, ok := thread["thread_path"]; !ok {
+		thread["thread_path"], err = json.Marshal(path.Base(path.Dir(names[0])))
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(thread)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// messagesByTime sorts messages by their times.
+type messagesByTime struct {
+	messages []json.RawMessage
+	times    []int64
+}
+
+func (m messagesByTime) Len() int           { return len(m.messages) }
+func (m messagesByTime) Less(i, j int) bool { return m.times[i] < m.times[j] }
+func (m messagesByTime) Swap(i, j int) {
+	m.messages[i], m.messages[j] = m.messages[j], m.messages[i]
+	m.times[i], m.times[j] = m.times[j], m.times[i]
+}
+
+// FixMojibake fixes the strings in the JSON of the archives,
+// which encodes each byte of the UTF-8 encoding of a non-ASCII
+// character as its own character, so "é" is escaped like
+// "\u00c3\u00a9". Runs of such escapes are replaced with the
+// bytes they stand for if those are valid UTF-8, so text that
+// was encoded correctly, as in older Instagram archives, is
+// left alone.
+func FixMojibake(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	var run []byte // bytes of the current run of escapes
+	runStart := 0  // index in b where the run started
+
+	flush := func(end int) {
+		if len(run) == 0 {
+			return
+		}
+		if utf8.Valid(run) {
+			out = append(out, run...)
+		} else {
+			out = append(out, b[runStart:end]...)
+		}
+		run = run[:0]
+	}
+
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' || i+1 >= len(b) {
+			flush(i)
+			out = append(out, b[i])
+			continue
+		}
+		if b[i+1] == 'u' && i+6 <= len(b) && b[i+2] == '0' && b[i+3] == '0' {
+			n, err := strconv.ParseUint(string(b[i+4:i+6]), 16, 8)
+			if err == nil && n >= 0x80 {
+				if len(run) == 0 {
+					runStart = i
+				}
+				run = append(run, byte(n))
+				i += 5
+				continue
+			}
+		}
+		// any other escape, which may be an escaped backslash
+		flush(i)
+		out = append(out, b[i], b[i+1])
+		i++
+	}
+	flush(len(b))
+
+	return out
+}
+
+// ContentID returns an ID for an item that does not have one
+// in the archive, made from the parts of it that identify it.
+func ContentID(prefix string, parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return prefix + hex.EncodeToString(h[:16])
+}
+
+var messageFileRegex = regexp.MustCompile(`^message_[0-9]+\.json$`)
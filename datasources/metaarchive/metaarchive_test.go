@@ -0,0 +1,82 @@
+package metaarchive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFixMojibake(t *testing.T) {
+	for i, tc := range []struct {
+		input, expect string
+	}{
+		{`"caf\u00c3\u00a9"`, `"café"`},
+		{`"café"`, `"café"`},                    // correctly encoded
+		{`"\\u00c3\u00a9"`, `"\\u00c3\u00a9"`},  // escaped backslash
+		{`"\u00c3\u00a9 \u00e2"`, `"é \u00e2"`}, // not valid UTF-8
+		{`"\u00f0\u009f\u0098\u0080"`, `"😀"`},
+	} {
+		if actual := string(FixMojibake([]byte(tc.input))); actual != tc.expect {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expect, actual)
+		}
+	}
+}
+
+// TestReadThread checks that the messages of a conversation
+// split across files are read from the oldest to the newest.
+func TestReadThread(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metaarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	thread := filepath.Join(dir, "messages", "inbox", "bob_123")
+	err = os.MkdirAll(thread, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"message_1.json": `{"title": "Bob", "messages": [{"timestamp_ms": 4}, {"timestamp_ms": 3}]}`,
+		"message_2.json": `{"title": "Bob", "messages": [{"timestamp_ms": 2}, {"timestamp_ms": 1}]}`,
+	} {
+		err := ioutil.WriteFile(filepath.Join(thread, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	arch, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arch.Close()
+
+	threads := arch.ThreadFiles()
+	if len(threads) != 1 || len(threads[0]) != 2 {
+		t.Fatalf("expected one conversation in two files, got %v", threads)
+	}
+	var th struct {
+		Title      string `json:"title"`
+		ThreadPath string `json:"thread_path"`
+		Messages   []struct {
+			TimestampMS int64 `json:"timestamp_ms"`
+		} `json:"messages"`
+	}
+	err = arch.ReadThread(threads[0], &th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Title != "Bob" || th.ThreadPath != "bob_123" {
+		t.Errorf("expected title Bob and path bob_123, got %q and %q", th.Title, th.ThreadPath)
+	}
+	var times []int64
+	for _, m := range th.Messages {
+		times = append(times, m.TimestampMS)
+	}
+	if !reflect.DeepEqual(times, []int64{1, 2, 3, 4}) {
+		t.Errorf("expected messages from the oldest to the newest, got %v", times)
+	}
+}
@@ -0,0 +1,121 @@
+package smsbackuprestore
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// call is an entry in the call log.
+type call struct {
+	Number      string `xml:"number,attr"`
+	Duration    int64  `xml:"duration,attr"` // seconds
+	Date        int64  `xml:"date,attr"`     // milliseconds
+	Type        int    `xml:"type,attr"`
+	ContactName string `xml:"contact_name,attr"`
+}
+
+// Values of call.Type
+const (
+	callTypeIncoming  = 1
+	callTypeOutgoing  = 2
+	callTypeMissed    = 3
+	callTypeVoicemail = 4
+	callTypeRejected  = 5
+	callTypeBlocked   = 6
+)
+
+// itemGraph returns the item graph for the call, which
+// relates an outgoing call to the person who was called.
+func (c *call) itemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(c)
+	if c.Type == callTypeOutgoing {
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   normalizeNumber(c.Number),
+			Name:     contactName(c.ContactName),
+			Relation: relCalled,
+		})
+	}
+	return ig
+}
+
+// relCalled relates an outgoing call to the person
+// who was called: "<from> was a call to <to>"
+var relCalled = timeliner.Relation{Label: "called", Bidirectional: false}
+
+// ID returns an ID for the call, which has none of its
+// own, made of when it was and with which number.
+func (c *call) ID() string {
+	return fmt.Sprintf("call_%d_%s", c.Date, normalizeNumber(c.Number))
+}
+
+func (c *call) Timestamp() time.Time {
+	return time.Unix(0, c.Date*int64(time.Millisecond))
+}
+
+func (c *call) Class() timeliner.ItemClass {
+	return timeliner.ClassPhoneCall
+}
+
+// Owner returns the caller of an incoming call;
+// outgoing calls are owned by the account.
+func (c *call) Owner() (*string, *string) {
+	if c.Type == callTypeOutgoing {
+		return nil, nil
+	}
+	number, name := normalizeNumber(c.Number), contactName(c.ContactName)
+	return &number, &name
+}
+
+func (c *call) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (c *call) DataFileName() *string {
+	return nil
+}
+
+func (c *call) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (c *call) DataFileHash() []byte {
+	return nil
+}
+
+func (c *call) DataFileMIMEType() *string {
+	return nil
+}
+
+func (c *call) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{
+		Duration:  time.Duration(c.Duration) * time.Second,
+		Direction: c.direction(),
+	}, nil
+}
+
+func (c *call) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// direction returns the direction of the call,
+// including whether it was answered.
+func (c *call) direction() string {
+	switch c.Type {
+	case callTypeIncoming:
+		return "incoming"
+	case callTypeOutgoing:
+		return "outgoing"
+	case callTypeMissed:
+		return "missed"
+	case callTypeVoicemail:
+		return "voicemail"
+	case callTypeRejected:
+		return "rejected"
+	case callTypeBlocked:
+		return "blocked"
+	}
+	return ""
+}
@@ -0,0 +1,356 @@
+package smsbackuprestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// sms is a text message.
+type sms struct {
+	Address     string `xml:"address,attr"`
+	Date        int64  `xml:"date,attr"` // milliseconds
+	Type        int    `xml:"type,attr"`
+	Subject     string `xml:"subject,attr"`
+	Body        string `xml:"body,attr"`
+	ContactName string `xml:"contact_name,attr"`
+}
+
+// Values of sms.Type
+const (
+	smsTypeInbox  = 1
+	smsTypeSent   = 2
+	smsTypeDraft  = 3
+	smsTypeOutbox = 4
+	smsTypeFailed = 5
+	smsTypeQueued = 6
+)
+
+// listable returns true if the message was sent or received;
+// drafts and messages that failed to send are skipped.
+func (s *sms) listable() bool {
+	return s.Type != smsTypeDraft && s.Type != smsTypeFailed
+}
+
+func (s *sms) outgoing() bool {
+	return s.Type == smsTypeSent || s.Type == smsTypeOutbox || s.Type == smsTypeQueued
+}
+
+// ID returns an ID for the message, which has none of its own,
+// made of when it was sent, to or from whom, and which way.
+func (s *sms) ID() string {
+	return fmt.Sprintf("sms_%d_%s_%d", s.Date, normalizeNumber(s.Address), s.Type)
+}
+
+func (s *sms) Timestamp() time.Time {
+	return time.Unix(0, s.Date*int64(time.Millisecond))
+}
+
+func (s *sms) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+// Owner returns the sender of a received message; sent
+// messages are owned by the account.
+func (s *sms) Owner() (*string, *string) {
+	if s.outgoing() {
+		return nil, nil
+	}
+	number, name := normalizeNumber(s.Address), contactName(s.ContactName)
+	return &number, &name
+}
+
+func (s *sms) DataText() (*string, error) {
+	text := strings.TrimSpace(nullable(s.Subject) + "\n\n" + nullable(s.Body))
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (s *sms) DataFileName() *string {
+	return nil
+}
+
+func (s *sms) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (s *sms) DataFileHash() []byte {
+	return nil
+}
+
+func (s *sms) DataFileMIMEType() *string {
+	return nil
+}
+
+func (s *sms) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Direction: direction(s.outgoing())}, nil
+}
+
+func (s *sms) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mms is a multimedia message, which is also how group
+// messages and long text messages are sent.
+type mms struct {
+	Date        int64    `xml:"date,attr"` // milliseconds
+	MsgBox      int      `xml:"msg_box,attr"`
+	Address     string   `xml:"address,attr"` // numbers separated by "~"
+	MessageID   string   `xml:"m_id,attr"`
+	Subject     string   `xml:"sub,attr"`
+	ContactName string   `xml:"contact_name,attr"` // names separated by ", "
+	Parts       []part   `xml:"parts>part"`
+	Addrs       []mmsAdr `xml:"addrs>addr"`
+
+	ownNumber string
+}
+
+// Values of mms.MsgBox
+const (
+	msgBoxInbox  = 1
+	msgBoxSent   = 2
+	msgBoxDraft  = 3
+	msgBoxOutbox = 4
+)
+
+func (m *mms) listable() bool {
+	return m.MsgBox != msgBoxDraft
+}
+
+func (m *mms) outgoing() bool {
+	return m.MsgBox == msgBoxSent || m.MsgBox == msgBoxOutbox
+}
+
+// contactNameOf returns the name of the contact with the
+// given address, from the names of all the contacts, which
+// are in the same order as the addresses in m.Address.
+func (m *mms) contactNameOf(address string, names []string) string {
+	for i, addr := range strings.Split(m.Address, "~") {
+		if normalizeNumber(addr) == normalizeNumber(address) && i < len(names) {
+			return contactName(names[i])
+		}
+	}
+	return ""
+}
+
+// sender returns the address of the sender.
+func (m *mms) sender() string {
+	for _, addr := range m.Addrs {
+		if addr.Type == addrTypeFrom {
+			return normalizeNumber(addr.Address)
+		}
+	}
+	return normalizeNumber(m.Address)
+}
+
+// ID returns the message's Message-ID, or if it doesn't
+// have one, an ID made of when it was sent and by whom.
+func (m *mms) ID() string {
+	if id := nullable(m.MessageID); id != "" {
+		return id
+	}
+	return fmt.Sprintf("mms_%d_%s", m.Date, m.sender())
+}
+
+func (m *mms) Timestamp() time.Time {
+	return time.Unix(0, m.Date*int64(time.Millisecond))
+}
+
+func (m *mms) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+// Owner returns the sender of a received message; sent
+// messages are owned by the account.
+func (m *mms) Owner() (*string, *string) {
+	if m.outgoing() {
+		return nil, nil
+	}
+	number := m.sender()
+	if number == "" || number == m.ownNumber {
+		return nil, nil
+	}
+	name := m.contactNameOf(number, strings.Split(m.ContactName, ", "))
+	return &number, &name
+}
+
+// DataText returns the subject of the message
+// and the text of its text parts.
+func (m *mms) DataText() (*string, error) {
+	texts := []string{nullable(m.Subject)}
+	for _, p := range m.Parts {
+		if p.ContentType == "text/plain" {
+			texts = append(texts, nullable(p.Text))
+		}
+	}
+	text := strings.TrimSpace(strings.Join(texts, "\n\n"))
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (m *mms) DataFileName() *string {
+	return nil
+}
+
+func (m *mms) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *mms) DataFileHash() []byte {
+	return nil
+}
+
+func (m *mms) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *mms) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Direction: direction(m.outgoing())}, nil
+}
+
+func (m *mms) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// attachments returns the parts of the message that are
+// files, like pictures, rather than text or the layout.
+func (m *mms) attachments() []*attachment {
+	var atts []*attachment
+	for i, p := range m.Parts {
+		if p.Data == "" || p.ContentType == "text/plain" || p.ContentType == "application/smil" {
+			continue
+		}
+		atts = append(atts, &attachment{msg: m, index: i, part: p})
+	}
+	return atts
+}
+
+// mmsAdr is a sender or recipient of an MMS.
+type mmsAdr struct {
+	Address string `xml:"address,attr"`
+	Type    int    `xml:"type,attr"`
+}
+
+// Values of mmsAdr.Type, which are the
+// PDU header field codes for the address
+const (
+	addrTypeBCC  = 129
+	addrTypeCC   = 130
+	addrTypeFrom = 137
+	addrTypeTo   = 151
+)
+
+// relation returns how a message relates
+// to the recipient at the address.
+func (a mmsAdr) relation() timeliner.Relation {
+	switch a.Type {
+	case addrTypeCC:
+		return timeliner.RelCC
+	case addrTypeBCC:
+		return relBCC
+	}
+	return timeliner.RelSentTo
+}
+
+// relBCC relates a message to its blind-copied recipients:
+// "<from> was blind-copied to <to>"
+var relBCC = timeliner.Relation{Label: "bcc", Bidirectional: false}
+
+// part is a part of an MMS.
+type part struct {
+	Seq         int    `xml:"seq,attr"`
+	ContentType string `xml:"ct,attr"`
+	Name        string `xml:"name,attr"`
+	Filename    string `xml:"fn,attr"`
+	ContentLoc  string `xml:"cl,attr"`
+	Text        string `xml:"text,attr"`
+	Data        string `xml:"data,attr"` // base64
+}
+
+// attachment is a file in an MMS.
+type attachment struct {
+	msg   *mms
+	index int
+	part  part
+}
+
+// ID returns the ID of the message and the position of
+// the part in it, since parts have no IDs of their own.
+func (a *attachment) ID() string {
+	return a.msg.ID() + "_" + strconv.Itoa(a.index)
+}
+
+func (a *attachment) Timestamp() time.Time {
+	return a.msg.Timestamp()
+}
+
+func (a *attachment) Class() timeliner.ItemClass {
+	switch {
+	case strings.HasPrefix(a.part.ContentType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(a.part.ContentType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(a.part.ContentType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (a *attachment) Owner() (*string, *string) {
+	return a.msg.Owner()
+}
+
+func (a *attachment) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (a *attachment) DataFileName() *string {
+	for _, name := range []string{a.part.Filename, a.part.ContentLoc, a.part.Name} {
+		if name = nullable(name); name != "" {
+			return &name
+		}
+	}
+	return nil
+}
+
+func (a *attachment) DataFileReader() (io.ReadCloser, error) {
+	data, err := base64.StdEncoding.DecodeString(a.part.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attachment: %v", err)
+	}
+	return timeliner.FakeCloser(bytes.NewReader(data)), nil
+}
+
+func (a *attachment) DataFileHash() []byte {
+	return nil
+}
+
+func (a *attachment) DataFileMIMEType() *string {
+	return &a.part.ContentType
+}
+
+func (a *attachment) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (a *attachment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// direction returns the direction of a message.
+func direction(outgoing bool) string {
+	if outgoing {
+		return "outgoing"
+	}
+	return "incoming"
+}
@@ -0,0 +1,279 @@
+// Package smsbackuprestore implements a Timeliner data source for
+// importing text messages and call logs from the XML backups made
+// by the Android app SMS Backup & Restore.
+package smsbackuprestore
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "SMS Backup & Restore"
+	DataSourceID   = "sms_backup_restore"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return &Client{ownNumber: normalizeNumber(acc.UserID)}, nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface. The user ID
+// of the account should be the phone number of the phone that was
+// backed up, so that it can be told apart from the other people in
+// group conversations.
+type Client struct {
+	ownNumber string
+}
+
+// ListItems lists the messages and calls in the backup file at
+// opt.Filename, or in all the .xml files in it if it is a folder.
+// Messages are listed as part of a collection for each
+// conversation. Timeframes are not honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	info, err := os.Stat(opt.Filename)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", opt.Filename, err)
+	}
+	if !info.IsDir() {
+		return c.listFile(ctx, opt.Filename, itemChan)
+	}
+
+	err = filepath.Walk(opt.Filename, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[ERROR][%s] Accessing %s: %v", DataSourceID, fpath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errStopWalk
+		}
+		if !info.Mode().IsRegular() || !strings.EqualFold(filepath.Ext(fpath), ".xml") {
+			return nil
+		}
+		err = c.listFile(ctx, fpath, itemChan)
+		if err != nil {
+			log.Printf("[ERROR][%s] Listing %s: %v", DataSourceID, fpath, err)
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// errStopWalk stops walking the folder.
+var errStopWalk = fmt.Errorf("stop walk")
+
+// listFile lists the messages or calls in the backup file at
+// fpath. Backups of messages have a root element of <smses>
+// with <sms> and <mms> elements in it, and backups of calls
+// have a root element of <calls> with <call> elements in it.
+// Since backups can be large, especially with MMS attachments,
+// they are decoded one element at a time.
+func (c *Client) listFile(ctx context.Context, fpath string, itemChan chan<- *timeliner.ItemGraph) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := xml.NewDecoder(f)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		tkn, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decoding XML: %v", err)
+		}
+		start, ok := tkn.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var ig *timeliner.ItemGraph
+		switch start.Name.Local {
+		case "sms":
+			var s sms
+			err = dec.DecodeElement(&s, &start)
+			if err == nil && s.listable() {
+				ig = c.smsItemGraph(&s)
+			}
+		case "mms":
+			var m mms
+			err = dec.DecodeElement(&m, &start)
+			if err == nil && m.listable() {
+				ig = c.mmsItemGraph(&m)
+			}
+		case "call":
+			var cl call
+			err = dec.DecodeElement(&cl, &start)
+			if err == nil {
+				ig = cl.itemGraph()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("decoding %s element: %v", start.Name.Local, err)
+		}
+		if ig != nil {
+			itemChan <- ig
+		}
+	}
+}
+
+// smsItemGraph returns the item graph for s, which relates it to
+// its recipient if it was sent, and puts it in the collection of
+// its conversation.
+func (c *Client) smsItemGraph(s *sms) *timeliner.ItemGraph {
+	number := normalizeNumber(s.Address)
+	ig := timeliner.NewItemGraph(s)
+	if s.outgoing() {
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   number,
+			Name:     contactName(s.ContactName),
+			Relation: timeliner.RelSentTo,
+		})
+	}
+	ig.Collections = append(ig.Collections, conversation([]string{number}, s.ContactName, s))
+	return ig
+}
+
+// mmsItemGraph returns the item graph for m, which relates it to
+// its attachments and its recipients other than the owner of the
+// phone, and puts it in the collection of its conversation.
+func (c *Client) mmsItemGraph(m *mms) *timeliner.ItemGraph {
+	m.ownNumber = c.ownNumber
+	ig := timeliner.NewItemGraph(m)
+	for _, a := range m.attachments() {
+		ig.Add(a, timeliner.RelAttached)
+	}
+
+	names := strings.Split(m.ContactName, ", ")
+	var others []string
+	for _, addr := range m.Addrs {
+		number := normalizeNumber(addr.Address)
+		if number == "" || number == c.ownNumber {
+			continue
+		}
+		others = append(others, number)
+		if addr.Type == addrTypeFrom {
+			continue
+		}
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   number,
+			Name:     m.contactNameOf(addr.Address, names),
+			Relation: addr.relation(),
+		})
+	}
+	if len(others) == 0 {
+		// no addresses listed separately
+		for _, addr := range strings.Split(m.Address, "~") {
+			if number := normalizeNumber(addr); number != "" && number != c.ownNumber {
+				others = append(others, number)
+			}
+		}
+	}
+
+	ig.Collections = append(ig.Collections, conversation(others, m.ContactName, m))
+	return ig
+}
+
+// conversation returns the collection of the conversation with
+// the people with the given phone numbers, with item in it.
+func conversation(numbers []string, name string, item timeliner.Item) timeliner.Collection {
+	numbers = append([]string(nil), numbers...)
+	sort.Strings(numbers)
+	var uniq []string
+	for i, n := range numbers {
+		if i == 0 || n != numbers[i-1] {
+			uniq = append(uniq, n)
+		}
+	}
+
+	coll := timeliner.Collection{
+		OriginalID: "conversation_" + strings.Join(uniq, ","),
+		Items:      []timeliner.CollectionItem{{Item: item}},
+	}
+	if name = contactName(name); name != "" {
+		coll.Name = &name
+	} else if len(uniq) > 0 {
+		name = strings.Join(uniq, ", ")
+		coll.Name = &name
+	}
+	return coll
+}
+
+// normalizeNumber removes the formatting from a phone number
+// so that it can identify a person, like "+15551234567" for
+// "+1 (555) 123-4567". Numbers are not otherwise changed, so
+// the same number may appear with and without a country code.
+func normalizeNumber(number string) string {
+	number = nullable(number)
+	var sb strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r == '+' && i == 0:
+			sb.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			// not a phone number, like the names that
+			// businesses send text messages from
+			return number
+		}
+	}
+	return sb.String()
+}
+
+// contactName returns the name of a contact as written in a
+// backup, or "" if the number was not in the phone's contacts.
+func contactName(name string) string {
+	name = nullable(name)
+	if name == "(Unknown)" {
+		return ""
+	}
+	return name
+}
+
+// nullable returns s, or "" if it is "null", which is how
+// backups write attributes that have no value.
+func nullable(s string) string {
+	if s == "null" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,174 @@
+package spotify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// historyEntry is an entry of the streaming history, in the
+// format of either the account data or the extended history.
+type historyEntry struct {
+	// account data
+	EndTime     string `json:"endTime"`
+	ArtistName  string `json:"artistName"`
+	TrackName   string `json:"trackName"`
+	PodcastName string `json:"podcastName"`
+	EpisodeName string `json:"episodeName"`
+	MSPlayed    int64  `json:"msPlayed"`
+
+	// extended streaming history
+	TS               string  `json:"ts"`
+	MSPlayedExt      int64   `json:"ms_played"`
+	MasterTrackName  *string `json:"master_metadata_track_name"`
+	MasterArtistName *string `json:"master_metadata_album_artist_name"`
+	MasterAlbumName  *string `json:"master_metadata_album_album_name"`
+	TrackURI         *string `json:"spotify_track_uri"`
+	EpisodeNameExt   *string `json:"episode_name"`
+	EpisodeShowName  *string `json:"episode_show_name"`
+	EpisodeURI       *string `json:"spotify_episode_uri"`
+}
+
+// play returns the play that the entry is of, or nil
+// if the entry is not of a song or podcast episode.
+func (e historyEntry) play() (*play, error) {
+	if e.TS == "" {
+		end, err := time.Parse(endTimeFormat, e.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("parsing end time: %v", err)
+		}
+		p := &play{
+			end:      end,
+			duration: time.Duration(e.MSPlayed) * time.Millisecond,
+			title:    e.TrackName,
+			artist:   e.ArtistName,
+		}
+		if e.EpisodeName != "" {
+			p.title, p.artist, p.podcast = e.EpisodeName, e.PodcastName, true
+		}
+		if p.title == "" {
+			return nil, nil
+		}
+		return p, nil
+	}
+
+	end, err := time.Parse(time.RFC3339, e.TS)
+	if err != nil {
+		return nil, fmt.Errorf("parsing end time: %v", err)
+	}
+	p := &play{
+		end:      end,
+		duration: time.Duration(e.MSPlayedExt) * time.Millisecond,
+		title:    deref(e.MasterTrackName),
+		artist:   deref(e.MasterArtistName),
+		album:    deref(e.MasterAlbumName),
+		uri:      deref(e.TrackURI),
+	}
+	if deref(e.EpisodeNameExt) != "" {
+		p.title, p.artist, p.album = deref(e.EpisodeNameExt), deref(e.EpisodeShowName), ""
+		p.uri, p.podcast = deref(e.EpisodeURI), true
+	}
+	if p.title == "" {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// endTimeFormat is the format of the time plays ended
+// in the account data, which is in UTC.
+const endTimeFormat = "2006-01-02 15:04"
+
+// play is a play of a song or podcast episode.
+type play struct {
+	end      time.Time
+	duration time.Duration
+	title    string
+	artist   string // or podcast
+	album    string
+	uri      string // like "spotify:track:ID", if known
+	podcast  bool
+}
+
+// ID returns an ID made from the minute the play ended and what
+// was played, which the account data and the extended history
+// both have, so that the same play has the same ID in either.
+func (p *play) ID() string {
+	h := sha256.Sum256([]byte(strings.Join([]string{p.artist, p.title}, "\x00")))
+	return fmt.Sprintf("spotify_play_%d_%s", p.end.Truncate(time.Minute).Unix(), hex.EncodeToString(h[:8]))
+}
+
+// Timestamp returns the time the play started, counted back
+// from the minute it ended, so that the same play starts at
+// the same time (and is in the same place in the plays of its
+// day) whether it came from the account data or the extended
+// history, which has the second it ended.
+func (p *play) Timestamp() time.Time {
+	return p.end.Truncate(time.Minute).Add(-p.duration)
+}
+
+func (p *play) Class() timeliner.ItemClass {
+	return timeliner.ClassAudio
+}
+
+func (p *play) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (p *play) DataText() (*string, error) {
+	return &p.title, nil
+}
+
+func (p *play) DataFileName() *string {
+	return nil
+}
+
+func (p *play) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (p *play) DataFileHash() []byte {
+	return nil
+}
+
+func (p *play) DataFileMIMEType() *string {
+	return nil
+}
+
+func (p *play) Metadata() (*timeliner.Metadata, error) {
+	m := &timeliner.Metadata{
+		Name:     p.title,
+		Artist:   p.artist,
+		Album:    p.album,
+		Duration: p.duration,
+		Link:     p.link(),
+	}
+	if p.podcast {
+		m.Type = "podcast"
+	}
+	return m, nil
+}
+
+func (p *play) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// link returns the web link to what was played, if its URI is known.
+func (p *play) link() string {
+	parts := strings.Split(p.uri, ":")
+	if len(parts) != 3 || parts[0] != "spotify" {
+		return ""
+	}
+	return "https://open.spotify.com/" + parts[1] + "/" + parts[2]
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,171 @@
+// Package spotify implements a Timeliner data source for importing
+// the streaming history in the account data requested from Spotify.
+package spotify
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Spotify"
+	DataSourceID   = "spotify"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the songs and podcast episodes played in the
+// streaming history at opt.Filename, which must be non-empty. It
+// may be the .zip file of the account data or of the extended
+// streaming history, a folder either was extracted into, or one
+// of the streaming history's JSON files. Plays are also listed
+// in a collection of the plays of their day.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	return eachHistoryFile(opt.Filename, func(name string, r io.Reader) error {
+		err := listPlays(ctx, r, itemChan, opt.Timeframe)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// listPlays lists the plays in the streaming history file read
+// from r that were played within timeframe.
+func listPlays(ctx context.Context, r io.Reader, itemChan chan<- *timeliner.ItemGraph,
+	timeframe timeliner.Timeframe) error {
+	dec := json.NewDecoder(r)
+
+	_, err := dec.Token() // opening bracket '['
+	if err != nil {
+		return fmt.Errorf("decoding opening token: %v", err)
+	}
+
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var entry historyEntry
+		err := dec.Decode(&entry)
+		if err != nil {
+			return fmt.Errorf("decoding history entry: %v", err)
+		}
+
+		p, err := entry.play()
+		if err != nil {
+			log.Printf("[ERROR][%s] Skipping history entry: %v", DataSourceID, err)
+			continue
+		}
+		if p == nil {
+			continue
+		}
+		ts := p.Timestamp()
+		if (timeframe.Since != nil && ts.Before(*timeframe.Since)) ||
+			(timeframe.Until != nil && ts.After(*timeframe.Until)) {
+			continue
+		}
+
+		ig := timeliner.NewItemGraph(p)
+		ig.Collections = append(ig.Collections, timeliner.DayCollection("spotify_plays_", "Spotify plays on ", p))
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// eachHistoryFile calls fn for each streaming history file in
+// the .zip file or folder at filename, or for the file itself if
+// it is neither.
+func eachHistoryFile(filename string, fn func(name string, r io.Reader) error) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", filename, err)
+	}
+
+	switch {
+	case info.IsDir():
+		return filepath.Walk(filename, func(fpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || !historyFileRegex.MatchString(info.Name()) {
+				return nil
+			}
+			return openAndCall(fpath, fn)
+		})
+
+	case path.Ext(filename) == ".zip":
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return fmt.Errorf("opening zip file: %v", err)
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if !historyFileRegex.MatchString(path.Base(zf.Name)) {
+				continue
+			}
+			f, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("opening %s: %v", zf.Name, err)
+			}
+			err = fn(zf.Name, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return openAndCall(filename, fn)
+}
+
+// openAndCall opens the file at fpath and calls fn with it.
+func openAndCall(fpath string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(fpath, f)
+}
+
+// historyFileRegex matches the names of the files of the streaming
+// history in the account data (StreamingHistory0.json, or in newer
+// ones, StreamingHistory_music_0.json and _podcast_0.json), and of
+// the extended streaming history (endsong_0.json, or in newer ones,
+// Streaming_History_Audio_2021-2022_0.json).
+var historyFileRegex = regexp.MustCompile(`^(StreamingHistory(_music_|_podcast_)?\d+|endsong_\d+|Streaming_History_Audio_.*)\.json$`)
@@ -0,0 +1,297 @@
+package telegram
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// message is a message in a chat.
+type message struct {
+	MessageID        json.Number     `json:"id"`
+	Type             string          `json:"type"`
+	Date             string          `json:"date"`
+	DateUnixtime     string          `json:"date_unixtime"`
+	From             string          `json:"from"`
+	FromID           userID          `json:"from_id"`
+	Text             json.RawMessage `json:"text"`
+	ReplyToMessageID json.Number     `json:"reply_to_message_id"`
+	ForwardedFrom    string          `json:"forwarded_from"`
+	Photo            string          `json:"photo"`
+	File             string          `json:"file"`
+	MediaType        string          `json:"media_type"`
+	MIMEType         string          `json:"mime_type"`
+	StickerEmoji     string          `json:"sticker_emoji"`
+	LocationInfo     *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"location_information"`
+
+	chat *chat
+	dir  string
+}
+
+// itemGraph returns the item graph for the message, which relates
+// it to its media, the message it replies to, and the person it
+// was forwarded from.
+func (m *message) itemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(m)
+
+	if m.Photo != "" && m.hasFile(m.Photo) {
+		ig.Add(&mediaFile{msg: m, kind: "photo", path: m.Photo, mimeType: "image/jpeg"}, timeliner.RelAttached)
+	}
+	if m.File != "" && m.hasFile(m.File) {
+		ig.Add(&mediaFile{msg: m, kind: "file", path: m.File, mimeType: m.MIMEType}, timeliner.RelAttached)
+	}
+
+	// replies are to earlier messages in the same chat, which
+	// have been listed but may still be being processed, or may
+	// not be in the export at all; the relation is made once
+	// the message is stored
+	if m.ReplyToMessageID != "" {
+		ig.Relations = append(ig.Relations, timeliner.RawRelation{
+			FromItemID: m.ID(),
+			ToItemID:   messageID(m.chat, m.ReplyToMessageID),
+			Relation:   timeliner.RelReplyTo,
+		})
+	}
+
+	if m.ForwardedFrom != "" {
+		ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+			UserID:   m.ForwardedFrom,
+			Name:     m.ForwardedFrom,
+			Relation: relForwardedFrom,
+		})
+	}
+
+	return ig
+}
+
+// relForwardedFrom relates a forwarded message to the person
+// who originally sent it: "<from> was forwarded from <to>"
+var relForwardedFrom = timeliner.Relation{Label: "forwarded_from", Bidirectional: false}
+
+// hasFile returns true if the media file at the relative path
+// p is in the export. Media that was not exported is listed
+// with an explanation instead of a path.
+func (m *message) hasFile(p string) bool {
+	info, err := os.Stat(filepath.Join(m.dir, filepath.FromSlash(p)))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// ID returns the ID of the message, which is unique only within
+// its chat, prefixed with the ID of the chat.
+func (m *message) ID() string {
+	return messageID(m.chat, m.MessageID)
+}
+
+func messageID(ch *chat, id json.Number) string {
+	return ch.ID.String() + "_" + id.String()
+}
+
+// Timestamp returns the time the message was sent. Exports before
+// 2022 only have its date in local time.
+func (m *message) Timestamp() time.Time {
+	if sec, err := strconv.ParseInt(m.DateUnixtime, 10, 64); err == nil {
+		return time.Unix(sec, 0)
+	}
+	ts, _ := time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local)
+	return ts
+}
+
+func (m *message) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+func (m *message) Owner() (*string, *string) {
+	if m.FromID == "" {
+		return nil, &m.From
+	}
+	id := string(m.FromID)
+	return &id, &m.From
+}
+
+// DataText returns the text of the message, or for a
+// sticker without any, the emoji it stands for.
+func (m *message) DataText() (*string, error) {
+	text := strings.TrimSpace(plainText(m.Text))
+	if text == "" {
+		text = m.StickerEmoji
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return &text, nil
+}
+
+func (m *message) DataFileName() *string {
+	return nil
+}
+
+func (m *message) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *message) DataFileHash() []byte {
+	return nil
+}
+
+func (m *message) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *message) Metadata() (*timeliner.Metadata, error) {
+	if m.ReplyToMessageID == "" {
+		return nil, nil
+	}
+	return &timeliner.Metadata{ParentID: messageID(m.chat, m.ReplyToMessageID)}, nil
+}
+
+func (m *message) Location() (*timeliner.Location, error) {
+	if m.LocationInfo == nil {
+		return nil, nil
+	}
+	return &timeliner.Location{
+		Latitude:  &m.LocationInfo.Latitude,
+		Longitude: &m.LocationInfo.Longitude,
+	}, nil
+}
+
+// userID is the ID of the sender of a message, like "user123".
+// Exports before 2021 only have the number, so the prefix of
+// user IDs is added to those.
+type userID string
+
+func (u *userID) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if json.Unmarshal(b, &n) == nil && !strings.HasPrefix(string(b), `"`) {
+		*u = userID("user" + n.String())
+		return nil
+	}
+	var s string
+	err := json.Unmarshal(b, &s)
+	*u = userID(s)
+	return err
+}
+
+// plainText returns the text of a message, which is either a
+// string, or for formatted text, a list of strings and objects
+// with the text of links, mentions, bold text, and the like.
+func plainText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(part, &s) == nil {
+			sb.WriteString(s)
+		} else if json.Unmarshal(part, &entity) == nil {
+			sb.WriteString(entity.Text)
+		}
+	}
+	return sb.String()
+}
+
+// mediaFile is a photo or other file sent in a message.
+type mediaFile struct {
+	msg      *message
+	kind     string // "photo" or "file"
+	path     string // relative to the export folder
+	mimeType string
+}
+
+func (mf *mediaFile) ID() string {
+	return mf.msg.ID() + "_" + mf.kind
+}
+
+func (mf *mediaFile) Timestamp() time.Time {
+	return mf.msg.Timestamp()
+}
+
+func (mf *mediaFile) Class() timeliner.ItemClass {
+	switch mf.msg.MediaType {
+	case "video_file", "video_message", "animation":
+		return timeliner.ClassVideo
+	case "voice_message", "audio_file":
+		return timeliner.ClassAudio
+	}
+	mimeType := mf.mimeTypeOrGuess()
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (mf *mediaFile) Owner() (*string, *string) {
+	return mf.msg.Owner()
+}
+
+func (mf *mediaFile) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (mf *mediaFile) DataFileName() *string {
+	name := path.Base(mf.path)
+	return &name
+}
+
+func (mf *mediaFile) DataFileReader() (io.ReadCloser, error) {
+	return os.Open(filepath.Join(mf.msg.dir, filepath.FromSlash(mf.path)))
+}
+
+func (mf *mediaFile) DataFileHash() []byte {
+	return nil
+}
+
+func (mf *mediaFile) DataFileMIMEType() *string {
+	mimeType := mf.mimeTypeOrGuess()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+func (mf *mediaFile) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (mf *mediaFile) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mimeTypeOrGuess returns the MIME type of the file as given in
+// the export, or if it isn't, as guessed from its extension.
+func (mf *mediaFile) mimeTypeOrGuess() string {
+	if mf.mimeType != "" {
+		return mf.mimeType
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(mf.path)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
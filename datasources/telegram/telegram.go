@@ -0,0 +1,243 @@
+// Package telegram implements a Timeliner data source for
+// importing chats exported from Telegram Desktop in JSON format.
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "Telegram"
+	DataSourceID   = "telegram"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the messages in the export at opt.Filename, which
+// must be non-empty. It may be the export's result.json file or the
+// folder it is in. Both exports of all data and of a single chat are
+// supported. Each chat is listed as a collection. Timeframes are not
+// honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	filename := opt.Filename
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", filename, err)
+	}
+	if info.IsDir() {
+		filename = filepath.Join(filename, "result.json")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("opening export: %v", err)
+	}
+	defer f.Close()
+
+	l := &lister{
+		ctx:      ctx,
+		dec:      json.NewDecoder(f),
+		dir:      filepath.Dir(filename),
+		itemChan: itemChan,
+	}
+	l.dec.UseNumber()
+
+	err = l.expectDelim('{')
+	if err != nil {
+		return err
+	}
+	return l.listChat()
+}
+
+// lister lists the chats in an export as its JSON is decoded.
+// The export can be very large, since it has all the messages
+// of all the chats, so it is decoded one message at a time.
+type lister struct {
+	ctx      context.Context
+	dec      *json.Decoder
+	dir      string // folder the media paths are relative to
+	itemChan chan<- *timeliner.ItemGraph
+}
+
+// listChat lists the messages of a chat, which is an object whose
+// opening brace has been read. The whole export is also read as a
+// chat, since it is one when only one chat was exported, or else
+// it has the list of chats in its "chats" and "left_chats" fields.
+//
+// A chat's name, type, and ID come before its messages, which
+// must be known to list the messages as part of a collection.
+func (l *lister) listChat() error {
+	var ch chat
+	for l.dec.More() {
+		if l.ctx.Err() != nil {
+			return nil
+		}
+		key, err := l.dec.Token()
+		if err != nil {
+			return fmt.Errorf("decoding field name: %v", err)
+		}
+
+		switch key {
+		case "name":
+			err = l.dec.Decode(&ch.Name)
+		case "type":
+			err = l.dec.Decode(&ch.Type)
+		case "id":
+			err = l.dec.Decode(&ch.ID)
+		case "messages":
+			err = l.listMessages(&ch)
+		case "chats", "left_chats":
+			err = l.listChats()
+		default:
+			var skip json.RawMessage
+			err = l.dec.Decode(&skip)
+		}
+		if err != nil {
+			return fmt.Errorf("decoding %v: %v", key, err)
+		}
+	}
+	return l.expectDelim('}')
+}
+
+// listChats lists the chats in the "list" field of an object
+// like the export's "chats" field.
+func (l *lister) listChats() error {
+	err := l.expectDelim('{')
+	if err != nil {
+		return err
+	}
+	for l.dec.More() {
+		key, err := l.dec.Token()
+		if err != nil {
+			return fmt.Errorf("decoding field name: %v", err)
+		}
+		if key != "list" {
+			var skip json.RawMessage
+			err = l.dec.Decode(&skip)
+			if err != nil {
+				return fmt.Errorf("decoding %v: %v", key, err)
+			}
+			continue
+		}
+
+		err = l.expectDelim('[')
+		if err != nil {
+			return err
+		}
+		for l.dec.More() {
+			if l.ctx.Err() != nil {
+				return nil
+			}
+			err := l.expectDelim('{')
+			if err != nil {
+				return err
+			}
+			err = l.listChat()
+			if err != nil {
+				return err
+			}
+		}
+		err = l.expectDelim(']')
+		if err != nil {
+			return err
+		}
+	}
+	return l.expectDelim('}')
+}
+
+// listMessages lists the messages in a chat's messages array.
+func (l *lister) listMessages(ch *chat) error {
+	err := l.expectDelim('[')
+	if err != nil {
+		return err
+	}
+
+	coll := timeliner.Collection{OriginalID: "chat_" + ch.ID.String()}
+	if name := ch.name(); name != "" {
+		coll.Name = &name
+	}
+
+	var position int
+	for l.dec.More() {
+		if l.ctx.Err() != nil {
+			return nil
+		}
+		m := &message{chat: ch, dir: l.dir}
+		err := l.dec.Decode(m)
+		if err != nil {
+			return fmt.Errorf("decoding message: %v", err)
+		}
+		if m.Type != "message" {
+			// service messages, like someone joining a
+			// group, are not messages sent by anyone
+			continue
+		}
+
+		ig := m.itemGraph()
+		cl := coll
+		cl.Items = []timeliner.CollectionItem{{Item: m, Position: position}}
+		ig.Collections = append(ig.Collections, cl)
+		l.itemChan <- ig
+		position++
+	}
+
+	return l.expectDelim(']')
+}
+
+// expectDelim reads the next token, which must be delim.
+func (l *lister) expectDelim(delim json.Delim) error {
+	tkn, err := l.dec.Token()
+	if err != nil {
+		return fmt.Errorf("decoding token: %v", err)
+	}
+	if tkn != delim {
+		return fmt.Errorf("expected %s but got %v", delim, tkn)
+	}
+	return nil
+}
+
+// chat is a chat in an export.
+type chat struct {
+	Name string      `json:"name"`
+	Type string      `json:"type"`
+	ID   json.Number `json:"id"`
+}
+
+// name returns the name of the chat; the chat with
+// oneself has no name, so it is named after its type.
+func (ch *chat) name() string {
+	if ch.Name == "" && ch.Type == "saved_messages" {
+		return "Saved Messages"
+	}
+	return ch.Name
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mholt/timeliner"
 )
@@ -18,27 +20,29 @@ func (c *Client) getFromAPI(ctx context.Context, itemChan chan<- *timeliner.Item
 
 	// get account owner information
 	cleanedScreenName := strings.TrimPrefix(c.acc.UserID, "@")
-	ownerAccount, err := c.getAccountFromAPI(cleanedScreenName, "")
+	ownerAccount, err := c.getAccountFromAPI(ctx, cleanedScreenName)
 	if err != nil {
 		return fmt.Errorf("getting user account information for @%s: %v", cleanedScreenName, err)
 	}
 	c.ownerAccount = ownerAccount
 
-	// get the starting bounds of this operation
-	var maxTweet, minTweet string
+	// set the bounds of this operation
+	q := tweetFieldsQuery()
+	q.Set("max_results", "100")
+	if !c.Retweets {
+		q.Set("exclude", "retweets")
+	}
 	if opt.Timeframe.SinceItemID != nil {
-		minTweet = *opt.Timeframe.SinceItemID
+		q.Set("since_id", *opt.Timeframe.SinceItemID)
 	}
-	if c.checkpoint.LastTweetID != "" {
-		// by default, start off at the last checkpoint
-		maxTweet = c.checkpoint.LastTweetID
-		if opt.Timeframe.UntilItemID != nil {
-			// if both a timeframe UntilItemID and a checkpoint are set,
-			// we will choose the one with a tweet ID that is higher,
-			// meaning more recent, to avoid potentially skipping
-			// a chunk of the timeline
-			maxTweet = maxTweetID(c.checkpoint.LastTweetID, *opt.Timeframe.UntilItemID)
-		}
+	if opt.Timeframe.UntilItemID != nil {
+		q.Set("until_id", *opt.Timeframe.UntilItemID)
+	}
+	if opt.Timeframe.Since != nil {
+		q.Set("start_time", opt.Timeframe.Since.UTC().Format(time.RFC3339))
+	}
+	if opt.Timeframe.Until != nil {
+		q.Set("end_time", opt.Timeframe.Until.UTC().Format(time.RFC3339))
 	}
 
 	for {
@@ -46,31 +50,64 @@ func (c *Client) getFromAPI(ctx context.Context, itemChan chan<- *timeliner.Item
 		case <-ctx.Done():
 			return nil
 		default:
-			tweets, err := c.nextPageOfTweetsFromAPI(maxTweet, minTweet)
+			// by default, start off at the last checkpoint
+			if c.checkpoint.PaginationToken != "" {
+				q.Set("pagination_token", c.checkpoint.PaginationToken)
+			}
+
+			var page apiResponse
+			err := c.apiGet(ctx, "/users/"+c.ownerAccount.id()+"/tweets", q, &page)
 			if err != nil {
 				return fmt.Errorf("getting next page of tweets: %v", err)
 			}
+			var tweets []apiTweet
+			if len(page.Data) > 0 {
+				err = json.Unmarshal(page.Data, &tweets)
+				if err != nil {
+					return fmt.Errorf("decoding tweets: %v", err)
+				}
+			}
 
-			// we are done when there are no more tweets
-			if len(tweets) == 0 {
-				return nil
+			// get the tweets in the threads of the tweets on this
+			// page, and those they quote, then assemble them all
+			// before processing any, so threads are complete; if
+			// replies by others are wanted, get the rest of the
+			// conversations too
+			set := newTweetSet()
+			set.add(tweets, page.Includes)
+			var replyIDs []string
+			if c.Replies {
+				replyIDs, err = c.searchConversations(ctx, set, tweets)
+				if err != nil {
+					return fmt.Errorf("searching conversations: %v", err)
+				}
+			}
+			err = c.lookUpReferencedTweets(ctx, set, tweets)
+			if err != nil {
+				return fmt.Errorf("looking up referenced tweets: %v", err)
+			}
+			assembled := make([]*tweet, 0, len(tweets)+len(replyIDs))
+			for _, at := range tweets {
+				assembled = append(assembled, set.tweet(at.ID))
+			}
+			for _, id := range replyIDs {
+				assembled = append(assembled, set.tweet(id))
 			}
 
-			for _, t := range tweets {
-				err = c.processTweetFromAPI(t, itemChan)
+			for _, t := range assembled {
+				err = c.processTweetFromAPI(*t, itemChan)
 				if err != nil {
 					return fmt.Errorf("processing tweet from API: %v", err)
 				}
 			}
 
-			// since max_id is inclusive, subtract 1 from the tweet ID
-			// https://developer.twitter.com/en/docs/tweets/timelines/guides/working-with-timelines
-			nextTweetID := tweets[len(tweets)-1].TweetID - 1
-			c.checkpoint.LastTweetID = strconv.FormatInt(int64(nextTweetID), 10)
-			c.checkpoint.save(ctx)
+			// we are done when there are no more pages
+			if page.Meta.NextToken == "" {
+				return nil
+			}
 
-			// decrease maxTweet to get the next page on next iteration
-			maxTweet = c.checkpoint.LastTweetID
+			c.checkpoint.PaginationToken = page.Meta.NextToken
+			c.checkpoint.save(ctx)
 		}
 	}
 }
@@ -84,7 +121,7 @@ func (c *Client) processTweetFromAPI(t tweet, itemChan chan<- *timeliner.ItemGra
 		return nil
 	}
 
-	ig, err := c.makeItemGraphFromTweet(t, "")
+	ig, err := c.makeItemGraphFromTweet(t, nil)
 	if err != nil {
 		return fmt.Errorf("processing tweet %s: %v", t.ID(), err)
 	}
@@ -97,119 +134,572 @@ func (c *Client) processTweetFromAPI(t tweet, itemChan chan<- *timeliner.ItemGra
 	return nil
 }
 
-// nextPageOfTweetsFromAPI returns the next page of tweets starting at maxTweet
-// and going for a full page or until minTweet, whichever comes first. Generally,
-// iterating over this function will involve decreasing maxTweet and leaving
-// minTweet the same, if set at all (maxTweet = "until", minTweet = "since").
-// Either or both can be empty strings, for no boundaries. This function returns
-// at least 0 tweets (signaling done, I think) or up to a full page of tweets.
-func (c *Client) nextPageOfTweetsFromAPI(maxTweet, minTweet string) ([]tweet, error) {
-	q := url.Values{
-		"user_id":         {c.ownerAccount.id()},
-		"count":           {"200"},
-		"tweet_mode":      {"extended"}, // https://developer.twitter.com/en/docs/tweets/tweet-updates
-		"exclude_replies": {"false"},    // always include replies in case it's a self-reply; we can filter all others
-		"include_rts":     {"false"},
+// lookUpReferencedTweets adds to set the tweets that are
+// referenced by the tweets on a page but were not included
+// with them: the tweets they quote or link to, and all the
+// tweets up their threads to the first tweet of each
+// conversation. The tweets are looked up in batches, one
+// level of each thread at a time.
+func (c *Client) lookUpReferencedTweets(ctx context.Context, set *tweetSet, page []apiTweet) error {
+	// only the tweets on the page are followed to the
+	// tweets they quote, so as not to crawl all of them
+	var ids []string
+	for _, at := range page {
+		ids = append(ids, at.quotedIDs()...)
+	}
+
+	for {
+		for _, at := range set.tweets {
+			ids = append(ids, at.threadIDs()...)
+		}
+		ids = set.unknown(ids)
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for len(ids) > 0 {
+			batch := ids
+			if len(batch) > maxLookupIDs {
+				batch = batch[:maxLookupIDs]
+			}
+			ids = ids[len(batch):]
+
+			q := tweetFieldsQuery()
+			q.Set("ids", strings.Join(batch, ","))
+			var resp apiResponse
+			err := c.apiGet(ctx, "/tweets", q, &resp)
+			if err != nil {
+				return err
+			}
+
+			// tweets that were deleted or are not visible
+			// are missing, which is okay; we skip them
+			var tweets []apiTweet
+			if len(resp.Data) > 0 {
+				err = json.Unmarshal(resp.Data, &tweets)
+				if err != nil {
+					return fmt.Errorf("decoding tweets: %v", err)
+				}
+			}
+			set.add(tweets, resp.Includes)
+			for _, id := range batch {
+				set.tried[id] = true
+			}
+		}
+	}
+}
+
+// searchConversations adds to set the tweets in the conversations
+// of the tweets on a page that were not searched before, such as
+// replies by others, and returns the IDs of those that were not on
+// the page. Only recent tweets can be searched (from the last 7
+// days, with most access levels), so older replies are not found.
+func (c *Client) searchConversations(ctx context.Context, set *tweetSet, page []apiTweet) ([]string, error) {
+	if c.searched == nil {
+		c.searched = make(map[string]bool)
 	}
-	if c.Retweets {
-		q.Set("include_rts", "true")
+	var convIDs []string
+	for _, at := range page {
+		if at.ConversationID != "" && !c.searched[at.ConversationID] {
+			convIDs = append(convIDs, at.ConversationID)
+			c.searched[at.ConversationID] = true
+		}
 	}
-	if maxTweet != "" {
-		q.Set("max_id", maxTweet)
+
+	onPage := make(map[string]bool)
+	for _, at := range page {
+		onPage[at.ID] = true
 	}
-	if minTweet != "" {
-		q.Set("since_id", minTweet)
+
+	var found []string
+	for len(convIDs) > 0 {
+		// search for as many conversations at once
+		// as fit in the longest query allowed
+		var terms []string
+		for len(convIDs) > 0 {
+			term := "conversation_id:" + convIDs[0]
+			if len(strings.Join(append(terms, term), " OR ")) > maxSearchQueryLen {
+				break
+			}
+			terms = append(terms, term)
+			convIDs = convIDs[1:]
+		}
+
+		q := tweetFieldsQuery()
+		q.Set("query", strings.Join(terms, " OR "))
+		q.Set("max_results", "100")
+		for {
+			var resp apiResponse
+			err := c.apiGet(ctx, "/tweets/search/recent", q, &resp)
+			if err != nil {
+				return nil, err
+			}
+			var tweets []apiTweet
+			if len(resp.Data) > 0 {
+				err = json.Unmarshal(resp.Data, &tweets)
+				if err != nil {
+					return nil, fmt.Errorf("decoding tweets: %v", err)
+				}
+			}
+			set.add(tweets, resp.Includes)
+			for _, at := range tweets {
+				if !onPage[at.ID] {
+					found = append(found, at.ID)
+					onPage[at.ID] = true
+				}
+			}
+			if resp.Meta.NextToken == "" {
+				break
+			}
+			q.Set("next_token", resp.Meta.NextToken)
+		}
 	}
-	u := "https://api.twitter.com/1.1/statuses/user_timeline.json?" + q.Encode()
 
-	resp, err := c.HTTPClient.Get(u)
+	return found, nil
+}
+
+// getAccountFromAPI gets the account information for screenName.
+func (c *Client) getAccountFromAPI(ctx context.Context, screenName string) (twitterAccount, error) {
+	var ta twitterAccount
+
+	q := url.Values{"user.fields": {"created_at,description,location,profile_image_url,url"}}
+	var resp struct {
+		Data *apiUser `json:"data"`
+	}
+	err := c.apiGet(ctx, "/users/by/username/"+url.PathEscape(screenName), q, &resp)
 	if err != nil {
-		return nil, fmt.Errorf("performing API request: %v", err)
+		return ta, err
+	}
+	if resp.Data == nil {
+		return ta, fmt.Errorf("user not found: %s", screenName)
 	}
-	defer resp.Body.Close()
 
-	// TODO: handle HTTP errors, esp. rate limiting, a lot better
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s: %s", u, resp.Status)
+	ta = resp.Data.account()
+	ta.Description = resp.Data.Description
+	ta.Location = resp.Data.Location
+	ta.URL = resp.Data.URL
+	ta.ProfileImageURLHTTPS = resp.Data.ProfileImageURL
+	ta.CreatedAt = resp.Data.CreatedAt
+
+	return ta, nil
+}
+
+// apiGet performs a GET request to the API endpoint with
+// the query string q, and decodes the response into v. If
+// the rate limit was exceeded, it waits until the limit is
+// reset and tries again, unless ctx is cancelled first.
+func (c *Client) apiGet(ctx context.Context, endpoint string, q url.Values, v interface{}) error {
+	u := apiBase + endpoint + "?" + q.Encode()
+
+	for {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return fmt.Errorf("making API request: %v", err)
+		}
+		resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("performing API request: %v", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			wait := rateLimitWait(resp.Header)
+			log.Printf("[INFO][%s/%s] Rate limit exceeded; waiting %s before trying again",
+				DataSourceID, c.acc.UserID, wait)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("HTTP error: %s: %s", u, resp.Status)
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(v)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+
+		return nil
 	}
+}
 
-	var tweets []tweet
-	err = json.NewDecoder(resp.Body).Decode(&tweets)
+// rateLimitWait returns how long to wait for the rate limit
+// to be reset, which is given by the x-rate-limit-reset
+// header of a response as the time in Unix seconds.
+func rateLimitWait(h http.Header) time.Duration {
+	reset, err := strconv.ParseInt(h.Get("x-rate-limit-reset"), 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body: %v", err)
+		return rateLimitWindow
+	}
+	wait := time.Until(time.Unix(reset, 0))
+	if wait < 0 {
+		return 0
 	}
+	return wait
+}
 
-	return tweets, nil
+// tweetFieldsQuery returns the query string parameters that get
+// tweets with all the fields and expansions needed for them.
+func tweetFieldsQuery() url.Values {
+	return url.Values{
+		"tweet.fields": {"created_at,author_id,conversation_id,in_reply_to_user_id," +
+			"referenced_tweets,entities,attachments,public_metrics,note_tweet"},
+		"expansions": {"author_id,in_reply_to_user_id,attachments.media_keys," +
+			"referenced_tweets.id,referenced_tweets.id.author_id"},
+		"media.fields": {"media_key,type,url,variants"},
+		"user.fields":  {"username,name"},
+	}
 }
 
-// getAccountFromAPI gets the account information for either
-// screenName, if set, or accountID, if set. Set only one;
-// leave the other argument empty string.
-func (c *Client) getAccountFromAPI(screenName, accountID string) (twitterAccount, error) {
-	var ta twitterAccount
+// tweetSet is a set of tweets from the API along with the users
+// and media included with them, from which tweets are assembled.
+type tweetSet struct {
+	tweets    map[string]apiTweet
+	users     map[string]apiUser
+	media     map[string]apiMedia
+	tried     map[string]bool   // IDs of tweets that were looked up
+	assembled map[string]*tweet // tweets that were assembled, by ID
+}
 
-	q := make(url.Values)
-	if screenName != "" {
-		q.Set("screen_name", screenName)
-	} else if accountID != "" {
-		q.Set("user_id", accountID)
+func newTweetSet() *tweetSet {
+	return &tweetSet{
+		tweets:    make(map[string]apiTweet),
+		users:     make(map[string]apiUser),
+		media:     make(map[string]apiMedia),
+		tried:     make(map[string]bool),
+		assembled: make(map[string]*tweet),
 	}
+}
 
-	u := "https://api.twitter.com/1.1/users/show.json?" + q.Encode()
+// add adds the tweets and what is included with them to the set.
+// Included tweets do not replace tweets that are already in the
+// set, since their media are not included.
+func (set *tweetSet) add(tweets []apiTweet, inc apiIncludes) {
+	for _, at := range tweets {
+		set.tweets[at.ID] = at
+	}
+	for _, at := range inc.Tweets {
+		if _, ok := set.tweets[at.ID]; !ok {
+			set.tweets[at.ID] = at
+		}
+	}
+	for _, u := range inc.Users {
+		set.users[u.ID] = u
+	}
+	for _, m := range inc.Media {
+		set.media[m.MediaKey] = m
+	}
+}
 
-	resp, err := c.HTTPClient.Get(u)
-	if err != nil {
-		return ta, fmt.Errorf("performing API request: %v", err)
+// unknown returns the IDs, without duplicates, of the
+// tweets that are neither in the set nor were looked up.
+func (set *tweetSet) unknown(ids []string) []string {
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if _, ok := set.tweets[id]; ok || set.tried[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unknown = append(unknown, id)
 	}
-	defer resp.Body.Close()
+	return unknown
+}
 
-	// TODO: handle HTTP errors, esp. rate limiting, a lot better
-	if resp.StatusCode != http.StatusOK {
-		return ta, fmt.Errorf("HTTP error: %s: %s", u, resp.Status)
+// tweet assembles the tweet with the given ID from the set, along
+// with the tweets it references, or returns nil if it isn't in
+// the set. The tweet is in the same form as tweets from archives.
+func (set *tweetSet) tweet(id string) *tweet {
+	if t, ok := set.assembled[id]; ok {
+		return t
+	}
+	at, ok := set.tweets[id]
+	if !ok {
+		return nil
 	}
+	t := new(tweet)
+	set.assembled[id] = t
 
-	err = json.NewDecoder(resp.Body).Decode(&ta)
-	if err != nil {
-		return ta, fmt.Errorf("reading response body: %v", err)
+	text, ents := at.Text, at.Entities
+	if at.NoteTweet != nil && at.NoteTweet.Text != "" {
+		// long tweets are truncated, and have their full text in a note
+		text, ents = at.NoteTweet.Text, at.NoteTweet.Entities
 	}
 
-	return ta, nil
+	tweetID, _ := strconv.ParseInt(at.ID, 10, 64)
+	t.TweetID = transInt(tweetID)
+	t.TweetIDStr = at.ID
+	t.FullText = text
+	t.createdAtParsed, _ = time.Parse(time.RFC3339, at.CreatedAt)
+	t.RetweetCount = transInt(at.PublicMetrics.RetweetCount)
+	t.FavoriteCount = transInt(at.PublicMetrics.LikeCount)
+	t.conversationID = at.ConversationID
+
+	author := set.users[at.AuthorID]
+	author.ID = at.AuthorID
+	t.User = &twitterUser{UserIDStr: author.ID, ScreenName: author.Username, Name: author.Name}
+	t.ownerAccount = author.account()
+
+	if at.InReplyToUserID != "" {
+		t.InReplyToUserIDStr = at.InReplyToUserID
+		t.InReplyToScreenName = set.users[at.InReplyToUserID].Username
+	}
+
+	// entity positions are counted in characters, but
+	// are used to slice the text, which is in bytes
+	offsets := byteOffsets(text)
+	indices := func(start, end int) []transInt {
+		if start < 0 || end < start || end >= len(offsets) {
+			return nil
+		}
+		return []transInt{transInt(offsets[start]), transInt(offsets[end])}
+	}
+
+	t.Entities = new(twitterEntities)
+	mediaLinks := make(map[string]apiURLEntity)
+	for _, u := range ents.URLs {
+		if u.MediaKey != "" {
+			mediaLinks[u.MediaKey] = u
+			continue
+		}
+		t.Entities.URLs = append(t.Entities.URLs, urlEntity{
+			URL:         u.URL,
+			ExpandedURL: u.ExpandedURL,
+			DisplayURL:  u.DisplayURL,
+			Indices:     indices(u.Start, u.End),
+		})
+	}
+	for _, m := range ents.Mentions {
+		t.Entities.UserMentions = append(t.Entities.UserMentions, userMentionEntity{
+			ScreenName: m.Username,
+			IDStr:      m.ID,
+			Indices:    indices(m.Start, m.End),
+		})
+	}
+
+	for _, key := range at.Attachments.MediaKeys {
+		am, ok := set.media[key]
+		if !ok {
+			continue
+		}
+		if t.ExtendedEntities == nil {
+			t.ExtendedEntities = new(extendedEntities)
+		}
+		t.ExtendedEntities.Media = append(t.ExtendedEntities.Media, am.mediaItem(mediaLinks[key], indices))
+	}
+
+	for _, ref := range at.ReferencedTweets {
+		switch ref.Type {
+		case "replied_to":
+			t.InReplyToStatusIDStr = ref.ID
+			if parent := set.tweet(ref.ID); parent != nil {
+				t.inReplyTo = parent
+				parent.hasReplies = true
+			}
+		case "retweeted":
+			t.RetweetedStatus = set.tweet(ref.ID)
+		}
+	}
+	for _, quotedID := range at.quotedIDs() {
+		if quoted := set.tweet(quotedID); quoted != nil {
+			t.quoted = append(t.quoted, quoted)
+		}
+	}
+
+	return t
+}
+
+// byteOffsets returns the byte offset of each character
+// in s, followed by the length of s.
+func byteOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+// apiResponse is a response from the API. Its data is
+// either a tweet, a list of tweets, or a user.
+type apiResponse struct {
+	Data     json.RawMessage `json:"data"`
+	Includes apiIncludes     `json:"includes"`
+	Meta     struct {
+		NextToken   string `json:"next_token"`
+		ResultCount int    `json:"result_count"`
+	} `json:"meta"`
 }
 
-func (c *Client) getTweetFromAPI(id string) (tweet, error) {
-	var t tweet
+type apiIncludes struct {
+	Tweets []apiTweet `json:"tweets"`
+	Users  []apiUser  `json:"users"`
+	Media  []apiMedia `json:"media"`
+}
 
-	q := url.Values{
-		"id":         {id},
-		"tweet_mode": {"extended"}, // https://developer.twitter.com/en/docs/tweets/tweet-updates
+type apiTweet struct {
+	ID               string `json:"id"`
+	Text             string `json:"text"`
+	AuthorID         string `json:"author_id"`
+	ConversationID   string `json:"conversation_id"`
+	CreatedAt        string `json:"created_at"`
+	InReplyToUserID  string `json:"in_reply_to_user_id"`
+	ReferencedTweets []struct {
+		Type string `json:"type"` // replied_to, quoted, or retweeted
+		ID   string `json:"id"`
+	} `json:"referenced_tweets"`
+	Attachments struct {
+		MediaKeys []string `json:"media_keys"`
+	} `json:"attachments"`
+	Entities      apiEntities `json:"entities"`
+	PublicMetrics struct {
+		RetweetCount int `json:"retweet_count"`
+		LikeCount    int `json:"like_count"`
+	} `json:"public_metrics"`
+	NoteTweet *struct {
+		Text     string      `json:"text"`
+		Entities apiEntities `json:"entities"`
+	} `json:"note_tweet"`
+}
+
+// threadIDs returns the IDs of the tweets above at in its
+// thread: the tweet it replies to, and the conversation's
+// first tweet.
+func (at apiTweet) threadIDs() []string {
+	var ids []string
+	for _, ref := range at.ReferencedTweets {
+		if ref.Type == "replied_to" {
+			ids = append(ids, ref.ID)
+		}
 	}
-	u := "https://api.twitter.com/1.1/statuses/show.json?" + q.Encode()
+	if at.ConversationID != "" && at.ConversationID != at.ID {
+		ids = append(ids, at.ConversationID)
+	}
+	return ids
+}
 
-	resp, err := c.HTTPClient.Get(u)
-	if err != nil {
-		return t, fmt.Errorf("performing API request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	switch resp.StatusCode {
-	case http.StatusNotFound:
-		// this is okay, because the tweet may simply have been deleted,
-		// and we skip empty tweets anyway
-		fallthrough
-	case http.StatusForbidden:
-		// this happens when the author's account is suspended
-		return t, nil
-	case http.StatusOK:
-		break
-	default:
-		// TODO: handle HTTP errors, esp. rate limiting, a lot better
-		return t, fmt.Errorf("HTTP error: %s: %s", u, resp.Status)
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&t)
-	if err != nil {
-		return t, fmt.Errorf("reading response body: %v", err)
+// quotedIDs returns the IDs of the tweets at quotes,
+// including those it only links to.
+func (at apiTweet) quotedIDs() []string {
+	var ids []string
+	seen := map[string]bool{at.ID: true}
+	for _, ref := range at.ReferencedTweets {
+		if ref.Type == "quoted" && !seen[ref.ID] {
+			ids = append(ids, ref.ID)
+			seen[ref.ID] = true
+		}
 	}
+	for _, u := range at.Entities.URLs {
+		if u.MediaKey != "" {
+			continue // links to attached media look like links to tweets
+		}
+		if id := getLinkedTweetID(u.ExpandedURL); id != "" && !seen[id] {
+			ids = append(ids, id)
+			seen[id] = true
+		}
+	}
+	return ids
+}
 
-	return t, nil
+type apiEntities struct {
+	URLs     []apiURLEntity `json:"urls"`
+	Mentions []struct {
+		Start    int    `json:"start"`
+		End      int    `json:"end"`
+		Username string `json:"username"`
+		ID       string `json:"id"`
+	} `json:"mentions"`
 }
+
+type apiURLEntity struct {
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	URL         string `json:"url"`
+	ExpandedURL string `json:"expanded_url"`
+	DisplayURL  string `json:"display_url"`
+	MediaKey    string `json:"media_key"` // set if the URL links to attached media
+}
+
+type apiUser struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Username        string `json:"username"`
+	CreatedAt       string `json:"created_at"`
+	Description     string `json:"description"`
+	Location        string `json:"location"`
+	ProfileImageURL string `json:"profile_image_url"`
+	URL             string `json:"url"`
+}
+
+func (u apiUser) account() twitterAccount {
+	return twitterAccount{
+		IDStr:      u.ID,
+		ScreenName: u.Username,
+		Name:       u.Name,
+	}
+}
+
+type apiMedia struct {
+	MediaKey string `json:"media_key"`
+	Type     string `json:"type"` // photo, video, or animated_gif
+	URL      string `json:"url"`  // only for photos
+	Variants []struct {
+		BitRate     int    `json:"bit_rate"`
+		ContentType string `json:"content_type"`
+		URL         string `json:"url"`
+	} `json:"variants"`
+}
+
+// mediaItem returns the media as a media item, with the
+// position of the link to it in the text of its tweet.
+func (am apiMedia) mediaItem(link apiURLEntity, indices func(start, end int) []transInt) *mediaItem {
+	// media keys are the media's ID prefixed
+	// with a number for its type and "_"
+	mediaID := am.MediaKey[strings.Index(am.MediaKey, "_")+1:]
+	id, _ := strconv.ParseInt(mediaID, 10, 64)
+
+	m := &mediaItem{
+		MediaID:       transInt(id),
+		MediaIDStr:    mediaID,
+		Type:          am.Type,
+		MediaURLHTTPS: am.URL,
+		URL:           link.URL,
+		ExpandedURL:   link.ExpandedURL,
+		DisplayURL:    link.DisplayURL,
+	}
+	if link.URL != "" {
+		m.Indices = indices(link.Start, link.End)
+	}
+	if len(am.Variants) > 0 {
+		m.VideoInfo = new(videoInfo)
+		for _, v := range am.Variants {
+			m.VideoInfo.Variants = append(m.VideoInfo.Variants, videoVariants{
+				Bitrate:     transInt(v.BitRate),
+				ContentType: v.ContentType,
+				URL:         v.URL,
+			})
+		}
+	}
+	return m
+}
+
+// apiBase is the base URL of the Twitter API v2.
+var apiBase = "https://api.twitter.com/2"
+
+const (
+	// maxLookupIDs is how many tweets can be looked up at once.
+	maxLookupIDs = 100
+
+	// maxSearchQueryLen is the longest search query
+	// allowed for recent tweets, in characters.
+	maxSearchQueryLen = 512
+
+	// rateLimitWindow is how long to wait when the rate limit
+	// was exceeded but the response doesn't say until when.
+	rateLimitWindow = 15 * time.Minute
+)
@@ -0,0 +1,287 @@
+package twitter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// TestGetFromAPIPaginates checks that all pages of the owner's
+// tweets are listed, and that listing resumes from the page in
+// the checkpoint.
+func TestGetFromAPIPaginates(t *testing.T) {
+	api := newTestAPI(t)
+	api.handle("/users/1/tweets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("pagination_token") {
+		case "":
+			fmt.Fprint(w, `{"data": [`+testTweet("12", "1", "")+`, `+testTweet("11", "1", "")+`],
+				"meta": {"next_token": "page2"}}`)
+		case "page2":
+			fmt.Fprint(w, `{"data": [`+testTweet("10", "1", "")+`], "meta": {}}`)
+		default:
+			t.Errorf("unexpected pagination token: %s", r.FormValue("pagination_token"))
+		}
+	})
+
+	igs, err := api.listItems(new(Client), timeliner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIDs(igs); !reflect.DeepEqual(ids, []string{"12", "11", "10"}) {
+		t.Errorf("expected tweets 12, 11, and 10, got %v", ids)
+	}
+
+	ckpt, err := timeliner.MarshalGob(checkpointInfo{PaginationToken: "page2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	igs, err = api.listItems(new(Client), timeliner.Options{Checkpoint: ckpt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIDs(igs); !reflect.DeepEqual(ids, []string{"10"}) {
+		t.Errorf("expected only tweet 10 after resuming, got %v", ids)
+	}
+}
+
+// TestGetFromAPIWaitsForRateLimit checks that a request that
+// exceeds the rate limit is tried again once it is reset.
+func TestGetFromAPIWaitsForRateLimit(t *testing.T) {
+	api := newTestAPI(t)
+	var requests int
+	api.handle("/users/1/tweets", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("x-rate-limit-reset", strconv.FormatInt(time.Now().Unix(), 10))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"data": [`+testTweet("10", "1", "")+`], "meta": {}}`)
+	})
+
+	igs, err := api.listItems(new(Client), timeliner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+	if ids := nodeIDs(igs); !reflect.DeepEqual(ids, []string{"10"}) {
+		t.Errorf("expected tweet 10, got %v", ids)
+	}
+
+	// when waiting, cancelling stops the listing without error
+	api.handle("/users/1/tweets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-rate-limit-reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	igs, err = api.listItemsContext(ctx, new(Client), timeliner.Options{})
+	if err != nil {
+		t.Errorf("expected no error when cancelled, got: %v", err)
+	}
+	if len(igs) != 0 {
+		t.Errorf("expected no tweets when cancelled, got %v", nodeIDs(igs))
+	}
+}
+
+// TestGetFromAPIExpansions checks that tweets are assembled from
+// the expansions of the response, that the tweets up the thread
+// which were not included are looked up, and that the replies by
+// others in the conversation are found.
+func TestGetFromAPIExpansions(t *testing.T) {
+	api := newTestAPI(t)
+	api.handle("/users/1/tweets", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"data": [{
+				"id": "20", "text": "@bob yes, see https://t.co/q https://t.co/m", "author_id": "1",
+				"conversation_id": "18", "created_at": "2021-01-02T15:04:05.000Z",
+				"in_reply_to_user_id": "2",
+				"referenced_tweets": [{"type": "replied_to", "id": "19"}, {"type": "quoted", "id": "30"}],
+				"attachments": {"media_keys": ["3_555"]},
+				"entities": {
+					"mentions": [{"start": 0, "end": 4, "username": "bob", "id": "2"}],
+					"urls": [
+						{"start": 14, "end": 27, "url": "https://t.co/q", "expanded_url": "https://twitter.com/carol/status/30"},
+						{"start": 28, "end": 41, "url": "https://t.co/m", "expanded_url": "https://twitter.com/owner/status/20/photo/1", "media_key": "3_555"}
+					]
+				}
+			}],
+			"includes": {
+				"tweets": [{"id": "19", "text": "tweet 19", "author_id": "2", "conversation_id": "18",
+					"created_at": "2021-01-02T14:04:05.000Z", "in_reply_to_user_id": "2",
+					"referenced_tweets": [{"type": "replied_to", "id": "18"}]}, `+testTweet("30", "3", "")+`],
+				"users": [
+					{"id": "1", "username": "owner", "name": "Owner"},
+					{"id": "2", "username": "bob", "name": "Bob"},
+					{"id": "3", "username": "carol", "name": "Carol"}
+				],
+				"media": [{"media_key": "3_555", "type": "photo", "url": "`+api.srv.URL+`/media/555.jpg"}]
+			},
+			"meta": {}
+		}`)
+	})
+	api.handle("/tweets", func(w http.ResponseWriter, r *http.Request) {
+		if ids := r.FormValue("ids"); ids != "18" {
+			t.Errorf("expected to look up tweet 18, got %s", ids)
+		}
+		fmt.Fprint(w, `{"data": [`+testTweet("18", "2", "18")+`],
+			"includes": {"users": [{"id": "2", "username": "bob", "name": "Bob"}]}}`)
+	})
+	api.handle("/tweets/search/recent", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.FormValue("query"); q != "conversation_id:18" {
+			t.Errorf("expected to search conversation 18, got %s", q)
+		}
+		reply := `{"id": "21", "text": "me too", "author_id": "3", "conversation_id": "18",
+			"created_at": "2021-01-02T16:04:05.000Z", "in_reply_to_user_id": "2",
+			"referenced_tweets": [{"type": "replied_to", "id": "19"}]}`
+		fmt.Fprint(w, `{"data": [`+reply+`],
+			"includes": {"users": [{"id": "3", "username": "carol", "name": "Carol"}]}, "meta": {}}`)
+	})
+	api.handle("/media/555.jpg:orig", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "jpeg")
+	})
+
+	igs, err := api.listItems(&Client{Replies: true}, timeliner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIDs(igs); !reflect.DeepEqual(ids, []string{"20", "21"}) {
+		t.Fatalf("expected tweet 20 and the reply 21 by another, got %v", ids)
+	}
+
+	tw := igs[0].Node.(*tweet)
+	if !tw.hasExactlyOneMediaItem() || tw.ExtendedEntities.Media[0].MediaIDStr != "555" {
+		t.Errorf("expected media 555 to be attached, got %+v", tw.ExtendedEntities)
+	}
+	if rc, err := tw.DataFileReader(); err != nil || rc == nil {
+		t.Errorf("expected media to be downloaded, got %v, %v", rc, err)
+	} else {
+		rc.Close()
+	}
+	if text := tw.text(); strings.Contains(text, "https://t.co/m") {
+		t.Errorf("expected link to media to be removed from text, got %q", text)
+	}
+
+	// the thread is 18 <- 19 <- 20, and 20 quotes 30
+	parent := edgeTo(t, igs[0], "19", timeliner.RelReplyTo)
+	if id, name := parent.Node.Owner(); id == nil || *id != "2" || name == nil || *name != "bob" {
+		t.Errorf("expected tweet 19 to be by bob, got %v, %v", id, name)
+	}
+	edgeTo(t, parent, "18", timeliner.RelReplyTo)
+	edgeTo(t, igs[0], "30", timeliner.RelQuotes)
+	edgeTo(t, igs[1], "19", timeliner.RelReplyTo)
+
+	for i, ig := range igs {
+		if len(ig.Collections) != 1 || ig.Collections[0].OriginalID != "conversation_18" ||
+			ig.Collections[0].Items[0].Position != 2 {
+			t.Errorf("expected tweet %s at position 2 in conversation 18, got %+v",
+				ig.Node.ID(), igs[i].Collections)
+		}
+	}
+}
+
+// testAPI is a fake of the API, which serves the owner's account
+// and whatever else is handled.
+type testAPI struct {
+	srv      *httptest.Server
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	api := &testAPI{handlers: make(map[string]http.HandlerFunc)}
+	api.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		h, ok := api.handlers[r.URL.Path]
+		api.mu.Unlock()
+		if !ok {
+			t.Errorf("unexpected request: %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(api.srv.Close)
+
+	api.handle("/users/by/username/owner", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"id": "1", "username": "owner", "name": "Owner"}}`)
+	})
+
+	oldBase := apiBase
+	apiBase = api.srv.URL
+	t.Cleanup(func() { apiBase = oldBase })
+
+	return api
+}
+
+func (api *testAPI) handle(path string, h http.HandlerFunc) {
+	api.mu.Lock()
+	api.handlers[path] = h
+	api.mu.Unlock()
+}
+
+func (api *testAPI) listItems(c *Client, opt timeliner.Options) ([]*timeliner.ItemGraph, error) {
+	return api.listItemsContext(context.Background(), c, opt)
+}
+
+// listItemsContext lists the items from the API with c as the
+// owner's client, outside of a timeline.
+func (api *testAPI) listItemsContext(ctx context.Context, c *Client, opt timeliner.Options) ([]*timeliner.ItemGraph, error) {
+	c.HTTPClient = api.srv.Client()
+	c.acc = timeliner.Account{UserID: "owner"}
+
+	ch := make(chan *timeliner.ItemGraph)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ListItems(ctx, ch, opt)
+	}()
+	var igs []*timeliner.ItemGraph
+	for ig := range ch {
+		igs = append(igs, ig)
+	}
+	return igs, <-done
+}
+
+// testTweet returns a tweet as the API returns it, by the
+// user with the given ID, in the given conversation.
+func testTweet(id, authorID, conversationID string) string {
+	return fmt.Sprintf(`{"id": %q, "text": "tweet %s", "author_id": %q,
+		"conversation_id": %q, "created_at": "2021-01-02T15:04:05.000Z"}`,
+		id, id, authorID, conversationID)
+}
+
+func nodeIDs(igs []*timeliner.ItemGraph) []string {
+	var ids []string
+	for _, ig := range igs {
+		ids = append(ids, ig.Node.ID())
+	}
+	return ids
+}
+
+// edgeTo returns the graph of the item with the given
+// ID that ig is related to by rel.
+func edgeTo(t *testing.T, ig *timeliner.ItemGraph, id string, rel timeliner.Relation) *timeliner.ItemGraph {
+	t.Helper()
+	for edge, rels := range ig.Edges {
+		if edge.Node.ID() == id {
+			if len(rels) != 1 || rels[0] != rel {
+				t.Errorf("expected tweet %s to be related to %s as %s, got %v",
+					ig.Node.ID(), id, rel.Label, rels)
+			}
+			return edge
+		}
+	}
+	t.Fatalf("expected tweet %s to be related to %s", ig.Node.ID(), id)
+	return nil
+}
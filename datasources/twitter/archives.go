@@ -1,81 +1,108 @@
 package twitter
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
 
-	"github.com/mholt/archiver"
 	"github.com/mholt/timeliner"
 )
 
-func (c *Client) getFromArchiveFile(itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+func (c *Client) getFromArchiveFile(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	arch, err := openArchive(opt.Filename)
+	if err != nil {
+		return err
+	}
+	defer timeliner.AfterItems(ctx, func() { arch.zr.Close() })
+
 	// load the user's account ID
-	var err error
-	c.ownerAccount, err = c.getOwnerAccountFromArchive(opt.Filename)
+	c.ownerAccount, err = c.getOwnerAccountFromArchive(arch)
 	if err != nil {
 		return fmt.Errorf("unable to get user account ID: %v", err)
 	}
 
 	// first pass - add tweets to timeline
-	err = c.processArchive(opt.Filename, itemChan, c.makeItemGraphFromTweet)
+	err = c.processArchive(ctx, arch, itemChan, c.makeItemGraphFromTweet)
 	if err != nil {
 		return fmt.Errorf("processing tweets: %v", err)
 	}
 
 	// second pass - add tweet relationships to timeline
-	err = c.processArchive(opt.Filename, itemChan, c.processReplyRelationFromArchive)
+	err = c.processArchive(ctx, arch, itemChan, c.processReplyRelationFromArchive)
 	if err != nil {
 		return fmt.Errorf("processing tweets: %v", err)
 	}
 
-	return nil
-}
-
-func (c *Client) processArchive(archiveFilename string, itemChan chan<- *timeliner.ItemGraph, processFunc archiveProcessFn) error {
-	err := archiver.Walk(archiveFilename, func(f archiver.File) error {
-		defer f.Close()
-		if f.Name() != "tweet.js" {
-			return nil
-		}
-
-		// consume non-JSON preface (JavaScript variable definition)
-		err := stripPreface(f, tweetFilePreface)
-		if err != nil {
-			return fmt.Errorf("reading tweet file preface: %v", err)
-		}
+	err = c.processDirectMessagesFromArchive(ctx, arch, itemChan)
+	if err != nil {
+		return fmt.Errorf("processing direct messages: %v", err)
+	}
 
-		err = c.processTweetsFromArchive(itemChan, f, archiveFilename, processFunc)
-		if err != nil {
-			return fmt.Errorf("processing tweet file: %v", err)
-		}
+	err = c.processLikesFromArchive(ctx, arch, itemChan)
+	if err != nil {
+		return fmt.Errorf("processing likes: %v", err)
+	}
 
-		return archiver.ErrStopWalk
-	})
+	err = c.processProfileFromArchive(ctx, arch, itemChan)
 	if err != nil {
-		return fmt.Errorf("walking archive file %s: %v", archiveFilename, err)
+		return fmt.Errorf("processing profile: %v", err)
 	}
 
 	return nil
 }
 
-func (c *Client) processTweetsFromArchive(itemChan chan<- *timeliner.ItemGraph, f io.Reader,
-	archiveFilename string, processFunc archiveProcessFn) error {
-
-	dec := json.NewDecoder(f)
-
-	// read array opening bracket '['
-	_, err := dec.Token()
-	if err != nil {
-		return fmt.Errorf("decoding opening token: %v", err)
+func (c *Client) processArchive(ctx context.Context, arch *archive, itemChan chan<- *timeliner.ItemGraph, processFunc archiveProcessFn) error {
+	for _, zf := range arch.dataFiles("tweet", "tweets") {
+		err := arch.readDataFile(zf, func(dec *json.Decoder) error {
+			return c.processTweetsFromArchive(ctx, itemChan, dec, arch, processFunc)
+		})
+		if err != nil {
+			return fmt.Errorf("processing tweet file %s: %v", zf.Name, err)
+		}
 	}
+	return nil
+}
+
+func (c *Client) processTweetsFromArchive(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, dec *json.Decoder,
+	arch *archive, processFunc archiveProcessFn) error {
 
 	for dec.More() {
-		var t tweet
-		err := dec.Decode(&t)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// newer archives wrap each tweet in an object
+		// with a "tweet" field; older ones do not
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err != nil {
+			return fmt.Errorf("decoding tweet element: %v", err)
+		}
+		var wrapped struct {
+			Tweet *tweet `json:"tweet"`
+		}
+		err = json.Unmarshal(raw, &wrapped)
 		if err != nil {
 			return fmt.Errorf("decoding tweet element: %v", err)
 		}
+		var t tweet
+		if wrapped.Tweet != nil {
+			t = *wrapped.Tweet
+		} else {
+			err = json.Unmarshal(raw, &t)
+			if err != nil {
+				return fmt.Errorf("decoding tweet element: %v", err)
+			}
+		}
 
 		skip, err := c.prepareTweet(&t, "archive")
 		if err != nil {
@@ -85,7 +112,7 @@ func (c *Client) processTweetsFromArchive(itemChan chan<- *timeliner.ItemGraph,
 			continue
 		}
 
-		ig, err := processFunc(t, archiveFilename)
+		ig, err := processFunc(t, arch)
 		if err != nil {
 			return fmt.Errorf("processing tweet: %v", err)
 		}
@@ -99,13 +126,14 @@ func (c *Client) processTweetsFromArchive(itemChan chan<- *timeliner.ItemGraph,
 	return nil
 }
 
-func (c *Client) processReplyRelationFromArchive(t tweet, archiveFilename string) (*timeliner.ItemGraph, error) {
+func (c *Client) processReplyRelationFromArchive(t tweet, arch *archive) (*timeliner.ItemGraph, error) {
 	if t.InReplyToStatusIDStr == "" {
 		// current tweet is not a reply, so no relationship to add
 		return nil, nil
 	}
 	if t.InReplyToUserIDStr != "" && t.InReplyToUserIDStr != c.ownerAccount.id() {
-		// from archives, we only support storing replies to self... (TODO)
+		// replies to others are related to stubs of the
+		// tweets they reply to along with the tweet itself
 		return nil, nil
 	}
 
@@ -122,51 +150,388 @@ func (c *Client) processReplyRelationFromArchive(t tweet, archiveFilename string
 	return ig, nil
 }
 
-func (c *Client) getOwnerAccountFromArchive(filename string) (twitterAccount, error) {
-	var ta twitterAccount
-	err := archiver.Walk(filename, func(f archiver.File) error {
-		defer f.Close()
-		if f.Name() != "account.js" {
+// processDirectMessagesFromArchive adds the direct messages
+// in the archive, including those in group conversations.
+// Each conversation is a collection.
+func (c *Client) processDirectMessagesFromArchive(ctx context.Context, arch *archive, itemChan chan<- *timeliner.ItemGraph) error {
+	files := arch.dataFiles("direct-message", "direct-messages",
+		"direct-message-group", "direct-messages-group")
+
+	for _, zf := range files {
+		err := arch.readDataFile(zf, func(dec *json.Decoder) error {
+			for dec.More() {
+				if ctx.Err() != nil {
+					return nil
+				}
+				var entry dmConversationEntry
+				err := dec.Decode(&entry)
+				if err != nil {
+					return fmt.Errorf("decoding conversation: %v", err)
+				}
+				c.processConversation(ctx, entry.DMConversation, arch, itemChan)
+			}
 			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("processing direct message file %s: %v", zf.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) processConversation(ctx context.Context, conv dmConversation, arch *archive, itemChan chan<- *timeliner.ItemGraph) {
+	// messages are listed newest first
+	for i, entry := range conv.Messages {
+		if ctx.Err() != nil {
+			return
 		}
+		dm := entry.MessageCreate
+		if dm == nil {
+			// other events, like people joining a group
+			continue
+		}
+		dm.conversationID = conv.ConversationID
+		dm.ownerAccount = c.ownerAccount
 
-		// consume non-JSON preface (JavaScript variable definition)
-		err := stripPreface(f, accountFilePreface)
+		err := dm.prepare()
 		if err != nil {
-			return fmt.Errorf("reading account file preface: %v", err)
+			log.Printf("[ERROR][%s/%s] Preparing direct message %s: %v",
+				DataSourceID, c.acc.UserID, dm.MessageID, err)
+			continue
+		}
+
+		ig := timeliner.NewItemGraph(dm)
+		if dm.RecipientID != "" && dm.RecipientID != c.ownerAccount.id() {
+			ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+				UserID:   dm.RecipientID,
+				Relation: timeliner.RelSentTo,
+			})
+		}
+
+		for _, mediaURL := range dm.MediaURLs {
+			name := dm.MessageID + "-" + path.Base(mediaURL)
+			zf := arch.mediaFile(name, "direct_messages_media", "direct_message_media",
+				"direct_messages_group_media", "direct_message_group_media")
+			if zf == nil {
+				log.Printf("[ERROR][%s/%s] Direct message media not in archive: %s",
+					DataSourceID, c.acc.UserID, name)
+				continue
+			}
+			ownerID, ownerName := dm.Owner()
+			ig.Add(&archivedMedia{
+				id:        name,
+				zipFile:   zf,
+				timestamp: dm.createdAtParsed,
+				ownerID:   ownerID,
+				ownerName: ownerName,
+			}, timeliner.RelAttached)
 		}
 
-		var accFile twitterAccountFile
-		err = json.NewDecoder(f).Decode(&accFile)
+		ig.Collections = append(ig.Collections, timeliner.Collection{
+			OriginalID: "dm_" + conv.ConversationID,
+			Items: []timeliner.CollectionItem{
+				{Item: dm, Position: len(conv.Messages) - 1 - i},
+			},
+		})
+
+		itemChan <- ig
+	}
+}
+
+// processLikesFromArchive adds the tweets the account owner
+// has liked, related to the owner.
+func (c *Client) processLikesFromArchive(ctx context.Context, arch *archive, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, zf := range arch.dataFiles("like") {
+		err := arch.readDataFile(zf, func(dec *json.Decoder) error {
+			for dec.More() {
+				if ctx.Err() != nil {
+					return nil
+				}
+				var entry struct {
+					Like likedTweet `json:"like"`
+				}
+				err := dec.Decode(&entry)
+				if err != nil {
+					return fmt.Errorf("decoding like: %v", err)
+				}
+				if entry.Like.TweetID == "" {
+					continue
+				}
+
+				lt := entry.Like
+				ig := timeliner.NewItemGraph(&lt)
+				ig.Persons = append(ig.Persons, timeliner.PersonRelation{
+					UserID:     c.ownerAccount.id(),
+					Name:       c.ownerAccount.screenName(),
+					FromPerson: true,
+					Relation:   timeliner.RelLikes,
+				})
+				itemChan <- ig
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("decoding account file: %v", err)
+			return fmt.Errorf("processing like file %s: %v", zf.Name, err)
 		}
-		if len(accFile) == 0 {
-			return fmt.Errorf("account file was empty")
+	}
+	return nil
+}
+
+// processProfileFromArchive adds the account owner's
+// profile picture and header image.
+func (c *Client) processProfileFromArchive(ctx context.Context, arch *archive, itemChan chan<- *timeliner.ItemGraph) error {
+	for _, zf := range arch.dataFiles("profile") {
+		err := arch.readDataFile(zf, func(dec *json.Decoder) error {
+			for dec.More() {
+				var entry struct {
+					Profile struct {
+						AvatarMediaURL string `json:"avatarMediaUrl"`
+						HeaderMediaURL string `json:"headerMediaUrl"`
+					} `json:"profile"`
+				}
+				err := dec.Decode(&entry)
+				if err != nil {
+					return fmt.Errorf("decoding profile: %v", err)
+				}
+
+				for _, mediaURL := range []string{entry.Profile.AvatarMediaURL, entry.Profile.HeaderMediaURL} {
+					if ctx.Err() != nil {
+						return nil
+					}
+					if mediaURL == "" {
+						continue
+					}
+					c.processProfileMedia(mediaURL, arch, itemChan)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("processing profile file %s: %v", zf.Name, err)
 		}
+	}
+	return nil
+}
+
+func (c *Client) processProfileMedia(mediaURL string, arch *archive, itemChan chan<- *timeliner.ItemGraph) {
+	// header images have no extension in their URL,
+	// but do in the archive
+	name := c.ownerAccount.id() + "-" + path.Base(mediaURL)
+	zf := arch.mediaFile(name, "profile_media")
+	if zf == nil {
+		zf = arch.mediaFileWithPrefix(name+".", "profile_media")
+	}
+	if zf == nil {
+		log.Printf("[ERROR][%s/%s] Profile media not in archive: %s",
+			DataSourceID, c.acc.UserID, name)
+		return
+	}
+
+	ownerID, ownerName := c.ownerAccount.id(), c.ownerAccount.screenName()
+	itemChan <- timeliner.NewItemGraph(&archivedMedia{
+		id:        "profile_" + name,
+		zipFile:   zf,
+		timestamp: zf.Modified,
+		ownerID:   &ownerID,
+		ownerName: &ownerName,
+	})
+}
+
+// replyToStub returns a stub of the tweet that t replies to.
+func (t *tweet) replyToStub() *tweetStub {
+	stub := &tweetStub{
+		tweetID:    t.InReplyToStatusIDStr,
+		userID:     t.InReplyToUserIDStr,
+		screenName: t.InReplyToScreenName,
+	}
+	if stub.screenName != "" {
+		stub.url = fmt.Sprintf("https://twitter.com/%s/status/%s", stub.screenName, stub.tweetID)
+	}
+	return stub
+}
 
-		ta = accFile[0].Account
+// quotedTweetStub returns a stub of the tweet at linkURL, which
+// t quotes, or nil if linkURL is not a link to another tweet. The
+// author's user ID is known only if the author is the owner or is
+// mentioned in t.
+func (t *tweet) quotedTweetStub(linkURL string) *tweetStub {
+	tweetID := getLinkedTweetID(linkURL)
+	if tweetID == "" || tweetID == t.ID() {
+		return nil
+	}
+	u, err := url.Parse(linkURL)
+	if err != nil {
+		return nil
+	}
+	stub := &tweetStub{
+		tweetID:    tweetID,
+		screenName: strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[0],
+		url:        linkURL,
+	}
+	if strings.EqualFold(stub.screenName, t.ownerAccount.screenName()) {
+		stub.userID = t.ownerAccount.id()
+	} else if t.Entities != nil {
+		for _, mention := range t.Entities.UserMentions {
+			if strings.EqualFold(stub.screenName, mention.ScreenName) {
+				stub.userID = mention.IDStr
+				break
+			}
+		}
+	}
+	return stub
+}
 
-		return archiver.ErrStopWalk
+func (c *Client) getOwnerAccountFromArchive(arch *archive) (twitterAccount, error) {
+	var ta twitterAccount
+	files := arch.dataFiles("account")
+	if len(files) == 0 {
+		return ta, fmt.Errorf("no account file in archive")
+	}
+	err := arch.readDataFile(files[0], func(dec *json.Decoder) error {
+		if !dec.More() {
+			return fmt.Errorf("account file was empty")
+		}
+		var entry struct {
+			Account twitterAccount `json:"account"`
+		}
+		err := dec.Decode(&entry)
+		if err != nil {
+			return fmt.Errorf("decoding account file: %v", err)
+		}
+		ta = entry.Account
+		return nil
 	})
 	return ta, err
 }
 
-func stripPreface(f io.Reader, preface string) error {
-	buf := make([]byte, len(preface))
-	_, err := io.ReadFull(f, buf)
+// archive is a Twitter archive file, with its files
+// indexed by path so they can be found without
+// reading through the whole archive each time.
+type archive struct {
+	zr    *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func openArchive(filename string) (*archive, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening archive file %s: %v", filename, err)
+	}
+	arch := &archive{zr: zr, files: make(map[string]*zip.File)}
+	for _, zf := range zr.File {
+		arch.files[zf.Name] = zf
+	}
+	return arch, nil
+}
+
+// dataFiles returns the data files in the archive that hold
+// the kinds of data given, such as "tweet" for tweet.js. Newer
+// archives keep their data files in a data folder, and split
+// large ones into parts, like tweets-part1.js, which are all
+// returned.
+func (arch *archive) dataFiles(kinds ...string) []*zip.File {
+	var files []*zip.File
+	for _, zf := range arch.zr.File {
+		dir, name := path.Split(zf.Name)
+		if dir != "" && dir != "data/" {
+			continue
+		}
+		match := dataFileRegex.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		for _, kind := range kinds {
+			if match[1] == kind {
+				files = append(files, zf)
+				break
+			}
+		}
+	}
+	return files
+}
+
+// readDataFile opens the data file zf, consumes its preface,
+// and calls readFn to read the elements of the array in it.
+func (arch *archive) readDataFile(zf *zip.File, readFn func(*json.Decoder) error) error {
+	f, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	// consume non-JSON preface (JavaScript variable definition)
+	err = stripPreface(br)
+	if err != nil {
+		return fmt.Errorf("reading file preface: %v", err)
+	}
+
+	dec := json.NewDecoder(br)
+
+	// read array opening bracket '['
+	_, err = dec.Token()
+	if err != nil {
+		return fmt.Errorf("decoding opening token: %v", err)
+	}
+
+	return readFn(dec)
+}
+
+// mediaFile returns the file named name in one of the given
+// media folders, which may be in the data folder, or nil if
+// there is no such file.
+func (arch *archive) mediaFile(name string, folders ...string) *zip.File {
+	for _, folder := range folders {
+		for _, dir := range []string{folder, path.Join("data", folder)} {
+			if zf, ok := arch.files[path.Join(dir, name)]; ok {
+				return zf
+			}
+		}
+	}
+	return nil
+}
+
+// mediaFileWithPrefix is like mediaFile, but for a file
+// whose name starts with prefix.
+func (arch *archive) mediaFileWithPrefix(prefix string, folders ...string) *zip.File {
+	for _, folder := range folders {
+		for _, dir := range []string{folder, path.Join("data", folder)} {
+			for _, zf := range arch.zr.File {
+				if strings.HasPrefix(zf.Name, path.Join(dir, prefix)) {
+					return zf
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stripPreface consumes the variable definition at the start of
+// a data file, such as "window.YTD.tweets.part0 = ", which is
+// intended for use with JavaScript but is of no use to us and
+// would break the JSON parser.
+func stripPreface(br *bufio.Reader) error {
+	start, err := br.Peek(1)
+	if err != nil {
+		return err
+	}
+	if bytes.ContainsAny(start, "[{") {
+		return nil // no preface
+	}
+	_, err = br.ReadString('=')
+	if err == io.EOF {
+		return fmt.Errorf("no variable definition found")
+	}
 	return err
 }
 
 // archiveProcessFn is a function that processes a
 // tweet from a Twitter export archive and returns
 // an ItemGraph created from t.
-type archiveProcessFn func(t tweet, archiveFilename string) (*timeliner.ItemGraph, error)
-
-// Variable definitions that are intended for
-// use with JavaScript but which are of no use
-// to us and would break the JSON parser.
-const (
-	tweetFilePreface   = "window.YTD.tweet.part0 ="
-	accountFilePreface = "window.YTD.account.part0 ="
-)
+type archiveProcessFn func(t tweet, arch *archive) (*timeliner.ItemGraph, error)
+
+// dataFileRegex matches the name of a data file, where
+// the first submatch is the kind of data it holds.
+var dataFileRegex = regexp.MustCompile(`^([a-z-]+?)(?:-part[0-9]+)?\.js$`)
@@ -1,12 +1,15 @@
 package twitter
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -49,6 +52,13 @@ type tweet struct {
 	createdAtParsed time.Time
 	ownerAccount    twitterAccount
 	source          string // "api|archive"
+
+	// from the API: the thread the tweet is in, and
+	// the tweets it replies to and quotes
+	conversationID string
+	inReplyTo      *tweet
+	quoted         []*tweet
+	hasReplies     bool
 }
 
 func (t *tweet) ID() string {
@@ -144,6 +154,16 @@ func (t *tweet) hasExactlyOneMediaItem() bool {
 	return t.ExtendedEntities != nil && len(t.ExtendedEntities.Media) == 1
 }
 
+// threadDepth returns how many tweets are known to be
+// above t in its thread.
+func (t *tweet) threadDepth() int {
+	var depth int
+	for parent := t.inReplyTo; parent != nil; parent = parent.inReplyTo {
+		depth++
+	}
+	return depth
+}
+
 func (t *tweet) isEmpty() bool {
 	return strings.TrimSpace(t.text()) == "" &&
 		(t.ExtendedEntities == nil || len(t.ExtendedEntities.Media) == 0)
@@ -299,7 +319,8 @@ type mediaItem struct {
 	VideoInfo           *videoInfo           `json:"video_info,omitempty"`
 
 	parent     *tweet
-	readCloser io.ReadCloser // access to the media contents
+	readCloser io.ReadCloser // access to the media contents (API)
+	zipFile    *zip.File     // the media file in the archive (archive)
 }
 
 func (m *mediaItem) ID() string {
@@ -350,6 +371,12 @@ func (m *mediaItem) DataFileName() *string {
 }
 
 func (m *mediaItem) DataFileReader() (io.ReadCloser, error) {
+	if m.zipFile != nil {
+		return m.zipFile.Open()
+	}
+	if m.parent.source == "archive" {
+		return nil, nil // not all media is included in archives
+	}
 	if m.readCloser == nil {
 		return nil, fmt.Errorf("missing data file reader; this is probably a bug: %+v -- video info (if any): %+v", m, m.VideoInfo)
 	}
@@ -506,10 +533,6 @@ type twitterUser struct {
 	Verified                       bool             `json:"verified"`
 }
 
-type twitterAccountFile []struct {
-	Account twitterAccount `json:"account"`
-}
-
 type twitterAccount struct {
 	// fields from export archive file: account.js
 	PhoneNumber        string `json:"phoneNumber"`
@@ -626,3 +649,326 @@ func (tf *transFloat) UnmarshalJSON(b []byte) error {
 	*tf = transFloat(f)
 	return nil
 }
+
+type dmConversationEntry struct {
+	DMConversation dmConversation `json:"dmConversation"`
+}
+
+type dmConversation struct {
+	ConversationID string `json:"conversationId"`
+	Messages       []struct {
+		MessageCreate *directMessage `json:"messageCreate"`
+	} `json:"messages"`
+}
+
+type directMessage struct {
+	MessageID   string   `json:"id"`
+	SenderID    string   `json:"senderId"`
+	RecipientID string   `json:"recipientId"` // empty in group conversations
+	Text        string   `json:"text"`
+	MediaURLs   []string `json:"mediaUrls"`
+	URLs        []struct {
+		URL      string `json:"url"`
+		Expanded string `json:"expanded"`
+	} `json:"urls"`
+	CreatedAt string `json:"createdAt"`
+
+	conversationID  string
+	createdAtParsed time.Time
+	ownerAccount    twitterAccount
+}
+
+// prepare parses the message's time string
+// into an actual time value.
+func (dm *directMessage) prepare() error {
+	var err error
+	dm.createdAtParsed, err = time.Parse(time.RFC3339, dm.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("parsing createdAt time: %v", err)
+	}
+	return nil
+}
+
+func (dm *directMessage) ID() string {
+	return dm.MessageID
+}
+
+func (dm *directMessage) Timestamp() time.Time {
+	return dm.createdAtParsed
+}
+
+func (dm *directMessage) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+func (dm *directMessage) Owner() (id *string, name *string) {
+	if dm.SenderID == dm.ownerAccount.id() {
+		idStr, nameStr := dm.ownerAccount.id(), dm.ownerAccount.screenName()
+		return &idStr, &nameStr
+	}
+	idStr := dm.SenderID
+	return &idStr, nil
+}
+
+// DataText returns the text of the message with any
+// shortened URLs replaced with their expanded form.
+func (dm *directMessage) DataText() (*string, error) {
+	txt := dm.Text
+	for _, u := range dm.URLs {
+		if u.URL != "" && u.Expanded != "" {
+			txt = strings.Replace(txt, u.URL, u.Expanded, 1)
+		}
+	}
+	txt = strings.TrimSpace(txt)
+	if txt == "" {
+		return nil, nil
+	}
+	return &txt, nil
+}
+
+func (dm *directMessage) DataFileName() *string {
+	return nil
+}
+
+func (dm *directMessage) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (dm *directMessage) DataFileHash() []byte {
+	return nil
+}
+
+func (dm *directMessage) DataFileMIMEType() *string {
+	return nil
+}
+
+func (dm *directMessage) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (dm *directMessage) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// likedTweet is a tweet the account owner liked, as listed in
+// an archive, which has only its ID, text, and link.
+type likedTweet struct {
+	TweetID     string `json:"tweetId"`
+	FullText    string `json:"fullText"`
+	ExpandedURL string `json:"expandedUrl"`
+}
+
+func (lt *likedTweet) ID() string {
+	return lt.TweetID
+}
+
+// Timestamp returns the time the tweet was posted, which is
+// encoded in its ID for tweets posted since November 2010.
+func (lt *likedTweet) Timestamp() time.Time {
+	return tweetIDTime(lt.TweetID)
+}
+
+func (lt *likedTweet) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+// Owner returns nil, since liked tweets are not listed
+// with their authors.
+func (lt *likedTweet) Owner() (id *string, name *string) {
+	return nil, nil
+}
+
+func (lt *likedTweet) DataText() (*string, error) {
+	if lt.FullText == "" {
+		return nil, nil
+	}
+	return &lt.FullText, nil
+}
+
+func (lt *likedTweet) DataFileName() *string {
+	return nil
+}
+
+func (lt *likedTweet) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (lt *likedTweet) DataFileHash() []byte {
+	return nil
+}
+
+func (lt *likedTweet) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata marks the liked tweet as a placeholder, since
+// it lacks its author and time, so that it does not replace
+// the full tweet, such as when the owner liked their own.
+func (lt *likedTweet) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Link: lt.ExpandedURL, Placeholder: true}, nil
+}
+
+func (lt *likedTweet) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// tweetStub stands in for a tweet that is only known by
+// reference from a tweet in an archive, like the tweet it
+// replies to or quotes, until the full tweet is obtained.
+type tweetStub struct {
+	tweetID    string
+	userID     string // empty if unknown
+	screenName string
+	url        string
+}
+
+func (ts *tweetStub) ID() string {
+	return ts.tweetID
+}
+
+func (ts *tweetStub) Timestamp() time.Time {
+	return tweetIDTime(ts.tweetID)
+}
+
+func (ts *tweetStub) Class() timeliner.ItemClass {
+	return timeliner.ClassPost
+}
+
+// Owner returns the author of the tweet. If only their screen
+// name is known, it stands in for their user ID, prefixed with
+// "@" so that it can't be mistaken for a numeric user ID.
+func (ts *tweetStub) Owner() (id *string, name *string) {
+	if ts.screenName != "" {
+		name = &ts.screenName
+	}
+	switch {
+	case ts.userID != "":
+		id = &ts.userID
+	case ts.screenName != "":
+		handle := "@" + ts.screenName
+		id = &handle
+	}
+	return
+}
+
+func (ts *tweetStub) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (ts *tweetStub) DataFileName() *string {
+	return nil
+}
+
+func (ts *tweetStub) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (ts *tweetStub) DataFileHash() []byte {
+	return nil
+}
+
+func (ts *tweetStub) DataFileMIMEType() *string {
+	return nil
+}
+
+func (ts *tweetStub) Metadata() (*timeliner.Metadata, error) {
+	return &timeliner.Metadata{Link: ts.url, Placeholder: true}, nil
+}
+
+func (ts *tweetStub) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// archivedMedia is a media file in an archive that is not
+// attached to a tweet, like a photo sent in a direct message
+// or a profile picture.
+type archivedMedia struct {
+	id        string
+	zipFile   *zip.File
+	timestamp time.Time
+	ownerID   *string
+	ownerName *string
+}
+
+func (am *archivedMedia) ID() string {
+	return am.id
+}
+
+func (am *archivedMedia) Timestamp() time.Time {
+	return am.timestamp
+}
+
+func (am *archivedMedia) Class() timeliner.ItemClass {
+	mimeType := am.mimeType()
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	}
+	return timeliner.ClassUnknown
+}
+
+func (am *archivedMedia) Owner() (id *string, name *string) {
+	return am.ownerID, am.ownerName
+}
+
+func (am *archivedMedia) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (am *archivedMedia) DataFileName() *string {
+	name := path.Base(am.zipFile.Name)
+	return &name
+}
+
+func (am *archivedMedia) DataFileReader() (io.ReadCloser, error) {
+	return am.zipFile.Open()
+}
+
+func (am *archivedMedia) DataFileHash() []byte {
+	return nil
+}
+
+func (am *archivedMedia) DataFileMIMEType() *string {
+	mimeType := am.mimeType()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+func (am *archivedMedia) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (am *archivedMedia) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mimeType returns the MIME type of the file as
+// guessed from its extension.
+func (am *archivedMedia) mimeType() string {
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(am.zipFile.Name)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// tweetIDTime returns the time encoded in a tweet ID, which
+// is a Snowflake ID for tweets posted since November 2010.
+// The zero time is returned for older tweets.
+func tweetIDTime(id string) time.Time {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < snowflakeMinID {
+		return time.Time{}
+	}
+	ms := (n >> 22) + snowflakeEpoch
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+const (
+	snowflakeEpoch = 1288834974657 // Twitter's epoch, in Unix milliseconds
+	snowflakeMinID = 29700859247   // the first tweet with a Snowflake ID
+)
@@ -3,20 +3,15 @@
 package twitter
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"path"
 	"regexp"
-	"strconv"
 	"time"
 
-	"github.com/mholt/archiver"
 	"github.com/mholt/timeliner"
 )
 
@@ -31,23 +26,33 @@ var dataSource = timeliner.DataSource{
 	Name: DataSourceName,
 	OAuth2: timeliner.OAuth2{
 		ProviderID: "twitter",
+		Scopes:     []string{"tweet.read", "users.read", "offline.access"},
 	},
 	RateLimit: timeliner.RateLimit{
-		// from https://developer.twitter.com/en/docs/basics/rate-limits
-		// with some leeway since it's actually a pretty generous limit
-		RequestsPerHour: 5900,
+		// from https://developer.twitter.com/en/docs/twitter-api/rate-limits
+		// (900 requests per 15 minutes) with some leeway
+		RequestsPerHour: 3500,
 	},
 	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
 		httpClient, err := acc.NewHTTPClient()
 		if err != nil {
 			return nil, err
 		}
+		opts := acc.Options.(*Options)
 		return &Client{
-			HTTPClient:    httpClient,
-			acc:           acc,
-			otherAccounts: make(map[string]twitterAccount),
+			Retweets:   opts.Retweets,
+			Replies:    opts.Replies,
+			HTTPClient: httpClient,
+			acc:        acc,
 		}, nil
 	},
+	ClientOptions: func() interface{} { return new(Options) },
+}
+
+// Options configures a Twitter client.
+type Options struct {
+	Retweets bool `toml:"retweets" desc:"Include retweets"`
+	Replies  bool `toml:"replies" desc:"Include replies that are not just replies to self, and recent replies by others in your conversations"`
 }
 
 func init() {
@@ -66,9 +71,9 @@ type Client struct {
 
 	checkpoint checkpointInfo
 
-	acc           timeliner.Account
-	ownerAccount  twitterAccount
-	otherAccounts map[string]twitterAccount // keyed by user/account ID
+	acc          timeliner.Account
+	ownerAccount twitterAccount
+	searched     map[string]bool // IDs of conversations that were searched
 }
 
 // ListItems lists items from opt.Filename if specified, or from the API otherwise.
@@ -76,10 +81,15 @@ func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemG
 	defer close(itemChan)
 
 	if opt.Filename != "" {
-		return c.getFromArchiveFile(itemChan, opt)
+		return c.getFromArchiveFile(ctx, itemChan, opt)
 	}
 
-	return c.getFromAPI(ctx, itemChan, opt)
+	err := c.getFromAPI(ctx, itemChan, opt)
+	if ctx.Err() != nil {
+		// requests fail once cancelled, which is not an error
+		return nil
+	}
+	return err
 }
 
 func (c *Client) prepareTweet(t *tweet, source string) (skip bool, err error) {
@@ -92,29 +102,11 @@ func (c *Client) prepareTweet(t *tweet, source string) (skip bool, err error) {
 	case "archive":
 		t.ownerAccount = c.ownerAccount
 	case "api":
-		if t.User != nil {
-			if t.User.UserIDStr == c.ownerAccount.id() {
-				// tweet author is the owner of the account - awesome
-				t.ownerAccount = c.ownerAccount
-			} else {
-				// look up author's account info
-				acc, ok := c.otherAccounts[t.User.UserIDStr]
-				if !ok {
-					acc, err = c.getAccountFromAPI("", t.User.UserIDStr)
-					if err != nil {
-						return false, fmt.Errorf("looking up tweet author's account information: %v", err)
-					}
-					// cache this for later
-					if len(c.otherAccounts) > 2000 {
-						for id := range c.otherAccounts {
-							delete(c.otherAccounts, id)
-							break
-						}
-					}
-					c.otherAccounts[acc.IDStr] = acc
-				}
-				t.ownerAccount = acc
-			}
+		// the author's account information is
+		// included with the tweet by the API
+		if t.ownerAccount.id() == c.ownerAccount.id() {
+			// tweet author is the owner of the account - awesome
+			t.ownerAccount = c.ownerAccount
 		}
 	default:
 		return false, fmt.Errorf("unrecognized source: %s", t.source)
@@ -136,15 +128,18 @@ func (c *Client) prepareTweet(t *tweet, source string) (skip bool, err error) {
 	}
 
 	// parse Twitter's time string into an actual time value
-	t.createdAtParsed, err = time.Parse("Mon Jan 2 15:04:05 -0700 2006", t.CreatedAt)
-	if err != nil {
-		return false, fmt.Errorf("parsing created_at time: %v", err)
+	// (tweets from the API are assembled with it parsed)
+	if t.createdAtParsed.IsZero() {
+		t.createdAtParsed, err = time.Parse("Mon Jan 2 15:04:05 -0700 2006", t.CreatedAt)
+		if err != nil {
+			return false, fmt.Errorf("parsing created_at time: %v", err)
+		}
 	}
 
 	return false, nil
 }
 
-func (c *Client) makeItemGraphFromTweet(t tweet, archiveFilename string) (*timeliner.ItemGraph, error) {
+func (c *Client) makeItemGraphFromTweet(t tweet, arch *archive) (*timeliner.ItemGraph, error) {
 	oneMediaItem := t.hasExactlyOneMediaItem()
 
 	// only create a tweet item if it has text OR exactly one media item
@@ -174,25 +169,10 @@ func (c *Client) makeItemGraphFromTweet(t tweet, archiveFilename string) (*timel
 
 			switch t.source {
 			case "archive":
-				targetFileInArchive := path.Join("tweet_media", dataFileName)
-
-				err := archiver.Walk(archiveFilename, func(f archiver.File) error {
-					if f.Header.(zip.FileHeader).Name != targetFileInArchive {
-						return nil
-					}
-
-					buf := new(bytes.Buffer)
-					_, err := io.Copy(buf, f)
-					if err != nil {
-						return fmt.Errorf("copying item into memory: %v", err)
-					}
-					m.readCloser = timeliner.FakeCloser(buf)
-
-					return archiver.ErrStopWalk
-				})
-				if err != nil {
-					return nil, fmt.Errorf("walking archive file %s in search of tweet media: %v",
-						archiveFilename, err)
+				m.zipFile = arch.mediaFile(dataFileName, "tweet_media", "tweets_media")
+				if m.zipFile == nil {
+					log.Printf("[ERROR][%s/%s] Tweet media not in archive: %s",
+						DataSourceID, c.acc.UserID, dataFileName)
 				}
 
 			case "api":
@@ -232,64 +212,77 @@ func (c *Client) makeItemGraphFromTweet(t tweet, archiveFilename string) (*timel
 		}
 	}
 
-	// if we're using the API, go ahead and get the
-	// 'parent' tweet to which this tweet is a reply
-	if t.source == "api" && t.InReplyToStatusIDStr != "" {
-		inReplyToTweet, err := c.getTweetFromAPI(t.InReplyToStatusIDStr)
-		if err != nil {
-			return nil, fmt.Errorf("getting tweet that this tweet (%s) is in reply to (%s): %v",
-				t.ID(), t.InReplyToStatusIDStr, err)
-		}
-		skip, err := c.prepareTweet(&inReplyToTweet, "api")
-		if err != nil {
-			return nil, fmt.Errorf("preparing reply-parent tweet: %v", err)
+	// archives only have the owner's own tweets, so relate this
+	// tweet to stubs of the tweets it replies to or quotes, which
+	// are replaced if the full tweets are later obtained from the API
+	if t.source == "archive" && ig != nil {
+		if t.InReplyToStatusIDStr != "" && t.InReplyToUserIDStr != t.ownerAccount.id() {
+			ig.Add(t.replyToStub(), timeliner.RelReplyTo)
 		}
-		if !skip {
-			repIG, err := c.makeItemGraphFromTweet(inReplyToTweet, "")
-			if err != nil {
-				return nil, fmt.Errorf("making item from tweet that this tweet (%s) is in reply to (%s): %v",
-					t.ID(), inReplyToTweet.ID(), err)
+		if t.Entities != nil {
+			for _, urlEnt := range t.Entities.URLs {
+				if stub := t.quotedTweetStub(urlEnt.ExpandedURL); stub != nil {
+					ig.Add(stub, timeliner.RelQuotes)
+				}
 			}
-			ig.Edges[repIG] = []timeliner.Relation{timeliner.RelReplyTo}
 		}
 	}
 
-	// if this tweet embeds/quotes/links to other tweets,
-	// we should establish those relationships as well
-	if t.source == "api" && t.Entities != nil {
-		for _, urlEnt := range t.Entities.URLs {
-			embeddedTweetID := getLinkedTweetID(urlEnt.ExpandedURL)
-			if embeddedTweetID == "" {
-				continue
-			}
-			embeddedTweet, err := c.getTweetFromAPI(embeddedTweetID)
+	// tweets from the API are assembled with the tweets they
+	// reply to and quote, and with the thread they are in
+	if t.source == "api" && ig != nil {
+		if t.inReplyTo != nil {
+			err := c.addReferencedTweet(ig, *t.inReplyTo, timeliner.RelReplyTo)
 			if err != nil {
-				return nil, fmt.Errorf("getting tweet that this tweet (%s) embeds (%s): %v",
-					t.ID(), t.InReplyToStatusIDStr, err)
+				return nil, fmt.Errorf("making item from tweet that this tweet (%s) is in reply to (%s): %v",
+					t.ID(), t.inReplyTo.ID(), err)
 			}
-			skip, err := c.prepareTweet(&embeddedTweet, "api")
+		}
+		for _, quoted := range t.quoted {
+			err := c.addReferencedTweet(ig, *quoted, timeliner.RelQuotes)
 			if err != nil {
-				return nil, fmt.Errorf("preparing embedded tweet: %v", err)
-			}
-			if !skip {
-				embIG, err := c.makeItemGraphFromTweet(embeddedTweet, "")
-				if err != nil {
-					return nil, fmt.Errorf("making item from tweet that this tweet (%s) embeds (%s): %v",
-						t.ID(), embeddedTweet.ID(), err)
-				}
-				ig.Edges[embIG] = []timeliner.Relation{timeliner.RelQuotes}
+				return nil, fmt.Errorf("making item from tweet that this tweet (%s) quotes (%s): %v",
+					t.ID(), quoted.ID(), err)
 			}
 		}
+		if t.conversationID != "" && (t.conversationID != t.ID() || t.hasReplies) {
+			ig.Collections = append(ig.Collections, timeliner.Collection{
+				OriginalID: "conversation_" + t.conversationID,
+				Items: []timeliner.CollectionItem{
+					{Item: ig.Node, Position: t.threadDepth()},
+				},
+			})
+		}
 	}
 
 	return ig, nil
 }
 
+// addReferencedTweet adds the tweet ref, which is referenced by
+// the tweet of ig, to ig with the relation rel.
+func (c *Client) addReferencedTweet(ig *timeliner.ItemGraph, ref tweet, rel timeliner.Relation) error {
+	skip, err := c.prepareTweet(&ref, "api")
+	if err != nil {
+		return fmt.Errorf("preparing referenced tweet: %v", err)
+	}
+	if skip {
+		return nil
+	}
+	refIG, err := c.makeItemGraphFromTweet(ref, nil)
+	if err != nil {
+		return err
+	}
+	if refIG != nil {
+		ig.Edges[refIG] = []timeliner.Relation{rel}
+	}
+	return nil
+}
+
 // Assuming checkpoints are short-lived (i.e. are resumed
 // somewhat quickly, before the page tokens/cursors expire),
 // we can just store the page tokens.
 type checkpointInfo struct {
-	LastTweetID string
+	PaginationToken string
 }
 
 // save records the checkpoint.
@@ -312,26 +305,6 @@ func (ch *checkpointInfo) load(checkpointGob []byte) {
 	}
 }
 
-// maxTweetID returns the higher of the two tweet IDs.
-// Errors parsing the strings as integers are ignored.
-// Empty string inputs are ignored so the other value
-// will win automatically. If both are empty, an empty
-// string is returned.
-func maxTweetID(id1, id2 string) string {
-	if id1 == "" {
-		return id2
-	}
-	if id2 == "" {
-		return id1
-	}
-	id1int, _ := strconv.ParseInt(id1, 10, 64)
-	id2int, _ := strconv.ParseInt(id2, 10, 64)
-	if id1int > id2int {
-		return id1
-	}
-	return id2
-}
-
 // getLinkedTweetID returns the ID of the tweet in
 // a link to a tweet, for example:
 // "https://twitter.com/foo/status/12345"
@@ -0,0 +1,298 @@
+package whatsapp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseChat reads the messages in a chat file. Each message starts
+// with a line that begins with its date and time, which are written
+// in the format of the locale of the phone the chat was exported
+// from, so they are parsed after reading the whole chat, once the
+// format is known. Lines that don't begin with a date and time
+// continue the previous message.
+//
+// System messages, like the notice that the chat is encrypted or
+// that someone joined a group, and placeholders for media that was
+// not exported, are not from anyone, so they are left out.
+func parseChat(r io.Reader, exp *export) ([]*message, error) {
+	var lines []*chatLine
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for sc.Scan() {
+		line := normalizeLine(sc.Text())
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+		}
+		if cl := parseChatLine(line); cl != nil {
+			lines = append(lines, cl)
+			continue
+		}
+		if len(lines) > 0 {
+			prev := lines[len(lines)-1]
+			prev.text += "\n" + strings.TrimPrefix(line, lrm)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no messages found")
+	}
+
+	order := detectDateOrder(lines)
+
+	var msgs []*message
+	for _, cl := range lines {
+		ts, err := cl.timestamp(order)
+		if err != nil {
+			log.Printf("[ERROR][%s] Skipping message at %s %s: %v", DataSourceID, cl.date, cl.clock, err)
+			continue
+		}
+		if m := cl.message(ts, exp); m != nil {
+			msgs = append(msgs, m)
+		}
+	}
+
+	return msgs, nil
+}
+
+// chatLine is the first line of a message,
+// plus any lines that continue it.
+type chatLine struct {
+	date, clock, ampm string
+	text              string // everything after the time
+}
+
+// parseChatLine parses line if it is the first line of a
+// message, and returns nil otherwise. On iOS these look like
+// "[31/12/2020, 23:59:59] Alice: Hello", and on Android like
+// "12/31/20, 11:59 PM - Alice: Hello", with the date and time
+// in the phone's locale.
+func parseChatLine(line string) *chatLine {
+	m := chatLineRegexp.FindStringSubmatch(strings.TrimPrefix(line, lrm))
+	if m == nil {
+		return nil
+	}
+	return &chatLine{date: m[1], clock: m[2], ampm: m[3], text: m[4]}
+}
+
+var chatLineRegexp = regexp.MustCompile(`^\[?(\d{1,4}[./-] ?\d{1,2}[./-] ?\d{1,4})\.?,? (\d{1,2}[:.]\d{2}(?:[:.]\d{2})?)(?: ?([AaPp]\.? ?[Mm]\.?))?(?:\] | [-–] )(.*)$`)
+
+// message returns the message on the line, or nil if it is not
+// a message from someone. Messages that only refer to a file in
+// the export are listed as having that file attached.
+func (cl *chatLine) message(ts time.Time, exp *export) *message {
+	// on Android, system messages have no sender; on iOS,
+	// they appear to be sent by the chat, but their text
+	// starts with a left-to-right mark, as do references
+	// to attachments and placeholders for omitted media
+	sep := strings.Index(cl.text, ": ")
+	if sep < 0 {
+		return nil
+	}
+	// Android system messages which quote text, like a new
+	// group subject, can have ": " in the quote, as in 'Alice
+	// changed the subject to "a: b"'; names of senders seldom
+	// have quotes in them, so those are system messages too
+	sender := cl.text[:sep]
+	if strings.ContainsAny(sender, "\"\u201c\u201d") {
+		return nil
+	}
+	m := &message{
+		timestamp: ts,
+		sender:    sender,
+	}
+	text := cl.text[sep+2:]
+	marked := strings.HasPrefix(text, lrm)
+	text = strings.Replace(text, lrm, "", -1)
+
+	var body []string
+	for i, line := range strings.Split(text, "\n") {
+		if i == 0 {
+			if name := attachedFile(line, exp); name != "" {
+				m.attachments = append(m.attachments, &attachment{msg: m, filename: name, exp: exp})
+				continue
+			}
+			if marked || line == "<Media omitted>" {
+				// a system message or omitted media
+				return nil
+			}
+		}
+		body = append(body, line)
+	}
+	m.text = strings.TrimSpace(strings.Join(body, "\n"))
+
+	if m.text == "" && len(m.attachments) == 0 {
+		return nil
+	}
+	return m
+}
+
+// attachedFile returns the name of the file that line refers to,
+// if it refers to one in the export. In English, these references
+// look like "<attached: 00000012-PHOTO-2020-12-31-23-59-59.jpg>" on
+// iOS and "IMG-20201231-WA0001.jpg (file attached)" on Android.
+func attachedFile(line string, exp *export) string {
+	line = strings.TrimSpace(line)
+	if m := iosAttachmentRegexp.FindStringSubmatch(line); m != nil && exp.has(m[1]) {
+		return m[1]
+	}
+	if m := androidAttachmentRegexp.FindStringSubmatch(line); m != nil && exp.has(m[1]) {
+		return m[1]
+	}
+	return ""
+}
+
+var (
+	iosAttachmentRegexp     = regexp.MustCompile(`^<[^:<>]+: ([^<>]+)>$`)
+	androidAttachmentRegexp = regexp.MustCompile(`^(\S+\.\w+) \([^()]+\)$`)
+)
+
+// dateOrder is the order of the day, month, and year in a date.
+type dateOrder int
+
+const (
+	dayMonthYear dateOrder = iota
+	monthDayYear
+	yearMonthDay
+)
+
+// detectDateOrder determines the order of the fields of the dates
+// of the messages. A 4-digit first field must be the year, and a
+// field over 12 must be the day; if that doesn't settle it, the
+// order in which the messages are chronological is chosen.
+func detectDateOrder(lines []*chatLine) dateOrder {
+	canDMY, canMDY := true, true
+	var usesAMPM bool
+	for _, cl := range lines {
+		f := dateFields(cl.date)
+		if len(f[0]) == 4 {
+			return yearMonthDay
+		}
+		a, _ := strconv.Atoi(f[0])
+		b, _ := strconv.Atoi(f[1])
+		if a > 12 {
+			canMDY = false
+		}
+		if b > 12 {
+			canDMY = false
+		}
+		if cl.ampm != "" {
+			usesAMPM = true
+		}
+	}
+	switch {
+	case canDMY && !canMDY:
+		return dayMonthYear
+	case canMDY && !canDMY:
+		return monthDayYear
+	}
+
+	outOfOrder := func(order dateOrder) int {
+		var n int
+		var prev time.Time
+		for _, cl := range lines {
+			ts, err := cl.timestamp(order)
+			if err != nil {
+				n++
+				continue
+			}
+			if ts.Before(prev) {
+				n++
+			}
+			prev = ts
+		}
+		return n
+	}
+	dmy, mdy := outOfOrder(dayMonthYear), outOfOrder(monthDayYear)
+	switch {
+	case dmy < mdy:
+		return dayMonthYear
+	case mdy < dmy:
+		return monthDayYear
+	case usesAMPM:
+		// mostly the US, which writes the month first
+		return monthDayYear
+	}
+	return dayMonthYear
+}
+
+// dateFields splits date into its three fields.
+func dateFields(date string) [3]string {
+	var f [3]string
+	parts := strings.FieldsFunc(date, func(r rune) bool {
+		return r == '/' || r == '.' || r == '-' || r == ' '
+	})
+	copy(f[:], parts)
+	return f
+}
+
+// timestamp returns the time of the message, in local time,
+// with its date fields in the given order.
+func (cl *chatLine) timestamp(order dateOrder) (time.Time, error) {
+	f := dateFields(cl.date)
+	var y, mo, d string
+	switch order {
+	case dayMonthYear:
+		d, mo, y = f[0], f[1], f[2]
+	case monthDayYear:
+		mo, d, y = f[0], f[1], f[2]
+	case yearMonthDay:
+		y, mo, d = f[0], f[1], f[2]
+	}
+	year, err := strconv.Atoi(y)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad year: %s", cl.date)
+	}
+	if len(y) <= 2 {
+		year += 2000
+	}
+	month, err := strconv.Atoi(mo)
+	if err != nil || month < 1 || month > 12 {
+		return time.Time{}, fmt.Errorf("bad month: %s", cl.date)
+	}
+	day, err := strconv.Atoi(d)
+	if err != nil || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("bad day: %s", cl.date)
+	}
+
+	var hms [3]int
+	for i, s := range strings.FieldsFunc(cl.clock, func(r rune) bool { return r == ':' || r == '.' }) {
+		hms[i], _ = strconv.Atoi(s)
+	}
+	ampm := strings.ToLower(strings.NewReplacer(".", "", " ", "").Replace(cl.ampm))
+	switch {
+	case ampm == "am" && hms[0] == 12:
+		hms[0] = 0
+	case ampm == "pm" && hms[0] < 12:
+		hms[0] += 12
+	}
+	if hms[0] > 23 || hms[1] > 59 || hms[2] > 59 {
+		return time.Time{}, fmt.Errorf("bad time: %s", cl.clock)
+	}
+
+	return time.Date(year, time.Month(month), day, hms[0], hms[1], hms[2], 0, time.Local), nil
+}
+
+// normalizeLine replaces the various kinds of spaces that
+// WhatsApp uses in dates and times with regular spaces.
+func normalizeLine(line string) string {
+	line = strings.TrimRight(line, "\r")
+	return spaceReplacer.Replace(line)
+}
+
+var spaceReplacer = strings.NewReplacer(
+	"\u00a0", " ", // no-break space
+	"\u202f", " ", // narrow no-break space
+)
+
+// lrm is the left-to-right mark, which iOS puts at the start of
+// system messages and references to attachments.
+const lrm = "\u200e"
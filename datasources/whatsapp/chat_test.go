@@ -0,0 +1,143 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		lines  []string
+		expect []time.Time
+	}{
+		{
+			name: "iOS",
+			lines: []string{
+				"[31/12/2020, 23:59:59] Alice: Hello",
+				"[01/01/2021, 00:00:05] Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 59, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 5, 0, time.Local),
+			},
+		},
+		{
+			name: "iOS US with AM/PM",
+			lines: []string{
+				"[12/31/20, 11:59:59 PM] Alice: Hello",
+				"[1/1/21, 12:00:05 AM] Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 59, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 5, 0, time.Local),
+			},
+		},
+		{
+			name: "Android",
+			lines: []string{
+				"31/12/2020, 23:59 - Alice: Hello",
+				"01/01/2021, 00:00 - Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 0, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local),
+			},
+		},
+		{
+			name: "Android US with AM/PM",
+			lines: []string{
+				"12/31/20, 11:59 PM - Alice: Hello",
+				"1/1/21, 12:00 AM - Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 0, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local),
+			},
+		},
+		{
+			name: "US with AM/PM, ambiguous days",
+			lines: []string{
+				"1/2/21, 9:00 AM - Alice: Hello",
+				"1/3/21, 9:00 PM - Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2021, 1, 2, 9, 0, 0, 0, time.Local),
+				time.Date(2021, 1, 3, 21, 0, 0, 0, time.Local),
+			},
+		},
+		{
+			name: "DE",
+			lines: []string{
+				"31.12.20, 23:59 - Alice: Hallo",
+				"01.01.21, 00:00 - Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 0, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local),
+			},
+		},
+		{
+			name: "DE, ambiguous days",
+			lines: []string{
+				"[02.01.21, 09:00:00] Alice: Hallo",
+				"[03.01.21, 21:00:00] Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2021, 1, 2, 9, 0, 0, 0, time.Local),
+				time.Date(2021, 1, 3, 21, 0, 0, 0, time.Local),
+			},
+		},
+		{
+			name: "YMD",
+			lines: []string{
+				"[2020/12/31 23:59:59] Alice: Hello",
+				"[2021/01/01 00:00:05] Bob: Hi",
+			},
+			expect: []time.Time{
+				time.Date(2020, 12, 31, 23, 59, 59, 0, time.Local),
+				time.Date(2021, 1, 1, 0, 0, 5, 0, time.Local),
+			},
+		},
+	} {
+		var lines []*chatLine
+		for _, line := range tc.lines {
+			cl := parseChatLine(normalizeLine(line))
+			if cl == nil {
+				t.Fatalf("%s: line not parsed: %q", tc.name, line)
+			}
+			lines = append(lines, cl)
+		}
+		order := detectDateOrder(lines)
+		for i, cl := range lines {
+			ts, err := cl.timestamp(order)
+			if err != nil {
+				t.Errorf("%s: line %d: %v", tc.name, i, err)
+				continue
+			}
+			if !ts.Equal(tc.expect[i]) {
+				t.Errorf("%s: line %d: expected %s, got %s", tc.name, i, tc.expect[i], ts)
+			}
+		}
+	}
+}
+
+func TestSystemMessages(t *testing.T) {
+	chat := strings.Join([]string{
+		"12/31/20, 11:58 PM - Messages and calls are end-to-end encrypted.",
+		`12/31/20, 11:59 PM - Alice changed the subject to "Plans: 2021"`,
+		"12/31/20, 11:59 PM - Alice: Hello: world",
+		"12/31/20, 11:59 PM - Bob joined using this group's invite link",
+	}, "\n")
+	msgs, err := parseChat(strings.NewReader(chat), &export{dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].sender != "Alice" || msgs[0].text != "Hello: world" {
+		t.Errorf("expected message from Alice, got %q from %q", msgs[0].text, msgs[0].sender)
+	}
+}
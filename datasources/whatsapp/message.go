@@ -0,0 +1,194 @@
+package whatsapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// message is a message in a chat.
+type message struct {
+	id          string
+	timestamp   time.Time
+	sender      string
+	text        string
+	attachments []*attachment
+}
+
+// setID sets the message's ID. Exported chats don't have message
+// IDs, so it is derived from the time, sender, and text, which are
+// the same every time the chat is exported; identical messages
+// sent in the same minute are told apart by the number of times
+// the ID has been seen, which is tracked in seen. The chat is only
+// known by the name of the file it was exported to, which changes
+// if the file is renamed, so it is not part of the ID. (A message
+// identical to one in another chat, sent the same minute, is the
+// same item, which is in the collections of both chats.)
+func (m *message) setID(seen map[string]int) {
+	var names []string
+	for _, a := range m.attachments {
+		names = append(names, a.filename)
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		m.timestamp.Format("2006-01-02T15:04:05"),
+		m.sender,
+		m.text,
+		strings.Join(names, "\n"),
+	}, "\x00")))
+	id := hex.EncodeToString(h[:])
+	if n := seen[id]; n > 0 {
+		seen[id]++
+		id = fmt.Sprintf("%s_%d", id, n)
+	} else {
+		seen[id] = 1
+	}
+	m.id = id
+}
+
+// itemGraph returns the item graph for the message,
+// which relates it to its attachments.
+func (m *message) itemGraph() *timeliner.ItemGraph {
+	ig := timeliner.NewItemGraph(m)
+	for _, a := range m.attachments {
+		ig.Add(a, timeliner.RelAttached)
+	}
+	return ig
+}
+
+func (m *message) ID() string {
+	return m.id
+}
+
+func (m *message) Timestamp() time.Time {
+	return m.timestamp
+}
+
+func (m *message) Class() timeliner.ItemClass {
+	return timeliner.ClassPrivateMessage
+}
+
+// Owner returns the sender of the message, who is known only
+// by their name in the exporter's contacts, or by their phone
+// number if they're not in them.
+func (m *message) Owner() (*string, *string) {
+	return &m.sender, &m.sender
+}
+
+func (m *message) DataText() (*string, error) {
+	if m.text == "" {
+		return nil, nil
+	}
+	return &m.text, nil
+}
+
+func (m *message) DataFileName() *string {
+	return nil
+}
+
+func (m *message) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *message) DataFileHash() []byte {
+	return nil
+}
+
+func (m *message) DataFileMIMEType() *string {
+	return nil
+}
+
+func (m *message) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (m *message) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// attachment is a media file sent in a message.
+type attachment struct {
+	msg      *message
+	filename string
+	exp      *export
+}
+
+// ID returns the ID of the message and the name of the file,
+// which is unique within a chat.
+func (a *attachment) ID() string {
+	return a.msg.id + "_" + a.filename
+}
+
+func (a *attachment) Timestamp() time.Time {
+	return a.msg.timestamp
+}
+
+func (a *attachment) Class() timeliner.ItemClass {
+	mimeType := a.mimeType()
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return timeliner.ClassImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return timeliner.ClassVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return timeliner.ClassAudio
+	}
+	return timeliner.ClassUnknown
+}
+
+func (a *attachment) Owner() (*string, *string) {
+	return a.msg.Owner()
+}
+
+func (a *attachment) DataText() (*string, error) {
+	return nil, nil
+}
+
+func (a *attachment) DataFileName() *string {
+	return &a.filename
+}
+
+func (a *attachment) DataFileReader() (io.ReadCloser, error) {
+	return a.exp.open(a.filename)
+}
+
+func (a *attachment) DataFileHash() []byte {
+	return nil
+}
+
+func (a *attachment) DataFileMIMEType() *string {
+	mimeType := a.mimeType()
+	if mimeType == "" {
+		return nil
+	}
+	return &mimeType
+}
+
+func (a *attachment) Metadata() (*timeliner.Metadata, error) {
+	return nil, nil
+}
+
+func (a *attachment) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
+
+// mimeType returns the MIME type of the file according to its
+// extension. Voice messages are Opus audio in Ogg files, which
+// have a .opus extension that isn't known everywhere.
+func (a *attachment) mimeType() string {
+	ext := strings.ToLower(path.Ext(a.filename))
+	if ext == ".opus" {
+		return "audio/ogg"
+	}
+	mimeType := mime.TypeByExtension(ext)
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
@@ -0,0 +1,209 @@
+// Package whatsapp implements a Timeliner data source for
+// importing chats exported from WhatsApp with "Export chat".
+package whatsapp
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "WhatsApp"
+	DataSourceID   = "whatsapp"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the messages in the chat export at opt.Filename,
+// which must be non-empty. It may be the .zip file that WhatsApp
+// exports a chat with media as, the chat's .txt file, or a folder
+// the .zip file was extracted into. The chat is listed as a
+// collection, named after the file or folder it was exported to.
+// Timeframes are not honored.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	exp, err := openExport(opt.Filename)
+	if err != nil {
+		return err
+	}
+	defer timeliner.AfterItems(ctx, func() { exp.Close() })
+
+	chatFile, err := exp.open(exp.chatFile)
+	if err != nil {
+		return fmt.Errorf("opening chat file: %v", err)
+	}
+	msgs, err := parseChat(chatFile, exp)
+	chatFile.Close()
+	if err != nil {
+		return fmt.Errorf("parsing chat: %v", err)
+	}
+
+	coll := timeliner.Collection{
+		OriginalID: "chat_" + exp.chatName,
+		Name:       &exp.chatName,
+	}
+	ids := make(map[string]int)
+	for i, m := range msgs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m.setID(ids)
+		ig := m.itemGraph()
+		cl := coll
+		cl.Items = []timeliner.CollectionItem{{Item: m, Position: i}}
+		ig.Collections = append(ig.Collections, cl)
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// export is a chat export, either a .zip file
+// or a folder with the chat's .txt file in it.
+type export struct {
+	chatName string
+	chatFile string // the name of the chat's .txt file
+	zr       *zip.ReadCloser
+	zipFiles map[string]*zip.File
+	dir      string
+}
+
+// openExport opens the chat export at filename.
+func openExport(filename string) (*export, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", filename, err)
+	}
+
+	exp := new(export)
+	switch {
+	case info.IsDir():
+		exp.dir = filename
+		exp.chatName = chatName(filepath.Base(filename))
+		entries, err := ioutil.ReadDir(filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading folder: %v", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				exp.considerChatFile(e.Name())
+			}
+		}
+
+	case strings.EqualFold(filepath.Ext(filename), ".zip"):
+		exp.chatName = chatName(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+		exp.zr, err = zip.OpenReader(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening zip file: %v", err)
+		}
+		exp.zipFiles = make(map[string]*zip.File)
+		for _, f := range exp.zr.File {
+			name := path.Base(f.Name)
+			exp.zipFiles[name] = f
+			exp.considerChatFile(name)
+		}
+
+	default:
+		exp.dir = filepath.Dir(filename)
+		exp.chatFile = filepath.Base(filename)
+		exp.chatName = chatName(strings.TrimSuffix(exp.chatFile, filepath.Ext(exp.chatFile)))
+		if exp.chatFile == "_chat.txt" {
+			exp.chatName = chatName(filepath.Base(exp.dir))
+		}
+	}
+
+	if exp.chatFile == "" {
+		exp.Close()
+		return nil, fmt.Errorf("no chat file found in %s", filename)
+	}
+
+	return exp, nil
+}
+
+// considerChatFile makes the file with the given name the
+// chat file if it is the best candidate so far. Any .txt file
+// will do, but since text files can also be attached to
+// messages, one with the name of a chat file is preferred.
+func (exp *export) considerChatFile(name string) {
+	if !strings.EqualFold(path.Ext(name), ".txt") {
+		return
+	}
+	if exp.chatFile == "" || name == "_chat.txt" || strings.HasPrefix(name, "WhatsApp Chat") {
+		exp.chatFile = name
+	}
+}
+
+// has returns true if the export has a file with the given name.
+func (exp *export) has(name string) bool {
+	if name == "" || name != path.Base(name) {
+		return false
+	}
+	if exp.zipFiles != nil {
+		_, ok := exp.zipFiles[name]
+		return ok
+	}
+	info, err := os.Stat(filepath.Join(exp.dir, name))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// open opens the file in the export with the given name.
+func (exp *export) open(name string) (io.ReadCloser, error) {
+	if exp.zipFiles != nil {
+		f, ok := exp.zipFiles[name]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		return f.Open()
+	}
+	return os.Open(filepath.Join(exp.dir, name))
+}
+
+func (exp *export) Close() error {
+	if exp.zr != nil {
+		return exp.zr.Close()
+	}
+	return nil
+}
+
+// chatName returns the name of a chat from the name (without
+// extension) of the file or folder it was exported to, which is
+// usually like "WhatsApp Chat with Alice" (Android) or "WhatsApp
+// Chat - Alice" (iOS) in English.
+func chatName(name string) string {
+	for _, prefix := range []string{"WhatsApp Chat with ", "WhatsApp Chat - "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	return name
+}
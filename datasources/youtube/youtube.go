@@ -0,0 +1,212 @@
+// Package youtube implements a Timeliner data source for importing
+// the YouTube watch and search history from Google Takeout.
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mholt/timeliner"
+)
+
+// Data source name and ID
+const (
+	DataSourceName = "YouTube"
+	DataSourceID   = "youtube"
+)
+
+var dataSource = timeliner.DataSource{
+	ID:   DataSourceID,
+	Name: DataSourceName,
+	NewClient: func(acc timeliner.Account) (timeliner.Client, error) {
+		return new(Client), nil
+	},
+}
+
+func init() {
+	err := timeliner.RegisterDataSource(dataSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Client implements the timeliner.Client interface.
+type Client struct{}
+
+// ListItems lists the entries of the history file at opt.Filename,
+// which must be non-empty. It is the watch-history.json or
+// search-history.json file of a Takeout of YouTube in JSON format.
+func (c *Client) ListItems(ctx context.Context, itemChan chan<- *timeliner.ItemGraph, opt timeliner.Options) error {
+	defer close(itemChan)
+
+	if opt.Filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+
+	file, err := os.Open(opt.Filename)
+	if err != nil {
+		return fmt.Errorf("opening history file: %v", err)
+	}
+	defer file.Close()
+
+	return ListHistory(ctx, file, itemChan)
+}
+
+// ListHistory lists the entries of the YouTube watch or search
+// history JSON read from r, sending them on itemChan. Videos that
+// were watched are also in a collection of those watched that day.
+// It does not close itemChan.
+func ListHistory(ctx context.Context, r io.Reader, itemChan chan<- *timeliner.ItemGraph) error {
+	dec := json.NewDecoder(r)
+
+	_, err := dec.Token() // opening bracket '['
+	if err != nil {
+		return fmt.Errorf("decoding opening token: %v", err)
+	}
+
+	for dec.More() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var entry activity
+		err := dec.Decode(&entry)
+		if err != nil {
+			return fmt.Errorf("decoding history entry: %v", err)
+		}
+		if entry.Time.IsZero() {
+			continue
+		}
+		ig := timeliner.NewItemGraph(&entry)
+		if entry.watched() {
+			ig.Collections = append(ig.Collections, timeliner.DayCollection("yt_watched_", "YouTube videos watched on ", &entry))
+		}
+		itemChan <- ig
+	}
+
+	return nil
+}
+
+// activity is an entry in the "My Activity" JSON format
+// that Takeout uses for YouTube history.
+type activity struct {
+	Header    string    `json:"header"`
+	Title     string    `json:"title"`
+	TitleURL  string    `json:"titleUrl"`
+	Time      time.Time `json:"time"`
+	Products  []string  `json:"products"`
+	Subtitles []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"subtitles"`
+}
+
+// ID returns an ID made from the kind of activity, what it
+// was about, and when it happened, since entries have no ID
+// of their own.
+func (a *activity) ID() string {
+	kind, subject := "yt_watch", a.videoID()
+	if !a.watched() {
+		kind, subject = "yt_search", ""
+	}
+	if subject == "" {
+		return fmt.Sprintf("%s_%d", kind, a.Time.Unix())
+	}
+	return fmt.Sprintf("%s_%s_%d", kind, subject, a.Time.Unix())
+}
+
+// videoID returns the ID of the video in the entry's
+// URL, if it links to a video.
+func (a *activity) videoID() string {
+	u, err := url.Parse(a.TitleURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("v")
+}
+
+// watched returns true if the entry is of a video
+// that was watched, rather than a search.
+func (a *activity) watched() bool {
+	return !strings.HasPrefix(a.Title, "Searched for ")
+}
+
+// videoTitle returns the title of the video that was watched,
+// which is the title of the entry without "Watched " before it.
+func (a *activity) videoTitle() string {
+	return strings.TrimPrefix(a.Title, "Watched ")
+}
+
+// channel returns the name of the channel
+// of the video that was watched, if known.
+func (a *activity) channel() string {
+	if len(a.Subtitles) == 0 {
+		return ""
+	}
+	return a.Subtitles[0].Name
+}
+
+func (a *activity) Timestamp() time.Time {
+	return a.Time
+}
+
+func (a *activity) Class() timeliner.ItemClass {
+	if a.watched() {
+		return timeliner.ClassVideo
+	}
+	return timeliner.ClassEvent
+}
+
+func (a *activity) Owner() (*string, *string) {
+	return nil, nil
+}
+
+func (a *activity) DataText() (*string, error) {
+	if a.Title == "" {
+		return nil, nil
+	}
+	return &a.Title, nil
+}
+
+func (a *activity) DataFileName() *string {
+	return nil
+}
+
+func (a *activity) DataFileReader() (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (a *activity) DataFileHash() []byte {
+	return nil
+}
+
+func (a *activity) DataFileMIMEType() *string {
+	return nil
+}
+
+// Metadata returns the link to what the entry is about, and for
+// videos that were watched, the video's title and channel. The
+// history does not say for how long videos were watched.
+func (a *activity) Metadata() (*timeliner.Metadata, error) {
+	if !a.watched() {
+		if a.TitleURL == "" {
+			return nil, nil
+		}
+		return &timeliner.Metadata{Link: a.TitleURL}, nil
+	}
+	return &timeliner.Metadata{
+		Link:   a.TitleURL,
+		Name:   a.videoTitle(),
+		Artist: a.channel(),
+	}, nil
+}
+
+func (a *activity) Location() (*timeliner.Location, error) {
+	return nil, nil
+}
@@ -10,7 +10,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func openDB(dataDir string) (*sql.DB, error) {
+// openDB opens the database in dataDir. If readOnly is true,
+// the database must already exist, and it is not provisioned.
+func openDB(dataDir string, readOnly bool) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
 	defer func() {
@@ -19,14 +21,29 @@ func openDB(dataDir string) (*sql.DB, error) {
 		}
 	}()
 
+	dbPath := filepath.Join(dataDir, "index.db")
+
+	// other processes may be reading the database while we
+	// write to it (or vice-versa), so use WAL mode, which
+	// allows that, and wait on busy locks instead of failing
+	dsn := "file:" + dbPath + "?_foreign_keys=true&_busy_timeout=10000"
+	if readOnly {
+		if _, err = os.Stat(dbPath); err != nil {
+			return nil, fmt.Errorf("checking for database: %v", err)
+		}
+		db, err = sql.Open("sqlite3", dsn+"&mode=ro")
+		if err != nil {
+			return nil, fmt.Errorf("opening database: %v", err)
+		}
+		return db, nil
+	}
+
 	err = os.MkdirAll(dataDir, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("making data directory: %v", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "index.db")
-
-	db, err = sql.Open("sqlite3", dbPath+"?_foreign_keys=true")
+	db, err = sql.Open("sqlite3", dsn+"&_journal_mode=WAL")
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %v", err)
 	}
@@ -66,6 +83,13 @@ CREATE TABLE IF NOT EXISTS "accounts" (
 	UNIQUE ("data_source_id", "user_id")
 );
 
+-- Sync state is kept by a data source for an account across runs; unlike checkpoints, it is not cleared when a run finishes.
+CREATE TABLE IF NOT EXISTS "sync_states" (
+	"account_id" INTEGER PRIMARY KEY,
+	"state" BLOB,
+	FOREIGN KEY ("account_id") REFERENCES "accounts"("id") ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS "persons" (
 	"id" INTEGER PRIMARY KEY,
 	"name" TEXT
@@ -108,6 +132,27 @@ CREATE INDEX IF NOT EXISTS "idx_items_timestamp" ON "items"("timestamp");
 CREATE INDEX IF NOT EXISTS "idx_items_data_text" ON "items"("data_text");
 CREATE INDEX IF NOT EXISTS "idx_items_data_hash" ON "items"("data_hash");
 
+-- An item revision holds the prior values of an item's columns that changed when the item was reprocessed.
+CREATE TABLE IF NOT EXISTS "item_revisions" (
+	"id" INTEGER PRIMARY KEY,
+	"item_id" INTEGER NOT NULL,
+	"revised" INTEGER NOT NULL, -- timestamp when these values were replaced
+	"changed" TEXT NOT NULL, -- comma-separated names of the columns that changed; only those columns have values here
+	"person_id" INTEGER,
+	"timestamp" INTEGER,
+	"class" INTEGER,
+	"mime_type" TEXT,
+	"data_text" TEXT,
+	"data_file" TEXT, -- only set if the prior data file was kept
+	"data_hash" TEXT,
+	"metadata" BLOB,
+	"latitude" REAL,
+	"longitude" REAL,
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "idx_item_revisions_item_id" ON "item_revisions"("item_id");
+
 -- Relationships draws relationships between and across items and persons.
 CREATE TABLE IF NOT EXISTS "relationships" (
 	"id" INTEGER PRIMARY KEY,
@@ -127,6 +172,38 @@ CREATE TABLE IF NOT EXISTS "relationships" (
 	UNIQUE ("from_person_id", "to_item_id", "label")
 );
 
+-- Relationships between items, by their original IDs, which could not be
+-- made yet because one of the items is not in the timeline; they are made
+-- once both are (see RawRelation).
+CREATE TABLE IF NOT EXISTS "pending_relationships" (
+	"id" INTEGER PRIMARY KEY,
+	"data_source_id" TEXT NOT NULL,
+	"from_original_id" TEXT NOT NULL,
+	"to_original_id" TEXT NOT NULL,
+	"directed" BOOLEAN,
+	"label" TEXT NOT NULL,
+	FOREIGN KEY ("data_source_id") REFERENCES "data_sources"("id") ON DELETE CASCADE,
+	UNIQUE ("data_source_id", "from_original_id", "to_original_id", "label")
+);
+
+CREATE INDEX IF NOT EXISTS "idx_pending_relationships_to_original_id" ON "pending_relationships"("data_source_id", "to_original_id");
+
+-- Spans of time in which an item is related to the items of a class, so that
+-- items stored after it are related to it too (see SpanRelation).
+CREATE TABLE IF NOT EXISTS "item_spans" (
+	"id" INTEGER PRIMARY KEY,
+	"item_id" INTEGER NOT NULL,
+	"class" INTEGER NOT NULL,
+	"start_time" INTEGER NOT NULL,
+	"end_time" INTEGER NOT NULL,
+	"directed" BOOLEAN,
+	"label" TEXT NOT NULL,
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON DELETE CASCADE,
+	UNIQUE ("item_id", "class", "label")
+);
+
+CREATE INDEX IF NOT EXISTS "idx_item_spans_class_start_time" ON "item_spans"("class", "start_time");
+
 CREATE TABLE IF NOT EXISTS "collections" (
 	"id" INTEGER PRIMARY KEY,
 	"account_id" INTEGER NOT NULL,
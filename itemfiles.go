@@ -18,7 +18,7 @@ import (
 )
 
 // downloadItemFile ... TODO.
-func (t *Timeline) downloadItemFile(src io.ReadCloser, dest *os.File, h hash.Hash) error {
+func (t *Timeline) downloadItemFile(src io.Reader, dest *os.File, h hash.Hash) error {
 	if src == nil {
 		return fmt.Errorf("missing reader with which to download file")
 	}
@@ -142,6 +142,7 @@ const (
 	ClassLocation
 	ClassEmail
 	ClassPrivateMessage
+	ClassPhoneCall
 )
 
 // These are the standard relationships that Timeliner
@@ -152,6 +153,10 @@ var (
 	RelReplyTo  = Relation{Label: "reply_to", Bidirectional: false} // "<from> is in reply to <to>"
 	RelAttached = Relation{Label: "attached", Bidirectional: true}  // "<to|from> is attached to <from|to>"
 	RelQuotes   = Relation{Label: "quotes", Bidirectional: false}   // "<from> quotes <to>"
+	RelSentTo   = Relation{Label: "sent_to", Bidirectional: false}  // "<from> was sent to <to>"
+	RelCC       = Relation{Label: "cc", Bidirectional: false}       // "<from> was copied to <to>"
+	RelAttendee = Relation{Label: "attendee", Bidirectional: false} // "<from> was attended by <to>"
+	RelLikes    = Relation{Label: "likes", Bidirectional: false}    // "<from> likes <to>"
 )
 
 // ItemRow has the structure of an item's row in our DB.
@@ -245,6 +250,30 @@ type ItemGraph struct {
 	//
 	// Optional.
 	Relations []RawRelation
+
+	// Relationships from Node to existing items in
+	// the same account whose timestamps fall within
+	// a span of time can be represented here. This
+	// is useful for items that cover a period of
+	// time, like a visit to a place, which can be
+	// related to the items that happened during it
+	// without knowing their IDs. Like Relations,
+	// this is a best-effort field: items that are
+	// not yet in the timeline are not related, but
+	// processing the graph again will relate them.
+	//
+	// Optional.
+	SpanRelations []SpanRelation
+
+	// Relationships between Node and persons on the data
+	// source, such as the recipients of a message,
+	// can be represented here. Persons are identified
+	// by their user ID on the data source, just like
+	// item owners, and are added to the timeline if
+	// they do not exist yet.
+	//
+	// Optional.
+	Persons []PersonRelation
 }
 
 // NewItemGraph returns a new node/graph.
@@ -282,13 +311,39 @@ func (ig *ItemGraph) Connect(node *ItemGraph, rel Relation) {
 // a data source's item IDs are globally unique
 // across accounts). The item IDs should be those
 // which are assigned/provided by the data source,
-// NOT a database row ID.
+// NOT a database row ID. If either item is not in
+// the timeline yet, the relation is kept until it
+// is, even if it is stored by a later operation.
 type RawRelation struct {
 	FromItemID string
 	ToItemID   string
 	Relation
 }
 
+// PersonRelation represents a relationship between an
+// item and a person, who is identified by their user ID
+// on the data source. The name is used only if the person
+// is not already known. The relationship goes from the
+// item to the person, unless FromPerson is true, as for
+// a person who likes the item.
+type PersonRelation struct {
+	UserID     string
+	Name       string
+	FromPerson bool
+	Relation
+}
+
+// SpanRelation represents a relationship between an
+// item and the items of a class whose timestamps are
+// between Start and End, inclusive. The items of the
+// same account in the span are related to the item
+// whether they are stored before or after it.
+type SpanRelation struct {
+	Start, End time.Time
+	Class      ItemClass
+	Relation
+}
+
 // Relation describes how two nodes in a graph are related.
 // It's essentially an edge on a graph.
 type Relation struct {
@@ -350,6 +405,27 @@ type CollectionItem struct {
 	itemRowID int64
 }
 
+// DayCollection returns the collection of the items of the day
+// of it, with it in it, for data sources that group items such
+// as plays by day. The day is that of the item's timestamp in
+// UTC, so that an item is in the same collection no matter the
+// time zone it is imported in. The collection's original ID is
+// idPrefix followed by the date, and its name is namePrefix
+// followed by the date. The item's position is the second of
+// the day of its timestamp, so that items are in the order
+// they happened.
+func DayCollection(idPrefix, namePrefix string, it Item) Collection {
+	ts := it.Timestamp().UTC()
+	name := namePrefix + ts.Format("January 2, 2006")
+	return Collection{
+		OriginalID: idPrefix + ts.Format("2006-01-02"),
+		Name:       &name,
+		Items: []CollectionItem{
+			{Item: it, Position: ts.Hour()*3600 + ts.Minute()*60 + ts.Second()},
+		},
+	}
+}
+
 // Metadata is a unified structure for storing
 // item metadata in the DB.
 type Metadata struct {
@@ -393,6 +469,24 @@ type Metadata struct {
 
 	Shares int // aka "Retweets" or "Reshares"
 	Likes  int
+
+	// Visits and activities (Google Location History so far)
+	Duration time.Duration
+	Distance int // meters
+
+	// Phone calls and text messages
+	Direction string // incoming, outgoing, missed, etc.
+
+	// Placeholder items stand in for items that are only known
+	// by reference, like the post another post replies to. They
+	// never replace items that are already in the timeline, and
+	// are replaced by the full item when it is processed.
+	Placeholder bool
+
+	// Songs, podcasts, and videos that were played; Name is the
+	// title, and Duration how long it was played for
+	Artist string // or podcast, or channel
+	Album  string
 }
 
 func (m *Metadata) encode() ([]byte, error) {
@@ -410,8 +504,44 @@ func (m *Metadata) decode(b []byte) error {
 	if b == nil {
 		return nil
 	}
+	if !isMetadataValue(b) {
+		// metadata used to be stored with the start of its
+		// value cut off, so it could not be read back; it is
+		// as empty now as it always was
+		*m = Metadata{}
+		return nil
+	}
 	fullGob := append(metadataGobPrefix, b...)
 	return gob.NewDecoder(bytes.NewReader(fullGob)).Decode(m)
 }
 
+// isMetadataValue returns true if b is a Metadata value as
+// encode returns it: a single gob message, which is its length
+// (a gob-encoded unsigned integer) followed by that many bytes,
+// starting with the ID of the Metadata type. Metadata that was
+// stored with the start of its value cut off is not.
+func isMetadataValue(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	n, b := uint64(b[0]), b[1:]
+	if n >= 0x80 {
+		// the first byte is the negated count of the bytes that follow
+		size := int(-int8(n))
+		if size < 1 || size > 8 || len(b) < size {
+			return false
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | uint64(c)
+		}
+		b = b[size:]
+	}
+	return uint64(len(b)) == n && bytes.HasPrefix(b, metadataTypeID)
+}
+
 var metadataGobPrefix []byte
+
+// metadataTypeID is the gob type ID of Metadata,
+// with which the encodings of its values start.
+var metadataTypeID []byte
@@ -0,0 +1,54 @@
+package timeliner
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataDecode(t *testing.T) {
+	m := Metadata{GeneralArea: "Somewhere", Altitude: 1200}
+	b, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Metadata
+	err = decoded.decode(b)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("expected %+v, got %+v", m, decoded)
+	}
+
+	// metadata used to be stored with the start of its value cut
+	// off, as long as the encoding of empty metadata's value
+	buf := new(bytes.Buffer)
+	err = gob.NewEncoder(buf).Encode(Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := buf.Len()
+	buf.Reset()
+	err = gob.NewEncoder(buf).Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded = Metadata{GeneralArea: "Elsewhere"}
+	err = decoded.decode(buf.Bytes()[cut:])
+	if err != nil {
+		t.Fatalf("decoding legacy metadata: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, Metadata{}) {
+		t.Errorf("expected legacy metadata to be empty, got %+v", decoded)
+	}
+
+	// other metadata that can't be decoded is an error
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)-1] = 0xff
+	err = decoded.decode(corrupt)
+	if err == nil {
+		t.Error("expected error decoding corrupt metadata")
+	}
+}
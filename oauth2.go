@@ -28,27 +28,62 @@ func (acc Account) NewOAuth2HTTPClient() (*http.Client, error) {
 		return nil, fmt.Errorf("OAuth2 token is empty: %+v", tkn)
 	}
 
+	src, err := acc.NewOAuth2TokenSource(acc.ds.OAuth2)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(context.Background(), src), nil
+}
+
+// NewOAuth2TokenSource returns a token source which provides OAuth2
+// tokens for the account acc from the provider described by oc. It is
+// for data sources that use OAuth2 in a way that depends on how the
+// account is configured, and which therefore cannot declare it in
+// their DataSource.OAuth2 field (for example, when the tokens are not
+// used in HTTP requests, or when OAuth2 is optional). If the account
+// does not have a token yet, one is obtained from the user first.
+// Refreshed tokens are stored with the account.
+func (acc Account) NewOAuth2TokenSource(oc OAuth2) (oauth2.TokenSource, error) {
+	var tkn *oauth2.Token
+	if len(acc.authorization) > 0 {
+		err := UnmarshalGob(acc.authorization, &tkn)
+		if err != nil {
+			return nil, fmt.Errorf("gob-decoding OAuth2 token: %v", err)
+		}
+	}
+
 	// load the service's "oauth app", which can provide both tokens and
-	// oauth configs -- in this case, we need the oauth config; we should
-	// already have a token
-	oapp, err := OAuth2AppSource(acc.ds.OAuth2.ProviderID, acc.ds.OAuth2.Scopes)
+	// oauth configs
+	oapp, err := OAuth2AppSource(oc.ProviderID, oc.Scopes)
 	if err != nil {
 		return nil, fmt.Errorf("getting token source for %s: %v", acc.DataSourceID, err)
 	}
 
-	// obtain a token source from the oauth's config so that it can keep
-	// the token refreshed if it expires
-	src := oapp.TokenSource(context.Background(), tkn)
+	// get the initial token, if we don't have one yet
+	if tkn == nil || tkn.AccessToken == "" {
+		tkn, err = oapp.InitialToken()
+		if err != nil {
+			return nil, fmt.Errorf("getting token from source: %v", err)
+		}
+		authBytes, err := MarshalGob(tkn)
+		if err != nil {
+			return nil, fmt.Errorf("gob-encoding new OAuth2 token: %v", err)
+		}
+		_, err = acc.t.db.Exec(`UPDATE accounts SET authorization=? WHERE id=?`, authBytes, acc.ID)
+		if err != nil {
+			return nil, fmt.Errorf("storing new OAuth2 token: %v", err)
+		}
+	}
 
-	// finally, create an HTTP client that authenticates using the token,
-	// but wrapping the underlying token source so we can persist any
-	// changes to the database
-	return oauth2.NewClient(context.Background(), &persistedTokenSource{
+	// obtain a token source from the oauth's config so that it can keep
+	// the token refreshed if it expires, but wrap it so we can persist
+	// any changes to the database
+	return &persistedTokenSource{
 		tl:        acc.t,
-		ts:        src,
+		ts:        oapp.TokenSource(context.Background(), tkn),
 		accountID: acc.ID,
 		token:     tkn,
-	}), nil
+	}, nil
 }
 
 // authorizeWithOAuth2 gets an initial OAuth2 token from the user.
@@ -8,6 +8,12 @@ import (
 // getPerson returns the person mapped to userID on service.
 // If the person does not exist, it is created.
 func (t *Timeline) getPerson(dataSourceID, userID, name string) (Person, error) {
+	// prevent the same person from being created twice
+	// if items are being processed concurrently
+	personLockID := dataSourceID + "_" + userID
+	personLocks.Lock(personLockID)
+	defer personLocks.Unlock(personLockID)
+
 	// first, load the person
 	var p Person
 	err := t.db.QueryRow(`SELECT persons.id, persons.name
@@ -27,12 +33,30 @@ func (t *Timeline) getPerson(dataSourceID, userID, name string) (Person, error)
 		if err != nil {
 			return Person{}, fmt.Errorf("getting person ID: %v", err)
 		}
-		_, err = t.db.Exec(`INSERT OR IGNORE INTO person_identities
+		res, err = t.db.Exec(`INSERT OR IGNORE INTO person_identities
 			(person_id, data_source_id, user_id) VALUES (?, ?, ?)`,
 			p.ID, dataSourceID, userID)
 		if err != nil {
 			return Person{}, fmt.Errorf("adding new person identity mapping: %v", err)
 		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			// another process, writing to another account of the
+			// same data source, has just added this person, so
+			// the person added here is not needed after all
+			_, err = t.db.Exec(`DELETE FROM persons WHERE id=?`, p.ID)
+			if err != nil {
+				return Person{}, fmt.Errorf("deleting duplicate person: %v", err)
+			}
+			err = t.db.QueryRow(`SELECT persons.id, persons.name
+				FROM persons, person_identities
+				WHERE person_identities.data_source_id=?
+					AND person_identities.user_id=?
+					AND persons.id = person_identities.person_id
+				LIMIT 1`, dataSourceID, userID).Scan(&p.ID, &p.Name)
+			if err != nil {
+				return Person{}, fmt.Errorf("selecting person identity: %v", err)
+			}
+		}
 	} else if err != nil {
 		return Person{}, fmt.Errorf("selecting person identity: %v", err)
 	}
@@ -73,3 +97,8 @@ type PersonIdentity struct {
 	DataSourceID string
 	UserID       string
 }
+
+// personLocks is used to ensure that a person
+// is not loaded and created more than once at
+// a time.
+var personLocks = newMapMutex()
@@ -2,6 +2,7 @@ package timeliner
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
@@ -10,27 +11,74 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // beginProcessing starts workers to process items that are
 // obtained from ac. It returns a WaitGroup which blocks until
 // all workers have finished, and a channel into which the
-// service should pipe its items.
-func (wc *WrappedClient) beginProcessing(cc concurrentCuckoo, reprocess, integrity bool) (*sync.WaitGroup, chan<- *ItemGraph) {
+// service should pipe its items. If ctx is cancelled, the
+// workers keep draining the channel (so the service is not
+// blocked) but stop processing items.
+//
+// Checkpoints and sync state saved while the items are being
+// processed are passed along in order with them (see afterItems),
+// and are only saved once all the items received before them
+// have been processed. If any of those items was not processed
+// because ctx was cancelled, they are not saved at all, so that
+// a resumed listing does not start after items that were never
+// stored.
+func (wc *WrappedClient) beginProcessing(ctx context.Context, cc concurrentCuckoo, reprocess, integrity bool) (*sync.WaitGroup, chan<- *ItemGraph) {
 	wg := new(sync.WaitGroup)
 	ch := make(chan *ItemGraph)
+	work := make(chan *ItemGraph)
+
+	var processing sync.WaitGroup // items received but not yet processed
+	l := &listing{
+		afters: make(chan func(interrupted bool)),
+		done:   make(chan struct{}),
+	}
+
+	wg.Add(1)
+	go func(items <-chan *ItemGraph) {
+		defer wg.Done()
+		defer close(work)
+		for items != nil {
+			select {
+			case ig, ok := <-items:
+				if !ok {
+					items = nil
+					break
+				}
+				processing.Add(1)
+				work <- ig
+			case fn := <-l.afters:
+				processing.Wait()
+				fn(atomic.LoadInt32(&l.interrupted) == 1)
+			}
+		}
+		processing.Wait()
+		close(l.done)
+	}(ch)
 
 	const workers = 2 // TODO: Make configurable?
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			for ig := range ch {
+			for ig := range work {
 				if ig == nil {
+					processing.Done()
+					continue
+				}
+				if ctx.Err() != nil {
+					atomic.StoreInt32(&l.interrupted, 1)
+					processing.Done()
 					continue
 				}
 				_, err := wc.processItemGraph(ig, &recursiveState{
+					ctx:            ctx,
 					timestamp:      time.Now(),
 					reprocess:      reprocess,
 					integrityCheck: integrity,
@@ -38,18 +86,69 @@ func (wc *WrappedClient) beginProcessing(cc concurrentCuckoo, reprocess, integri
 					idmap:          make(map[string]int64),
 					cuckoo:         cc,
 				})
-				if err != nil {
+				if err != nil && ctx.Err() != nil {
+					atomic.StoreInt32(&l.interrupted, 1)
+				} else if err != nil {
 					log.Printf("[ERROR][%s/%s] Processing item graph: %v",
 						wc.ds.ID, wc.acc.UserID, err)
 				}
+				processing.Done()
 			}
 		}(i)
 	}
 
+	wc.listing = l
+
 	return wg, ch
 }
 
+// listing is the state of the items being listed
+// and processed by an operation.
+type listing struct {
+	// functions to call once the items sent
+	// before them have been processed
+	afters chan func(interrupted bool)
+
+	// closed once ListItems has closed its channel
+	// and all of the items have been processed
+	done chan struct{}
+
+	// set to 1 if an item was not processed because
+	// the operation was cancelled
+	interrupted int32
+}
+
+// finished returns true if ListItems has closed its
+// channel and all of the items have been processed.
+func (l *listing) finished() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// afterItems calls fn after the items that have been sent for
+// processing so far are processed, telling it whether any of
+// them was not processed because the operation was cancelled.
+// If no items are being processed, or if ListItems has already
+// closed its channel, fn is called once they all have been.
+func (wc *WrappedClient) afterItems(fn func(interrupted bool)) {
+	l := wc.listing
+	if l == nil {
+		fn(false)
+		return
+	}
+	select {
+	case l.afters <- fn:
+	case <-l.done:
+		fn(atomic.LoadInt32(&l.interrupted) == 1)
+	}
+}
+
 type recursiveState struct {
+	ctx            context.Context
 	timestamp      time.Time
 	reprocess      bool
 	integrityCheck bool
@@ -85,6 +184,19 @@ func (wc *WrappedClient) processItemGraph(ig *ItemGraph, state *recursiveState)
 			return 0, fmt.Errorf("processing node of item graph: %v", err)
 		}
 
+		// relate it to the items whose spans it is in, and
+		// to those whose relations to it were waiting for it
+		if igRowID != 0 {
+			err = wc.relateToSpans(igRowID)
+			if err != nil {
+				return 0, fmt.Errorf("relating item to spans: %v (item=%d)", err, igRowID)
+			}
+			err = wc.resolvePendingRelations(ig.Node.ID())
+			if err != nil {
+				return 0, fmt.Errorf("resolving pending relationships: %v (item=%d)", err, igRowID)
+			}
+		}
+
 		// mark this node as visited
 		state.seen[ig] = igRowID
 
@@ -131,7 +243,7 @@ func (wc *WrappedClient) processItemGraph(ig *ItemGraph, state *recursiveState)
 			coll.Items[i].itemRowID = state.idmap[it.Item.ID()]
 		}
 
-		err := wc.processCollection(coll, state.timestamp)
+		err := wc.processCollection(state.ctx, coll, state.timestamp)
 		if err != nil {
 			return 0, fmt.Errorf("processing collection: %v (original_id=%s)", err, coll.OriginalID)
 		}
@@ -141,34 +253,206 @@ func (wc *WrappedClient) processItemGraph(ig *ItemGraph, state *recursiveState)
 	for _, rr := range ig.Relations {
 		// get each item's row ID from their data source item ID
 		fromItemRowID, err := wc.itemRowIDFromOriginalID(rr.FromItemID)
-		if err == sql.ErrNoRows {
-			continue // item does not exist in timeline; skip this relation
-		}
-		if err != nil {
+		if err != nil && err != sql.ErrNoRows {
 			return 0, fmt.Errorf("querying 'from' item row ID: %v", err)
 		}
 		toItemRowID, err := wc.itemRowIDFromOriginalID(rr.ToItemID)
-		if err == sql.ErrNoRows {
-			continue // item does not exist in timeline; skip this relation
-		}
-		if err != nil {
+		if err != nil && err != sql.ErrNoRows {
 			return 0, fmt.Errorf("querying 'to' item row ID: %v", err)
 		}
+		if fromItemRowID == 0 || toItemRowID == 0 {
+			// an item is not in the timeline (yet); keep the
+			// relation until it is, which may be any moment
+			err = wc.storePendingRelation(rr)
+			if err != nil {
+				return 0, fmt.Errorf("storing pending relationship: %v (from=%s to=%s label=%v)",
+					err, rr.FromItemID, rr.ToItemID, rr.Label)
+			}
+			continue
+		}
 
 		// store the relation
 		_, err = wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
 					(from_item_id, to_item_id, directed, label)
 					VALUES (?, ?, ?, ?)`,
-			fromItemRowID, toItemRowID, rr.Bidirectional, rr.Label)
+			fromItemRowID, toItemRowID, !rr.Bidirectional, rr.Label)
 		if err != nil {
 			return 0, fmt.Errorf("storing raw item relationship: %v (from_item=%d to_item=%d directed=%t label=%v)",
 				err, fromItemRowID, toItemRowID, !rr.Bidirectional, rr.Label)
 		}
 	}
 
+	// process person relations, if any
+	if ig.Node != nil {
+		for _, pr := range ig.Persons {
+			person, err := wc.tl.getPerson(wc.ds.ID, pr.UserID, pr.Name)
+			if err != nil {
+				return 0, fmt.Errorf("getting related person: %v (user_id=%s)", err, pr.UserID)
+			}
+			if pr.FromPerson {
+				_, err = wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
+					(from_person_id, to_item_id, directed, label)
+					VALUES (?, ?, ?, ?)`,
+					person.ID, igRowID, !pr.Bidirectional, pr.Label)
+				if err != nil {
+					return 0, fmt.Errorf("storing person relationship: %v (from_person=%d to_item=%d directed=%t label=%v)",
+						err, person.ID, igRowID, !pr.Bidirectional, pr.Label)
+				}
+				continue
+			}
+			_, err = wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
+					(from_item_id, to_person_id, directed, label)
+					VALUES (?, ?, ?, ?)`,
+				igRowID, person.ID, !pr.Bidirectional, pr.Label)
+			if err != nil {
+				return 0, fmt.Errorf("storing person relationship: %v (from_item=%d to_person=%d directed=%t label=%v)",
+					err, igRowID, person.ID, !pr.Bidirectional, pr.Label)
+			}
+		}
+	}
+
+	// process span relations, if any
+	if ig.Node != nil {
+		for _, sr := range ig.SpanRelations {
+			err := wc.processSpanRelation(igRowID, sr)
+			if err != nil {
+				return 0, fmt.Errorf("processing span relation: %v (from_item=%d label=%v)",
+					err, igRowID, sr.Label)
+			}
+		}
+	}
+
 	return igRowID, nil
 }
 
+// processSpanRelation relates the item with row ID fromItemRowID
+// to the existing items in the account that are described by sr.
+// The span is stored, so that items in it which are stored later
+// are related to the item too (see relateToSpans).
+func (wc *WrappedClient) processSpanRelation(fromItemRowID int64, sr SpanRelation) error {
+	_, err := wc.tl.db.Exec(`INSERT INTO item_spans
+			(item_id, class, start_time, end_time, directed, label)
+			VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (item_id, class, label) DO UPDATE
+			SET start_time=?, end_time=?, directed=?`,
+		fromItemRowID, sr.Class, sr.Start.Unix(), sr.End.Unix(), !sr.Bidirectional, sr.Label,
+		sr.Start.Unix(), sr.End.Unix(), !sr.Bidirectional)
+	if err != nil {
+		return fmt.Errorf("storing span: %v", err)
+	}
+
+	rows, err := wc.tl.db.Query(`SELECT id FROM items
+		WHERE account_id=? AND class=? AND timestamp >= ? AND timestamp <= ? AND id != ?`,
+		wc.acc.ID, sr.Class, sr.Start.Unix(), sr.End.Unix(), fromItemRowID)
+	if err != nil {
+		return fmt.Errorf("querying items in span: %v", err)
+	}
+	var toItemRowIDs []int64
+	for rows.Next() {
+		var rowID int64
+		err := rows.Scan(&rowID)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning item row ID: %v", err)
+		}
+		toItemRowIDs = append(toItemRowIDs, rowID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating items in span: %v", err)
+	}
+
+	for _, toItemRowID := range toItemRowIDs {
+		_, err = wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
+					(from_item_id, to_item_id, directed, label)
+					VALUES (?, ?, ?, ?)`,
+			fromItemRowID, toItemRowID, !sr.Bidirectional, sr.Label)
+		if err != nil {
+			return fmt.Errorf("storing relationship: %v (to_item=%d)", err, toItemRowID)
+		}
+	}
+
+	return nil
+}
+
+// storePendingRelation stores rr, one of whose items is
+// not in the timeline, so that it can be made once both
+// are. Since the missing item may have been stored in the
+// meantime, the relation is then resolved right away if
+// it can be.
+func (wc *WrappedClient) storePendingRelation(rr RawRelation) error {
+	_, err := wc.tl.db.Exec(`INSERT INTO pending_relationships
+			(data_source_id, from_original_id, to_original_id, directed, label)
+			VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (data_source_id, from_original_id, to_original_id, label) DO UPDATE
+			SET directed=?`,
+		wc.ds.ID, rr.FromItemID, rr.ToItemID, !rr.Bidirectional, rr.Label, !rr.Bidirectional)
+	if err != nil {
+		return err
+	}
+	return wc.resolvePendingRelations(rr.FromItemID)
+}
+
+// resolvePendingRelations makes the pending relationships
+// of the item with the given original ID whose items are
+// both in the timeline now, and forgets them.
+func (wc *WrappedClient) resolvePendingRelations(originalID string) error {
+	_, err := wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
+			(from_item_id, to_item_id, directed, label)
+		SELECT from_items.id, to_items.id, pending.directed, pending.label
+		FROM pending_relationships AS pending,
+			items AS from_items, accounts AS from_accounts,
+			items AS to_items, accounts AS to_accounts
+		WHERE pending.data_source_id=?
+			AND (pending.from_original_id=? OR pending.to_original_id=?)
+			AND from_items.original_id=pending.from_original_id
+			AND from_accounts.id=from_items.account_id
+			AND from_accounts.data_source_id=pending.data_source_id
+			AND to_items.original_id=pending.to_original_id
+			AND to_accounts.id=to_items.account_id
+			AND to_accounts.data_source_id=pending.data_source_id`,
+		wc.ds.ID, originalID, originalID)
+	if err != nil {
+		return fmt.Errorf("storing relationships: %v", err)
+	}
+	_, err = wc.tl.db.Exec(`DELETE FROM pending_relationships
+		WHERE data_source_id=?
+			AND (from_original_id=? OR to_original_id=?)
+			AND EXISTS(SELECT 1 FROM items, accounts
+				WHERE items.original_id=pending_relationships.from_original_id
+					AND accounts.id=items.account_id
+					AND accounts.data_source_id=pending_relationships.data_source_id)
+			AND EXISTS(SELECT 1 FROM items, accounts
+				WHERE items.original_id=pending_relationships.to_original_id
+					AND accounts.id=items.account_id
+					AND accounts.data_source_id=pending_relationships.data_source_id)`,
+		wc.ds.ID, originalID, originalID)
+	if err != nil {
+		return fmt.Errorf("deleting resolved relationships: %v", err)
+	}
+	return nil
+}
+
+// relateToSpans relates the item with row ID itemRowID to the items
+// of the same account whose stored spans cover it, which may have
+// been stored before it. Together with processSpanRelation, this
+// relates items to spans no matter which of them is stored first.
+func (wc *WrappedClient) relateToSpans(itemRowID int64) error {
+	_, err := wc.tl.db.Exec(`INSERT OR IGNORE INTO relationships
+			(from_item_id, to_item_id, directed, label)
+		SELECT item_spans.item_id, items.id, item_spans.directed, item_spans.label
+		FROM items, item_spans, items AS span_items
+		WHERE items.id=?
+			AND item_spans.class=items.class
+			AND item_spans.start_time <= items.timestamp
+			AND item_spans.end_time >= items.timestamp
+			AND item_spans.item_id != items.id
+			AND span_items.id=item_spans.item_id
+			AND span_items.account_id=items.account_id`,
+		itemRowID)
+	return err
+}
+
 func (wc *WrappedClient) processSingleItemGraphNode(it Item, state *recursiveState) (int64, error) {
 	if itemID := it.ID(); itemID != "" && state.cuckoo.Filter != nil {
 		state.cuckoo.Lock()
@@ -176,7 +460,7 @@ func (wc *WrappedClient) processSingleItemGraphNode(it Item, state *recursiveSta
 		state.cuckoo.Unlock()
 	}
 
-	itemRowID, err := wc.storeItemFromService(it, state.timestamp, state.reprocess, state.integrityCheck)
+	itemRowID, err := wc.storeItemFromService(state.ctx, it, state.timestamp, state.reprocess, state.integrityCheck)
 	if err != nil {
 		return itemRowID, err
 	}
@@ -196,7 +480,7 @@ func (wc *WrappedClient) processSingleItemGraphNode(it Item, state *recursiveSta
 	return itemRowID, nil
 }
 
-func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, reprocess, integrity bool) (int64, error) {
+func (wc *WrappedClient) storeItemFromService(ctx context.Context, it Item, timestamp time.Time, reprocess, integrity bool) (itemRowID int64, err error) {
 	if it == nil {
 		return 0, nil
 	}
@@ -224,7 +508,9 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 	}
 
 	// if the item is already in our DB, load it
-	var ir ItemRow
+	var ir, prev ItemRow
+	var bakFile, revDataFile string
+	var keepDataFile bool
 	if itemOriginalID != "" {
 		ir, err = wc.loadItemRow(wc.acc.ID, itemOriginalID)
 		if err != nil {
@@ -237,24 +523,50 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 				return ir.ID, nil
 			}
 
+			// remember what the item looked like before, so
+			// that we can record a revision if it changes
+			prev = ir
+
+			// if the service reports that the data file has not
+			// changed since it was completely downloaded, keep
+			// it instead of downloading it again, so only the
+			// rest of the item is updated
+			if rc != nil && !integrity && dataFileUnchanged(it, ir) {
+				rc = nil
+				keepDataFile = true
+			}
+
 			// at this point, we will be replacing the existing
 			// file, so move it temporarily as a safe measure,
 			// and also because our filename-generator will not
 			// allow a file to be overwritten, but we want to
-			// replace the existing file in this case
+			// replace the existing file in this case; if prior
+			// data files are to be kept, move it to where it
+			// would be kept in the first place
 			if ir.DataFile != nil && rc != nil {
 				origFile := wc.tl.fullpath(*ir.DataFile)
-				bakFile := wc.tl.fullpath(*ir.DataFile + ".bak")
+				bakFile = wc.tl.fullpath(*ir.DataFile + ".bak")
+				if wc.tl.KeepRevisionDataFiles {
+					revDataFile = wc.tl.revisionDataFileName(*ir.DataFile, timestamp)
+					bakFile = wc.tl.fullpath(revDataFile)
+				}
 				err = os.Rename(origFile, bakFile)
-				if err != nil && !os.IsNotExist(err) {
+				if os.IsNotExist(err) {
+					bakFile = ""
+				} else if err != nil {
 					return 0, fmt.Errorf("temporarily moving data file: %v", err)
 				}
 
 				// if this function returns with an error,
 				// restore the original file in case it was
 				// partially written or something; otherwise
-				// delete the old file altogether
+				// delete the old file altogether, unless it
+				// was kept with a revision (in which case
+				// bakFile will have been cleared)
 				defer func() {
+					if bakFile == "" {
+						return
+					}
 					if err == nil {
 						err := os.Remove(bakFile)
 						if err != nil && !os.IsNotExist(err) {
@@ -279,6 +591,8 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 			return 0, fmt.Errorf("opening output data file: %v", err)
 		}
 		defer datafile.Close()
+	} else if keepDataFile {
+		dataFileName = prev.DataFile
 	}
 
 	// prepare the item's DB row values
@@ -308,19 +622,22 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 
 	// get the item's row ID (this works regardless of whether
 	// the last query was an insert or an update)
-	var itemRowID int64
 	err = wc.tl.db.QueryRow(`SELECT id FROM items
 		WHERE account_id=? AND original_id=? LIMIT 1`,
 		ir.AccountID, ir.OriginalID).Scan(&itemRowID)
-	if err != nil && err != sql.ErrNoRows {
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
 		return 0, fmt.Errorf("getting item row ID: %v", err)
 	}
+	ir.ID = itemRowID
 
 	// if there is a data file, download it and compute its checksum;
 	// then update the item's row in the DB with its name and checksum
 	if rc != nil && dataFileName != nil {
 		h := sha256.New()
-		err := wc.tl.downloadItemFile(rc, datafile, h)
+		err = wc.tl.downloadItemFile(contextReader{ctx, rc}, datafile, h)
 		if err != nil {
 			return 0, fmt.Errorf("downloading data file: %v (item_id=%v)", err, itemRowID)
 		}
@@ -343,6 +660,24 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 			log.Printf("[ERROR][%s/%s] Updating item's data file hash in DB: %v; cleaning up data file: %s (item_id=%d)",
 				wc.ds.ID, wc.acc.UserID, err, datafile.Name(), itemRowID)
 			os.Remove(wc.tl.fullpath(*dataFileName))
+			err = nil
+		} else {
+			ir.DataHash = &b64hash
+		}
+	}
+
+	// if this replaced an existing item, record what changed
+	if prev.ID > 0 {
+		var keptDataFile *string
+		if revDataFile != "" && bakFile != "" && !stringPtrsEqual(prev.DataHash, ir.DataHash) {
+			keptDataFile = &revDataFile
+		}
+		recorded, err := wc.tl.saveItemRevision(prev, ir, keptDataFile, timestamp)
+		if err != nil {
+			log.Printf("[ERROR][%s/%s] Recording item revision: %v (item_id=%d)",
+				wc.ds.ID, wc.acc.UserID, err, itemRowID)
+		} else if recorded && keptDataFile != nil {
+			bakFile = "" // keep the prior data file with the revision
 		}
 	}
 
@@ -350,6 +685,15 @@ func (wc *WrappedClient) storeItemFromService(it Item, timestamp time.Time, repr
 }
 
 func (wc *WrappedClient) shouldProcessExistingItem(it Item, dbItem ItemRow, reprocess, integrity bool) bool {
+	// placeholders never replace items, and are
+	// always replaced by the items they stand in for
+	if meta, err := it.Metadata(); err == nil && meta != nil && meta.Placeholder {
+		return false
+	}
+	if dbItem.Metadata != nil && dbItem.Metadata.Placeholder && dbItem.Modified == nil {
+		return true
+	}
+
 	// if integrity check is enabled and checksum mismatches, always reprocess
 	if integrity && dbItem.DataFile != nil && dbItem.DataHash != nil {
 		datafile, err := os.Open(wc.tl.fullpath(*dbItem.DataFile))
@@ -399,6 +743,18 @@ func (wc *WrappedClient) shouldProcessExistingItem(it Item, dbItem ItemRow, repr
 	return reprocess
 }
 
+// dataFileUnchanged returns true if the data file of dbItem was
+// completely downloaded and the service reports the same hash
+// for the file of it as when it was.
+func dataFileUnchanged(it Item, dbItem ItemRow) bool {
+	if dbItem.DataFile == nil || dbItem.DataHash == nil ||
+		dbItem.Metadata == nil || dbItem.Metadata.ServiceHash == nil {
+		return false
+	}
+	serviceHash := it.DataFileHash()
+	return serviceHash != nil && bytes.Equal(serviceHash, dbItem.Metadata.ServiceHash)
+}
+
 func (wc *WrappedClient) fillItemRow(ir *ItemRow, it Item, timestamp time.Time, canonicalDataFileName *string) error {
 	// unpack the item's information into values to use in the row
 
@@ -434,6 +790,9 @@ func (wc *WrappedClient) fillItemRow(ir *ItemRow, it Item, timestamp time.Time,
 		return fmt.Errorf("getting item metadata: %v", err)
 	}
 	if serviceHash := it.DataFileHash(); serviceHash != nil {
+		if metadata == nil {
+			metadata = new(Metadata)
+		}
 		metadata.ServiceHash = serviceHash
 	}
 	var metaGob []byte
@@ -460,7 +819,7 @@ func (wc *WrappedClient) fillItemRow(ir *ItemRow, it Item, timestamp time.Time,
 	return nil
 }
 
-func (wc *WrappedClient) processCollection(coll Collection, timestamp time.Time) error {
+func (wc *WrappedClient) processCollection(ctx context.Context, coll Collection, timestamp time.Time) error {
 	_, err := wc.tl.db.Exec(`INSERT INTO collections
 		(account_id, original_id, name) VALUES (?, ?, ?)
 		ON CONFLICT (account_id, original_id)
@@ -484,7 +843,7 @@ func (wc *WrappedClient) processCollection(coll Collection, timestamp time.Time)
 	// (TODO: could batch this for faster inserts)
 	for _, cit := range coll.Items {
 		if cit.itemRowID == 0 {
-			itID, err := wc.storeItemFromService(cit.Item, timestamp, false, false) // never reprocess or check integrity here
+			itID, err := wc.storeItemFromService(ctx, cit.Item, timestamp, false, false) // never reprocess or check integrity here
 			if err != nil {
 				return fmt.Errorf("adding item from collection to storage: %v", err)
 			}
@@ -529,6 +888,7 @@ func (wc *WrappedClient) loadItemRow(accountID int64, originalID string) (ItemRo
 	if err != nil {
 		return ItemRow{}, fmt.Errorf("gob-decoding metadata: %v", err)
 	}
+	ir.metaGob = metadataGob
 
 	ir.Timestamp = time.Unix(ts, 0)
 	ir.Stored = time.Unix(stored, 0)
@@ -558,3 +918,18 @@ func (wc *WrappedClient) itemRowIDFromOriginalID(originalID string) (int64, erro
 // itemLocks is used to ensure that an item
 // is not processed twice at the same time.
 var itemLocks = newMapMutex()
+
+// contextReader is an io.Reader which stops
+// reading once its context is cancelled, so
+// that downloads can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,372 @@
+package timeliner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	err := RegisterDataSource(DataSource{
+		ID:   testDataSourceID,
+		Name: "Test",
+		NewClient: func(acc Account) (Client, error) {
+			return new(testClient), nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+const testDataSourceID = "test"
+
+// TestResumeAfterCancel cancels a listing part way through, like
+// on SIGINT, then resumes it from its checkpoint, and checks that
+// every item ends up in the timeline with its data file.
+func TestResumeAfterCancel(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		cancelAt   int  // number of the item before which ctx is cancelled
+		inDownload bool // if true, ctx is cancelled while its data file is read instead
+	}{
+		{name: "between items", cancelAt: 3},
+		{name: "during download", cancelAt: 3, inDownload: true},
+		{name: "before first item", cancelAt: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tl.Close()
+			err = tl.AddAccount(testDataSourceID, "user")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err = importTestItems(tl, ctx, &testClient{
+				count:      5,
+				cancelAt:   tc.cancelAt,
+				inDownload: tc.inDownload,
+				cancel:     cancel,
+			})
+			if err != ErrInterrupted {
+				t.Fatalf("expected interruption, got: %v", err)
+			}
+
+			err = importTestItems(tl, context.Background(), &testClient{count: 5})
+			if err != nil {
+				t.Fatalf("resuming: %v", err)
+			}
+
+			for i := 1; i <= 5; i++ {
+				var dataHash *string
+				err := tl.db.QueryRow(`SELECT data_hash FROM items WHERE original_id=?`,
+					testItemID(i)).Scan(&dataHash)
+				if err != nil {
+					t.Errorf("item %d: %v", i, err)
+					continue
+				}
+				if dataHash == nil {
+					t.Errorf("item %d: data file was not completely downloaded", i)
+				}
+			}
+
+			var checkpoint []byte
+			err = tl.db.QueryRow(`SELECT checkpoint FROM accounts`).Scan(&checkpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint != nil {
+				t.Errorf("expected checkpoint to be cleared after success, got %v", checkpoint)
+			}
+		})
+	}
+}
+
+// TestAfterListing checks that a data source can still save a
+// checkpoint and wait for its items after it has closed its channel.
+func TestAfterListing(t *testing.T) {
+	tl, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	err = tl.AddAccount(testDataSourceID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc, err := tl.NewClient(testDataSourceID, "user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := new(lateClient)
+	wc.Client = cl
+
+	err = wc.Import(context.Background(), "test", false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.err != nil {
+		t.Fatal(cl.err)
+	}
+	if !cl.stored {
+		t.Error("expected item to be stored when the AfterItems function was called")
+	}
+	var checkpoint []byte
+	err = tl.db.QueryRow(`SELECT checkpoint FROM accounts`).Scan(&checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != nil {
+		t.Errorf("expected no checkpoint after success, got %v", checkpoint)
+	}
+}
+
+// lateClient lists one item, and then, after closing its
+// channel, saves a checkpoint and checks that the item is
+// stored once it has been processed.
+type lateClient struct {
+	stored bool
+	err    error
+}
+
+func (c *lateClient) ListItems(ctx context.Context, itemChan chan<- *ItemGraph, opt Options) error {
+	itemChan <- NewItemGraph(&testItem{num: 1})
+	close(itemChan)
+
+	cp, err := MarshalGob(1)
+	if err != nil {
+		return err
+	}
+	Checkpoint(ctx, cp)
+
+	AfterItems(ctx, func() {
+		c.stored, c.err = OwnItemStored(ctx, testItemID(1))
+	})
+	return nil
+}
+
+// TestSpanRelations checks that the items in a span are related
+// to its item whether they are imported before or after it.
+func TestSpanRelations(t *testing.T) {
+	for _, spanFirst := range []bool{true, false} {
+		tl, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tl.Close()
+		err = tl.AddAccount(testDataSourceID, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		span := NewItemGraph(&testItem{num: 1})
+		span.SpanRelations = []SpanRelation{{
+			Start:    time.Unix(2*3600, 0),
+			End:      time.Unix(3*3600, 0),
+			Class:    ClassPost,
+			Relation: Relation{Label: "includes"},
+		}}
+		imports := []*ItemGraph{span, NewItemGraph(&testItem{num: 2})}
+		if !spanFirst {
+			imports[0], imports[1] = imports[1], imports[0]
+		}
+		for _, ig := range imports {
+			wc, err := tl.NewClient(testDataSourceID, "user", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wc.Client = &graphClient{graphs: []*ItemGraph{ig}}
+			err = wc.Import(context.Background(), "test", false, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var related bool
+		err = tl.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM relationships, items AS from_items, items AS to_items
+			WHERE from_items.id=relationships.from_item_id AND to_items.id=relationships.to_item_id
+				AND from_items.original_id=? AND to_items.original_id=? AND relationships.label=?)`,
+			testItemID(1), testItemID(2), "includes").Scan(&related)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !related {
+			t.Errorf("span first=%t: expected item in span to be related to the span's item", spanFirst)
+		}
+	}
+}
+
+// TestPendingRelations checks that a raw relation to an item
+// which is not in the timeline yet is made once the item is
+// stored by a later import.
+func TestPendingRelations(t *testing.T) {
+	tl, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	err = tl.AddAccount(testDataSourceID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := NewItemGraph(&testItem{num: 2})
+	reply.Relations = []RawRelation{{
+		FromItemID: testItemID(2),
+		ToItemID:   testItemID(1),
+		Relation:   RelReplyTo,
+	}}
+	for _, ig := range []*ItemGraph{reply, NewItemGraph(&testItem{num: 1})} {
+		wc, err := tl.NewClient(testDataSourceID, "user", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc.Client = &graphClient{graphs: []*ItemGraph{ig}}
+		err = wc.Import(context.Background(), "test", false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var related bool
+	err = tl.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM relationships, items AS from_items, items AS to_items
+		WHERE from_items.id=relationships.from_item_id AND to_items.id=relationships.to_item_id
+			AND from_items.original_id=? AND to_items.original_id=? AND relationships.label=?)`,
+		testItemID(2), testItemID(1), RelReplyTo.Label).Scan(&related)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !related {
+		t.Error("expected reply to be related to the item it replies to")
+	}
+	var pending int
+	err = tl.db.QueryRow(`SELECT count(*) FROM pending_relationships`).Scan(&pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("expected no pending relationships, got %d", pending)
+	}
+}
+
+// graphClient lists the given item graphs.
+type graphClient struct {
+	graphs []*ItemGraph
+}
+
+func (c *graphClient) ListItems(ctx context.Context, itemChan chan<- *ItemGraph, opt Options) error {
+	defer close(itemChan)
+	for _, ig := range c.graphs {
+		itemChan <- ig
+	}
+	return nil
+}
+
+// importTestItems imports the items listed by cl into tl,
+// as the test account, like resuming from the command line.
+func importTestItems(tl *Timeline, ctx context.Context, cl *testClient) error {
+	wc, err := tl.NewClient(testDataSourceID, "user", nil)
+	if err != nil {
+		return err
+	}
+	wc.Client = cl
+	return wc.Import(ctx, "test", false, false, false)
+}
+
+// testClient lists items numbered 1 to count, resuming after the
+// last item in the checkpoint, which is saved after each item.
+type testClient struct {
+	count      int
+	cancelAt   int
+	inDownload bool
+	cancel     context.CancelFunc
+}
+
+func (c *testClient) ListItems(ctx context.Context, itemChan chan<- *ItemGraph, opt Options) error {
+	defer close(itemChan)
+
+	var last int
+	if opt.Checkpoint != nil {
+		err := UnmarshalGob(opt.Checkpoint, &last)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := last + 1; i <= c.count; i++ {
+		it := &testItem{num: i}
+		if i == c.cancelAt {
+			if c.inDownload {
+				it.cancel = c.cancel
+			} else {
+				c.cancel()
+			}
+		}
+		itemChan <- NewItemGraph(it)
+
+		cp, err := MarshalGob(i)
+		if err != nil {
+			return err
+		}
+		Checkpoint(ctx, cp)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func testItemID(num int) string { return fmt.Sprintf("item%d", num) }
+
+// testItem is an item with a data file. If cancel is
+// set, it is called when the data file is first read.
+type testItem struct {
+	num    int
+	cancel context.CancelFunc
+}
+
+func (it *testItem) ID() string                { return testItemID(it.num) }
+func (it *testItem) Timestamp() time.Time      { return time.Unix(int64(it.num)*3600, 0) }
+func (it *testItem) Class() ItemClass          { return ClassPost }
+func (it *testItem) Owner() (*string, *string) { return nil, nil }
+func (it *testItem) DataText() (*string, error) {
+	return nil, nil
+}
+func (it *testItem) DataFileName() *string {
+	name := it.ID() + ".txt"
+	return &name
+}
+func (it *testItem) DataFileReader() (io.ReadCloser, error) {
+	return FakeCloser(&cancelingReader{
+		r:      strings.NewReader(strings.Repeat(it.ID(), 1000)),
+		cancel: it.cancel,
+	}), nil
+}
+func (it *testItem) DataFileHash() []byte         { return nil }
+func (it *testItem) DataFileMIMEType() *string    { return nil }
+func (it *testItem) Metadata() (*Metadata, error) { return nil, nil }
+func (it *testItem) Location() (*Location, error) { return nil, nil }
+
+// cancelingReader calls cancel, if set, before its first read.
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (cr *cancelingReader) Read(p []byte) (int, error) {
+	if cr.cancel != nil {
+		cr.cancel()
+		cr.cancel = nil
+	}
+	return cr.r.Read(p)
+}
@@ -19,17 +19,14 @@ type RateLimit struct {
 func (acc Account) NewRateLimitedRoundTripper(rt http.RoundTripper) http.RoundTripper {
 	rlKey := acc.DataSourceID + "_" + acc.UserID
 
+	acc.t.rateLimitersMu.Lock()
+	defer acc.t.rateLimitersMu.Unlock()
+
 	rl, ok := acc.t.rateLimiters[rlKey]
 
 	if !ok && acc.ds.RateLimit.RequestsPerHour > 0 {
-		secondsBetweenReqs := 60.0 / (float64(acc.ds.RateLimit.RequestsPerHour) / 60.0)
-		millisBetweenReqs := secondsBetweenReqs * 1000.0
-		reqInterval := time.Duration(millisBetweenReqs) * time.Millisecond
-		if reqInterval < minInterval {
-			reqInterval = minInterval
-		}
-
-		rl.ticker = time.NewTicker(reqInterval)
+		rl = acc.ds.RateLimit
+		rl.ticker = time.NewTicker(rl.Interval())
 		rl.token = make(chan struct{}, rl.BurstSize)
 
 		for i := 0; i < cap(rl.token); i++ {
@@ -50,13 +47,32 @@ func (acc Account) NewRateLimitedRoundTripper(rt http.RoundTripper) http.RoundTr
 	}
 }
 
+// Interval returns the amount of time between requests
+// that is allowed by rl, or 0 if rl does not limit requests.
+func (rl RateLimit) Interval() time.Duration {
+	if rl.RequestsPerHour <= 0 {
+		return 0
+	}
+	secondsBetweenReqs := 60.0 / (float64(rl.RequestsPerHour) / 60.0)
+	millisBetweenReqs := secondsBetweenReqs * 1000.0
+	reqInterval := time.Duration(millisBetweenReqs) * time.Millisecond
+	if reqInterval < minInterval {
+		reqInterval = minInterval
+	}
+	return reqInterval
+}
+
 type rateLimitedRoundTripper struct {
 	http.RoundTripper
 	token <-chan struct{}
 }
 
 func (rt rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	<-rt.token
+	select {
+	case <-rt.token:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
 	return rt.RoundTripper.RoundTrip(req)
 }
 
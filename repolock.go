@@ -0,0 +1,132 @@
+package timeliner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repoLock is an advisory lock on a timeline repository, or on
+// one of its accounts. Every process which opens a repository
+// holds a shared lock on the repository, so that other tools
+// which need it to themselves (to move or back it up, say) can
+// tell that it is in use by trying to lock it exclusively.
+// Processes which write to an account hold an exclusive lock
+// on the account while they do, so only one process at a time
+// writes to each account, but different accounts can be written
+// to by different processes at the same time. Reading needs no
+// lock of its own, since the database gives readers a consistent
+// view of it while it is being written to.
+type repoLock struct {
+	file      *os.File
+	path      string
+	exclusive bool
+}
+
+// lockHolder describes the process which holds a repo lock.
+type lockHolder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (h lockHolder) String() string {
+	return fmt.Sprintf("process %d (%s) since %s",
+		h.PID, h.Command, h.Started.Format(time.RFC3339))
+}
+
+// lockRepo obtains the shared lock on the repository at repoDir.
+// It fails only if another process has it locked exclusively.
+func lockRepo(repoDir string) (*repoLock, error) {
+	lockPath := filepath.Join(repoDir, lockFilename)
+	f, err := acquireLockFile(lockPath, false)
+	if err == errLocked {
+		return nil, fmt.Errorf("repository %s is locked by another process; "+
+			"try again when it is done (lock file: %s)", repoDir, lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acquiring lock file: %v", err)
+	}
+	return &repoLock{file: f, path: lockPath}, nil
+}
+
+// lockAccount obtains the exclusive lock on the given account of
+// the repository at repoDir. If it can't be obtained, it returns
+// an error describing the process that is holding it.
+func lockAccount(repoDir string, acc Account) (*repoLock, error) {
+	lockDir := filepath.Join(repoDir, accountLocksDir)
+	err := os.MkdirAll(lockDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("making lock directory: %v", err)
+	}
+	lockPath := filepath.Join(lockDir, fmt.Sprintf("account_%d.lock", acc.ID))
+
+	f, err := acquireLockFile(lockPath, true)
+	if err == errLocked {
+		holder, err := readLockHolder(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("account %s/%s is in use by another process "+
+				"(could not determine which: %v)", acc.DataSourceID, acc.UserID, err)
+		}
+		return nil, fmt.Errorf("account %s/%s is in use by %s (lock file: %s)",
+			acc.DataSourceID, acc.UserID, holder, lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acquiring lock file: %v", err)
+	}
+	rl := &repoLock{file: f, path: lockPath, exclusive: true}
+
+	// record who holds the lock, so that other
+	// processes can report it if they need it
+	holder := lockHolder{
+		PID:     os.Getpid(),
+		Command: strings.Join(os.Args, " "),
+		Started: time.Now(),
+	}
+	err = f.Truncate(0)
+	if err == nil {
+		err = json.NewEncoder(f).Encode(holder)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		rl.unlock()
+		return nil, fmt.Errorf("writing lock file: %v", err)
+	}
+
+	return rl, nil
+}
+
+// readLockHolder reads the holder of the lock file at lockPath.
+func readLockHolder(lockPath string) (lockHolder, error) {
+	var holder lockHolder
+	f, err := os.Open(lockPath)
+	if err != nil {
+		return holder, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&holder)
+	if err == io.EOF {
+		err = fmt.Errorf("lock file is empty")
+	}
+	return holder, err
+}
+
+// errLocked is returned by acquireLockFile
+// if the lock is held by another process.
+var errLocked = fmt.Errorf("locked")
+
+const (
+	// lockFilename is the name of the lock
+	// file in the root of the repository.
+	lockFilename = "timeliner.lock"
+
+	// accountLocksDir is the folder in the root of
+	// the repository with the lock files of accounts.
+	accountLocksDir = "locks"
+)
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package timeliner
+
+import (
+	"log"
+	"os"
+	"syscall"
+)
+
+// acquireLockFile opens the lock file at lockPath and takes an
+// exclusive or shared flock on it. The lock is released by the
+// OS if the process dies, so stale lock files are never a problem.
+func acquireLockFile(lockPath string, exclusive bool) (*os.File, error) {
+	flag, how := os.O_RDONLY, syscall.LOCK_SH
+	if exclusive {
+		flag, how = os.O_RDWR, syscall.LOCK_EX
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, errLocked
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlock releases the lock. The lock file itself is left in
+// place, since removing it could race with another process
+// that has opened it but not yet locked it.
+func (rl *repoLock) unlock() {
+	if rl.exclusive {
+		err := rl.file.Truncate(0)
+		if err != nil {
+			log.Printf("[ERROR] Clearing repository lock file: %v", err)
+		}
+	}
+	err := syscall.Flock(int(rl.file.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		log.Printf("[ERROR] Releasing repository lock: %v", err)
+	}
+	rl.file.Close()
+}
@@ -0,0 +1,58 @@
+package timeliner
+
+import (
+	"log"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// acquireLockFile opens the lock file at lockPath and takes an
+// exclusive or shared lock on it with LockFileEx. Like flock on
+// other systems, the lock is released by the OS if the process
+// dies, so stale lock files are never a problem.
+func acquireLockFile(lockPath string, exclusive bool) (*os.File, error) {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err = windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, lockedRange())
+	if err == windows.ERROR_LOCK_VIOLATION {
+		f.Close()
+		return nil, errLocked
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlock releases the lock. The lock file itself is left in
+// place, since removing it could race with another process
+// that has opened it but not yet locked it.
+func (rl *repoLock) unlock() {
+	if rl.exclusive {
+		err := rl.file.Truncate(0)
+		if err != nil {
+			log.Printf("[ERROR] Clearing repository lock file: %v", err)
+		}
+	}
+	err := windows.UnlockFileEx(windows.Handle(rl.file.Fd()), 0, 1, 0, lockedRange())
+	if err != nil {
+		log.Printf("[ERROR] Releasing repository lock: %v", err)
+	}
+	rl.file.Close()
+}
+
+// lockedRange returns the range of the lock file that is locked.
+// Locks on Windows keep other processes from reading what they
+// cover, so the lock is on a byte far past the end of the file,
+// which leaves who holds the lock readable.
+func lockedRange() *windows.Overlapped {
+	return &windows.Overlapped{OffsetHigh: 1}
+}
@@ -0,0 +1,318 @@
+package timeliner
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ItemRevision is a prior version of an item. Revisions are
+// recorded when an item is reprocessed and some of its values
+// change. Only the columns named in Changed carry values; all
+// other fields are nil.
+type ItemRevision struct {
+	ID        int64
+	ItemID    int64
+	Revised   time.Time // when these values were replaced
+	Changed   []string  // names of the columns that changed
+	PersonID  *int64
+	Timestamp *time.Time
+	Class     *ItemClass
+	MIMEType  *string
+	DataText  *string
+	DataFile  *string // only set if the prior data file was kept
+	DataHash  *string
+	Metadata  *Metadata
+	Location
+}
+
+// ItemRevisions returns the revision history of the item
+// with the given row ID, most recent revision first.
+func (t *Timeline) ItemRevisions(itemID int64) ([]ItemRevision, error) {
+	rows, err := t.db.Query(`SELECT
+			id, item_id, revised, changed, person_id, timestamp, class,
+			mime_type, data_text, data_file, data_hash, metadata,
+			latitude, longitude
+		FROM item_revisions WHERE item_id=? ORDER BY id DESC`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("querying item revisions: %v", err)
+	}
+	defer rows.Close()
+
+	var revs []ItemRevision
+	for rows.Next() {
+		var rev ItemRevision
+		var revised int64
+		var changed string
+		var ts *int64
+		var class *int
+		var metadataGob []byte
+		err := rows.Scan(&rev.ID, &rev.ItemID, &revised, &changed, &rev.PersonID, &ts, &class,
+			&rev.MIMEType, &rev.DataText, &rev.DataFile, &rev.DataHash, &metadataGob,
+			&rev.Latitude, &rev.Longitude)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item revision: %v", err)
+		}
+		rev.Revised = time.Unix(revised, 0)
+		rev.Changed = strings.Split(changed, ",")
+		if ts != nil {
+			tsTime := time.Unix(*ts, 0)
+			rev.Timestamp = &tsTime
+		}
+		if class != nil {
+			ic := ItemClass(*class)
+			rev.Class = &ic
+		}
+		if metadataGob != nil {
+			rev.Metadata = new(Metadata)
+			err = rev.Metadata.decode(metadataGob)
+			if err != nil {
+				return nil, fmt.Errorf("gob-decoding metadata of revision %d: %v", rev.ID, err)
+			}
+		}
+		revs = append(revs, rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating revision rows: %v", err)
+	}
+
+	return revs, nil
+}
+
+// RestoreItemRevision restores the values of the revision with the
+// given ID to its item. The values being replaced are themselves
+// recorded as a new revision, so a restore can be undone. Because
+// restoring is a local change, the item is marked as modified, which
+// prevents future reprocessing from overwriting it.
+func (t *Timeline) RestoreItemRevision(revisionID int64) error {
+	var itemID int64
+	var changed string
+	var keptDataFile *string
+	err := t.db.QueryRow(`SELECT item_id, changed, data_file
+		FROM item_revisions WHERE id=? LIMIT 1`,
+		revisionID).Scan(&itemID, &changed, &keptDataFile)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no revision with ID %d", revisionID)
+	}
+	if err != nil {
+		return fmt.Errorf("loading revision: %v", err)
+	}
+
+	// the item must not be reprocessed by another
+	// process while it is restored, so lock its account
+	var acc Account
+	err = t.db.QueryRow(`SELECT accounts.id, accounts.data_source_id, accounts.user_id
+		FROM items, accounts WHERE items.id=? AND accounts.id=items.account_id LIMIT 1`,
+		itemID).Scan(&acc.ID, &acc.DataSourceID, &acc.UserID)
+	if err != nil {
+		return fmt.Errorf("loading account of item %d: %v", itemID, err)
+	}
+	lock, err := t.lockAccount(acc)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	// a data file can only be restored if it was kept, and
+	// its hash is meaningless without the file
+	var cols []string
+	for _, col := range strings.Split(changed, ",") {
+		if _, ok := revisionColumns[col]; !ok {
+			return fmt.Errorf("revision %d has unrecognized column: %s", revisionID, col)
+		}
+		if col == "data_hash" && keptDataFile == nil {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("revision %d has nothing that can be restored", revisionID)
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// record the current values as a revision of their own
+	colList := strings.Join(cols, ", ")
+	_, err = tx.Exec(`INSERT INTO item_revisions (item_id, revised, changed, `+colList+`)
+		SELECT id, ?, ?, `+colList+` FROM items WHERE id=?`,
+		time.Now().Unix(), strings.Join(cols, ","), itemID)
+	if err != nil {
+		return fmt.Errorf("recording current values as revision: %v", err)
+	}
+
+	// then copy the revision's values back into the item
+	var sets []string
+	for _, col := range cols {
+		sets = append(sets, fmt.Sprintf("%s=(SELECT %s FROM item_revisions WHERE id=?)", col, col))
+	}
+	args := make([]interface{}, 0, len(cols)+2)
+	for range cols {
+		args = append(args, revisionID)
+	}
+	args = append(args, time.Now().Unix(), itemID)
+	_, err = tx.Exec(`UPDATE items SET `+strings.Join(sets, ", ")+`, modified=? WHERE id=?`, args...)
+	if err != nil {
+		return fmt.Errorf("restoring item values: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// saveItemRevision records the values of prev which differ from
+// those of cur as a revision of the item. If the prior data file
+// was preserved, keptDataFile is its canonical name. It returns
+// true if a revision was recorded.
+func (t *Timeline) saveItemRevision(prev, cur ItemRow, keptDataFile *string, revised time.Time) (bool, error) {
+	cols := []string{"item_id", "revised"}
+	vals := []interface{}{cur.ID, revised.Unix()}
+	var changed []string
+
+	add := func(col string, val interface{}) {
+		changed = append(changed, col)
+		cols = append(cols, col)
+		vals = append(vals, val)
+	}
+
+	if prev.PersonID != cur.PersonID {
+		add("person_id", prev.PersonID)
+	}
+	// timestamps are stored to the second
+	if prev.Timestamp.Unix() != cur.Timestamp.Unix() {
+		add("timestamp", prev.Timestamp.Unix())
+	}
+	if prev.Class != cur.Class {
+		add("class", prev.Class)
+	}
+	if !stringPtrsEqual(prev.MIMEType, cur.MIMEType) {
+		add("mime_type", prev.MIMEType)
+	}
+	if !stringPtrsEqual(prev.DataText, cur.DataText) {
+		add("data_text", prev.DataText)
+	}
+	if keptDataFile != nil {
+		add("data_file", keptDataFile)
+	}
+	if !stringPtrsEqual(prev.DataHash, cur.DataHash) {
+		add("data_hash", prev.DataHash)
+	}
+	if !metadataGobsEqual(prev.metaGob, cur.metaGob) {
+		add("metadata", prev.metaGob)
+	}
+	if !float64PtrsEqual(prev.Latitude, cur.Latitude) {
+		add("latitude", prev.Latitude)
+	}
+	if !float64PtrsEqual(prev.Longitude, cur.Longitude) {
+		add("longitude", prev.Longitude)
+	}
+
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	cols = append(cols, "changed")
+	vals = append(vals, strings.Join(changed, ","))
+
+	_, err := t.db.Exec(`INSERT INTO item_revisions (`+strings.Join(cols, ", ")+`)
+		VALUES (?`+strings.Repeat(", ?", len(cols)-1)+`)`, vals...)
+	if err != nil {
+		return false, fmt.Errorf("inserting item revision: %v", err)
+	}
+
+	return true, nil
+}
+
+// deleteRevisionDataFiles deletes the data files that were kept
+// with revisions of the item, unless an item still uses them.
+func (t *Timeline) deleteRevisionDataFiles(itemID int64) error {
+	rows, err := t.db.Query(`SELECT data_file FROM item_revisions
+		WHERE item_id=? AND data_file IS NOT NULL
+		AND data_file NOT IN (SELECT data_file FROM items WHERE data_file IS NOT NULL)`, itemID)
+	if err != nil {
+		return fmt.Errorf("querying revision data files: %v", err)
+	}
+	defer rows.Close()
+
+	var dataFiles []string
+	for rows.Next() {
+		var dataFile string
+		err := rows.Scan(&dataFile)
+		if err != nil {
+			return fmt.Errorf("scanning revision data file: %v", err)
+		}
+		dataFiles = append(dataFiles, dataFile)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating revision rows: %v", err)
+	}
+	rows.Close()
+
+	for _, dataFile := range dataFiles {
+		err := os.Remove(t.fullpath(dataFile))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting revision data file: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// revisionDataFileName returns an available canonical name
+// under which the prior version of the data file named
+// canonical can be kept, based on when it was replaced.
+func (t *Timeline) revisionDataFileName(canonical string, revised time.Time) string {
+	ext := path.Ext(canonical)
+	base := strings.TrimSuffix(canonical, ext)
+	name := fmt.Sprintf("%s_rev%d%s", base, revised.Unix(), ext)
+	for i := 2; t.datafileExists(name); i++ {
+		name = fmt.Sprintf("%s_rev%d_%d%s", base, revised.Unix(), i, ext)
+	}
+	return name
+}
+
+// metadataGobsEqual returns true if the encoded metadata a and b
+// have the same values. The encodings themselves can't be compared,
+// since maps (like EXIF data) are not encoded in any certain order.
+func metadataGobsEqual(a, b []byte) bool {
+	var ma, mb Metadata
+	if ma.decode(a) != nil || mb.decode(b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(ma, mb)
+}
+
+func stringPtrsEqual(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func float64PtrsEqual(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// revisionColumns is the set of item columns
+// that can be recorded in a revision.
+var revisionColumns = map[string]struct{}{
+	"person_id": {},
+	"timestamp": {},
+	"class":     {},
+	"mime_type": {},
+	"data_text": {},
+	"data_file": {},
+	"data_hash": {},
+	"metadata":  {},
+	"latitude":  {},
+	"longitude": {},
+}
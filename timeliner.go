@@ -25,6 +25,7 @@ import (
 	"io"
 	"log"
 	mathrand "math/rand"
+	"os"
 	"sync"
 	"time"
 
@@ -39,28 +40,82 @@ func init() {
 // The zero value is NOT valid; use Open() to obtain
 // a valid value.
 type Timeline struct {
-	db           *sql.DB
-	repoDir      string
-	rateLimiters map[string]RateLimit
+	// If true, when an item's data file is replaced
+	// while reprocessing, the prior file is kept with
+	// the item's revision instead of being deleted.
+	KeepRevisionDataFiles bool
+
+	db             *sql.DB
+	repoDir        string
+	lock           *repoLock // shared lock on the repository
+	rateLimiters   map[string]RateLimit
+	rateLimitersMu sync.Mutex
 }
 
 // Open creates/opens a timeline at the given
 // repository directory. Timelines should always
 // be Close()'d for a clean shutdown when done.
+//
+// Any number of processes may have a repository open
+// at the same time. Operations which write to an account
+// (like GetLatest, GetAll, and Import) lock the account
+// while they run, so only one process at a time writes
+// to each account; if another process is writing to it,
+// they return an error which describes that process.
+// To only read a repository, use OpenReadOnly.
 func Open(repo string) (*Timeline, error) {
-	db, err := openDB(repo)
+	err := os.MkdirAll(repo, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("making data directory: %v", err)
+	}
+	lock, err := lockRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openDB(repo, false)
+	if err != nil {
+		lock.unlock()
+		return nil, fmt.Errorf("opening database: %v", err)
+	}
+	return &Timeline{
+		db:           db,
+		repoDir:      repo,
+		lock:         lock,
+		rateLimiters: make(map[string]RateLimit),
+	}, nil
+}
+
+// OpenReadOnly opens an existing timeline at the given
+// repository directory for reading only. It can be open
+// while other processes are writing to the timeline.
+// Operations which would write to the timeline will fail.
+func OpenReadOnly(repo string) (*Timeline, error) {
+	lock, err := lockRepo(repo)
 	if err != nil {
+		return nil, err
+	}
+	db, err := openDB(repo, true)
+	if err != nil {
+		lock.unlock()
 		return nil, fmt.Errorf("opening database: %v", err)
 	}
 	return &Timeline{
 		db:           db,
 		repoDir:      repo,
+		lock:         lock,
 		rateLimiters: make(map[string]RateLimit),
 	}, nil
 }
 
+// lockAccount locks acc for writing until
+// the returned lock is unlocked.
+func (t *Timeline) lockAccount(acc Account) (*repoLock, error) {
+	return lockAccount(t.repoDir, acc)
+}
+
 // Close frees up resources allocated from Open.
 func (t *Timeline) Close() error {
+	t.rateLimitersMu.Lock()
 	for key, rl := range t.rateLimiters {
 		if rl.ticker != nil {
 			rl.ticker.Stop()
@@ -68,10 +123,16 @@ func (t *Timeline) Close() error {
 		}
 		delete(t.rateLimiters, key)
 	}
+	t.rateLimitersMu.Unlock()
+	var err error
 	if t.db != nil {
-		return t.db.Close()
+		err = t.db.Close()
 	}
-	return nil
+	if t.lock != nil {
+		t.lock.unlock()
+		t.lock = nil
+	}
+	return err
 }
 
 type concurrentCuckoo struct {
@@ -94,6 +155,10 @@ type Options struct {
 	// A checkpoint from which to resume
 	// item retrieval.
 	Checkpoint []byte
+
+	// The sync state last saved for this
+	// account with SaveSyncState.
+	SyncState []byte
 }
 
 // FakeCloser turns an io.Reader into an io.ReadCloser
@@ -118,25 +183,112 @@ type ctxKey string
 // wrappedClientCtxKey is how the context value is accessed.
 var wrappedClientCtxKey ctxKey = "wrapped_client"
 
+// SaveSyncState saves state for the account associated with
+// the provided context, overwriting any previous state. Unlike
+// checkpoints, which are only for resuming interrupted runs,
+// sync state persists across successful runs, so data sources
+// can use it for incremental syncing (e.g. sync tokens or
+// caches). It is passed into ListItems in Options.SyncState.
+// Like checkpoints, it is saved once the items sent before it
+// have been processed. Any errors are logged.
+func SaveSyncState(ctx context.Context, state []byte) {
+	wc, ok := ctx.Value(wrappedClientCtxKey).(*WrappedClient)
+	if !ok {
+		log.Printf("[ERROR] Sync state function not available; got type %T (%#v)",
+			ctx.Value(wrappedClientCtxKey), ctx.Value(wrappedClientCtxKey))
+		return
+	}
+
+	wc.afterItems(func(interrupted bool) {
+		if interrupted {
+			return
+		}
+		_, err := wc.tl.db.Exec(`INSERT INTO sync_states (account_id, state) VALUES (?, ?)
+		ON CONFLICT (account_id) DO UPDATE SET state=?`,
+			wc.acc.ID, state, state)
+		if err != nil {
+			log.Printf("[ERROR][%s/%s] Saving sync state: %v", wc.ds.ID, wc.acc.UserID, err)
+		}
+	})
+}
+
+// AfterItems calls fn once the items that have been sent so far
+// by the ListItems call associated with the provided context have
+// been processed (or skipped, if the context was cancelled). Data
+// sources can use it to release what the items need while they
+// are processed, such as an archive their data files are read
+// from. If it is called after ListItems closed its channel, fn
+// is called once all of the items have been processed. If the
+// context is not that of an operation, fn is called right away.
+func AfterItems(ctx context.Context, fn func()) {
+	wc, ok := ctx.Value(wrappedClientCtxKey).(*WrappedClient)
+	if !ok {
+		fn()
+		return
+	}
+	wc.afterItems(func(bool) { fn() })
+}
+
+// OwnItemStored returns true if an item with the given original
+// ID has been stored from the account associated with the provided
+// context, as an item of the account's owner (which is how items
+// are stored when Owner returns a nil ID). Items that have been
+// sent but are still being processed are not found yet; data
+// sources can wait for them with AfterItems. If the context is not
+// that of an operation, false is returned.
+func OwnItemStored(ctx context.Context, originalID string) (bool, error) {
+	wc, ok := ctx.Value(wrappedClientCtxKey).(*WrappedClient)
+	if !ok {
+		return false, nil
+	}
+	var stored bool
+	err := wc.tl.db.QueryRow(`SELECT EXISTS(SELECT 1
+		FROM items, person_identities
+		WHERE items.account_id=? AND items.original_id=?
+			AND person_identities.person_id=items.person_id
+			AND person_identities.data_source_id=? AND person_identities.user_id=?)`,
+		wc.acc.ID, originalID, wc.ds.ID, wc.acc.UserID).Scan(&stored)
+	if err != nil {
+		return false, fmt.Errorf("querying item %s: %v", originalID, err)
+	}
+	return stored, nil
+}
+
+// ErrInterrupted is returned by operations which stopped
+// early because their context was cancelled.
+var ErrInterrupted = fmt.Errorf("interrupted")
+
 // CheckpointFn is a function that saves a checkpoint.
 type CheckpointFn func(checkpoint []byte) error
 
 // Checkpoint saves a checkpoint for the processing associated
 // with the provided context. It overwrites any previous
-// checkpoint. Any errors are logged.
+// checkpoint. The checkpoint is saved once the items sent
+// before it have been processed, so it must be called before
+// ListItems returns; later checkpoints are ignored, since
+// there is nothing left to resume. Any errors are logged.
 func Checkpoint(ctx context.Context, checkpoint []byte) {
 	wc, ok := ctx.Value(wrappedClientCtxKey).(*WrappedClient)
 
 	if !ok {
-		log.Printf("[ERROR][%s/%s] Checkpoint function not available; got type %T (%#v)",
-			wc.ds.ID, wc.acc.UserID, wc, wc)
+		log.Printf("[ERROR] Checkpoint function not available; got type %T (%#v)",
+			ctx.Value(wrappedClientCtxKey), ctx.Value(wrappedClientCtxKey))
 		return
 	}
 
-	_, err := wc.tl.db.Exec(`UPDATE accounts SET checkpoint=? WHERE id=?`, // TODO: LIMIT 1 (see https://github.com/mattn/go-sqlite3/pull/564)
-		checkpoint, wc.acc.ID)
-	if err != nil {
-		log.Printf("[ERROR][%s/%s] Checkpoint: %v", wc.ds.ID, wc.acc.UserID, err)
-		return
-	}
+	wc.afterItems(func(interrupted bool) {
+		if interrupted {
+			return
+		}
+		if wc.listing != nil && wc.listing.finished() {
+			log.Printf("[ERROR][%s/%s] Checkpoint after all items were listed; ignoring it",
+				wc.ds.ID, wc.acc.UserID)
+			return
+		}
+		_, err := wc.tl.db.Exec(`UPDATE accounts SET checkpoint=? WHERE id=?`, // TODO: LIMIT 1 (see https://github.com/mattn/go-sqlite3/pull/564)
+			checkpoint, wc.acc.ID)
+		if err != nil {
+			log.Printf("[ERROR][%s/%s] Checkpoint: %v", wc.ds.ID, wc.acc.UserID, err)
+		}
+	})
 }
@@ -25,17 +25,29 @@ type WrappedClient struct {
 	lastItemRowID     int64
 	lastItemTimestamp time.Time
 	lastItemMu        *sync.Mutex
+
+	// the items being listed and processed
+	// by the current operation, if any
+	listing *listing
 }
 
 // GetLatest gets the most recent items from wc. It does not prune or
 // reprocess; only meant for a quick pull. If there are no items pulled
-// yet, all items will be pulled.
+// yet, all items will be pulled. If ctx is cancelled, items already
+// being processed are cleaned up, the last checkpoint is kept so the
+// operation can be resumed, and ErrInterrupted is returned.
 func (wc *WrappedClient) GetLatest(ctx context.Context) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 	ctx = context.WithValue(ctx, wrappedClientCtxKey, wc)
 
+	lock, err := wc.tl.lockAccount(wc.acc)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
 	// get date and original ID of the most recent item for this
 	// account from the last successful run
 	var mostRecentTimestamp int64
@@ -58,19 +70,30 @@ func (wc *WrappedClient) GetLatest(ctx context.Context) error {
 		timeframe.SinceItemID = &mostRecentOriginalID
 	}
 
-	wg, ch := wc.beginProcessing(concurrentCuckoo{}, false, false)
+	syncState, err := wc.loadSyncState()
+	if err != nil {
+		return err
+	}
+
+	wg, ch := wc.beginProcessing(ctx, concurrentCuckoo{}, false, false)
 
-	err := wc.Client.ListItems(ctx, ch, Options{
+	err = wc.Client.ListItems(ctx, ch, Options{
 		Timeframe:  timeframe,
 		Checkpoint: wc.acc.checkpoint,
+		SyncState:  syncState,
 	})
-	if err != nil {
-		return fmt.Errorf("getting items from service: %v", err)
-	}
 
 	// wait for processing to complete
 	wg.Wait()
 
+	// if interrupted, leave the checkpoint for resuming
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if err != nil {
+		return fmt.Errorf("getting items from service: %v", err)
+	}
+
 	err = wc.successCleanup()
 	if err != nil {
 		return fmt.Errorf("processing completed, but error cleaning up: %v", err)
@@ -85,7 +108,8 @@ func (wc *WrappedClient) GetLatest(ctx context.Context) error {
 // from the timeline at the end of the listing. If integrity is true,
 // all items that are listed by wc that exist in the timeline and which
 // consist of a data file will be opened and checked for integrity; if
-// the file has changed, it will be reprocessed.
+// the file has changed, it will be reprocessed. Cancellation is the
+// same as with GetLatest.
 func (wc *WrappedClient) GetAll(ctx context.Context, reprocess, prune, integrity bool) error {
 	if wc.Client == nil {
 		return fmt.Errorf("no client")
@@ -95,22 +119,41 @@ func (wc *WrappedClient) GetAll(ctx context.Context, reprocess, prune, integrity
 	}
 	ctx = context.WithValue(ctx, wrappedClientCtxKey, wc)
 
+	lock, err := wc.tl.lockAccount(wc.acc)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
 	var cc concurrentCuckoo
 	if prune {
 		cc.Filter = cuckoo.NewFilter(10000000) // 10mil = ~16 MB on 64-bit
 		cc.Mutex = new(sync.Mutex)
 	}
 
-	wg, ch := wc.beginProcessing(cc, reprocess, integrity)
-
-	err := wc.Client.ListItems(ctx, ch, Options{Checkpoint: wc.acc.checkpoint})
+	syncState, err := wc.loadSyncState()
 	if err != nil {
-		return fmt.Errorf("getting items from service: %v", err)
+		return err
 	}
 
+	wg, ch := wc.beginProcessing(ctx, cc, reprocess, integrity)
+
+	err = wc.Client.ListItems(ctx, ch, Options{
+		Checkpoint: wc.acc.checkpoint,
+		SyncState:  syncState,
+	})
+
 	// wait for processing to complete
 	wg.Wait()
 
+	// if interrupted, leave the checkpoint for resuming
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if err != nil {
+		return fmt.Errorf("getting items from service: %v", err)
+	}
+
 	err = wc.successCleanup()
 	if err != nil {
 		return fmt.Errorf("processing completed, but error cleaning up: %v", err)
@@ -156,6 +199,16 @@ func (wc *WrappedClient) Import(ctx context.Context, filename string, reprocess,
 	if wc.Client == nil {
 		return fmt.Errorf("no client")
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = context.WithValue(ctx, wrappedClientCtxKey, wc)
+
+	lock, err := wc.tl.lockAccount(wc.acc)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
 
 	var cc concurrentCuckoo
 	if prune {
@@ -163,19 +216,30 @@ func (wc *WrappedClient) Import(ctx context.Context, filename string, reprocess,
 		cc.Mutex = new(sync.Mutex)
 	}
 
-	wg, ch := wc.beginProcessing(cc, reprocess, integrity)
+	syncState, err := wc.loadSyncState()
+	if err != nil {
+		return err
+	}
+
+	wg, ch := wc.beginProcessing(ctx, cc, reprocess, integrity)
 
-	err := wc.Client.ListItems(ctx, ch, Options{
+	err = wc.Client.ListItems(ctx, ch, Options{
 		Filename:   filename,
 		Checkpoint: wc.acc.checkpoint,
+		SyncState:  syncState,
 	})
-	if err != nil {
-		return fmt.Errorf("importing: %v", err)
-	}
 
 	// wait for processing to complete
 	wg.Wait()
 
+	// if interrupted, leave the checkpoint for resuming
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if err != nil {
+		return fmt.Errorf("importing: %v", err)
+	}
+
 	err = wc.successCleanup()
 	if err != nil {
 		return fmt.Errorf("processing completed, but error cleaning up: %v", err)
@@ -192,6 +256,20 @@ func (wc *WrappedClient) Import(ctx context.Context, filename string, reprocess,
 	return nil
 }
 
+// loadSyncState loads the sync state of wc's account, if any.
+func (wc *WrappedClient) loadSyncState() ([]byte, error) {
+	var state []byte
+	err := wc.tl.db.QueryRow(`SELECT state FROM sync_states WHERE account_id=? LIMIT 1`,
+		wc.acc.ID).Scan(&state)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading sync state: %v", err)
+	}
+	return state, nil
+}
+
 func (wc *WrappedClient) doPrune(cuckoo concurrentCuckoo) error {
 	// absolutely do not allow a prune to happen if the account
 	// has a checkpoint; this is because we don't store the cuckoo
@@ -277,6 +355,12 @@ func (wc *WrappedClient) deleteItem(rowID int64) error {
 		return fmt.Errorf("querying count of rows sharing data file: %v", err)
 	}
 
+	// data files kept with the item's revisions go with it
+	err = wc.tl.deleteRevisionDataFiles(rowID)
+	if err != nil {
+		return fmt.Errorf("deleting data files of item revisions: %v", err)
+	}
+
 	_, err = wc.tl.db.Exec(`DELETE FROM items WHERE id=?`, rowID) // TODO: limit 1
 	if err != nil {
 		return fmt.Errorf("deleting item from DB: %v", err)
@@ -300,3 +384,6 @@ func (wc *WrappedClient) DataSourceID() string { return wc.ds.ID }
 
 // UserID returns the ID of the user associated with this client.
 func (wc *WrappedClient) UserID() string { return wc.acc.UserID }
+
+// RateLimit returns the rate limit of the data source wc was created from.
+func (wc *WrappedClient) RateLimit() RateLimit { return wc.ds.RateLimit }